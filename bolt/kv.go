@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdata/influxdb/v2/kit/tracing"
@@ -61,6 +62,12 @@ func (s *KVStore) tempPath() string {
 	return s.path + ".tmp"
 }
 
+// backupPath returns the path to the copy of the database file kept while
+// CompactBucket swaps in the compacted file.
+func (s *KVStore) backupPath() string {
+	return s.path + ".bak"
+}
+
 // Open creates boltDB file it doesn't exists and opens it otherwise.
 func (s *KVStore) Open(ctx context.Context) error {
 	span, _ := tracing.StartSpanFromContext(ctx)
@@ -80,6 +87,11 @@ func (s *KVStore) Open(ctx context.Context) error {
 		return fmt.Errorf("unable to remove boltdb partial restore file: %w", err)
 	}
 
+	// Remove any copy of the database file left by a failed compaction.
+	if err := os.Remove(s.backupPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove boltdb compaction backup file: %w", err)
+	}
+
 	// Open database file.
 	if err := s.openDB(); err != nil {
 		return fmt.Errorf("unable to open boltdb file %v", err)
@@ -112,10 +124,56 @@ func (s *KVStore) DB() *bolt.DB {
 	return s.db
 }
 
+// txScope marks the context of a transaction of the store while it is open.
+type txScope struct {
+	store *KVStore
+	open  int32
+}
+
+type txScopeKey struct{}
+
+// begin holds the read lock for a transaction, so the database is not swapped
+// out from under it by CompactBucket or Restore, and returns the context of the
+// transaction along with the func that ends it. A transaction opened with the
+// context of an open transaction of the store is covered by the lock that one
+// holds, and does not take it again; a pending CompactBucket would block it.
+func (s *KVStore) begin(ctx context.Context) (context.Context, func()) {
+	if scope, ok := ctx.Value(txScopeKey{}).(*txScope); ok && scope.store == s && atomic.LoadInt32(&scope.open) == 1 {
+		return ctx, func() {}
+	}
+
+	s.mu.RLock()
+	scope := &txScope{store: s, open: 1}
+	return context.WithValue(ctx, txScopeKey{}, scope), func() {
+		atomic.StoreInt32(&scope.open, 0)
+		s.mu.RUnlock()
+	}
+}
+
+// view runs fn in a read-only bolt transaction, holding the read lock as begin
+// does.
+func (s *KVStore) view(ctx context.Context, fn func(ctx context.Context, tx *bolt.Tx) error) error {
+	ctx, end := s.begin(ctx)
+	defer end()
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(ctx, tx)
+	})
+}
+
+// update runs fn in a writable bolt transaction, holding the read lock as begin
+// does.
+func (s *KVStore) update(ctx context.Context, fn func(ctx context.Context, tx *bolt.Tx) error) error {
+	ctx, end := s.begin(ctx)
+	defer end()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(ctx, tx)
+	})
+}
+
 // Flush removes all bolt keys within each bucket.
 func (s *KVStore) Flush(ctx context.Context) {
-	_ = s.DB().Update(
-		func(tx *bolt.Tx) error {
+	_ = s.update(ctx,
+		func(_ context.Context, tx *bolt.Tx) error {
 			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
 				s.cleanBucket(tx, b)
 				return nil
@@ -151,7 +209,7 @@ func (s *KVStore) View(ctx context.Context, fn func(tx kv.Tx) error) error {
 	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
 
-	return s.DB().View(func(tx *bolt.Tx) error {
+	return s.view(ctx, func(ctx context.Context, tx *bolt.Tx) error {
 		return fn(&Tx{
 			tx:  tx,
 			ctx: ctx,
@@ -164,18 +222,26 @@ func (s *KVStore) Update(ctx context.Context, fn func(tx kv.Tx) error) error {
 	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
 
-	return s.DB().Update(func(tx *bolt.Tx) error {
-		return fn(&Tx{
-			tx:  tx,
-			ctx: ctx,
-		})
-	})
+	tx := &Tx{}
+	if err := s.update(ctx, func(ctx context.Context, btx *bolt.Tx) error {
+		tx.tx, tx.ctx = btx, ctx
+		return fn(tx)
+	}); err != nil {
+		return err
+	}
+
+	// the commit hooks are run once the lock is released, so a hook opening a
+	// transaction does not wait on a pending CompactBucket
+	for _, hook := range tx.onCommit {
+		hook()
+	}
+	return nil
 }
 
 // CreateBucket creates a bucket in the underlying boltdb store if it
 // does not already exist
 func (s *KVStore) CreateBucket(ctx context.Context, name []byte) error {
-	return s.DB().Update(func(tx *bolt.Tx) error {
+	return s.update(ctx, func(_ context.Context, tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists(name)
 		return err
 	})
@@ -184,7 +250,7 @@ func (s *KVStore) CreateBucket(ctx context.Context, name []byte) error {
 // DeleteBucket creates a bucket in the underlying boltdb store if it
 // does not already exist
 func (s *KVStore) DeleteBucket(ctx context.Context, name []byte) error {
-	return s.DB().Update(func(tx *bolt.Tx) error {
+	return s.update(ctx, func(_ context.Context, tx *bolt.Tx) error {
 		if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
 			return err
 		}
@@ -195,15 +261,121 @@ func (s *KVStore) DeleteBucket(ctx context.Context, name []byte) error {
 
 // Backup copies all K:Vs to a writer, in BoltDB format.
 func (s *KVStore) Backup(ctx context.Context, w io.Writer) error {
-	span, _ := tracing.StartSpanFromContext(ctx)
+	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
 
-	return s.DB().View(func(tx *bolt.Tx) error {
+	return s.view(ctx, func(_ context.Context, tx *bolt.Tx) error {
 		_, err := tx.WriteTo(w)
 		return err
 	})
 }
 
+// CompactBucket reclaims the space freed within the bucket. Bolt only returns
+// free pages to the filesystem by rewriting the database, so every bucket is
+// compacted. Transactions are blocked while the database is rewritten and
+// swapped, and should the swap fail the original database is reopened.
+//
+// CompactBucket waits for the open transactions to end, and new transactions
+// wait for it. A transaction opened within another must therefore be opened with
+// the context of the tx it is opened within, see Tx.Context, or it deadlocks
+// against a pending CompactBucket. Commit hooks are run after the transaction
+// has ended, and may open transactions with any context.
+func (s *KVStore) CompactBucket(ctx context.Context, bucket []byte) error {
+	span, _ := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucket) == nil {
+			return fmt.Errorf("bucket %q: %w", string(bucket), kv.ErrBucketNotFound)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	err := s.compact()
+	// clean up whichever of the compacted and backup files are left
+	os.Remove(s.tempPath())
+	os.Remove(s.backupPath())
+	return err
+}
+
+// compact copies every bucket into a new database file and swaps it with the
+// current one. The caller must hold the lock, which keeps every other
+// transaction out until the compacted database is open.
+func (s *KVStore) compact() error {
+	dst, err := bolt.Open(s.tempPath(), 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := s.db.View(func(src *bolt.Tx) error {
+		return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return dst.Update(func(tx *bolt.Tx) error {
+				dstBkt, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(dstBkt, b)
+			})
+		})
+	}); err != nil {
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	// Keep the current file to fall back on until the compacted one is open.
+	if err := os.Link(s.path, s.backupPath()); err != nil {
+		return err
+	}
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+
+	// Atomically swap the compacted file with current DB file.
+	if err := fs.RenameFileWithReplacement(s.tempPath(), s.path); err != nil {
+		return s.reopenBackup(err)
+	}
+	if err := s.openDB(); err != nil {
+		return s.reopenBackup(err)
+	}
+	return nil
+}
+
+// reopenBackup swaps the file kept by compact back in and reopens it, returning
+// the error the compaction failed with.
+func (s *KVStore) reopenBackup(compactErr error) error {
+	if err := fs.RenameFileWithReplacement(s.backupPath(), s.path); err != nil {
+		return fmt.Errorf("%v; unable to restore boltdb file: %v", compactErr, err)
+	}
+	if err := s.openDB(); err != nil {
+		return fmt.Errorf("%v; unable to reopen boltdb file: %v", compactErr, err)
+	}
+	return compactErr
+}
+
+// copyBucket copies every key, and nested bucket, from src to dst.
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		// a nil value indicates a nested bucket.
+		if v == nil {
+			nested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(nested, src.Bucket(k))
+		}
+		return dst.Put(k, v)
+	})
+}
+
 // Restore replaces the underlying database with the data from r.
 func (s *KVStore) Restore(ctx context.Context, r io.Reader) error {
 	if err := func() error {
@@ -247,6 +419,8 @@ func (s *KVStore) Restore(ctx context.Context, r io.Reader) error {
 type Tx struct {
 	tx  *bolt.Tx
 	ctx context.Context
+
+	onCommit []func()
 }
 
 // Context returns the context for the transaction.
@@ -259,6 +433,18 @@ func (tx *Tx) WithContext(ctx context.Context) {
 	tx.ctx = ctx
 }
 
+// Writable returns true when the transaction is able to mutate data.
+func (tx *Tx) Writable() bool {
+	return tx.tx.Writable()
+}
+
+// OnCommit adds a function to run after the transaction is committed. The
+// function is run by Update once the transaction has ended, and never for a
+// read-only transaction.
+func (tx *Tx) OnCommit(fn func()) {
+	tx.onCommit = append(tx.onCommit, fn)
+}
+
 // Bucket retrieves the bucket named b.
 func (tx *Tx) Bucket(b []byte) (kv.Bucket, error) {
 	bkt := tx.tx.Bucket(b)
@@ -285,6 +471,16 @@ func (b *Bucket) Get(key []byte) ([]byte, error) {
 	return val, nil
 }
 
+// Stats returns the number of keys in the bucket and the bytes of the pages
+// in use by the bucket.
+func (b *Bucket) Stats() kv.BucketStats {
+	stats := b.bucket.Stats()
+	return kv.BucketStats{
+		KeyN:  stats.KeyN,
+		Bytes: stats.BranchInuse + stats.LeafInuse + stats.InlineBucketInuse,
+	}
+}
+
 // GetBatch retrieves the values for the provided keys.
 func (b *Bucket) GetBatch(keys ...[]byte) ([][]byte, error) {
 	values := make([][]byte, len(keys))
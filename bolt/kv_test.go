@@ -1,13 +1,20 @@
 package bolt_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/influxdata/influxdb/v2/kv"
 	"github.com/influxdata/influxdb/v2/kv/migration"
 	platformtesting "github.com/influxdata/influxdb/v2/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func initKVStore(f platformtesting.KVStoreFields, t *testing.T) (kv.Store, func()) {
@@ -53,3 +60,219 @@ func mustCreateBucket(t testing.TB, store kv.SchemaStore, bucket []byte) {
 		t.Fatal(err)
 	}
 }
+
+func TestKVStore_CompactBucket(t *testing.T) {
+	s, closeFn, err := NewTestKVStore(t)
+	require.NoError(t, err)
+	defer closeFn()
+
+	bucket := []byte("compact")
+	mustCreateBucket(t, s, bucket)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key_%05d", i)) }
+	value := bytes.Repeat([]byte("v"), 1024)
+
+	err = s.Update(context.Background(), func(tx kv.Tx) error {
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			if err := b.Put(key(i), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = s.Update(context.Background(), func(tx kv.Tx) error {
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			return err
+		}
+		for i := 10; i < 2000; i++ {
+			if err := b.Delete(key(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	sizeBefore := fileSize(t, s.DB().Path())
+	require.NoError(t, kv.CompactBucket(context.Background(), s, bucket))
+	sizeAfter := fileSize(t, s.DB().Path())
+	assert.Less(t, sizeAfter, sizeBefore)
+
+	err = s.View(context.Background(), func(tx kv.Tx) error {
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			v, err := b.Get(key(i))
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, value, v)
+		}
+		_, err = b.Get(key(10))
+		assert.Equal(t, kv.ErrKeyNotFound, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	t.Run("missing bucket", func(t *testing.T) {
+		err := s.CompactBucket(context.Background(), []byte("missing"))
+		assert.True(t, errors.Is(err, kv.ErrBucketNotFound))
+	})
+}
+
+func TestKVStore_CompactBucket_ConcurrentWrites(t *testing.T) {
+	s, closeFn, err := NewTestKVStore(t)
+	require.NoError(t, err)
+	defer closeFn()
+
+	bucket := []byte("compact")
+	mustCreateBucket(t, s, bucket)
+
+	put := func(key []byte, fn func()) error {
+		return s.Update(context.Background(), func(tx kv.Tx) error {
+			b, err := tx.Bucket(bucket)
+			if err != nil {
+				return err
+			}
+			fn()
+			return b.Put(key, []byte("v"))
+		})
+	}
+
+	var keys [][]byte
+	for i := 0; i < 10; i++ {
+		// a write is open when the compaction starts, with another write
+		// queued behind it; neither may be lost to the compaction
+		open, held := []byte(fmt.Sprintf("open_%02d", i)), []byte(fmt.Sprintf("queued_%02d", i))
+		keys = append(keys, open, held)
+
+		var (
+			wg               sync.WaitGroup
+			started, release = make(chan struct{}), make(chan struct{})
+			errs             = make([]error, 3)
+		)
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			errs[0] = put(open, func() {
+				close(started)
+				<-release
+			})
+		}()
+		<-started
+		go func() {
+			defer wg.Done()
+			errs[1] = put(held, func() {})
+		}()
+		go func() {
+			defer wg.Done()
+			errs[2] = s.CompactBucket(context.Background(), bucket)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+	}
+
+	err = s.View(context.Background(), func(tx kv.Tx) error {
+		b, err := tx.Bucket(bucket)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			_, err := b.Get(key)
+			assert.NoError(t, err, "%s written during compaction", key)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestKVStore_CompactBucket_NestedTransactions(t *testing.T) {
+	s, closeFn, err := NewTestKVStore(t)
+	require.NoError(t, err)
+	defer closeFn()
+
+	bucket := []byte("compact")
+	mustCreateBucket(t, s, bucket)
+
+	// withPendingCompaction runs fn in a write with a compaction pending behind
+	// it, failing should the write and compaction not both end
+	withPendingCompaction := func(t *testing.T, fn func(tx kv.Tx) error) {
+		t.Helper()
+
+		var (
+			wg   sync.WaitGroup
+			errs = make([]error, 2)
+			done = make(chan struct{})
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs[0] = s.Update(context.Background(), func(tx kv.Tx) error {
+				go func() {
+					defer wg.Done()
+					errs[1] = s.CompactBucket(context.Background(), bucket)
+				}()
+				time.Sleep(10 * time.Millisecond)
+				return fn(tx)
+			})
+		}()
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			// closing the store would block on the deadlocked transactions
+			panic("deadlocked against the pending compaction")
+		}
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("view opened within an update", func(t *testing.T) {
+		withPendingCompaction(t, func(tx kv.Tx) error {
+			return s.View(tx.Context(), func(tx kv.Tx) error {
+				_, err := tx.Bucket(bucket)
+				return err
+			})
+		})
+	})
+
+	t.Run("view opened by a commit hook", func(t *testing.T) {
+		var viewErr error
+		withPendingCompaction(t, func(tx kv.Tx) error {
+			tx.(kv.CommitHookTx).OnCommit(func() {
+				viewErr = s.View(context.Background(), func(tx kv.Tx) error {
+					_, err := tx.Bucket(bucket)
+					return err
+				})
+			})
+			return nil
+		})
+		require.NoError(t, viewErr)
+	})
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	return fi.Size()
+}
@@ -26,6 +26,7 @@ const (
 	EUnauthorized        = "unauthorized"
 	EMethodNotAllowed    = "method not allowed"
 	ETooLarge            = "request too large"
+	ECanceled            = "canceled" // operation was canceled by the caller
 )
 
 // Error is the error struct of platform.
@@ -118,6 +119,19 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("<%s>", e.Code)
 }
 
+// Is reports whether the error, or any error it embeds, matches the target. An
+// *Error target matches errors with the same code and message.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || e == nil || t == nil || t.Code == "" {
+		return false
+	}
+	if e.Code == t.Code && e.Msg == t.Msg {
+		return true
+	}
+	return e.Err != nil && errors.Is(e.Err, target)
+}
+
 // ErrorCode returns the code of the root error, if available; otherwise returns EINTERNAL.
 func ErrorCode(err error) string {
 	if err == nil {
@@ -205,23 +219,23 @@ type errEncode struct {
 	Err  interface{} `json:"error,omitempty"`   // Err is a stack of additional errors.
 }
 
-// MarshalJSON recursively marshals the stack of Err.
+// MarshalJSON recursively marshals the stack of Err. An Err that is not an *Error
+// is flattened into its message, including the messages of any errors it wraps,
+// as its type can not be decoded again.
 func (e *Error) MarshalJSON() (result []byte, err error) {
 	ee := errEncode{
 		Code: e.Code,
 		Msg:  e.Msg,
 		Op:   e.Op,
 	}
-	if e.Err != nil {
-		if _, ok := e.Err.(*Error); ok {
-			_, err := e.Err.(*Error).MarshalJSON()
-			if err != nil {
-				return result, err
-			}
-			ee.Err = e.Err
-		} else {
-			ee.Err = e.Err.Error()
+	switch inner := e.Err.(type) {
+	case nil:
+	case *Error:
+		if inner != nil {
+			ee.Err = inner
 		}
+	default:
+		ee.Err = inner.Error()
 	}
 	return json.Marshal(ee)
 }
@@ -154,6 +154,64 @@ func TestErrorOp(t *testing.T) {
 		}
 	}
 }
+func TestErrorIs(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		target error
+		want   bool
+	}{
+		{
+			name:   "same code and message",
+			err:    &platform.Error{Code: platform.ENotFound, Msg: "bucket not found"},
+			target: &platform.Error{Code: platform.ENotFound, Msg: "bucket not found"},
+			want:   true,
+		},
+		{
+			name:   "target without message",
+			err:    &platform.Error{Code: platform.ENotFound, Msg: "bucket not found"},
+			target: &platform.Error{Code: platform.ENotFound},
+		},
+		{
+			name:   "both without message",
+			err:    &platform.Error{Code: platform.ENotFound},
+			target: &platform.Error{Code: platform.ENotFound},
+			want:   true,
+		},
+		{
+			name:   "embedded error",
+			err:    &platform.Error{Code: platform.EInternal, Msg: "failed", Err: &platform.Error{Code: platform.ENotFound, Msg: "bucket not found"}},
+			target: &platform.Error{Code: platform.ENotFound, Msg: "bucket not found"},
+			want:   true,
+		},
+		{
+			name:   "different message",
+			err:    &platform.Error{Code: platform.ENotFound, Msg: "org not found"},
+			target: &platform.Error{Code: platform.ENotFound, Msg: "bucket not found"},
+		},
+		{
+			name:   "different code",
+			err:    &platform.Error{Code: platform.EConflict, Msg: "bucket not found"},
+			target: &platform.Error{Code: platform.ENotFound},
+		},
+		{
+			name:   "target without code",
+			err:    &platform.Error{Code: platform.ENotFound},
+			target: &platform.Error{},
+		},
+		{
+			name:   "default error",
+			err:    errors.New("s"),
+			target: &platform.Error{Code: platform.EInternal},
+		},
+	}
+	for _, c := range cases {
+		if result := errors.Is(c.err, c.target); c.want != result {
+			t.Errorf("%s failed, want %t, got %t", c.name, c.want, result)
+		}
+	}
+}
+
 func TestErrorCode(t *testing.T) {
 	cases := []struct {
 		name string
@@ -278,6 +336,80 @@ func TestJSON(t *testing.T) {
 	}
 }
 
+func TestJSON_nested(t *testing.T) {
+	t.Run("deeply nested errors round trip", func(t *testing.T) {
+		var err error = errors.New("connection refused")
+		codes := []string{platform.EUnavailable, platform.EInternal, platform.EInvalid, platform.EConflict, platform.ENotFound}
+		for i, code := range codes {
+			err = &platform.Error{
+				Code: code,
+				Msg:  fmt.Sprintf("level %d", len(codes)-i),
+				Op:   fmt.Sprintf("kv.level%d", len(codes)-i),
+				Err:  err,
+			}
+		}
+		want := err.(*platform.Error)
+
+		encoded, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		got := new(platform.Error)
+		if err := json.Unmarshal(encoded, got); err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+
+		decodeEqual(t, want, got, "deeply nested")
+		if want.Error() != got.Error() {
+			t.Errorf("want error %q, got %q", want.Error(), got.Error())
+		}
+		if platform.ErrorCode(got) != platform.ENotFound {
+			t.Errorf("want code %q, got %q", platform.ENotFound, platform.ErrorCode(got))
+		}
+	})
+
+	t.Run("wrapped errors are flattened into their message", func(t *testing.T) {
+		want := &platform.Error{
+			Code: platform.EInternal,
+			Msg:  "failed to write shard",
+			Err: fmt.Errorf("dial node 2: %w", &platform.Error{
+				Code: platform.EUnavailable,
+				Msg:  "node unavailable",
+				Err:  errors.New("connection refused"),
+			}),
+		}
+
+		encoded, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		const expected = `{"code":"internal error","message":"failed to write shard","error":"dial node 2: node unavailable: connection refused"}`
+		if string(encoded) != expected {
+			t.Errorf("want encoded %s, got %s", expected, encoded)
+		}
+
+		got := new(platform.Error)
+		if err := json.Unmarshal(encoded, got); err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		decodeEqual(t, want, got, "wrapped")
+		if want.Error() != got.Error() {
+			t.Errorf("want error %q, got %q", want.Error(), got.Error())
+		}
+	})
+
+	t.Run("nil internal error is omitted", func(t *testing.T) {
+		var inner *platform.Error
+		encoded, err := json.Marshal(&platform.Error{Code: platform.EInvalid, Err: inner})
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		if string(encoded) != `{"code":"invalid"}` {
+			t.Errorf("want encoded %s, got %s", `{"code":"invalid"}`, encoded)
+		}
+	})
+}
+
 func decodeEqual(t *testing.T, want, result *platform.Error, caseName string) {
 	if want.Code != result.Code {
 		t.Errorf("%s code failed, want %s, got %s", caseName, want.Code, result.Code)
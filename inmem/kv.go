@@ -47,14 +47,33 @@ func (s *KVStore) View(ctx context.Context, fn func(kv.Tx) error) error {
 
 // Update opens up a transaction with a write lock.
 func (s *KVStore) Update(ctx context.Context, fn func(kv.Tx) error) error {
+	onCommit, err := s.update(ctx, fn)
+	if err != nil {
+		return err
+	}
+
+	// the hooks run once the store is unlocked, so a hook may use the store
+	for _, commitFn := range onCommit {
+		commitFn()
+	}
+	return nil
+}
+
+// update runs fn in a writable tx while the store is locked, returning the
+// functions to run once the tx is committed.
+func (s *KVStore) update(ctx context.Context, fn func(kv.Tx) error) ([]func(), error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return fn(&Tx{
+	tx := &Tx{
 		kv:       s,
 		writable: true,
 		ctx:      ctx,
-	})
+	}
+	if err := fn(tx); err != nil {
+		return nil, err
+	}
+	return tx.onCommit, nil
 }
 
 // CreateBucket creates a bucket with the provided name if one
@@ -119,6 +138,7 @@ type Tx struct {
 	kv       *KVStore
 	writable bool
 	ctx      context.Context
+	onCommit []func()
 }
 
 // Context returns the context for the transaction.
@@ -131,6 +151,17 @@ func (t *Tx) WithContext(ctx context.Context) {
 	t.ctx = ctx
 }
 
+// Writable returns true when the transaction is able to mutate data.
+func (t *Tx) Writable() bool {
+	return t.writable
+}
+
+// OnCommit adds a function to run after the transaction returns without error.
+// Functions added to a read only transaction are never run.
+func (t *Tx) OnCommit(fn func()) {
+	t.onCommit = append(t.onCommit, fn)
+}
+
 // Bucket retrieves the bucket at the provided key.
 func (t *Tx) Bucket(b []byte) (kv.Bucket, error) {
 	bkt, ok := t.kv.buckets[string(b)]
@@ -3,14 +3,17 @@ package inmem_test
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
 	"os"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/v2"
 	"github.com/influxdata/influxdb/v2/inmem"
 	"github.com/influxdata/influxdb/v2/kv"
 	"github.com/influxdata/influxdb/v2/kv/migration"
@@ -236,6 +239,82 @@ func fillBucket(t testing.TB, s *inmem.KVStore, bucket string, lines int64) {
 	}
 }
 
+func TestKVStore_Update_CommitHooksRunUnlocked(t *testing.T) {
+	type item struct {
+		ID    influxdb.ID
+		OrgID influxdb.ID
+		Name  string
+	}
+
+	s := inmem.NewKVStore()
+	mustCreateBucket(t, s, []byte("items"))
+	mustCreateBucket(t, s, []byte("items_index"))
+
+	decodeFn := func(key, val []byte) ([]byte, interface{}, error) {
+		var i item
+		err := json.Unmarshal(val, &i)
+		return key, i, err
+	}
+	convertFn := func(k []byte, v interface{}) (kv.Entity, error) {
+		i := v.(item)
+		return newItemEnt(i.ID, i.OrgID, i.Name, i), nil
+	}
+	indexStore := &kv.IndexStore{
+		Resource:   "item",
+		EntStore:   kv.NewStoreBase("item", []byte("items"), kv.EncIDKey, kv.EncBodyJSON, decodeFn, convertFn),
+		IndexStore: kv.NewOrgNameKeyStore("item", []byte("items_index"), false),
+		EventBus:   kv.NewEventBus(),
+	}
+	sub, err := indexStore.Subscribe(0, kv.EventBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// the subscriber reads the store after the first event, while the update
+	// waits to deliver the second
+	readErr := make(chan error, 1)
+	go func() {
+		<-sub.C
+		readErr <- s.View(context.Background(), func(tx kv.Tx) error {
+			_, err := indexStore.FindEnt(context.Background(), tx, kv.Entity{PK: kv.EncID(1)})
+			return err
+		})
+		<-sub.C
+	}()
+
+	updated := make(chan error, 1)
+	go func() {
+		updated <- s.Update(context.Background(), func(tx kv.Tx) error {
+			for _, i := range []item{{ID: 1, OrgID: 9000, Name: "item_1"}, {ID: 2, OrgID: 9000, Name: "item_2"}} {
+				if err := indexStore.Put(context.Background(), tx, newItemEnt(i.ID, i.OrgID, i.Name, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+
+	for _, ch := range []chan error{readErr, updated} {
+		select {
+		case err := <-ch:
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("subscriber reading the store deadlocked the update")
+		}
+	}
+}
+
+func newItemEnt(id, orgID influxdb.ID, name string, body interface{}) kv.Entity {
+	return kv.Entity{
+		PK:        kv.EncID(id),
+		UniqueKey: kv.Encode(kv.EncID(orgID), kv.EncString(name)),
+		Body:      body,
+	}
+}
+
 func mustCreateBucket(t testing.TB, store kv.SchemaStore, bucket []byte) {
 	t.Helper()
 
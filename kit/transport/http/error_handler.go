@@ -90,6 +90,7 @@ var influxDBErrorToStatusCode = map[string]int{
 	influxdb.EUnauthorized:        http.StatusUnauthorized,
 	influxdb.EMethodNotAllowed:    http.StatusMethodNotAllowed,
 	influxdb.ETooLarge:            http.StatusRequestEntityTooLarge,
+	influxdb.ECanceled:            499, // https://httpstatuses.com/499
 }
 
 var httpStatusCodeToInfluxDBError = map[int]string{}
@@ -0,0 +1,397 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+)
+
+// BufferedTx wraps a Tx, holding the writes made via its buckets in memory until
+// they are flushed to the wrapped Tx via Flush, i.e. for an operation that puts
+// many related entities. The writes are flushed in the order of their bucket name
+// and key, rather than the order they were made in, which keeps the writes of
+// the underlying store local. Reads made via the buckets of the BufferedTx see its
+// buffered writes, so a StoreBase or IndexStore used with it reads its own
+// writes. A BufferedTx is not safe for concurrent use.
+type BufferedTx struct {
+	tx Tx
+
+	buckets  map[string]*bufferedWrites
+	onCommit []func()
+}
+
+// NewBufferedTx creates a BufferedTx wrapping the tx.
+func NewBufferedTx(tx Tx) *BufferedTx {
+	return &BufferedTx{
+		tx:      tx,
+		buckets: make(map[string]*bufferedWrites),
+	}
+}
+
+// UpdateBuffered opens a transaction via Update, calling fn with it wrapped in a
+// BufferedTx, and flushes the buffered writes once fn returns without error. The
+// buffered writes are discarded along with the transaction when fn errors.
+func UpdateBuffered(ctx context.Context, store Store, fn func(Tx) error) error {
+	var btx *BufferedTx
+	err := store.Update(ctx, func(tx Tx) error {
+		btx = NewBufferedTx(tx)
+		if err := fn(btx); err != nil {
+			return err
+		}
+		return btx.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	for _, fn := range btx.onCommit {
+		fn()
+	}
+	return nil
+}
+
+// Bucket returns the bucket of the wrapped Tx, with its writes buffered.
+func (tx *BufferedTx) Bucket(b []byte) (Bucket, error) {
+	bkt, err := tx.tx.Bucket(b)
+	if err != nil {
+		return nil, err
+	}
+
+	writes, ok := tx.buckets[string(b)]
+	if !ok {
+		writes = &bufferedWrites{writes: make(map[string]bufferedWrite)}
+		tx.buckets[string(b)] = writes
+	}
+	return &bufferedBucket{tx: tx, bucket: bkt, writes: writes}, nil
+}
+
+// Context returns the context of the wrapped Tx.
+func (tx *BufferedTx) Context() context.Context {
+	return tx.tx.Context()
+}
+
+// WithContext associates a context with the wrapped Tx.
+func (tx *BufferedTx) WithContext(ctx context.Context) {
+	tx.tx.WithContext(ctx)
+}
+
+// Writable returns whether the wrapped Tx is writable. A wrapped Tx that does
+// not report it is taken to be writable.
+func (tx *BufferedTx) Writable() bool {
+	wtx, ok := tx.tx.(WritableTx)
+	return !ok || wtx.Writable()
+}
+
+// OnCommit adds a function to run after the wrapped Tx is committed. When the
+// wrapped Tx does not support commit hooks, the function is only run by
+// UpdateBuffered.
+func (tx *BufferedTx) OnCommit(fn func()) {
+	if hookTx, ok := tx.tx.(CommitHookTx); ok {
+		hookTx.OnCommit(fn)
+		return
+	}
+	tx.onCommit = append(tx.onCommit, fn)
+}
+
+// Len returns the number of buffered writes.
+func (tx *BufferedTx) Len() int {
+	var n int
+	for _, writes := range tx.buckets {
+		n += len(writes.writes)
+	}
+	return n
+}
+
+// Flush writes the buffered writes to the wrapped Tx, in order of their bucket
+// name and key, and empties the buffer.
+func (tx *BufferedTx) Flush() error {
+	names := make([]string, 0, len(tx.buckets))
+	for name := range tx.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		writes := tx.buckets[name]
+		if len(writes.writes) == 0 {
+			continue
+		}
+
+		bkt, err := tx.tx.Bucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		for _, key := range writes.sortedKeys() {
+			w := writes.writes[key]
+			if w.deleted {
+				err = bkt.Delete([]byte(key))
+			} else {
+				err = bkt.Put([]byte(key), w.value)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to flush key %q of bucket %q: %w", key, name, err)
+			}
+		}
+		writes.writes = make(map[string]bufferedWrite)
+	}
+	return nil
+}
+
+// bufferedWrites are the buffered writes of a bucket by their key.
+type bufferedWrites struct {
+	writes map[string]bufferedWrite
+}
+
+type bufferedWrite struct {
+	value   []byte
+	deleted bool
+}
+
+func (w *bufferedWrites) sortedKeys() []string {
+	keys := make([]string, 0, len(w.writes))
+	for key := range w.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// bufferedBucket is a bucket of a BufferedTx. Its reads see the buffered writes of
+// the bucket over the keys of the wrapped bucket.
+type bufferedBucket struct {
+	tx     *BufferedTx
+	bucket Bucket
+	writes *bufferedWrites
+}
+
+func (b *bufferedBucket) Get(key []byte) ([]byte, error) {
+	if w, ok := b.writes.writes[string(key)]; ok {
+		if w.deleted {
+			return nil, ErrKeyNotFound
+		}
+		return w.value, nil
+	}
+	return b.bucket.Get(key)
+}
+
+func (b *bufferedBucket) GetBatch(keys ...[]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		v, err := b.Get(key)
+		if err != nil {
+			if IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (b *bufferedBucket) Put(key, value []byte) error {
+	if !b.tx.Writable() {
+		return ErrTxNotWritable
+	}
+	b.writes.writes[string(key)] = bufferedWrite{value: append([]byte(nil), value...)}
+	return nil
+}
+
+func (b *bufferedBucket) Delete(key []byte) error {
+	if !b.tx.Writable() {
+		return ErrTxNotWritable
+	}
+	b.writes.writes[string(key)] = bufferedWrite{deleted: true}
+	return nil
+}
+
+// Cursor returns a cursor over the keys of the wrapped bucket and the buffered
+// writes made before it was created.
+func (b *bufferedBucket) Cursor(hints ...CursorHint) (Cursor, error) {
+	cur, err := b.bucket.Cursor(hints...)
+	if err != nil {
+		return nil, err
+	}
+	return b.newCursor(cur), nil
+}
+
+// ForwardCursor returns a forward cursor over the keys of the wrapped bucket and
+// the buffered writes made before it was created.
+func (b *bufferedBucket) ForwardCursor(seek []byte, opts ...CursorOption) (ForwardCursor, error) {
+	config := NewCursorConfig(opts...)
+	if config.Prefix != nil && !bytes.HasPrefix(seek, config.Prefix) {
+		return nil, fmt.Errorf("seek bytes %q not prefixed with %q: %w", string(seek), string(config.Prefix), ErrSeekMissingPrefix)
+	}
+
+	cur, err := b.bucket.Cursor()
+	if err != nil {
+		return nil, err
+	}
+	c := &bufferedForwardCursor{cursor: b.newCursor(cur), config: config}
+
+	var k, v []byte
+	if len(seek) == 0 && config.Direction == CursorDescending {
+		k, v = c.cursor.Last()
+	} else {
+		k, v = c.cursor.Seek(seek)
+	}
+	if !config.SkipFirst {
+		c.key, c.value = k, v
+	}
+	return c, nil
+}
+
+func (b *bufferedBucket) newCursor(cur Cursor) *bufferedCursor {
+	keys := b.writes.sortedKeys()
+	writes := make([]bufferedPair, len(keys))
+	for i, key := range keys {
+		writes[i] = bufferedPair{key: []byte(key), bufferedWrite: b.writes.writes[key]}
+	}
+	return &bufferedCursor{cursor: cur, writes: writes}
+}
+
+type bufferedPair struct {
+	key []byte
+	bufferedWrite
+}
+
+// bufferedCursor merges the buffered writes of a bucket, in order of their key,
+// over a cursor of the wrapped bucket. A buffered write hides the key of the
+// wrapped bucket it replaces or deletes. Every move is made from the current
+// key, by seeking the wrapped cursor to it, so a change of direction needs no
+// further bookkeeping.
+type bufferedCursor struct {
+	cursor Cursor
+	writes []bufferedPair
+
+	key []byte
+}
+
+func (c *bufferedCursor) Seek(prefix []byte) ([]byte, []byte) {
+	k, v := c.cursor.Seek(prefix)
+	return c.forward(k, v, c.writeIndex(prefix))
+}
+
+func (c *bufferedCursor) First() ([]byte, []byte) {
+	k, v := c.cursor.First()
+	return c.forward(k, v, 0)
+}
+
+func (c *bufferedCursor) Last() ([]byte, []byte) {
+	k, v := c.cursor.Last()
+	return c.backward(k, v, len(c.writes)-1)
+}
+
+func (c *bufferedCursor) Next() ([]byte, []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+	k, v := c.cursor.Seek(c.key)
+	if k != nil && bytes.Equal(k, c.key) {
+		k, v = c.cursor.Next()
+	}
+	i := c.writeIndex(c.key)
+	if i < len(c.writes) && bytes.Equal(c.writes[i].key, c.key) {
+		i++
+	}
+	return c.forward(k, v, i)
+}
+
+func (c *bufferedCursor) Prev() ([]byte, []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+	k, v := c.cursor.Seek(c.key)
+	if k == nil {
+		k, v = c.cursor.Last()
+	} else {
+		k, v = c.cursor.Prev()
+	}
+	return c.backward(k, v, c.writeIndex(c.key)-1)
+}
+
+// writeIndex returns the index of the first buffered write at or after the key.
+func (c *bufferedCursor) writeIndex(key []byte) int {
+	return sort.Search(len(c.writes), func(i int) bool {
+		return bytes.Compare(c.writes[i].key, key) >= 0
+	})
+}
+
+func (c *bufferedCursor) buffered(key []byte) bool {
+	i := c.writeIndex(key)
+	return i < len(c.writes) && bytes.Equal(c.writes[i].key, key)
+}
+
+// forward returns the lowest of the key of the wrapped cursor and the key of the
+// buffered write at i, skipping the keys hidden by the buffer.
+func (c *bufferedCursor) forward(k, v []byte, i int) ([]byte, []byte) {
+	for k != nil && c.buffered(k) {
+		k, v = c.cursor.Next()
+	}
+	for i < len(c.writes) && c.writes[i].deleted {
+		i++
+	}
+	if i < len(c.writes) && (k == nil || bytes.Compare(c.writes[i].key, k) < 0) {
+		k, v = c.writes[i].key, c.writes[i].value
+	}
+	c.key = k
+	return k, v
+}
+
+// backward returns the highest of the key of the wrapped cursor and the key of
+// the buffered write at i, skipping the keys hidden by the buffer.
+func (c *bufferedCursor) backward(k, v []byte, i int) ([]byte, []byte) {
+	for k != nil && c.buffered(k) {
+		k, v = c.cursor.Prev()
+	}
+	for i >= 0 && c.writes[i].deleted {
+		i--
+	}
+	if i >= 0 && (k == nil || bytes.Compare(c.writes[i].key, k) > 0) {
+		k, v = c.writes[i].key, c.writes[i].value
+	}
+	c.key = k
+	return k, v
+}
+
+// bufferedForwardCursor is a forward cursor over a bufferedCursor, honoring the
+// cursor config as the forward cursor of the bolt store does.
+type bufferedForwardCursor struct {
+	cursor *bufferedCursor
+	config CursorConfig
+
+	// previously seeked key/value
+	key, value []byte
+	closed     bool
+	seen       int
+}
+
+func (c *bufferedForwardCursor) Next() ([]byte, []byte) {
+	if c.closed || (c.config.Limit != nil && c.seen >= *c.config.Limit) {
+		return nil, nil
+	}
+
+	k, v := c.key, c.value
+	if k != nil {
+		c.key, c.value = nil, nil
+	} else if c.config.Direction == CursorDescending {
+		k, v = c.cursor.Prev()
+	} else {
+		k, v = c.cursor.Next()
+	}
+	if k == nil || (c.config.Prefix != nil && !bytes.HasPrefix(k, c.config.Prefix)) {
+		return nil, nil
+	}
+
+	c.seen++
+	return k, v
+}
+
+func (c *bufferedForwardCursor) Err() error {
+	return nil
+}
+
+func (c *bufferedForwardCursor) Close() error {
+	c.closed = true
+	return nil
+}
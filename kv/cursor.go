@@ -29,17 +29,19 @@ func NewStaticCursor(pairs []Pair) Cursor {
 	}
 }
 
-// Seek searches the slice for the first key with the provided prefix.
-func (c *staticCursor) Seek(prefix []byte) ([]byte, []byte) {
-	// TODO: do binary search for prefix since pairs are ordered.
-	for i, pair := range c.pairs {
-		if bytes.HasPrefix(pair.Key, prefix) {
-			c.idx = i
-			return pair.Key, pair.Value
-		}
+// Seek moves the cursor to the first key at or after the provided seek bytes,
+// as a bolt cursor does. When every key sorts before them the cursor is moved
+// past the last key, which Prev moves back from.
+func (c *staticCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.idx = sort.Search(len(c.pairs), func(i int) bool {
+		return bytes.Compare(c.pairs[i].Key, seek) >= 0
+	})
+	if c.idx == len(c.pairs) {
+		return nil, nil
 	}
 
-	return nil, nil
+	pair := c.pairs[c.idx]
+	return pair.Key, pair.Value
 }
 
 func (c *staticCursor) getValueAtIndex(delta int) ([]byte, []byte) {
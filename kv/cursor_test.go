@@ -224,6 +224,43 @@ func TestStaticCursor_Seek(t *testing.T) {
 				val: []byte("yoyo"),
 			},
 		},
+		{
+			name: "between keys",
+			args: args{
+				prefix: []byte("abd"),
+				pairs: []kv.Pair{
+					{
+						Key:   []byte("abc"),
+						Value: []byte("oyoy"),
+					},
+					{
+						Key:   []byte("bcd"),
+						Value: []byte("yoyo"),
+					},
+				},
+			},
+			wants: wants{
+				key: []byte("bcd"),
+				val: []byte("yoyo"),
+			},
+		},
+		{
+			name: "past the last key",
+			args: args{
+				prefix: []byte("cde"),
+				pairs: []kv.Pair{
+					{
+						Key:   []byte("abc"),
+						Value: []byte("oyoy"),
+					},
+					{
+						Key:   []byte("bcd"),
+						Value: []byte("yoyo"),
+					},
+				},
+			},
+			wants: wants{},
+		},
 	}
 
 	for _, tt := range tests {
@@ -242,3 +279,22 @@ func TestStaticCursor_Seek(t *testing.T) {
 		})
 	}
 }
+
+func TestStaticCursor_SeekPastLastThenPrev(t *testing.T) {
+	cur := kv.NewStaticCursor([]kv.Pair{
+		{Key: []byte("abc"), Value: []byte("oyoy")},
+		{Key: []byte("bcd"), Value: []byte("yoyo")},
+	})
+
+	if key, _ := cur.Seek([]byte("cde")); key != nil {
+		t.Fatalf("expected no key got %s", string(key))
+	}
+
+	key, val := cur.Prev()
+	if want, got := []byte("bcd"), key; !bytes.Equal(want, got) {
+		t.Errorf("expected to get key %s got %s", string(want), string(got))
+	}
+	if want, got := []byte("yoyo"), val; !bytes.Equal(want, got) {
+		t.Errorf("expected to get value %s got %s", string(want), string(got))
+	}
+}
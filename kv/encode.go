@@ -1,6 +1,7 @@
 package kv
 
 import (
+	"encoding/binary"
 	"errors"
 	"strings"
 
@@ -54,3 +55,62 @@ func EncBytes(b []byte) EncodeFn {
 		return b, nil
 	}
 }
+
+// EncIndexKeyParts joins a list of encodings together with IndexKeyParts.
+func EncIndexKeyParts(encodings ...EncodeFn) EncodeFn {
+	return func() ([]byte, error) {
+		parts := make([][]byte, 0, len(encodings))
+		for _, enc := range encodings {
+			part, err := enc()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		return IndexKeyParts(parts...), nil
+	}
+}
+
+// IndexKeyParts joins the parts of a compound key so that two different sets of
+// parts never produce the same key, regardless of the bytes within the parts.
+//
+// Each part is written as its length, encoded as an unsigned varint, followed by
+// the part's bytes:
+//
+//	uvarint(len(part[0])) | part[0] | uvarint(len(part[1])) | part[1] | ...
+//
+// The key for the leading parts is a prefix of the key for all parts, so a prefix
+// scan on i.e. the org ID part finds every key within the org.
+func IndexKeyParts(parts ...[]byte) []byte {
+	size := 0
+	for _, part := range parts {
+		size += binary.MaxVarintLen64 + len(part)
+	}
+
+	key := make([]byte, 0, size)
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, part := range parts {
+		n := binary.PutUvarint(lenBuf, uint64(len(part)))
+		key = append(key, lenBuf[:n]...)
+		key = append(key, part...)
+	}
+	return key
+}
+
+// DecodeIndexKeyParts splits a key created by IndexKeyParts into its parts.
+func DecodeIndexKeyParts(key []byte) ([][]byte, error) {
+	var parts [][]byte
+	for len(key) > 0 {
+		partLen, n := binary.Uvarint(key)
+		if n <= 0 {
+			return nil, errors.New("invalid index key part length")
+		}
+		key = key[n:]
+		if partLen > uint64(len(key)) {
+			return nil, errors.New("index key part exceeds key length")
+		}
+		parts = append(parts, key[:partLen])
+		key = key[partLen:]
+	}
+	return parts, nil
+}
@@ -0,0 +1,99 @@
+package kv_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/influxdata/influxdb/v2/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexKeyParts(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts [][]byte
+	}{
+		{
+			name:  "single part",
+			parts: [][]byte{[]byte("foo")},
+		},
+		{
+			name:  "multiple parts",
+			parts: [][]byte{[]byte("org"), []byte("name")},
+		},
+		{
+			name:  "parts containing separator bytes",
+			parts: [][]byte{[]byte("a/b"), {0x00, 0x03}, []byte("/")},
+		},
+		{
+			name:  "zero length parts",
+			parts: [][]byte{{}, []byte("name"), {}},
+		},
+		{
+			name:  "long part",
+			parts: [][]byte{bytes.Repeat([]byte("x"), 300), []byte("y")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := kv.IndexKeyParts(tt.parts...)
+
+			parts, err := kv.DecodeIndexKeyParts(key)
+			require.NoError(t, err)
+			require.Len(t, parts, len(tt.parts))
+			for i := range tt.parts {
+				assert.Equal(t, tt.parts[i], parts[i], "part %d", i)
+			}
+		})
+	}
+
+	t.Run("different splits never collide", func(t *testing.T) {
+		splits := [][][]byte{
+			{[]byte("ab"), []byte("c")},
+			{[]byte("a"), []byte("bc")},
+			{[]byte("abc")},
+			{[]byte("abc"), {}},
+			{{}, []byte("abc")},
+			{[]byte{0x01, 'a'}, []byte("bc")},
+		}
+
+		seen := make(map[string]int)
+		for i, parts := range splits {
+			key := string(kv.IndexKeyParts(parts...))
+			if j, ok := seen[key]; ok {
+				t.Fatalf("split %d collides with split %d", i, j)
+			}
+			seen[key] = i
+		}
+	})
+
+	t.Run("leading parts are a prefix", func(t *testing.T) {
+		prefix := kv.IndexKeyParts([]byte("org"))
+		key := kv.IndexKeyParts([]byte("org"), []byte("name"))
+		assert.True(t, bytes.HasPrefix(key, prefix))
+	})
+
+	t.Run("encode fns", func(t *testing.T) {
+		key, err := kv.EncIndexKeyParts(kv.EncID(1), kv.EncString("name"))()
+		require.NoError(t, err)
+
+		id, err := kv.EncID(1)()
+		require.NoError(t, err)
+		assert.Equal(t, kv.IndexKeyParts(id, []byte("name")), key)
+
+		_, err = kv.EncIndexKeyParts(kv.EncID(0))()
+		require.Error(t, err)
+	})
+
+	t.Run("decoding truncated keys fails", func(t *testing.T) {
+		key := kv.IndexKeyParts([]byte("org"), []byte("name"))
+
+		_, err := kv.DecodeIndexKeyParts(key[:len(key)-1])
+		require.Error(t, err)
+
+		_, err = kv.DecodeIndexKeyParts([]byte{0x80})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,105 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// ErrTxConflict is the error returned by a store when a transaction could not be
+// committed due to a concurrent transaction. Such a transaction is expected to
+// succeed once run again. See WithRetry.
+var ErrTxConflict = errors.New("transaction conflict")
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 10 * time.Millisecond
+)
+
+// RetryOpts configures the retries of WithRetry.
+type RetryOpts struct {
+	// MaxAttempts is the number of times the tx is run, including the first time.
+	// Defaults to 3.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, which doubles with every retry
+	// after. Defaults to 10ms.
+	Backoff time.Duration
+	// RetryableFn reports whether the tx may succeed once retried after failing with
+	// the error. Defaults to IsRetryable.
+	RetryableFn func(error) bool
+}
+
+// WithRetry runs fn within an update tx of the store, running it again within a
+// fresh tx when it fails with a retryable error, up to the max attempts. As the tx
+// of a failed attempt is rolled back, fn must not hold on to state between
+// attempts. The error of the last attempt is returned once the attempts are
+// exhausted, noting the number of attempts made.
+func WithRetry(ctx context.Context, store Store, fn func(tx Tx) error, opts RetryOpts) error {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultRetryAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultRetryBackoff
+	}
+	if opts.RetryableFn == nil {
+		opts.RetryableFn = IsRetryable
+	}
+
+	backoff := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := store.Update(ctx, fn)
+		if err == nil || !opts.RetryableFn(err) {
+			return err
+		}
+		if attempt >= opts.MaxAttempts {
+			return &influxdb.Error{
+				Code: influxdb.ErrorCode(err),
+				Msg:  fmt.Sprintf("transaction failed after %d attempts", attempt),
+				Err:  err,
+			}
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &influxdb.Error{
+				Code: influxdb.ECanceled,
+				Msg:  "retry canceled",
+				Err:  ctx.Err(),
+			}
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// IsRetryable reports whether a tx that failed with the error may succeed once
+// retried. The EConflict and EInvalid errors of the stores are deterministic and
+// are never retried. Otherwise the error must be caused by an ErrTxConflict, or
+// by an error reporting itself as Temporary.
+func IsRetryable(err error) bool {
+	switch influxdb.ErrorCode(err) {
+	case influxdb.EConflict, influxdb.EInvalid:
+		return false
+	}
+
+	for err != nil {
+		if err == ErrTxConflict {
+			return true
+		}
+		if t, ok := err.(interface{ Temporary() bool }); ok && t.Temporary() {
+			return true
+		}
+
+		if iErr, ok := err.(*influxdb.Error); ok {
+			err = iErr.Err
+			continue
+		}
+		err = errors.Unwrap(err)
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package kv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kv"
+	"github.com/influxdata/influxdb/v2/kv/migration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStore fails the first updates with the errors provided, before running
+// the tx against the underlying store.
+type flakyStore struct {
+	kv.Store
+
+	errs     []error
+	attempts int
+}
+
+func (s *flakyStore) Update(ctx context.Context, fn func(kv.Tx) error) error {
+	s.attempts++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	return s.Store.Update(ctx, fn)
+}
+
+func TestWithRetry(t *testing.T) {
+	newFlakyStore := func(t *testing.T, errs ...error) (*flakyStore, *kv.StoreBase, func()) {
+		t.Helper()
+
+		kvStore, done, err := NewTestBoltStore(t)
+		require.NoError(t, err)
+
+		bktName := []byte("foo_retry")
+		err = migration.CreateBuckets("add foo bucket", bktName).Up(context.Background(), kvStore)
+		require.NoError(t, err)
+
+		base := kv.NewStoreBase("foo", bktName, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+		return &flakyStore{Store: kvStore, errs: errs}, base, done
+	}
+
+	putFoo := func(base *kv.StoreBase) func(tx kv.Tx) error {
+		return func(tx kv.Tx) error {
+			return base.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1"))
+		}
+	}
+
+	opts := kv.RetryOpts{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	t.Run("retries a retryable error", func(t *testing.T) {
+		store, base, done := newFlakyStore(t, &influxdb.Error{Code: influxdb.EInternal, Err: kv.ErrTxConflict})
+		defer done()
+
+		require.NoError(t, kv.WithRetry(context.TODO(), store, putFoo(base), opts))
+		assert.Equal(t, 2, store.attempts)
+
+		view(t, store, func(tx kv.Tx) error {
+			actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+			require.NoError(t, err)
+			assert.Equal(t, newFooEnt(1, 9000, "foo_1").Body, actual)
+			return nil
+		})
+	})
+
+	t.Run("does not retry deterministic errors", func(t *testing.T) {
+		for _, code := range []string{influxdb.EConflict, influxdb.EInvalid} {
+			store, base, done := newFlakyStore(t, &influxdb.Error{Code: code, Err: kv.ErrTxConflict})
+
+			err := kv.WithRetry(context.TODO(), store, putFoo(base), opts)
+			assert.Equal(t, code, influxdb.ErrorCode(err))
+			assert.Equal(t, 1, store.attempts)
+			done()
+		}
+	})
+
+	t.Run("exhausted attempts are reported", func(t *testing.T) {
+		store, base, done := newFlakyStore(t, kv.ErrTxConflict, kv.ErrTxConflict, kv.ErrTxConflict)
+		defer done()
+
+		err := kv.WithRetry(context.TODO(), store, putFoo(base), opts)
+		require.Error(t, err)
+		assert.Equal(t, 3, store.attempts)
+		assert.Contains(t, err.Error(), "after 3 attempts")
+	})
+
+	t.Run("canceled context stops the retries", func(t *testing.T) {
+		store, base, done := newFlakyStore(t, kv.ErrTxConflict)
+		defer done()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := kv.WithRetry(ctx, store, putFoo(base), kv.RetryOpts{MaxAttempts: 3, Backoff: time.Hour})
+		assert.Equal(t, influxdb.ECanceled, influxdb.ErrorCode(err))
+		assert.Equal(t, 1, store.attempts)
+	})
+}
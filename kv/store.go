@@ -41,6 +41,40 @@ type SchemaStore interface {
 	DeleteBucket(ctx context.Context, bucket []byte) error
 }
 
+// BucketCompactor is a store that is able to reclaim the space freed by deleting
+// keys from a bucket. The bolt store reclaims space by rewriting its file, which
+// compacts every bucket at once. The inmem store releases memory as keys are
+// deleted and does not implement it.
+type BucketCompactor interface {
+	// CompactBucket reclaims the space freed within the bucket.
+	CompactBucket(ctx context.Context, bucket []byte) error
+}
+
+// CompactBucket reclaims the space freed within the bucket when the store is a
+// BucketCompactor. It is a no-op for stores that are not.
+func CompactBucket(ctx context.Context, store Store, bucket []byte) error {
+	compactor, ok := store.(BucketCompactor)
+	if !ok {
+		return nil
+	}
+	return compactor.CompactBucket(ctx, bucket)
+}
+
+// BucketStats describes the keys held by a bucket.
+type BucketStats struct {
+	// KeyN is the number of keys in the bucket.
+	KeyN int `json:"keyN"`
+	// Bytes approximates the number of bytes the bucket occupies in the store.
+	Bytes int `json:"bytes"`
+}
+
+// StatsBucket is a bucket that is able to report its stats without a scan of its
+// keys. The bolt store reports the page usage of its buckets.
+type StatsBucket interface {
+	// Stats returns the stats of the bucket.
+	Stats() BucketStats
+}
+
 // Store is an interface for a generic key value store. It is modeled after
 // the boltdb database struct.
 type Store interface {
@@ -65,6 +99,23 @@ type Tx interface {
 	WithContext(ctx context.Context)
 }
 
+// WritableTx is a transaction that reports whether it is able to mutate data.
+type WritableTx interface {
+	Tx
+
+	// Writable returns true when the transaction was opened via Update.
+	Writable() bool
+}
+
+// CommitHookTx is a transaction that is able to run functions once it has been
+// committed. The functions are not run when the transaction is rolled back.
+type CommitHookTx interface {
+	Tx
+
+	// OnCommit adds a function to run after the transaction is committed.
+	OnCommit(fn func())
+}
+
 type CursorPredicateFunc func(key, value []byte) bool
 
 type CursorHints struct {
@@ -1,10 +1,16 @@
 package kv
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/influxdata/influxdb/v2"
 	"github.com/influxdata/influxdb/v2/kit/tracing"
@@ -16,6 +22,10 @@ type Entity struct {
 	UniqueKey EncodeFn
 
 	Body interface{}
+
+	// ExpiresAt is the time after which the entity is no longer found. The zero
+	// value never expires. Only a StoreBase with an ExpiryStore honors it.
+	ExpiresAt time.Time
 }
 
 // EncodeEntFn encodes the entity. This is used both for the key and vals in the store base.
@@ -94,7 +104,19 @@ func NewOrgNameKeyStore(resource string, bktName []byte, caseSensitive bool) *St
 		return ent, nil
 	}
 
-	return NewStoreBase(resource, bktName, EncUniqKey, EncIDKey, DecIndexID, decValToEntFn)
+	store := NewStoreBase(resource, bktName, EncUniqKey, EncIDKey, DecIndexID, decValToEntFn)
+	store.KeyStringFn = OrgNameKeyString
+	return store
+}
+
+// OrgNameKeyString renders a key of an organization id and name index, as created
+// by NewOrgNameKeyStore, as org=<id>/name=<name>.
+func OrgNameKeyString(k []byte) string {
+	orgID, name, err := DecodeOrgNameKey(k)
+	if err != nil {
+		return defaultKeyString(k)
+	}
+	return fmt.Sprintf("org=%s/name=%s", orgID, name)
 }
 
 // StoreBase is the base behavior for accessing buckets in kv. It provides mechanisms that can
@@ -107,6 +129,76 @@ type StoreBase struct {
 	EncodeEntBodyFn   EncodeEntFn
 	DecodeEntFn       DecodeBucketValFn
 	ConvertValToEntFn ConvertValToEntFn
+
+	// ConvertValToSummaryFn, when set, decodes a raw bucket value into a small
+	// summary of the entity, i.e. only its ID and name, for finds made with
+	// FindOpts.SummaryOnly. It should skip over the parts of the body the summary
+	// does not need rather than decode them.
+	ConvertValToSummaryFn DecodeBucketValFn
+
+	// DecodedNameFn, when set, returns the name of a decoded value of the bucket,
+	// by which finds made with SortByName are sorted. It is called with the
+	// values captured by the find, i.e. summaries for a SummaryOnly find.
+	DecodedNameFn func(decodedVal interface{}) string
+
+	// DecodedOrgIDFn, when set, returns the ID of the org a decoded value of the
+	// bucket belongs to, by which FindEntInOrg scopes its finds.
+	DecodedOrgIDFn func(decodedVal interface{}) influxdb.ID
+
+	// KeyNormalizeFn, when set, normalizes every encoded entity key before it
+	// is used to store or look up an entity. I.e. providing bytes.ToLower to an
+	// index store results in a case insensitive index, while the entity store
+	// retains the original casing.
+	KeyNormalizeFn func([]byte) []byte
+
+	// KeyValidateFn, when set, validates the encoded key of every entity put, i.e.
+	// to reject a PK that is not an encoded platform ID. A key it returns an error
+	// for fails the put with an EInvalid error naming the key.
+	KeyValidateFn func(key []byte) error
+
+	// KeyStringFn, when set, renders an encoded key in a human readable form for
+	// the error messages of the store. See KeyString.
+	KeyStringFn func(key []byte) string
+
+	// Metrics, when set, observes the duration of the store's operations.
+	Metrics Metrics
+
+	// ExpiryStore, when set, records the ExpiresAt of each entity put. Expired
+	// entities are not found by FindEnt, but remain in the bucket, and visible
+	// to Find, until removed by SweepExpired. See NewExpiryStore.
+	ExpiryStore *StoreBase
+
+	// integrityCheck is set by WithIntegrityCheck.
+	integrityCheck bool
+	// allowEmptyKey is set by WithEmptyKeys.
+	allowEmptyKey bool
+	// maxValueSize is set by WithMaxValueSize.
+	maxValueSize int
+	// schemaVersion and schemaMigrations are set by WithSchemaVersion and
+	// WithSchemaMigration.
+	schemaVersion    uint8
+	schemaMigrations map[uint8]SchemaMigrationFn
+	// shards is set by WithShards.
+	shards int
+}
+
+// Metrics observes the duration of store operations by resource. An IndexStore
+// reports the index lookups it performs through the Metrics of its entity store.
+type Metrics interface {
+	ObservePut(resource string, dur time.Duration)
+	ObserveFind(resource string, dur time.Duration)
+	ObserveDelete(resource string, dur time.Duration)
+	ObserveIndexLookup(resource string, dur time.Duration)
+	ObserveDecode(resource string, dur time.Duration)
+}
+
+// IndexFetchMetrics is implemented by the Metrics that also observe the fetch of
+// the entity an index lookup resolved to, so the time an IndexStore spends on the
+// index hop, observed via ObserveIndexLookup, can be told apart from the fetch
+// that follows it. It is optional, as adding it to Metrics would break existing
+// implementations.
+type IndexFetchMetrics interface {
+	ObserveIndexFetch(resource string, dur time.Duration)
 }
 
 // NewStoreBase creates a new store base.
@@ -121,12 +213,61 @@ func NewStoreBase(resource string, bktName []byte, encKeyFn, encBodyFn EncodeEnt
 	}
 }
 
+// KeyString returns the human readable form of the key for error messages, via the
+// KeyStringFn when set. Otherwise printable keys are returned as is, and keys with
+// unprintable bytes, i.e. binary encoded composite keys, as a hex dump.
+func (s *StoreBase) KeyString(key []byte) string {
+	if s.KeyStringFn != nil {
+		return s.KeyStringFn(key)
+	}
+	return defaultKeyString(key)
+}
+
+func defaultKeyString(key []byte) string {
+	for _, r := range string(key) {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return "0x" + hex.EncodeToString(key)
+		}
+	}
+	return string(key)
+}
+
 // EntKey returns the key for the entity provided. This is a shortcut for grabbing the EntKey without
 // having to juggle the encoding funcs.
 func (s *StoreBase) EntKey(ctx context.Context, ent Entity) ([]byte, error) {
 	span, ctx := s.startSpan(ctx)
 	defer span.Finish()
-	return s.encodeEnt(ctx, ent, s.EncodeEntKeyFn)
+
+	key, err := s.encodeEnt(ctx, ent, s.EncodeEntKeyFn)
+	if err != nil {
+		return nil, err
+	}
+	if s.KeyNormalizeFn != nil {
+		key = s.KeyNormalizeFn(key)
+	}
+	if len(key) == 0 && !s.allowEmptyKey {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("provided %s key is empty", s.Resource),
+		}
+	}
+	return key, nil
+}
+
+// WithMaxValueSize limits the encoded size of the entity bodies put into the store
+// to n bytes and returns the store. A put of a larger body fails with an EInvalid
+// error. Values are not limited by default, or when n is 0.
+func (s *StoreBase) WithMaxValueSize(n int) *StoreBase {
+	s.maxValueSize = n
+	return s
+}
+
+// WithEmptyKeys allows the store's entities to be keyed by an empty key and returns
+// the store. By default EntKey, and so Put, reject an entity whose key encodes to
+// zero bytes, as it is most likely an entity missing its key fields.
+func (s *StoreBase) WithEmptyKeys() *StoreBase {
+	s.allowEmptyKey = true
+	return s
 }
 
 type (
@@ -136,23 +277,89 @@ type (
 	DeleteOpts struct {
 		DeleteRelationFns []DeleteRelationsFn
 		FilterFn          FilterFn
+
+		// BatchSize, when greater than 0, limits the number of entities a single
+		// Delete removes. DeleteBatches deletes BatchSize entities per tx.
+		BatchSize int
 	}
 
 	// DeleteRelationsFn is a hook that a store that composes other stores can use to
 	// delete an entity and any relations it may share. An example would be deleting an
 	// an entity and its associated index.
 	DeleteRelationsFn func(key []byte, decodedVal interface{}) error
+
+	deleteEntOption struct {
+		softDelete bool
+		force      bool
+		matchFn    func(existing Entity) bool
+	}
+
+	// DeleteEntOptionFn provides a hint to the store about how to delete an entity.
+	DeleteEntOptionFn func(o *deleteEntOption)
 )
 
+// DeleteEntSoft marks the entity as deleted and removes its indexes, freeing up its
+// unique keys, while keeping the entity itself so it can be restored. This only
+// applies to an IndexStore with a TombstoneStore; see IndexStore.Restore.
+func DeleteEntSoft() DeleteEntOptionFn {
+	return func(o *deleteEntOption) {
+		o.softDelete = true
+	}
+}
+
+// DeleteEntForce deletes the entity even when its index entries are already
+// missing, i.e. after an earlier partial failure left the entity unindexed. An
+// index key that does not resolve to the entity is left as is, and logged to the
+// Logger of the store when set. Without it, deleting an entity that is missing an
+// index entry fails with an ENotFound error. This only applies to an IndexStore.
+func DeleteEntForce() DeleteEntOptionFn {
+	return func(o *deleteEntOption) {
+		o.force = true
+	}
+}
+
+// DeleteEntIfMatch deletes the entity only when match returns true for the stored
+// entity, failing with an EConflict otherwise. The stored entity is read within
+// the tx, so it can not change between the match and the delete. The entity passed
+// to match is converted from the stored value, with its Body holding the decoded
+// value. I.e. a job can only be deleted while it is still "pending". This only
+// applies to an IndexStore.
+func DeleteEntIfMatch(match func(existing Entity) bool) DeleteEntOptionFn {
+	return func(o *deleteEntOption) {
+		o.matchFn = match
+	}
+}
+
+func newDeleteEntOption(opts ...DeleteEntOptionFn) deleteEntOption {
+	var opt deleteEntOption
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
 // Delete deletes entities by the provided options.
 func (s *StoreBase) Delete(ctx context.Context, tx Tx, opts DeleteOpts) error {
 	span, ctx := s.startSpan(ctx)
 	defer span.Finish()
+	span.SetTag("Operation", opDelete)
+
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObserveDelete(s.Resource, time.Since(start)) }(time.Now())
+	}
+
+	_, err := s.deleteMany(ctx, tx, opts)
+	return err
+}
 
+// deleteMany deletes entities by the provided options and returns the number of
+// entities deleted.
+func (s *StoreBase) deleteMany(ctx context.Context, tx Tx, opts DeleteOpts) (int, error) {
 	if opts.FilterFn == nil {
-		return nil
+		return 0, nil
 	}
 
+	var n int
 	findOpts := FindOpts{
 		CaptureFn: func(k []byte, v interface{}) error {
 			for _, deleteFn := range opts.DeleteRelationFns {
@@ -160,22 +367,165 @@ func (s *StoreBase) Delete(ctx context.Context, tx Tx, opts DeleteOpts) error {
 					return err
 				}
 			}
-			return s.bucketDelete(ctx, tx, k)
+			if err := s.deleteExpiry(ctx, tx, k); err != nil {
+				return err
+			}
+			if err := s.bucketDelete(ctx, tx, k); err != nil {
+				return err
+			}
+			n++
+			return nil
 		},
 		FilterEntFn: opts.FilterFn,
+		Limit:       opts.BatchSize,
 	}
-	return s.Find(ctx, tx, findOpts)
+	if err := s.Find(ctx, tx, findOpts); err != nil {
+		return 0, err
+	}
+	return n, nil
 }
 
-// DeleteEnt deletes an entity.
-func (s *StoreBase) DeleteEnt(ctx context.Context, tx Tx, ent Entity) error {
+// DeletePrefix deletes every entity whose key has the prefix, i.e. all the entities
+// of an organization in a bucket keyed by organization id, and returns the number
+// of entities deleted. An empty prefix is rejected with an EInvalid error rather
+// than deleting the whole bucket.
+func (s *StoreBase) DeletePrefix(ctx context.Context, tx Tx, prefix []byte) (int, error) {
 	span, ctx := s.startSpan(ctx)
 	defer span.Finish()
+	span.SetTag("Operation", opDelete)
+
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObserveDelete(s.Resource, time.Since(start)) }(time.Now())
+	}
+
+	n, err := s.deletePrefix(ctx, tx, prefix)
+	span.SetTag("Count", n)
+	return n, err
+}
+
+// deletePrefix deletes the entities with the prefix, calling the deleteRelationFns
+// with each entity before it is deleted. The keys are collected before any are
+// deleted, as deleting the key a cursor is at moves the cursor.
+func (s *StoreBase) deletePrefix(ctx context.Context, tx Tx, prefix []byte, deleteRelationFns ...DeleteRelationsFn) (int, error) {
+	if len(prefix) == 0 {
+		return 0, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("a prefix is required to delete %s entities by prefix", s.Resource),
+		}
+	}
+
+	cur, err := s.bucketCursor(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		keys [][]byte
+		vals []interface{}
+	)
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		if err := checkScanCtx(ctx, len(keys)+1); err != nil {
+			return 0, err
+		}
+		key, val, err := s.decodeVal(k, v)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, append([]byte(nil), key...))
+		vals = append(vals, val)
+	}
+
+	for i, k := range keys {
+		for _, deleteFn := range deleteRelationFns {
+			if err := deleteFn(k, vals[i]); err != nil {
+				return 0, err
+			}
+		}
+		if err := s.deleteExpiry(ctx, tx, k); err != nil {
+			return 0, err
+		}
+		if err := s.bucketDelete(ctx, tx, k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// DeleteBatches deletes entities by the provided options in batches of up to
+// BatchSize entities, each in its own tx opened via the store's Update, and
+// returns the number of entities deleted. This relaxes the all or nothing
+// guarantee of Delete: when an error is returned, or the context is canceled,
+// the batches committed before it remain deleted. Every batch finds the entities
+// left to delete anew, so an interrupted delete is resumed by calling
+// DeleteBatches again with the same options.
+func (s *StoreBase) DeleteBatches(ctx context.Context, store Store, opts DeleteOpts) (int, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opDelete)
+
+	return deleteBatches(ctx, store, opts, s.deleteMany)
+}
+
+func deleteBatches(ctx context.Context, store Store, opts DeleteOpts, deleteFn func(context.Context, Tx, DeleteOpts) (int, error)) (int, error) {
+	if opts.BatchSize <= 0 {
+		return 0, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("batch size must be greater than 0; got %d", opts.BatchSize),
+		}
+	}
+
+	var total int
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, &influxdb.Error{
+				Code: influxdb.ECanceled,
+				Msg:  "delete canceled",
+				Err:  err,
+			}
+		}
+
+		var n int
+		err := store.Update(ctx, func(tx Tx) error {
+			var err error
+			n, err = deleteFn(ctx, tx, opts)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < opts.BatchSize {
+			return total, nil
+		}
+	}
+}
+
+// DeleteEnt deletes an entity. A StoreBase does not support soft deletes, so
+// DeleteEntSoft results in an EInvalid error.
+func (s *StoreBase) DeleteEnt(ctx context.Context, tx Tx, ent Entity, opts ...DeleteEntOptionFn) error {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opDelete)
+
+	if opt := newDeleteEntOption(opts...); opt.softDelete {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s entities are not soft deleted", s.Resource),
+		}
+	}
+
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObserveDelete(s.Resource, time.Since(start)) }(time.Now())
+	}
 
 	encodedID, err := s.EntKey(ctx, ent)
 	if err != nil {
 		return err
 	}
+	span.SetTag("KeyHash", hashKey(encodedID))
+	if err := s.deleteExpiry(ctx, tx, encodedID); err != nil {
+		return err
+	}
 	return s.bucketDelete(ctx, tx, encodedID)
 }
 
@@ -191,6 +541,69 @@ type (
 		Prefix      []byte
 		CaptureFn   FindCaptureFn
 		FilterEntFn FilterFn
+
+		// After, when set, skips every key up to and including it, i.e. the key of
+		// the last entity of the previous page, so a page within a prefix starts
+		// from where the last page ended rather than from an offset. A descending
+		// find skips every key from After onwards instead. Combined with a Prefix,
+		// the cursor starts at whichever of the two is further along, and the find
+		// ends once the keys no longer have the prefix, as a descending find with
+		// a prefix always does.
+		After []byte
+
+		// FilterDecodedFn, when set, skips the entities it returns false for. It is
+		// called with the entity converted from the decoded value, after the
+		// FilterEntFn, so a filter can be applied to the entity's fields rather
+		// than a raw key and value. Only entities passing both filters count
+		// towards the Offset and Limit.
+		FilterDecodedFn EntityFilterFn
+
+		// ReadOnly asserts the find is performed in a transaction opened via
+		// View. Find fails when provided a writable transaction.
+		ReadOnly bool
+
+		// Sort is the order in which the entities found are captured. Defaults to
+		// SortByKey. Only Find and IndexStore.FindIndex honor it.
+		Sort FindSort
+
+		// SummaryOnly decodes the values found via the store's ConvertValToSummaryFn
+		// rather than its DecodeEntFn, so the CaptureFn and FilterEntFn are called
+		// with the summaries. It is only honored by Find, and can not be combined
+		// with a FilterDecodedFn.
+		SummaryOnly bool
+
+		// ReportMore peeks one entity past the Limit, so FindWithResult can report
+		// whether the find was cut short by the Limit. The entity peeked at is not
+		// captured. It has no effect without a Limit.
+		ReportMore bool
+
+		// MaxBytes, when greater than 0, caps the cumulative size of the stored
+		// values of the entities captured. The find stops before the entity that
+		// would take the total past the cap, reporting HasMore in the result of
+		// FindWithResult. The first entity is captured whatever its size, so a
+		// find paged by the After key always makes progress. It is only honored
+		// by Find with SortByKey.
+		MaxBytes int
+
+		// KeysOnly skips decoding the values found, so the CaptureFn and
+		// FilterEntFn are called with each key and a nil decoded value. It can not
+		// be combined with a FilterDecodedFn, SummaryOnly or a Sort other than
+		// SortByKey. See FindKeys.
+		KeysOnly bool
+
+		// KeyFormatFn, when set, formats each key returned by FindKeys, i.e. to
+		// strip the prefix of a composite key.
+		KeyFormatFn func(key []byte) ([]byte, error)
+	}
+
+	// FindResult describes the outcome of a find. See FindWithResult.
+	FindResult struct {
+		// Count is the number of entities captured.
+		Count int
+		// HasMore is true when the Limit left out an entity that would have been
+		// captured otherwise, for a find with ReportMore set, or when the MaxBytes
+		// cap did.
+		HasMore bool
 	}
 
 	// FindCaptureFn is the mechanism for closing over the key and decoded value pair
@@ -203,124 +616,683 @@ type (
 	// was seen is one that is valid and should be either captured or deleted (depending on
 	// the caller of the filter func).
 	FilterFn func(key []byte, decodedVal interface{}) bool
+
+	// EntityFilterFn indicates the entity is one that should be found.
+	EntityFilterFn func(ent Entity) bool
 )
 
 // Find provides a mechanism for looking through the bucket via
 // the set options. When a prefix is provided, the prefix is used to
-// seek the bucket.
+// seek the bucket. A descending find walks the bucket from the last
+// key, or with a prefix, from the last key with the prefix until the
+// prefix no longer matches. The scan is abandoned with an ECanceled
+// error when the context is canceled.
+//
+// Entities are captured in ascending key order, or descending key order
+// for a descending find, unless another order is requested via the
+// Sort option.
 func (s *StoreBase) Find(ctx context.Context, tx Tx, opts FindOpts) error {
+	_, err := s.FindWithResult(ctx, tx, opts)
+	return err
+}
+
+// FindWithResult finds the entities as Find does, and returns the number of
+// entities captured. When the options set both a Limit and ReportMore, the
+// result reports whether there are more entities past the Limit, i.e. so a list
+// can offer to load more rather than being silently truncated.
+func (s *StoreBase) FindWithResult(ctx context.Context, tx Tx, opts FindOpts) (FindResult, error) {
 	span, ctx := s.startSpan(ctx)
 	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	if opts.ReadOnly {
+		if err := assertReadOnly(tx); err != nil {
+			return FindResult{}, err
+		}
+	}
+
+	if opts.Sort != SortByKey {
+		if opts.KeysOnly {
+			return FindResult{}, validKeysOnly(opts)
+		}
+		if opts.MaxBytes > 0 {
+			return FindResult{}, &influxdb.Error{
+				Code: influxdb.EInvalid,
+				Msg:  "finds capped by size can only be sorted by key",
+			}
+		}
+		sortedOpts, captureFn, err := s.sortedFind(opts)
+		if err != nil {
+			return FindResult{}, err
+		}
+		if err := s.Find(ctx, tx, sortedOpts); err != nil {
+			return FindResult{}, err
+		}
+		result, err := captureFn()
+		result.HasMore = result.HasMore && opts.ReportMore
+		return result, err
+	}
+
+	decodeFn, err := s.findDecodeFn(opts)
+	if err != nil {
+		return FindResult{}, err
+	}
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObserveFind(s.Resource, time.Since(start)) }(time.Now())
+		valDecodeFn := decodeFn
+		decodeFn = func(key, val []byte) ([]byte, interface{}, error) {
+			defer func(start time.Time) { s.Metrics.ObserveDecode(s.Resource, time.Since(start)) }(time.Now())
+			return valDecodeFn(key, val)
+		}
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	cur, err := s.bucketCursor(ctx, tx)
 	if err != nil {
-		return err
+		return FindResult{}, err
 	}
 
+	reportMore := opts.ReportMore && opts.Limit > 0
 	iter := &iterator{
-		cursor:     cur,
-		descending: opts.Descending,
-		limit:      opts.Limit,
-		offset:     opts.Offset,
-		prefix:     opts.Prefix,
-		decodeFn:   s.DecodeEntFn,
-		filterFn:   opts.FilterEntFn,
+		cursor:          cur,
+		descending:      opts.Descending,
+		limit:           opts.Limit,
+		offset:          opts.Offset,
+		prefix:          opts.Prefix,
+		after:           opts.After,
+		decodeFn:        decodeFn,
+		filterFn:        opts.FilterEntFn,
+		filterDecodedFn: s.filterDecodedFn(opts.FilterDecodedFn),
+	}
+	if reportMore {
+		// the entity past the limit is only peeked at
+		iter.limit++
 	}
 
-	for k, v, err := iter.Next(ctx); k != nil; k, v, err = iter.Next(ctx) {
+	var (
+		result FindResult
+		size   int
+	)
+	for {
+		k, v, err := iter.Next(ctx)
 		if err != nil {
-			return err
+			return FindResult{}, err
+		}
+		if k != nil && reportMore && result.Count == opts.Limit {
+			result.HasMore = true
+			k = nil
+		}
+		if k != nil && opts.MaxBytes > 0 {
+			if result.Count > 0 && size+iter.size > opts.MaxBytes {
+				result.HasMore = true
+				k = nil
+			}
+			size += iter.size
+		}
+		if k == nil {
+			span.SetTag("Count", result.Count)
+			return result, nil
 		}
 		if err := opts.CaptureFn(k, v); err != nil {
+			return FindResult{}, err
+		}
+		result.Count++
+	}
+}
+
+// FindStream calls fn with each entity found via the set options as the cursor
+// advances, rather than collecting them. The CaptureFn of the options is replaced.
+// When fn returns an error the scan is stopped and the error is returned.
+func (s *StoreBase) FindStream(ctx context.Context, tx Tx, opts FindOpts, fn func(Entity) error) error {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	if opts.SummaryOnly {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  "summary only finds can not be streamed as entities",
+		}
+	}
+	if opts.KeysOnly {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  "keys only finds can not be streamed as entities",
+		}
+	}
+
+	opts.CaptureFn = func(k []byte, v interface{}) error {
+		ent, err := s.convertValToEnt(k, v)
+		if err != nil {
+			return err
+		}
+		return fn(ent)
+	}
+	return s.Find(ctx, tx, opts)
+}
+
+type (
+	// FindPageOpts provides a means to page through the bucket by key. The AfterKey
+	// is the NextKey of a previous page; when empty the first page is returned. Paging
+	// by key avoids walking all preceding entries as an offset would require.
+	FindPageOpts struct {
+		AfterKey    []byte
+		Limit       int
+		Prefix      []byte
+		FilterEntFn FilterFn
+	}
+
+	// Page is a single page of decoded values found via FindPage. When there are no
+	// more pages the NextKey is nil.
+	Page struct {
+		Values  []interface{}
+		NextKey []byte
+	}
+)
+
+// FindPage returns a page of decoded values in ascending key order. Pages are
+// anchored on the key of the last value seen, so entities that are deleted or
+// inserted between calls do not cause values to be repeated or skipped after
+// the anchor.
+func (s *StoreBase) FindPage(ctx context.Context, tx Tx, opts FindPageOpts) (Page, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObserveFind(s.Resource, time.Since(start)) }(time.Now())
+	}
+
+	cur, err := s.bucketCursor(ctx, tx)
+	if err != nil {
+		return Page{}, err
+	}
+
+	seek := opts.Prefix
+	if bytes.Compare(opts.AfterKey, seek) > 0 {
+		seek = opts.AfterKey
+	}
+
+	k, v := seekFrom(cur, opts.Prefix, seek)
+
+	var (
+		page    Page
+		lastKey []byte
+	)
+	for ; k != nil; k, v = cur.Next() {
+		if len(opts.Prefix) > 0 && !bytes.HasPrefix(k, opts.Prefix) {
+			break
+		}
+		if len(opts.AfterKey) > 0 && bytes.Compare(k, opts.AfterKey) <= 0 {
+			continue
+		}
+
+		key, decodedVal, err := s.decodeVal(k, v)
+		if err != nil {
+			return Page{}, err
+		}
+		if opts.FilterEntFn != nil && !opts.FilterEntFn(key, decodedVal) {
+			continue
+		}
+
+		if opts.Limit > 0 && len(page.Values) == opts.Limit {
+			page.NextKey = lastKey
+			break
+		}
+		page.Values = append(page.Values, decodedVal)
+		lastKey = append(lastKey[:0:0], k...)
+	}
+	span.SetTag("Count", len(page.Values))
+	return page, nil
+}
+
+// Count returns the number of entities whose key matches the prefix. Values are
+// only decoded when filter funcs are provided, in which case only the entities
+// that satisfy every filter are counted.
+func (s *StoreBase) Count(ctx context.Context, tx Tx, prefix []byte, filterFns ...FilterFn) (int, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	cur, err := s.bucketCursor(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	var k, v []byte
+	if len(prefix) > 0 {
+		k, v = cur.Seek(prefix)
+	} else {
+		k, v = cur.First()
+	}
+
+	var count, seen int
+	for ; k != nil; k, v = cur.Next() {
+		if len(prefix) > 0 && !bytes.HasPrefix(k, prefix) {
+			break
+		}
+
+		seen++
+		if err := checkScanCtx(ctx, seen); err != nil {
+			return 0, err
+		}
+
+		if len(filterFns) > 0 {
+			key, decodedVal, err := s.decodeVal(k, v)
+			if err != nil {
+				return 0, err
+			}
+			if !allFilters(filterFns, key, decodedVal) {
+				continue
+			}
+		}
+		count++
+	}
+	span.SetTag("Count", count)
+	return count, nil
+}
+
+// forEachKey calls fn with every key of the bucket that has the prefix, in
+// ascending order, without reading the values. The key is only valid for the
+// duration of the call.
+func (s *StoreBase) forEachKey(ctx context.Context, tx Tx, prefix []byte, fn func(k []byte) error) error {
+	cur, err := s.bucketCursor(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	k, _ := cur.First()
+	if len(prefix) > 0 {
+		k, _ = cur.Seek(prefix)
+	}
+	for seen := 1; k != nil && bytes.HasPrefix(k, prefix); seen++ {
+		if err := checkScanCtx(ctx, seen); err != nil {
 			return err
 		}
+		if err := fn(k); err != nil {
+			return err
+		}
+		k, _ = cur.Next()
 	}
 	return nil
 }
 
+func allFilters(filterFns []FilterFn, key []byte, decodedVal interface{}) bool {
+	for _, fn := range filterFns {
+		if !fn(key, decodedVal) {
+			return false
+		}
+	}
+	return true
+}
+
+type (
+	findEntOption struct {
+		allowMissing   bool
+		allowStale     bool
+		includeDeleted bool
+		readOnly       bool
+		repairOnRead   bool
+		verifyIndex    bool
+		onMissFn       OnMissFn
+	}
+
+	// FindEntOptionFn provides a hint to the store about how to find an entity.
+	FindEntOptionFn func(o *findEntOption)
+)
+
+// FindEntVerifyIndex verifies that the index of an entity found via its PK resolves
+// to that same PK, when the entity provided includes both the PK and index keys. A
+// mismatch is reported as an EInternal error. This only applies to an IndexStore.
+func FindEntVerifyIndex() FindEntOptionFn {
+	return func(o *findEntOption) {
+		o.verifyIndex = true
+	}
+}
+
+// FindEntReadOnly asserts the find is performed in a transaction opened via View.
+// FindEnt fails when provided a writable transaction.
+func FindEntReadOnly() FindEntOptionFn {
+	return func(o *findEntOption) {
+		o.readOnly = true
+	}
+}
+
+// FindEntIncludeDeleted finds the entity even when it has been soft deleted. Soft
+// deleted entities no longer have indexes, so they can only be found by their PK.
+// This only applies to an IndexStore; see DeleteEntSoft.
+func FindEntIncludeDeleted() FindEntOptionFn {
+	return func(o *findEntOption) {
+		o.includeDeleted = true
+	}
+}
+
+func newFindEntOption(opts ...FindEntOptionFn) findEntOption {
+	var opt findEntOption
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
 // FindEnt returns the decoded entity body via the provided entity.
 // An example entity should not include a Body, but rather the ID,
 // Name, or OrgID.
-func (s *StoreBase) FindEnt(ctx context.Context, tx Tx, ent Entity) (interface{}, error) {
+func (s *StoreBase) FindEnt(ctx context.Context, tx Tx, ent Entity, opts ...FindEntOptionFn) (interface{}, error) {
 	span, ctx := s.startSpan(ctx)
 	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	opt := newFindEntOption(opts...)
+	if opt.readOnly {
+		if err := assertReadOnly(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObserveFind(s.Resource, time.Since(start)) }(time.Now())
+	}
 
 	encodedID, err := s.EntKey(ctx, ent)
 	if err != nil {
 		// TODO: fix this error up
 		return nil, err
 	}
+	span.SetTag("KeyHash", hashKey(encodedID))
 
 	body, err := s.bucketGet(ctx, tx, encodedID)
 	if err != nil {
 		return nil, err
 	}
-
-	return s.decodeEnt(ctx, body)
+	if err := s.assertNotExpired(ctx, tx, encodedID); err != nil {
+		return nil, err
+	}
+
+	v, err := s.decodeEnt(ctx, encodedID, body)
+	if err != nil || opt.onMissFn == nil {
+		return v, err
+	}
+	return s.onMiss(ctx, tx, encodedID, v, opt.onMissFn, func(ent Entity) error {
+		return s.Put(ctx, tx, ent, PutUpdate())
+	})
+}
+
+// Compact reclaims the space freed by deleted entities within the store's bucket,
+// when the underlying store supports it. See BucketCompactor.
+func (s *StoreBase) Compact(ctx context.Context, store Store) error {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+
+	for _, name := range s.ShardBucketNames() {
+		if err := CompactBucket(ctx, store, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindEntRaw returns the raw stored bytes of the entity, without decoding them.
+// The entity is identified in the same manner as FindEnt, and a missing entity
+// results in the same ENotFound error.
+func (s *StoreBase) FindEntRaw(ctx context.Context, tx Tx, ent Entity) ([]byte, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	encodedID, err := s.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("KeyHash", hashKey(encodedID))
+
+	body, err := s.bucketGet(ctx, tx, encodedID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.assertNotExpired(ctx, tx, encodedID); err != nil {
+		return nil, err
+	}
+	// the bucket's bytes are only valid for the life of the tx
+	return append([]byte(nil), body...), nil
+}
+
+type (
+	putOption struct {
+		isNew    bool
+		isUpdate bool
+		isUpsert bool
+
+		replaceIndex bool
+		dryRun       bool
+
+		expectVersion   bool
+		expectedVersion int
+
+		matchFieldFn  func(Entity) []byte
+		matchExpected []byte
+
+		skipIndex bool
+	}
+
+	// PutOptionFn provides a hint to the store to make some guarantees about the
+	// put action. I.e. If it is new, then will validate there is no existing entity
+	// by the given PK.
+	PutOptionFn func(o *putOption) error
+)
+
+// PutNew will create an entity that is not does not already exist. Guarantees uniqueness
+// by the store's uniqueness guarantees.
+func PutNew() PutOptionFn {
+	return func(o *putOption) error {
+		o.isNew = true
+		return nil
+	}
+}
+
+// PutUpdate will update an entity that must already exist.
+func PutUpdate() PutOptionFn {
+	return func(o *putOption) error {
+		o.isUpdate = true
+		return nil
+	}
+}
+
+// PutUpsert will create the entity when it does not exist, or update it when it
+// does. The store's uniqueness guarantees are upheld in either case.
+func PutUpsert() PutOptionFn {
+	return func(o *putOption) error {
+		o.isUpsert = true
+		return nil
+	}
+}
+
+// PutReplaceIndex will replace the index entries of the entity with those of the
+// entity provided. Every new index key is checked against the indexes before any
+// existing index entry is removed, so a put colliding with another entity's key
+// fails with an EConflict and leaves the stores untouched. When combined with
+// PutUpdate the entity must already exist. This only applies to an IndexStore.
+func PutReplaceIndex() PutOptionFn {
+	return func(o *putOption) error {
+		o.replaceIndex = true
+		return nil
+	}
 }
 
-type (
-	putOption struct {
-		isNew    bool
-		isUpdate bool
+// PutExpectedVersion will only persist the entity when its stored version matches
+// the version provided, failing with an EConflict otherwise. An entity that has
+// never been put has version 0. This only applies to an IndexStore with a
+// VersionStore; see IndexStore.Version.
+func PutExpectedVersion(v int) PutOptionFn {
+	return func(o *putOption) error {
+		o.expectVersion = true
+		o.expectedVersion = v
+		return nil
 	}
+}
 
-	// PutOptionFn provides a hint to the store to make some guarantees about the
-	// put action. I.e. If it is new, then will validate there is no existing entity
-	// by the given PK.
-	PutOptionFn func(o *putOption) error
-)
-
-// PutNew will create an entity that is not does not already exist. Guarantees uniqueness
-// by the store's uniqueness guarantees.
-func PutNew() PutOptionFn {
+// PutIfMatch will only persist the entity when the field the fieldFn extracts
+// from the stored entity equals the expected value, failing with an EConflict
+// otherwise. The stored entity is read within the tx, so the field can not change
+// between the check and the put, and must exist as it would for PutUpdate. The
+// entity passed to the fieldFn is converted from the stored value, with its Body
+// holding the decoded value. I.e. a state machine can only move a job out of the
+// "pending" status when it is still pending.
+func PutIfMatch(fieldFn func(Entity) []byte, expected []byte) PutOptionFn {
 	return func(o *putOption) error {
-		o.isNew = true
+		if fieldFn == nil {
+			return errors.New("the match of a put requires a field func")
+		}
+		o.matchFieldFn = fieldFn
+		o.matchExpected = expected
 		return nil
 	}
 }
 
-// PutUpdate will update an entity that must already exist.
-func PutUpdate() PutOptionFn {
+// PutSkipIndex will write the entity to the entity store only, leaving its index
+// entries unwritten, for bulk loads into a fresh store whose index is written
+// afterwards via BackfillIndex. The unique keys of the entity are neither checked
+// nor written, so their uniqueness is the caller's responsibility until the
+// backfill, which fails with an EConflict for entities sharing a unique key. It
+// can not be combined with PutNew, PutUpdate, PutUpsert or PutReplaceIndex, as
+// those validate the put against the index. This only applies to an IndexStore.
+func PutSkipIndex() PutOptionFn {
 	return func(o *putOption) error {
-		o.isUpdate = true
+		o.skipIndex = true
 		return nil
 	}
 }
 
-// Put will persist the entity.
-func (s *StoreBase) Put(ctx context.Context, tx Tx, ent Entity, opts ...PutOptionFn) error {
-	span, ctx := s.startSpan(ctx)
-	defer span.Finish()
+// PutDryRun will validate the put as it would be validated otherwise, returning
+// the same errors, without writing to any bucket. I.e. a create request can be
+// validated ahead of the tx that persists it. Another tx may still take a unique
+// key in between, so the actual put must validate again.
+func PutDryRun() PutOptionFn {
+	return func(o *putOption) error {
+		o.dryRun = true
+		return nil
+	}
+}
 
+func newPutOption(opts ...PutOptionFn) (putOption, error) {
 	var opt putOption
 	for _, o := range opts {
 		if err := o(&opt); err != nil {
-			return &influxdb.Error{
+			return putOption{}, &influxdb.Error{
 				Code: influxdb.EConflict,
 				Err:  err,
 			}
 		}
 	}
+	return opt, nil
+}
+
+// Put will persist the entity. With an ExpiryStore, the entity's ExpiresAt replaces
+// any expiry previously recorded for it.
+func (s *StoreBase) Put(ctx context.Context, tx Tx, ent Entity, opts ...PutOptionFn) error {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opPut)
+
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObservePut(s.Resource, time.Since(start)) }(time.Now())
+	}
+
+	opt, err := newPutOption(opts...)
+	if err != nil {
+		return err
+	}
 
 	if err := s.putValidate(ctx, tx, ent, opt); err != nil {
 		return err
 	}
+	if err := s.putMatch(ctx, tx, ent, opt); err != nil {
+		return err
+	}
 
-	encodedID, err := s.EntKey(ctx, ent)
+	encodedID, body, err := s.encodePut(ctx, ent)
 	if err != nil {
 		return err
 	}
+	span.SetTag("KeyHash", hashKey(encodedID))
+	if opt.dryRun {
+		return nil
+	}
+
+	if err := s.putExpiry(ctx, tx, encodedID, ent.ExpiresAt); err != nil {
+		return err
+	}
+	return s.bucketPut(ctx, tx, encodedID, s.addIntegrity(s.addSchemaVersion(body)))
+}
+
+// encodePut returns the key and body of the entity put, validating the key via
+// the KeyValidateFn and the body against the max value size.
+func (s *StoreBase) encodePut(ctx context.Context, ent Entity) ([]byte, []byte, error) {
+	encodedID, err := s.EntKey(ctx, ent)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.validKey(encodedID); err != nil {
+		return nil, nil, err
+	}
 
 	body, err := s.encodeEnt(ctx, ent, s.EncodeEntBodyFn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.maxValueSize > 0 && len(body) > s.maxValueSize {
+		return nil, nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s value of %d bytes exceeds the max value size of %d bytes", s.Resource, len(body), s.maxValueSize),
+		}
+	}
+	return encodedID, body, nil
+}
+
+// validKey validates the key of an entity put via the KeyValidateFn, when set.
+func (s *StoreBase) validKey(key []byte) error {
+	if s.KeyValidateFn == nil {
+		return nil
+	}
+	if err := s.KeyValidateFn(key); err != nil {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("provided %s key %s is invalid", s.Resource, s.KeyString(key)),
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// putMatch verifies the field of the stored entity matches the expected value
+// of the PutIfMatch option, when provided.
+func (s *StoreBase) putMatch(ctx context.Context, tx Tx, ent Entity, opt putOption) error {
+	if opt.matchFieldFn == nil {
+		return nil
+	}
+
+	encodedID, err := s.EntKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+	v, err := s.FindEnt(ctx, tx, ent)
+	if err != nil {
+		return err
+	}
+	stored, err := s.convertValToEnt(encodedID, v)
 	if err != nil {
 		return err
 	}
 
-	return s.bucketPut(ctx, tx, encodedID, body)
+	if actual := opt.matchFieldFn(stored); !bytes.Equal(actual, opt.matchExpected) {
+		return &influxdb.Error{
+			Code: influxdb.EConflict,
+			Msg:  fmt.Sprintf("%s entity for key %s has field value %q; expected %q", s.Resource, s.KeyString(encodedID), actual, opt.matchExpected),
+		}
+	}
+	return nil
 }
 
 func (s *StoreBase) putValidate(ctx context.Context, tx Tx, ent Entity, opt putOption) error {
@@ -337,7 +1309,7 @@ func (s *StoreBase) putValidate(ctx context.Context, tx Tx, ent Entity, opt putO
 			return &influxdb.Error{
 				Code: influxdb.EConflict,
 				Msg:  fmt.Sprintf("%s is not unique", s.Resource),
-				Err:  err,
+				Err:  keyInUse(err),
 			}
 		}
 		return nil
@@ -364,27 +1336,34 @@ func (s *StoreBase) bucketCursor(ctx context.Context, tx Tx) (Cursor, error) {
 	span, _ := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
 
-	b, err := s.bucket(ctx, tx)
+	bkts, err := s.shardBuckets(ctx, tx)
 	if err != nil {
 		return nil, err
 	}
 
-	cur, err := b.Cursor()
-	if err != nil {
-		return nil, &influxdb.Error{
-			Code: influxdb.EInternal,
-			Msg:  "failed to retrieve cursor",
-			Err:  err,
+	curs := make([]Cursor, 0, len(bkts))
+	for _, b := range bkts {
+		cur, err := b.Cursor()
+		if err != nil {
+			return nil, &influxdb.Error{
+				Code: influxdb.EInternal,
+				Msg:  "failed to retrieve cursor",
+				Err:  err,
+			}
 		}
+		curs = append(curs, cur)
 	}
-	return cur, nil
+	if len(curs) == 1 {
+		return curs[0], nil
+	}
+	return newShardCursor(curs), nil
 }
 
 func (s *StoreBase) bucketDelete(ctx context.Context, tx Tx, key []byte) error {
 	span, _ := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
 
-	b, err := s.bucket(ctx, tx)
+	b, err := s.keyBucket(ctx, tx, key)
 	if err != nil {
 		return err
 	}
@@ -409,17 +1388,14 @@ func (s *StoreBase) bucketGet(ctx context.Context, tx Tx, key []byte) ([]byte, e
 	span, _ := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
 
-	b, err := s.bucket(ctx, tx)
+	b, err := s.keyBucket(ctx, tx, key)
 	if err != nil {
 		return nil, err
 	}
 
 	body, err := b.Get(key)
 	if IsNotFound(err) {
-		return nil, &influxdb.Error{
-			Code: influxdb.ENotFound,
-			Msg:  fmt.Sprintf("%s not found for key %q", s.Resource, string(key)),
-		}
+		return nil, s.ErrEntNotFound(key)
 	}
 	if err != nil {
 		return nil, &influxdb.Error{
@@ -435,7 +1411,7 @@ func (s *StoreBase) bucketPut(ctx context.Context, tx Tx, key, body []byte) erro
 	span, _ := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
 
-	b, err := s.bucket(ctx, tx)
+	b, err := s.keyBucket(ctx, tx, key)
 	if err != nil {
 		return err
 	}
@@ -449,19 +1425,101 @@ func (s *StoreBase) bucketPut(ctx context.Context, tx Tx, key, body []byte) erro
 	return nil
 }
 
-func (s *StoreBase) decodeEnt(ctx context.Context, body []byte) (interface{}, error) {
+func (s *StoreBase) decodeEnt(ctx context.Context, key, body []byte) (interface{}, error) {
 	span, _ := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
 
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObserveDecode(s.Resource, time.Since(start)) }(time.Now())
+	}
+
+	body, err := s.storedBody(key, body)
+	if err != nil {
+		return nil, err
+	}
+
 	_, v, err := s.DecodeEntFn([]byte{}, body) // ignore key here
 	if err != nil {
+		return nil, s.decodeErr(key, len(body), err)
+	}
+	return v, nil
+}
+
+// decodeVal decodes a value found in the bucket at the key.
+func (s *StoreBase) decodeVal(key, val []byte) ([]byte, interface{}, error) {
+	val, err := s.storedBody(key, val)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k, v, err := s.DecodeEntFn(key, val)
+	if err != nil {
+		return nil, nil, s.decodeErr(key, len(val), err)
+	}
+	return k, v, nil
+}
+
+// findDecodeFn returns the func decoding the values of a find with the options.
+func (s *StoreBase) findDecodeFn(opts FindOpts) (func(key, val []byte) ([]byte, interface{}, error), error) {
+	if opts.KeysOnly {
+		if err := validKeysOnly(opts); err != nil {
+			return nil, err
+		}
+		return decodeKeyOnly, nil
+	}
+	if !opts.SummaryOnly {
+		return s.decodeVal, nil
+	}
+
+	if s.ConvertValToSummaryFn == nil {
 		return nil, &influxdb.Error{
-			Code: influxdb.EInternal,
-			Msg:  fmt.Sprintf("failed to decode %s body", s.Resource),
-			Err:  err,
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s store does not support summary only finds", s.Resource),
 		}
 	}
-	return v, nil
+	if opts.FilterDecodedFn != nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  "summary only finds can not filter decoded entities",
+		}
+	}
+	return s.decodeSummary, nil
+}
+
+// decodeSummary decodes a raw value of the bucket into its summary.
+func (s *StoreBase) decodeSummary(key, val []byte) ([]byte, interface{}, error) {
+	val, err := s.storedBody(key, val)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k, v, err := s.ConvertValToSummaryFn(key, val)
+	if err != nil {
+		return nil, nil, s.decodeErr(key, len(val), err)
+	}
+	return k, v, nil
+}
+
+// convertValToEnt converts a decoded value of the bucket into an entity.
+func (s *StoreBase) convertValToEnt(key []byte, v interface{}) (Entity, error) {
+	ent, err := s.ConvertValToEntFn(key, v)
+	if err != nil {
+		return Entity{}, s.decodeErr(key, -1, err)
+	}
+	return ent, nil
+}
+
+func (s *StoreBase) decodeErr(key []byte, rawLen int, err error) error {
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  fmt.Sprintf("failed to decode %s body", s.Resource),
+		Err: &DecodeError{
+			Resource: s.Resource,
+			Key:      append([]byte(nil), key...),
+			Len:      rawLen,
+			Err:      err,
+		},
+	}
 }
 
 func (s *StoreBase) encodeEnt(ctx context.Context, ent Entity, fn EncodeEntFn) ([]byte, error) {
@@ -493,19 +1551,43 @@ func (s *StoreBase) startSpan(ctx context.Context) (opentracing.Span, context.Co
 	return span, ctx
 }
 
+// operations recorded as the Operation tag of a span.
+const (
+	opDelete = "delete"
+	opFind   = "find"
+	opPut    = "put"
+)
+
+// hashKey returns a short hash of the key for use as a span tag, keys can hold
+// sensitive values, i.e. names, that must not be recorded in traces.
+func hashKey(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ctxCheckInterval is the number of keys an iterator visits between checks
+// of the context for cancellation.
+const ctxCheckInterval = 100
+
 type iterator struct {
 	cursor Cursor
 
 	counter    int
+	seen       int
 	descending bool
 	limit      int
 	offset     int
 	prefix     []byte
+	after      []byte
 
 	nextFn func() (key, val []byte)
+	// size is the size of the stored value of the entity returned last.
+	size int
 
 	decodeFn func(key, val []byte) (k []byte, decodedVal interface{}, err error)
 	filterFn FilterFn
+	// filterDecodedFn filters on the entity the decoded value converts to.
+	filterDecodedFn func(k []byte, decodedVal interface{}) (bool, error)
 }
 
 func (i *iterator) Next(ctx context.Context) (key []byte, val interface{}, err error) {
@@ -520,36 +1602,165 @@ func (i *iterator) Next(ctx context.Context) (key []byte, val interface{}, err e
 	switch {
 	case i.nextFn != nil:
 		k, vRaw = i.nextFn()
-	case len(i.prefix) > 0:
-		k, vRaw = i.cursor.Seek(i.prefix)
-		i.nextFn = i.cursor.Next
 	case i.descending:
-		k, vRaw = i.cursor.Last()
+		k, vRaw = i.seekLast()
 		i.nextFn = i.cursor.Prev
 	default:
-		k, vRaw = i.cursor.First()
+		k, vRaw = i.seekFirst()
 		i.nextFn = i.cursor.Next
 	}
 
-	k, decodedVal, err := i.decodeFn(k, vRaw)
-	for ; ; k, decodedVal, err = i.decodeFn(i.nextFn()) {
+	for ; k != nil; k, vRaw = i.nextFn() {
+		if (i.descending || i.after != nil) && len(i.prefix) > 0 && !bytes.HasPrefix(k, i.prefix) {
+			return nil, nil, nil
+		}
+		if err := i.checkCtx(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		key, decodedVal, err := i.decodeFn(k, vRaw)
 		if err != nil {
 			return nil, nil, err
 		}
-		if i.isNext(k, decodedVal) {
-			break
+		ok, err := i.isNext(key, decodedVal)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			i.size = len(vRaw)
+			return key, decodedVal, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// seekFirst moves the cursor to the first key of an ascending scan: the first key
+// with the iterator's prefix that is after its after key.
+func (i *iterator) seekFirst() ([]byte, []byte) {
+	seek := i.prefix
+	if bytes.Compare(i.after, seek) > 0 {
+		seek = i.after
+	}
+
+	k, v := seekFrom(i.cursor, i.prefix, seek)
+	for i.after != nil && k != nil && bytes.Compare(k, i.after) <= 0 {
+		k, v = i.cursor.Next()
+	}
+	return k, v
+}
+
+// seekFrom moves the cursor to the first key at or after the seek bytes, which
+// sort at or after the prefix. Not every cursor seeks to the first key at or
+// after the seek bytes; some only seek to the first key starting with them. So
+// when the seek finds nothing but there are keys at or after the seek bytes,
+// the cursor steps forward to them from the first key with the prefix.
+func seekFrom(cur Cursor, prefix, seek []byte) ([]byte, []byte) {
+	if len(seek) == 0 {
+		return cur.First()
+	}
+	if k, v := cur.Seek(seek); k != nil {
+		return k, v
+	}
+	if k, _ := cur.Last(); k == nil || bytes.Compare(k, seek) < 0 {
+		return nil, nil
+	}
+
+	k, v := cur.First()
+	if len(prefix) > 0 {
+		k, v = cur.Seek(prefix)
+	}
+	for k != nil && bytes.Compare(k, seek) < 0 {
+		k, v = cur.Next()
+	}
+	return k, v
+}
+
+// seekLast moves the cursor to the first key of a descending scan: the last key
+// with the iterator's prefix that is before its after key, or the last key
+// before those when none have the prefix.
+func (i *iterator) seekLast() ([]byte, []byte) {
+	end := prefixEnd(i.prefix)
+	if i.after != nil && (end == nil || bytes.Compare(i.after, end) < 0) {
+		end = i.after
+	}
+	if end == nil {
+		return i.cursor.Last()
+	}
+
+	k, v := i.cursor.Seek(end)
+	if k == nil {
+		k, v = i.cursor.Last()
+	}
+	// not every cursor seeks to the first key at or after the seek bytes, so
+	// step back over any key that sorts at or after the end.
+	for k != nil && bytes.Compare(k, end) >= 0 {
+		k, v = i.cursor.Prev()
+	}
+	return k, v
+}
+
+// prefixEnd returns the first key that sorts after every key with the prefix, or
+// nil when there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (i *iterator) checkCtx(ctx context.Context) error {
+	i.seen++
+	return checkScanCtx(ctx, i.seen)
+}
+
+// checkScanCtx checks the context for cancellation once every ctxCheckInterval
+// keys seen by a scan.
+func checkScanCtx(ctx context.Context, seen int) error {
+	if seen%ctxCheckInterval != 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return &influxdb.Error{
+			Code: influxdb.ECanceled,
+			Msg:  "scan canceled",
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// filterDecodedFn returns the filterDecodedFn of an iterator that converts the
+// decoded value into an entity for the filter, or nil when there is no filter.
+func (s *StoreBase) filterDecodedFn(fn EntityFilterFn) func([]byte, interface{}) (bool, error) {
+	if fn == nil {
+		return nil
+	}
+	return func(k []byte, v interface{}) (bool, error) {
+		ent, err := s.convertValToEnt(k, v)
+		if err != nil {
+			return false, err
 		}
+		return fn(ent), nil
 	}
-	return k, decodedVal, nil
 }
 
-func (i *iterator) isNext(k []byte, v interface{}) bool {
+func (i *iterator) isNext(k []byte, v interface{}) (bool, error) {
 	if len(k) == 0 {
-		return true
+		return true, nil
 	}
 
 	if i.filterFn != nil && !i.filterFn(k, v) {
-		return false
+		return false, nil
+	}
+	if i.filterDecodedFn != nil {
+		ok, err := i.filterDecodedFn(k, v)
+		if err != nil || !ok {
+			return false, err
+		}
 	}
 
 	// increase counter here since the entity is a valid ent
@@ -559,12 +1770,24 @@ func (i *iterator) isNext(k []byte, v interface{}) bool {
 	i.counter++
 
 	if i.limit > 0 && i.counter >= i.limit+i.offset {
-		return true
+		return true, nil
 	}
 	if i.offset > 0 && i.counter <= i.offset {
-		return false
+		return false, nil
 	}
-	return true
+	return true, nil
+}
+
+// assertReadOnly errors when the transaction reports it is writable. Transactions
+// that do not implement WritableTx are not checked.
+func assertReadOnly(tx Tx) error {
+	if wtx, ok := tx.(WritableTx); ok && wtx.Writable() {
+		return &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  "read only find performed in a writable transaction",
+		}
+	}
+	return nil
 }
 
 func IsErrUnexpectedDecodeVal(ok bool) error {
@@ -573,3 +1796,147 @@ func IsErrUnexpectedDecodeVal(ok bool) error {
 	}
 	return errors.New("unexpected value decoded")
 }
+
+// DecodeError is the cause of the EInternal error returned for a stored value that
+// could not be decoded, which is distinct from an error reading the store. It
+// identifies the record so it can be located and quarantined. See AsDecodeError.
+type DecodeError struct {
+	Resource string
+	Key      []byte
+	// Len is the length of the raw stored value, or -1 when the value was decoded
+	// but could not be converted into an entity.
+	Len int
+	Err error
+}
+
+// Error returns the cause of the decode failure along with the record's key.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s value for key %q of %d bytes: %v", e.Resource, string(e.Key), e.Len, e.Err)
+}
+
+// Unwrap returns the error returned by the decoder.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// AsDecodeError returns the DecodeError that caused the error, when the error, or
+// any *influxdb.Error it embeds, was caused by a stored value failing to decode.
+func AsDecodeError(err error) (*DecodeError, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *DecodeError:
+			return e, true
+		case *influxdb.Error:
+			err = e.Err
+		default:
+			var decErr *DecodeError
+			ok := errors.As(err, &decErr)
+			return decErr, ok
+		}
+	}
+	return nil, false
+}
+
+// errKeyInUse marks the EConflict errors of keys that are already in use, which
+// IsErrKeyConflict matches, from the other EConflict errors of the stores, i.e.
+// of a stale version.
+var errKeyInUse = errors.New("key already in use")
+
+// keyInUseError is the errKeyInUse of a key whose lookup failed with err, which
+// is not known to be free.
+type keyInUseError struct {
+	err error
+}
+
+func (e *keyInUseError) Error() string {
+	return errKeyInUse.Error() + ": " + e.err.Error()
+}
+
+func (e *keyInUseError) Unwrap() error {
+	return e.err
+}
+
+func (e *keyInUseError) Is(target error) bool {
+	return target == errKeyInUse
+}
+
+// keyInUse returns the error to embed in the conflict of a key that is in use,
+// or whose lookup failed with a non nil err.
+func keyInUse(err error) error {
+	if err == nil {
+		return errKeyInUse
+	}
+	return &keyInUseError{err: err}
+}
+
+// ErrKeyConflict returns the EConflict error for a resource key that is already
+// in use. Callers can match a specific conflict with errors.Is, or any conflict
+// with IsErrKeyConflict. The key is rendered as KeyString renders it for a store
+// without a KeyStringFn; match the conflict of a store with one via its
+// ErrKeyConflict method.
+func ErrKeyConflict(resource string, key []byte) *influxdb.Error {
+	return errKeyConflict(resource, defaultKeyString(key))
+}
+
+// ErrKeyConflict returns the EConflict error for a key of the store that is
+// already in use, with the key rendered via KeyString as the conflicts an
+// IndexStore validates render it.
+func (s *StoreBase) ErrKeyConflict(key []byte) *influxdb.Error {
+	return errKeyConflict(s.Resource, s.KeyString(key))
+}
+
+func errKeyConflict(resource, key string) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.EConflict,
+		Msg:  fmt.Sprintf("%s is not unique for key %s", resource, key),
+		Err:  errKeyInUse,
+	}
+}
+
+// ErrEntNotFound returns the ENotFound error for a resource key that does not
+// exist. Callers can match a specific entity with errors.Is, or any missing
+// entity with IsErrEntNotFound. The key is rendered as ErrKeyConflict renders it.
+func ErrEntNotFound(resource string, key []byte) *influxdb.Error {
+	return errEntNotFound(resource, defaultKeyString(key))
+}
+
+// ErrEntNotFound returns the ENotFound error for a key of the store that does
+// not exist, with the key rendered via KeyString.
+func (s *StoreBase) ErrEntNotFound(key []byte) *influxdb.Error {
+	return errEntNotFound(s.Resource, s.KeyString(key))
+}
+
+func errEntNotFound(resource, key string) *influxdb.Error {
+	return &influxdb.Error{
+		Code: influxdb.ENotFound,
+		Msg:  fmt.Sprintf("%s not found for key %q", resource, key),
+	}
+}
+
+// IsErrKeyConflict reports whether the error, or any error it embeds, is the
+// conflict of a key that is already in use. Other EConflict errors, i.e. of a
+// stale version or a field that does not match, are not key conflicts.
+func IsErrKeyConflict(err error) bool {
+	return anyErr(err, func(e *influxdb.Error) bool {
+		return e.Code == influxdb.EConflict && errors.Is(e.Err, errKeyInUse)
+	})
+}
+
+// IsErrEntNotFound reports whether the error, or any error it embeds, is a missing entity.
+func IsErrEntNotFound(err error) bool {
+	return anyErr(err, func(e *influxdb.Error) bool {
+		return e.Code == influxdb.ENotFound
+	})
+}
+
+// anyErr reports whether fn is true for the error, or any error it embeds.
+func anyErr(err error, fn func(*influxdb.Error) bool) bool {
+	var e *influxdb.Error
+	for errors.As(err, &e) {
+		if fn(e) {
+			return true
+		}
+		err = e.Err
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// NewExpiryStore creates a store for the expiry times of a StoreBase's entities.
+// The store's bucket is keyed by the entity's PK and holds the time the entity
+// expires. Entities missing from the bucket never expire. See StoreBase.ExpiryStore.
+func NewExpiryStore(resource string, bktName []byte) *StoreBase {
+	return newTimeStore(resource, bktName, "expiresAt")
+}
+
+// newTimeStore creates a store keyed by an entity's PK that holds a time.Time body.
+func newTimeStore(resource string, bktName []byte, field string) *StoreBase {
+	var encTimeFn EncodeEntFn = func(ent Entity) ([]byte, string, error) {
+		t, ok := ent.Body.(time.Time)
+		if err := IsErrUnexpectedDecodeVal(ok); err != nil {
+			return nil, field, err
+		}
+		b, err := t.MarshalBinary()
+		return b, field, err
+	}
+
+	var decTimeFn DecodeBucketValFn = func(key, val []byte) ([]byte, interface{}, error) {
+		var t time.Time
+		if err := t.UnmarshalBinary(val); err != nil {
+			return nil, nil, err
+		}
+		return key, t, nil
+	}
+
+	var decValToEntFn ConvertValToEntFn = func(k []byte, v interface{}) (Entity, error) {
+		t, ok := v.(time.Time)
+		if err := IsErrUnexpectedDecodeVal(ok); err != nil {
+			return Entity{}, err
+		}
+		return Entity{PK: EncBytes(k), Body: t}, nil
+	}
+
+	return NewStoreBase(resource, bktName, EncIDKey, encTimeFn, decTimeFn, decValToEntFn)
+}
+
+// SweepExpired deletes up to max entities that have expired as of now, and
+// returns the number of entities deleted. Bounding the sweep keeps the tx
+// small; callers should sweep again until fewer than max entities are deleted.
+func (s *StoreBase) SweepExpired(ctx context.Context, tx Tx, now time.Time, max int) (int, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opDelete)
+
+	return s.sweepExpired(ctx, tx, now, max)
+}
+
+func (s *StoreBase) sweepExpired(ctx context.Context, tx Tx, now time.Time, max int, deleteRelationFns ...DeleteRelationsFn) (int, error) {
+	if s.ExpiryStore == nil {
+		return 0, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s entities do not expire", s.Resource),
+		}
+	}
+	if max <= 0 {
+		return 0, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("max must be greater than 0; got %d", max),
+		}
+	}
+
+	// the expired keys are collected before deleting anything, as the bucket
+	// must not be modified while the cursor walks it
+	var expiredKeys [][]byte
+	err := s.ExpiryStore.Find(ctx, tx, FindOpts{
+		Limit: max,
+		FilterEntFn: func(k []byte, v interface{}) bool {
+			expiresAt, ok := v.(time.Time)
+			return ok && !expiresAt.After(now)
+		},
+		CaptureFn: func(k []byte, v interface{}) error {
+			expiredKeys = append(expiredKeys, append([]byte(nil), k...))
+			return nil
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, k := range expiredKeys {
+		body, err := s.bucketGet(ctx, tx, k)
+		if err != nil && influxdb.ErrorCode(err) != influxdb.ENotFound {
+			return n, err
+		}
+
+		if err == nil {
+			v, err := s.decodeEnt(ctx, k, body)
+			if err != nil {
+				return n, err
+			}
+			for _, deleteFn := range deleteRelationFns {
+				if err := deleteFn(k, v); err != nil {
+					return n, err
+				}
+			}
+			if err := s.bucketDelete(ctx, tx, k); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if err := s.ExpiryStore.bucketDelete(ctx, tx, k); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// putExpiry records when the entity expires, or clears it when the entity no
+// longer expires.
+func (s *StoreBase) putExpiry(ctx context.Context, tx Tx, key []byte, expiresAt time.Time) error {
+	if s.ExpiryStore == nil {
+		return nil
+	}
+	if expiresAt.IsZero() {
+		return s.deleteExpiry(ctx, tx, key)
+	}
+	return s.ExpiryStore.Put(ctx, tx, Entity{PK: EncBytes(key), Body: expiresAt})
+}
+
+func (s *StoreBase) deleteExpiry(ctx context.Context, tx Tx, key []byte) error {
+	if s.ExpiryStore == nil {
+		return nil
+	}
+	err := s.ExpiryStore.bucketDelete(ctx, tx, key)
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return nil
+	}
+	return err
+}
+
+// expired returns whether the entity stored at the key has expired as of now.
+func (s *StoreBase) expired(ctx context.Context, tx Tx, key []byte, now time.Time) (bool, error) {
+	if s.ExpiryStore == nil {
+		return false, nil
+	}
+
+	v, err := s.ExpiryStore.FindEnt(ctx, tx, Entity{PK: EncBytes(key)})
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	expiresAt, ok := v.(time.Time)
+	if err := IsErrUnexpectedDecodeVal(ok); err != nil {
+		return false, err
+	}
+	return !expiresAt.After(now), nil
+}
+
+// assertNotExpired returns an ENotFound error when the entity stored at the key
+// has expired.
+func (s *StoreBase) assertNotExpired(ctx context.Context, tx Tx, key []byte) error {
+	expired, err := s.expired(ctx, tx, key, time.Now())
+	if err != nil {
+		return err
+	}
+	if expired {
+		return s.ErrEntNotFound(key)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// integrityMarker prefixes a value stored with its checksum. Neither JSON bodies
+// nor encoded IDs start with a zero byte, which lets values stored before the
+// integrity check was enabled be told apart from checksummed ones.
+var integrityMarker = []byte{0x00, 'c', 'r', 'c'}
+
+const integrityHeaderLen = 4 + crc32.Size
+
+var integrityTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WithIntegrityCheck enables the integrity check of the store's values and returns
+// the store. Each value put is stored with a CRC32 checksum of its body, which is
+// verified every time the value is decoded; a mismatch is returned as an EInternal
+// error caused by a CorruptionError. Values stored without a checksum are read as
+// is, so the check may be enabled on an existing bucket. Checksummed values can
+// not be read once the check is disabled again.
+func (s *StoreBase) WithIntegrityCheck() *StoreBase {
+	s.integrityCheck = true
+	return s
+}
+
+// addIntegrity prefixes the body with the integrity marker and its checksum, when
+// the integrity check is enabled.
+func (s *StoreBase) addIntegrity(body []byte) []byte {
+	if !s.integrityCheck {
+		return body
+	}
+
+	out := make([]byte, integrityHeaderLen, integrityHeaderLen+len(body))
+	copy(out, integrityMarker)
+	binary.BigEndian.PutUint32(out[len(integrityMarker):], crc32.Checksum(body, integrityTable))
+	return append(out, body...)
+}
+
+// checkIntegrity verifies the checksum of a stored value and returns its body.
+// Values without the integrity marker are returned untouched.
+func (s *StoreBase) checkIntegrity(key, val []byte) ([]byte, error) {
+	if !s.integrityCheck || !bytes.HasPrefix(val, integrityMarker) {
+		return val, nil
+	}
+
+	corruptErr := &CorruptionError{
+		Resource: s.Resource,
+		Key:      append([]byte(nil), key...),
+	}
+	if len(val) < integrityHeaderLen {
+		return nil, s.corruptionErr(corruptErr)
+	}
+
+	body := val[integrityHeaderLen:]
+	corruptErr.Expected = binary.BigEndian.Uint32(val[len(integrityMarker):])
+	corruptErr.Actual = crc32.Checksum(body, integrityTable)
+	if corruptErr.Expected != corruptErr.Actual {
+		return nil, s.corruptionErr(corruptErr)
+	}
+	return body, nil
+}
+
+func (s *StoreBase) corruptionErr(err *CorruptionError) error {
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  fmt.Sprintf("%s value failed its integrity check", s.Resource),
+		Err:  err,
+	}
+}
+
+// CorruptionError is the cause of the EInternal error returned for a stored value
+// whose checksum does not match its body. See StoreBase.WithIntegrityCheck and
+// AsCorruptionError.
+type CorruptionError struct {
+	Resource string
+	Key      []byte
+	// Expected is the stored checksum, and Actual the checksum of the stored body.
+	// Both are zero when the value is too short to hold a checksum.
+	Expected uint32
+	Actual   uint32
+}
+
+// Error returns the mismatched checksums along with the record's key.
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s value for key %q is corrupt: checksum %08x does not match %08x", e.Resource, string(e.Key), e.Actual, e.Expected)
+}
+
+// AsCorruptionError returns the CorruptionError that caused the error, when the
+// error, or any *influxdb.Error it embeds, was caused by a stored value failing
+// its integrity check.
+func AsCorruptionError(err error) (*CorruptionError, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *CorruptionError:
+			return e, true
+		case *influxdb.Error:
+			err = e.Err
+		default:
+			var corruptErr *CorruptionError
+			ok := errors.As(err, &corruptErr)
+			return corruptErr, ok
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,64 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// OnMissFn computes the derived fields of an entity found via FindEnt, provided its
+// decoded body. When the derived fields are missing it returns the entity with them
+// populated and true, otherwise false, in which case nothing is persisted.
+type OnMissFn func(ctx context.Context, decodedVal interface{}) (Entity, bool, error)
+
+// FindEntOnMiss calls fn with the entity found, persisting the entity it returns
+// within the same tx when fn reports the entity's derived fields as missing, before
+// the stored entity is returned. This populates computed fields on their first read,
+// so later finds return them without calling fn again for a change. The entity fn
+// returns must have the PK of the entity found. In a read only tx the populated
+// entity is returned without being persisted.
+//
+// fn is called at most once per find, and the entity is persisted via a put that
+// does not find the entity again, so a fn that keeps reporting the fields as
+// missing can not loop.
+func FindEntOnMiss(fn OnMissFn) FindEntOptionFn {
+	return func(o *findEntOption) {
+		o.onMissFn = fn
+	}
+}
+
+// onMiss calls the fn with the decoded entity stored at the key, persisting the
+// entity the fn returns via the put when its derived fields are missing, and returns
+// the decoded entity that is stored.
+func (s *StoreBase) onMiss(ctx context.Context, tx Tx, key []byte, v interface{}, fn OnMissFn, putFn func(Entity) error) (interface{}, error) {
+	ent, missing, err := fn(ctx, v)
+	if err != nil || !missing {
+		return v, err
+	}
+
+	entKey, err := s.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(entKey, key) {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("%s entity populated on miss does not match the entity found for key %s", s.Resource, s.KeyString(key)),
+		}
+	}
+
+	if wtx, ok := tx.(WritableTx); ok && !wtx.Writable() {
+		return ent.Body, nil
+	}
+	if err := putFn(ent); err != nil {
+		return nil, err
+	}
+
+	body, err := s.bucketGet(ctx, tx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeEnt(ctx, key, body)
+}
@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// schemaMarker prefixes a value stored with its schema version, which follows the
+// marker as a single byte. As with the integrityMarker, neither JSON bodies nor
+// encoded IDs start with a zero byte, so values stored before versioning was
+// enabled are told apart from versioned ones, and read as version 0.
+var schemaMarker = []byte{0x00, 's', 'v'}
+
+const schemaHeaderLen = 3 + 1
+
+// SchemaMigrationFn upgrades a stored body from the version it is registered for
+// to the next version, i.e. by renaming or filling in the fields of the resource
+// that have changed since.
+type SchemaMigrationFn func(body []byte) ([]byte, error)
+
+// WithSchemaVersion stores each value put with the schema version provided and
+// returns the store. Stored values of an older version are upgraded to the
+// current version every time they are decoded, by applying the migrations
+// registered via WithSchemaMigration from the stored version onwards, before the
+// DecodeEntFn is called. The upgraded body is not written back; the value is
+// stored at the current version the next time the entity is put. A value of a
+// newer version than the store's, i.e. written by a newer release, fails to
+// decode with an EInternal error.
+func (s *StoreBase) WithSchemaVersion(version uint8) *StoreBase {
+	s.schemaVersion = version
+	return s
+}
+
+// WithSchemaMigration registers the migration that upgrades the store's values
+// from the version provided to the next version, and returns the store. See
+// WithSchemaVersion.
+func (s *StoreBase) WithSchemaMigration(from uint8, fn SchemaMigrationFn) *StoreBase {
+	if s.schemaMigrations == nil {
+		s.schemaMigrations = make(map[uint8]SchemaMigrationFn)
+	}
+	s.schemaMigrations[from] = fn
+	return s
+}
+
+// addSchemaVersion prefixes the body with the schema marker and the store's
+// schema version, when the store is versioned.
+func (s *StoreBase) addSchemaVersion(body []byte) []byte {
+	if s.schemaVersion == 0 {
+		return body
+	}
+
+	out := make([]byte, schemaHeaderLen, schemaHeaderLen+len(body))
+	copy(out, schemaMarker)
+	out[len(schemaMarker)] = s.schemaVersion
+	return append(out, body...)
+}
+
+// migrateSchema returns the body of a stored value upgraded to the store's schema
+// version. Values without the schema marker are upgraded from version 0.
+func (s *StoreBase) migrateSchema(key, val []byte) ([]byte, error) {
+	if s.schemaVersion == 0 && len(s.schemaMigrations) == 0 {
+		return val, nil
+	}
+
+	var version uint8
+	if bytes.HasPrefix(val, schemaMarker) && len(val) >= schemaHeaderLen {
+		version = val[len(schemaMarker)]
+		val = val[schemaHeaderLen:]
+	}
+	if version > s.schemaVersion {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("%s value for key %s is of schema version %d, newer than the supported version %d", s.Resource, s.KeyString(key), version, s.schemaVersion),
+		}
+	}
+
+	for ; version < s.schemaVersion; version++ {
+		fn, ok := s.schemaMigrations[version]
+		if !ok {
+			return nil, &influxdb.Error{
+				Code: influxdb.EInternal,
+				Msg:  fmt.Sprintf("%s store has no migration from schema version %d", s.Resource, version),
+			}
+		}
+
+		migrated, err := fn(val)
+		if err != nil {
+			return nil, &influxdb.Error{
+				Code: influxdb.EInternal,
+				Msg:  fmt.Sprintf("failed to migrate %s value for key %s from schema version %d", s.Resource, s.KeyString(key), version),
+				Err:  err,
+			}
+		}
+		val = migrated
+	}
+	return val, nil
+}
+
+// storedBody returns the body of a stored value as the DecodeEntFn expects it,
+// with its checksum verified and its schema upgraded.
+func (s *StoreBase) storedBody(key, val []byte) ([]byte, error) {
+	val, err := s.checkIntegrity(key, val)
+	if err != nil {
+		return nil, err
+	}
+	return s.migrateSchema(key, val)
+}
@@ -0,0 +1,284 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// WithShards spreads the store's records across n buckets and returns the store,
+// for a store whose single bucket would otherwise be a write hotspot, i.e. the
+// index of a high cardinality resource. Each key is stored in the bucket picked
+// by a hash of the key, so a put, find or delete of a key touches a single shard.
+// Scans merge the cursors of every shard and see the records in key order as
+// they would in a single bucket. The shards are named by ShardBucketNames, all
+// of which must be created before the store is used. A store with fewer than two
+// shards uses its BktName as is.
+func (s *StoreBase) WithShards(n int) *StoreBase {
+	s.shards = n
+	return s
+}
+
+// ShardBucketNames returns the names of the buckets the store's records are kept
+// in: the BktName suffixed by the number of each shard, or the BktName alone for
+// a store that is not sharded.
+func (s *StoreBase) ShardBucketNames() [][]byte {
+	if s.shards < 2 {
+		return [][]byte{s.BktName}
+	}
+
+	names := make([][]byte, s.shards)
+	for i := range names {
+		names[i] = s.shardBucketName(i)
+	}
+	return names
+}
+
+// ShardBucket returns the name of the bucket the key is stored in.
+func (s *StoreBase) ShardBucket(key []byte) []byte {
+	if s.shards < 2 {
+		return s.BktName
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+	return s.shardBucketName(int(h.Sum32() % uint32(s.shards)))
+}
+
+func (s *StoreBase) shardBucketName(i int) []byte {
+	return []byte(fmt.Sprintf("%s_%d", s.BktName, i))
+}
+
+// keyBucket returns the bucket the key is stored in.
+func (s *StoreBase) keyBucket(ctx context.Context, tx Tx, key []byte) (Bucket, error) {
+	if s.shards < 2 {
+		return s.bucket(ctx, tx)
+	}
+	return s.namedBucket(ctx, tx, s.ShardBucket(key))
+}
+
+// shardBuckets returns every bucket the store's records are kept in.
+func (s *StoreBase) shardBuckets(ctx context.Context, tx Tx) ([]Bucket, error) {
+	names := s.ShardBucketNames()
+	bkts := make([]Bucket, 0, len(names))
+	for _, name := range names {
+		b, err := s.namedBucket(ctx, tx, name)
+		if err != nil {
+			return nil, err
+		}
+		bkts = append(bkts, b)
+	}
+	return bkts, nil
+}
+
+func (s *StoreBase) namedBucket(ctx context.Context, tx Tx, name []byte) (Bucket, error) {
+	span, _ := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	bkt, err := tx.Bucket(name)
+	if err != nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("unexpected error retrieving bucket %q; Err %v", string(name), err),
+			Err:  err,
+		}
+	}
+	return bkt, nil
+}
+
+// getBatch returns the values of the keys, with a nil value for a missing key. The
+// keys of a sharded store are read from their shards one at a time.
+func (s *StoreBase) getBatch(ctx context.Context, tx Tx, keys [][]byte) ([][]byte, error) {
+	if s.shards < 2 {
+		b, err := s.bucket(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		values, err := b.GetBatch(keys...)
+		if err != nil {
+			return nil, &influxdb.Error{
+				Code: influxdb.EInternal,
+				Err:  err,
+			}
+		}
+		return values, nil
+	}
+
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		v, err := s.bucketGet(ctx, tx, key)
+		if influxdb.ErrorCode(err) == influxdb.ENotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// shardCursor merges the cursors of every shard of a store into a single cursor
+// over the keys of all shards, in key order. As each key is stored in a single
+// shard, no two cursors are ever at the same key. Once exhausted, the cursor only
+// moves again via First, Last or Seek.
+type shardCursor struct {
+	curs []Cursor
+	// keys and vals hold the record each cursor is at, with a nil key once the
+	// cursor is exhausted in the current direction.
+	keys, vals [][]byte
+
+	// at is the cursor whose record was returned last, or -1 before the first move.
+	at      int
+	reverse bool
+}
+
+func newShardCursor(curs []Cursor) *shardCursor {
+	return &shardCursor{
+		curs: curs,
+		keys: make([][]byte, len(curs)),
+		vals: make([][]byte, len(curs)),
+		at:   -1,
+	}
+}
+
+func (c *shardCursor) Seek(prefix []byte) ([]byte, []byte) {
+	return c.each(false, func(cur Cursor) ([]byte, []byte) { return cur.Seek(prefix) })
+}
+
+func (c *shardCursor) First() ([]byte, []byte) {
+	return c.each(false, Cursor.First)
+}
+
+func (c *shardCursor) Last() ([]byte, []byte) {
+	return c.each(true, Cursor.Last)
+}
+
+func (c *shardCursor) Next() ([]byte, []byte) {
+	if c.at < 0 {
+		return c.First()
+	}
+	if c.keys[c.at] == nil {
+		return nil, nil
+	}
+	if c.reverse {
+		c.turn()
+	}
+	c.keys[c.at], c.vals[c.at] = c.curs[c.at].Next()
+	return c.pick()
+}
+
+func (c *shardCursor) Prev() ([]byte, []byte) {
+	if c.at < 0 {
+		return c.Last()
+	}
+	if c.keys[c.at] == nil {
+		return nil, nil
+	}
+	if !c.reverse {
+		c.turn()
+	}
+	c.keys[c.at], c.vals[c.at] = c.curs[c.at].Prev()
+	return c.pick()
+}
+
+// turn moves every cursor but the one at the current key to the other side of the
+// current key, for a change of direction. Moving forward, every other cursor is
+// at its first key after the current key, and moving in reverse at its last key
+// before it, so stepping it once turns it. Those that are exhausted are moved
+// from their far end instead. The cursors are not repositioned via Seek, as not
+// every cursor seeks to the first key at or after the seek bytes.
+func (c *shardCursor) turn() {
+	key := c.keys[c.at]
+	for i, cur := range c.curs {
+		if i == c.at {
+			continue
+		}
+
+		k, v := c.keys[i], c.vals[i]
+		switch {
+		case c.reverse && k == nil:
+			k, v = cur.First()
+		case c.reverse:
+			k, v = cur.Next()
+		case k == nil:
+			k, v = cur.Last()
+		default:
+			k, v = cur.Prev()
+		}
+		// a cursor left exhausted by a seek that found nothing may still have
+		// keys on this side of the current key, so step over them
+		for k != nil && c.behind(k, key) {
+			if c.reverse {
+				k, v = cur.Next()
+			} else {
+				k, v = cur.Prev()
+			}
+		}
+		c.keys[i], c.vals[i] = k, v
+	}
+	c.reverse = !c.reverse
+}
+
+// behind reports whether k has already been passed when the cursor, at key,
+// turns from its current direction.
+func (c *shardCursor) behind(k, key []byte) bool {
+	cmp := bytes.Compare(k, key)
+	if c.reverse {
+		return cmp <= 0
+	}
+	return cmp >= 0
+}
+
+// Close closes the cursors of the shards that are closers.
+func (c *shardCursor) Close() error {
+	var firstErr error
+	for _, cur := range c.curs {
+		if closer, ok := cur.(io.Closer); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// each moves every cursor via fn, returning the record picked in the direction
+// provided.
+func (c *shardCursor) each(reverse bool, fn func(Cursor) ([]byte, []byte)) ([]byte, []byte) {
+	for i, cur := range c.curs {
+		c.keys[i], c.vals[i] = fn(cur)
+	}
+	c.reverse = reverse
+	return c.pick()
+}
+
+// pick returns the lowest key the cursors are at, or the highest key when moving
+// in reverse.
+func (c *shardCursor) pick() ([]byte, []byte) {
+	at := -1
+	for i, k := range c.keys {
+		if k == nil {
+			continue
+		}
+		if at < 0 {
+			at = i
+			continue
+		}
+		cmp := bytes.Compare(k, c.keys[at])
+		if (!c.reverse && cmp < 0) || (c.reverse && cmp > 0) {
+			at = i
+		}
+	}
+	if at < 0 {
+		// every cursor is exhausted, which the cursor no longer moves from
+		return nil, nil
+	}
+	c.at = at
+	return c.keys[at], c.vals[at]
+}
@@ -0,0 +1,90 @@
+package kv
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// FindSort is the order in which a find captures its entities.
+type FindSort int
+
+const (
+	// SortByKey captures the entities in ascending order of the keys scanned, or
+	// descending order for a Descending find. This is the default, and requires
+	// no buffering of the entities found.
+	SortByKey FindSort = iota
+	// SortByName captures the entities in ascending order of their names, as
+	// provided by the DecodedNameFn of the entity store, or descending order for a
+	// Descending find. Entities sharing a name are captured in key order.
+	SortByName
+)
+
+// sortedFind returns the options of a find that buffers its entities, and the func
+// that captures the buffered entities, sorted per the Sort of the options provided,
+// along with their Offset and Limit. Every entity passing the filters of the find
+// is buffered before the first is captured, so the result of the capture always
+// reports whether the Limit left out any entities.
+func (s *StoreBase) sortedFind(opts FindOpts) (FindOpts, func() (FindResult, error), error) {
+	if opts.Sort != SortByName {
+		return FindOpts{}, nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("unknown find sort %d", opts.Sort),
+		}
+	}
+	if s.DecodedNameFn == nil {
+		return FindOpts{}, nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s store does not support sorting by name", s.Resource),
+		}
+	}
+
+	type found struct {
+		key  []byte
+		val  interface{}
+		name string
+	}
+	var buffered []found
+
+	bufferOpts := opts
+	bufferOpts.Sort = SortByKey
+	bufferOpts.Offset, bufferOpts.Limit = 0, 0
+	bufferOpts.CaptureFn = func(k []byte, v interface{}) error {
+		buffered = append(buffered, found{
+			key:  append([]byte(nil), k...),
+			val:  v,
+			name: s.DecodedNameFn(v),
+		})
+		return nil
+	}
+
+	captureFn := func() (FindResult, error) {
+		// the entities are buffered in key order, which a stable sort keeps for
+		// entities sharing a name
+		sort.SliceStable(buffered, func(i, j int) bool {
+			if opts.Descending {
+				return buffered[i].name > buffered[j].name
+			}
+			return buffered[i].name < buffered[j].name
+		})
+
+		var result FindResult
+		if opts.Offset >= len(buffered) {
+			return result, nil
+		}
+		buffered = buffered[opts.Offset:]
+		if opts.Limit > 0 && opts.Limit < len(buffered) {
+			buffered = buffered[:opts.Limit]
+			result.HasMore = true
+		}
+		for _, f := range buffered {
+			if err := opts.CaptureFn(f.key, f.val); err != nil {
+				return FindResult{}, err
+			}
+			result.Count++
+		}
+		return result, nil
+	}
+	return bufferOpts, captureFn, nil
+}
@@ -3,22 +3,29 @@ package kv_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/bolt"
 	"github.com/influxdata/influxdb/v2/kv"
 	"github.com/influxdata/influxdb/v2/kv/migration"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
 )
 
 func TestStoreBase(t *testing.T) {
-	newStoreBase := func(t *testing.T, bktSuffix string, encKeyFn, encBodyFn kv.EncodeEntFn, decFn kv.DecodeBucketValFn, decToEntFn kv.ConvertValToEntFn) (*kv.StoreBase, func(), kv.Store) {
+	newStoreBase := func(t *testing.T, newKVStore func(*testing.T) (kv.SchemaStore, func(), error), bktSuffix string, encKeyFn, encBodyFn kv.EncodeEntFn, decFn kv.DecodeBucketValFn, decToEntFn kv.ConvertValToEntFn) (*kv.StoreBase, func(), kv.Store) {
 		t.Helper()
 
-		inmemSVC, done, err := NewTestBoltStore(t)
+		inmemSVC, done, err := newKVStore(t)
 		require.NoError(t, err)
 
 		bucket := []byte("foo_" + bktSuffix)
@@ -35,7 +42,13 @@ func TestStoreBase(t *testing.T) {
 	}
 
 	newFooStoreBase := func(t *testing.T, bktSuffix string) (*kv.StoreBase, func(), kv.Store) {
-		return newStoreBase(t, bktSuffix, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+		return newStoreBase(t, NewTestBoltStore, bktSuffix, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+	}
+
+	// newInmemFooStoreBase is newFooStoreBase over the in memory store, whose
+	// cursors are not bolt cursors.
+	newInmemFooStoreBase := func(t *testing.T, bktSuffix string) (*kv.StoreBase, func(), kv.Store) {
+		return newStoreBase(t, NewTestInmemStore, bktSuffix, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
 	}
 
 	t.Run("Put", func(t *testing.T) {
@@ -43,109 +56,2023 @@ func TestStoreBase(t *testing.T) {
 			base, done, kvStore := newFooStoreBase(t, "put")
 			defer done()
 
-			testPutBase(t, kvStore, base, base.BktName)
+			testPutBase(t, kvStore, base, base.BktName)
+		})
+
+		t.Run("new", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "put")
+			defer done()
+
+			expected := newFooEnt(3, 33, "name3")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, expected, kv.PutNew())
+			})
+
+			var actual interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				f, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: expected.PK})
+				actual = f
+				return err
+			})
+
+			assert.Equal(t, expected.Body, actual)
+		})
+
+		t.Run("update", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "put")
+			defer done()
+
+			expected := testPutBase(t, kvStore, base, base.BktName)
+
+			updateEnt := newFooEnt(expected.ID, expected.OrgID, "new name")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, updateEnt, kv.PutUpdate())
+			})
+
+			var actual interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				f, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(expected.ID)})
+				actual = f
+				return err
+			})
+
+			expected.Name = "new name"
+			assert.Equal(t, expected, actual)
+		})
+
+		t.Run("error cases", func(t *testing.T) {
+			t.Run("new entity conflicts with existing", func(t *testing.T) {
+				base, done, kvStore := newFooStoreBase(t, "put")
+				defer done()
+
+				expected := testPutBase(t, kvStore, base, base.BktName)
+
+				err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+					entCopy := newFooEnt(expected.ID, expected.OrgID, expected.Name)
+					return base.Put(context.TODO(), tx, entCopy, kv.PutNew())
+				})
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+			})
+		})
+
+		t.Run("updating entity that does not exist", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "put")
+			defer done()
+
+			expected := testPutBase(t, kvStore, base, base.BktName)
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				// ent by id does not exist
+				entCopy := newFooEnt(333, expected.OrgID, "name1")
+				return base.Put(context.TODO(), tx, entCopy, kv.PutUpdate())
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.ENotFound, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("DeleteEnt", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "delete_ent")
+		defer done()
+
+		testDeleteEntBase(t, kvStore, base)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		testDeleteBase(t, func(t *testing.T, suffix string) (storeBase, func(), kv.Store) {
+			return newFooStoreBase(t, suffix)
+		})
+	})
+
+	t.Run("FindEnt", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_ent")
+		defer done()
+
+		testFindEnt(t, kvStore, base)
+	})
+
+	t.Run("Find", func(t *testing.T) {
+		testFind(t, func(t *testing.T, suffix string) (storeBase, func(), kv.Store) {
+			return newFooStoreBase(t, suffix)
+		})
+	})
+
+	t.Run("canceled context stops the scan", func(t *testing.T) {
+		const numEnts = 1000
+
+		seedMany := func(t *testing.T, kvStore kv.Store, base *kv.StoreBase) {
+			t.Helper()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				for i := 1; i <= numEnts; i++ {
+					ent := newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i))
+					if err := base.Put(context.TODO(), tx, ent); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+
+		t.Run("find", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "find_cancel")
+			defer done()
+
+			seedMany(t, kvStore, base)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var captured int
+			err := kvStore.View(ctx, func(tx kv.Tx) error {
+				return base.Find(ctx, tx, kv.FindOpts{
+					CaptureFn: func(key []byte, decodedVal interface{}) error {
+						captured++
+						if captured == 5 {
+							cancel()
+						}
+						return nil
+					},
+				})
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.ECanceled, influxdb.ErrorCode(err))
+			assert.Less(t, captured, numEnts/2)
+		})
+
+		t.Run("delete", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "delete_cancel")
+			defer done()
+
+			seedMany(t, kvStore, base)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var seen int
+			err := kvStore.Update(ctx, func(tx kv.Tx) error {
+				return base.Delete(ctx, tx, kv.DeleteOpts{
+					FilterFn: func(k []byte, v interface{}) bool {
+						seen++
+						if seen == 5 {
+							cancel()
+						}
+						return false
+					},
+				})
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.ECanceled, influxdb.ErrorCode(err))
+			assert.Less(t, seen, numEnts/2)
+		})
+	})
+
+	t.Run("FindStream", func(t *testing.T) {
+		expectedEnts := []kv.Entity{
+			newFooEnt(1, 9000, "foo_0"),
+			newFooEnt(2, 9000, "foo_1"),
+			newFooEnt(3, 9003, "foo_2"),
+		}
+
+		t.Run("streams every entity", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "find_stream")
+			defer done()
+
+			seedEnts(t, kvStore, base, expectedEnts...)
+
+			var actuals []interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return base.FindStream(context.TODO(), tx, kv.FindOpts{}, func(ent kv.Entity) error {
+					actuals = append(actuals, ent.Body)
+					return nil
+				})
+			})
+			assert.Equal(t, toIfaces(expectedEnts...), actuals)
+		})
+
+		t.Run("stops when fn errors", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "find_stream")
+			defer done()
+
+			seedEnts(t, kvStore, base, expectedEnts...)
+
+			stopErr := errors.New("stop")
+			var actuals []interface{}
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				return base.FindStream(context.TODO(), tx, kv.FindOpts{}, func(ent kv.Entity) error {
+					actuals = append(actuals, ent.Body)
+					if len(actuals) == 2 {
+						return stopErr
+					}
+					return nil
+				})
+			})
+			assert.Equal(t, stopErr, err)
+			assert.Equal(t, toIfaces(expectedEnts[:2]...), actuals)
+		})
+	})
+
+	t.Run("Count", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "count")
+		defer done()
+
+		seedEnts(t, kvStore, base,
+			newFooEnt(1, 9000, "foo_0"),
+			newFooEnt(2000, 9000, "foo_1"),
+			newFooEnt(3000000, 9003, "foo_2"),
+			newFooEnt(4000000000, 9004, "foo_3"),
+		)
+
+		tests := []struct {
+			name      string
+			prefix    []byte
+			filterFns []kv.FilterFn
+			expected  int
+		}{
+			{
+				name:     "all",
+				expected: 4,
+			},
+			{
+				name:     "with id prefix",
+				prefix:   encodeID(t, 3000000)[:influxdb.IDLength-5],
+				expected: 1,
+			},
+			{
+				name:     "with prefix matching nothing",
+				prefix:   []byte("zzz"),
+				expected: 0,
+			},
+			{
+				name: "with filter",
+				filterFns: []kv.FilterFn{func(key []byte, decodedVal interface{}) bool {
+					return decodedVal.(foo).OrgID == 9000
+				}},
+				expected: 2,
+			},
+			{
+				name:   "with prefix and filter",
+				prefix: encodeID(t, 3000000)[:influxdb.IDLength-5],
+				filterFns: []kv.FilterFn{func(key []byte, decodedVal interface{}) bool {
+					return decodedVal.(foo).OrgID == 9003
+				}},
+				expected: 1,
+			},
+		}
+
+		for _, tt := range tests {
+			fn := func(t *testing.T) {
+				var actual int
+				view(t, kvStore, func(tx kv.Tx) error {
+					n, err := base.Count(context.TODO(), tx, tt.prefix, tt.filterFns...)
+					actual = n
+					return err
+				})
+				assert.Equal(t, tt.expected, actual)
+			}
+			t.Run(tt.name, fn)
+		}
+	})
+
+	t.Run("FindPage", func(t *testing.T) {
+		expectedEnts := []kv.Entity{
+			newFooEnt(1, 9000, "foo_0"),
+			newFooEnt(2, 9000, "foo_1"),
+			newFooEnt(3, 9003, "foo_2"),
+			newFooEnt(4, 9004, "foo_3"),
+			newFooEnt(5, 9004, "foo_4"),
+		}
+
+		findPage := func(t *testing.T, kvStore kv.Store, base *kv.StoreBase, opts kv.FindPageOpts) kv.Page {
+			t.Helper()
+
+			var page kv.Page
+			view(t, kvStore, func(tx kv.Tx) error {
+				p, err := base.FindPage(context.TODO(), tx, opts)
+				page = p
+				return err
+			})
+			return page
+		}
+
+		t.Run("pages through every entity", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "find_page")
+			defer done()
+
+			seedEnts(t, kvStore, base, expectedEnts...)
+
+			page := findPage(t, kvStore, base, kv.FindPageOpts{Limit: 2})
+			assert.Equal(t, toIfaces(expectedEnts[:2]...), page.Values)
+			require.Equal(t, encodeID(t, 2), page.NextKey)
+
+			page = findPage(t, kvStore, base, kv.FindPageOpts{Limit: 2, AfterKey: page.NextKey})
+			assert.Equal(t, toIfaces(expectedEnts[2:4]...), page.Values)
+			require.Equal(t, encodeID(t, 4), page.NextKey)
+
+			page = findPage(t, kvStore, base, kv.FindPageOpts{Limit: 2, AfterKey: page.NextKey})
+			assert.Equal(t, toIfaces(expectedEnts[4]), page.Values)
+			assert.Nil(t, page.NextKey)
+		})
+
+		t.Run("exactly limit entities has no next key", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "find_page")
+			defer done()
+
+			seedEnts(t, kvStore, base, expectedEnts...)
+
+			page := findPage(t, kvStore, base, kv.FindPageOpts{Limit: len(expectedEnts)})
+			assert.Equal(t, toIfaces(expectedEnts...), page.Values)
+			assert.Nil(t, page.NextKey)
+		})
+
+		t.Run("anchor entity deleted between pages", func(t *testing.T) {
+			for name, newBase := range map[string]func(*testing.T, string) (*kv.StoreBase, func(), kv.Store){
+				"bolt":  newFooStoreBase,
+				"inmem": newInmemFooStoreBase,
+			} {
+				t.Run(name, func(t *testing.T) {
+					base, done, kvStore := newBase(t, "find_page")
+					defer done()
+
+					seedEnts(t, kvStore, base, expectedEnts...)
+
+					page := findPage(t, kvStore, base, kv.FindPageOpts{Limit: 2})
+					require.Equal(t, encodeID(t, 2), page.NextKey)
+
+					update(t, kvStore, func(tx kv.Tx) error {
+						if err := base.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)}); err != nil {
+							return err
+						}
+						return base.Put(context.TODO(), tx, newFooEnt(6, 9000, "foo_5"))
+					})
+
+					page = findPage(t, kvStore, base, kv.FindPageOpts{AfterKey: page.NextKey})
+					assert.Equal(t, append(toIfaces(expectedEnts[2:]...), newFooEnt(6, 9000, "foo_5").Body), page.Values)
+					assert.Nil(t, page.NextKey)
+				})
+			}
+		})
+
+		t.Run("with filter", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "find_page")
+			defer done()
+
+			seedEnts(t, kvStore, base, expectedEnts...)
+
+			page := findPage(t, kvStore, base, kv.FindPageOpts{
+				Limit: 1,
+				FilterEntFn: func(key []byte, decodedVal interface{}) bool {
+					return decodedVal.(foo).OrgID == 9004
+				},
+			})
+			assert.Equal(t, toIfaces(expectedEnts[3]), page.Values)
+			require.Equal(t, encodeID(t, 4), page.NextKey)
+		})
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "read_only")
+		defer done()
+
+		ent := newFooEnt(1, 9000, "foo_1")
+		seedEnts(t, kvStore, base, ent)
+
+		find := func(tx kv.Tx) error {
+			return base.Find(context.TODO(), tx, kv.FindOpts{
+				ReadOnly:  true,
+				CaptureFn: func(key []byte, decodedVal interface{}) error { return nil },
+			})
+		}
+		findEnt := func(tx kv.Tx) error {
+			_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK}, kv.FindEntReadOnly())
+			return err
+		}
+
+		for _, fn := range []func(kv.Tx) error{find, findEnt} {
+			view(t, kvStore, fn)
+
+			err := kvStore.Update(context.TODO(), fn)
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
+		}
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "metrics")
+		defer done()
+
+		metrics := &fakeMetrics{}
+		base.Metrics = metrics
+
+		ent := newFooEnt(1, 9000, "foo_1")
+		seedEnts(t, kvStore, base, ent, newFooEnt(2, 9000, "foo_2"))
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+			require.NoError(t, err)
+
+			return base.Find(context.TODO(), tx, kv.FindOpts{
+				CaptureFn: func(key []byte, decodedVal interface{}) error { return nil },
+			})
+		})
+
+		update(t, kvStore, func(tx kv.Tx) error {
+			return base.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+		})
+
+		assert.Equal(t, map[string]int{
+			"put:foo":    2,
+			"find:foo":   2,
+			"decode:foo": 3,
+			"delete:foo": 1,
+		}, metrics.calls)
+	})
+	t.Run("expiry", func(t *testing.T) {
+		newExpiringStoreBase := func(t *testing.T) (*kv.StoreBase, func(), kv.Store) {
+			t.Helper()
+
+			base, done, kvStore := newFooStoreBase(t, "expiry")
+
+			expiryBucket := []byte("foo_expires_at")
+			err := migration.CreateBuckets("add foo expiry bucket", expiryBucket).Up(context.Background(), kvStore.(kv.SchemaStore))
+			require.NoError(t, err)
+			base.ExpiryStore = kv.NewExpiryStore("foo", expiryBucket)
+
+			return base, done, kvStore
+		}
+
+		newExpiringEnt := func(id influxdb.ID, expiresAt time.Time) kv.Entity {
+			ent := newFooEnt(id, 9000, fmt.Sprintf("foo_%d", id))
+			ent.ExpiresAt = expiresAt
+			return ent
+		}
+
+		t.Run("expired entities are not found", func(t *testing.T) {
+			base, done, kvStore := newExpiringStoreBase(t)
+			defer done()
+
+			now := time.Now()
+			expired := newExpiringEnt(1, now.Add(-time.Minute))
+			live := newExpiringEnt(2, now.Add(time.Hour))
+			forever := newExpiringEnt(3, time.Time{})
+			seedEnts(t, kvStore, base, expired, live, forever)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: expired.PK})
+				isNotFoundErr(t, err)
+
+				for _, ent := range []kv.Entity{live, forever} {
+					v, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+					require.NoError(t, err)
+					assert.Equal(t, ent.Body, v)
+				}
+				return nil
+			})
+		})
+
+		t.Run("putting without an expiry clears it", func(t *testing.T) {
+			base, done, kvStore := newExpiringStoreBase(t)
+			defer done()
+
+			ent := newExpiringEnt(1, time.Now().Add(-time.Minute))
+			seedEnts(t, kvStore, base, ent)
+
+			ent.ExpiresAt = time.Time{}
+			seedEnts(t, kvStore, base, ent)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+				require.NoError(t, err)
+				return nil
+			})
+		})
+
+		t.Run("sweep is bounded by max", func(t *testing.T) {
+			base, done, kvStore := newExpiringStoreBase(t)
+			defer done()
+
+			now := time.Now()
+			var ents []kv.Entity
+			for i := 1; i <= 5; i++ {
+				ents = append(ents, newExpiringEnt(influxdb.ID(i), now.Add(-time.Minute)))
+			}
+			live := newExpiringEnt(6, now.Add(time.Hour))
+			seedEnts(t, kvStore, base, append(ents, live)...)
+
+			sweep := func(max int) int {
+				var n int
+				update(t, kvStore, func(tx kv.Tx) error {
+					var err error
+					n, err = base.SweepExpired(context.TODO(), tx, now, max)
+					return err
+				})
+				return n
+			}
+
+			assert.Equal(t, 3, sweep(3))
+			assert.Equal(t, 2, sweep(3))
+			assert.Equal(t, 0, sweep(3))
+
+			var keys []influxdb.ID
+			view(t, kvStore, func(tx kv.Tx) error {
+				return base.Find(context.TODO(), tx, kv.FindOpts{
+					CaptureFn: func(key []byte, decodedVal interface{}) error {
+						keys = append(keys, decodedVal.(foo).ID)
+						return nil
+					},
+				})
+			})
+			assert.Equal(t, []influxdb.ID{6}, keys)
+
+			// the live entity expires once the sweep is run at a later time
+			later := now.Add(2 * time.Hour)
+			update(t, kvStore, func(tx kv.Tx) error {
+				n, err := base.SweepExpired(context.TODO(), tx, later, 3)
+				assert.Equal(t, 1, n)
+				return err
+			})
+		})
+
+		t.Run("sweep requires an expiry store and a max", func(t *testing.T) {
+			base, done, kvStore := newExpiringStoreBase(t)
+			defer done()
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, err := base.SweepExpired(context.TODO(), tx, time.Now(), 0)
+				return err
+			})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+
+			base.ExpiryStore = nil
+			err = kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, err := base.SweepExpired(context.TODO(), tx, time.Now(), 1)
+				return err
+			})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+	t.Run("FindEnt on miss", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_on_miss")
+		defer done()
+
+		// the name is derived from the ID when it is missing
+		seedEnts(t, kvStore, base, kv.Entity{PK: kv.EncID(1), Body: foo{ID: 1, OrgID: 9000}})
+
+		var calls int
+		nameOnMiss := func(ctx context.Context, decodedVal interface{}) (kv.Entity, bool, error) {
+			calls++
+			f := decodedVal.(foo)
+			if f.Name != "" {
+				return kv.Entity{}, false, nil
+			}
+			return newFooEnt(f.ID, f.OrgID, fmt.Sprintf("foo_%d", f.ID)), true, nil
+		}
+		expected := newFooEnt(1, 9000, "foo_1").Body
+
+		t.Run("read only tx returns the populated entity without persisting it", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)}, kv.FindEntOnMiss(nameOnMiss))
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual)
+
+				actual, err = base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				require.NoError(t, err)
+				assert.Empty(t, actual.(foo).Name)
+				return nil
+			})
+		})
+
+		t.Run("populated entity is persisted", func(t *testing.T) {
+			calls = 0
+			update(t, kvStore, func(tx kv.Tx) error {
+				actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)}, kv.FindEntOnMiss(nameOnMiss))
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual)
+				return nil
+			})
+			assert.Equal(t, 1, calls)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual)
+				return nil
+			})
+		})
+
+		t.Run("second read skips the population", func(t *testing.T) {
+			calls = 0
+			var populated bool
+			update(t, kvStore, func(tx kv.Tx) error {
+				actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)}, kv.FindEntOnMiss(func(ctx context.Context, decodedVal interface{}) (kv.Entity, bool, error) {
+					ent, missing, err := nameOnMiss(ctx, decodedVal)
+					populated = missing
+					return ent, missing, err
+				}))
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual)
+				return nil
+			})
+			assert.Equal(t, 1, calls)
+			assert.False(t, populated)
+		})
+
+		t.Run("entity populated with another PK is rejected", func(t *testing.T) {
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)}, kv.FindEntOnMiss(func(ctx context.Context, decodedVal interface{}) (kv.Entity, bool, error) {
+					return newFooEnt(2, 9000, "foo_2"), true, nil
+				}))
+				return err
+			})
+			assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+				isNotFoundErr(t, err)
+				return nil
+			})
+		})
+	})
+
+	t.Run("FindEntInto", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_into")
+		defer done()
+
+		ent := newFooEnt(1, 9000, "foo_1")
+		seedEnts(t, kvStore, base, ent)
+
+		t.Run("correct type", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				var actual foo
+				require.NoError(t, kv.FindEntInto(context.TODO(), tx, base, kv.Entity{PK: kv.EncID(1)}, &actual))
+				assert.Equal(t, ent.Body, actual)
+
+				var iface interface{}
+				require.NoError(t, kv.FindEntInto(context.TODO(), tx, base, kv.Entity{PK: kv.EncID(1)}, &iface))
+				assert.Equal(t, ent.Body, iface)
+				return nil
+			})
+		})
+
+		t.Run("wrong type", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				var actual fooSummary
+				err := kv.FindEntInto(context.TODO(), tx, base, kv.Entity{PK: kv.EncID(1)}, &actual)
+				assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
+				assert.Contains(t, err.Error(), "kv_test.foo")
+				assert.Contains(t, err.Error(), "kv_test.fooSummary")
+				assert.Empty(t, actual)
+				return nil
+			})
+		})
+
+		t.Run("not a pointer", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				err := kv.FindEntInto(context.TODO(), tx, base, kv.Entity{PK: kv.EncID(1)}, foo{})
+				assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+				return nil
+			})
+		})
+
+		t.Run("not found", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				var actual foo
+				isNotFoundErr(t, kv.FindEntInto(context.TODO(), tx, base, kv.Entity{PK: kv.EncID(2)}, &actual))
+				return nil
+			})
+		})
+	})
+
+	t.Run("Find order", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_order")
+		defer done()
+
+		// the names sort in an order unlike the IDs
+		ents := []kv.Entity{
+			newFooEnt(1, 9000, "foo_c"),
+			newFooEnt(2, 9000, "foo_a"),
+			newFooEnt(3, 9000, "foo_d"),
+			newFooEnt(4, 9000, "foo_b"),
+			newFooEnt(5, 9000, "foo_a"),
+		}
+		// seeded out of key order
+		for _, i := range []int{3, 0, 4, 1, 2} {
+			seedEnts(t, kvStore, base, ents[i])
+		}
+
+		find := func(t *testing.T, opts kv.FindOpts) ([]influxdb.ID, error) {
+			var ids []influxdb.ID
+			opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+				ids = append(ids, decodedVal.(foo).ID)
+				return nil
+			}
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				return base.Find(context.TODO(), tx, opts)
+			})
+			return ids, err
+		}
+
+		t.Run("by key", func(t *testing.T) {
+			ids, err := find(t, kv.FindOpts{})
+			require.NoError(t, err)
+			assert.Equal(t, []influxdb.ID{1, 2, 3, 4, 5}, ids)
+
+			ids, err = find(t, kv.FindOpts{Descending: true})
+			require.NoError(t, err)
+			assert.Equal(t, []influxdb.ID{5, 4, 3, 2, 1}, ids)
+		})
+
+		t.Run("by name without a name fn", func(t *testing.T) {
+			_, err := find(t, kv.FindOpts{Sort: kv.SortByName})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+
+		base.DecodedNameFn = func(decodedVal interface{}) string {
+			return decodedVal.(foo).Name
+		}
+
+		t.Run("by name", func(t *testing.T) {
+			ids, err := find(t, kv.FindOpts{Sort: kv.SortByName})
+			require.NoError(t, err)
+			assert.Equal(t, []influxdb.ID{2, 5, 4, 1, 3}, ids)
+
+			ids, err = find(t, kv.FindOpts{Sort: kv.SortByName, Descending: true})
+			require.NoError(t, err)
+			assert.Equal(t, []influxdb.ID{3, 1, 4, 5, 2}, ids)
+		})
+
+		t.Run("by name applies the offset and limit to the sorted entities", func(t *testing.T) {
+			ids, err := find(t, kv.FindOpts{Sort: kv.SortByName, Offset: 1, Limit: 3})
+			require.NoError(t, err)
+			assert.Equal(t, []influxdb.ID{5, 4, 1}, ids)
+
+			ids, err = find(t, kv.FindOpts{Sort: kv.SortByName, Offset: 10})
+			require.NoError(t, err)
+			assert.Empty(t, ids)
+		})
+
+		t.Run("by name filters before sorting", func(t *testing.T) {
+			ids, err := find(t, kv.FindOpts{
+				Sort: kv.SortByName,
+				FilterEntFn: func(key []byte, decodedVal interface{}) bool {
+					return decodedVal.(foo).ID%2 == 1
+				},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []influxdb.ID{5, 1, 3}, ids)
+		})
+	})
+
+	t.Run("Find report more", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_report_more")
+		defer done()
+
+		seedEnts(t, kvStore, base,
+			newFooEnt(1, 9000, "foo_c"),
+			newFooEnt(2, 9000, "foo_a"),
+			newFooEnt(3, 9000, "foo_b"),
+		)
+		base.DecodedNameFn = func(decodedVal interface{}) string {
+			return decodedVal.(foo).Name
+		}
+
+		find := func(t *testing.T, opts kv.FindOpts) ([]influxdb.ID, kv.FindResult) {
+			t.Helper()
+
+			var (
+				ids    []influxdb.ID
+				result kv.FindResult
+			)
+			opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+				ids = append(ids, decodedVal.(foo).ID)
+				return nil
+			}
+			view(t, kvStore, func(tx kv.Tx) error {
+				var err error
+				result, err = base.FindWithResult(context.TODO(), tx, opts)
+				return err
+			})
+			return ids, result
+		}
+
+		for _, findSort := range []kv.FindSort{kv.SortByKey, kv.SortByName} {
+			t.Run(fmt.Sprintf("sort %d", findSort), func(t *testing.T) {
+				t.Run("more than the limit", func(t *testing.T) {
+					ids, result := find(t, kv.FindOpts{Limit: 2, ReportMore: true, Sort: findSort})
+					assert.Len(t, ids, 2)
+					assert.Equal(t, kv.FindResult{Count: 2, HasMore: true}, result)
+
+					ids, result = find(t, kv.FindOpts{Offset: 1, Limit: 1, ReportMore: true, Sort: findSort})
+					assert.Len(t, ids, 1)
+					assert.Equal(t, kv.FindResult{Count: 1, HasMore: true}, result)
+				})
+
+				t.Run("exactly the limit", func(t *testing.T) {
+					ids, result := find(t, kv.FindOpts{Limit: 3, ReportMore: true, Sort: findSort})
+					assert.Len(t, ids, 3)
+					assert.Equal(t, kv.FindResult{Count: 3}, result)
+
+					ids, result = find(t, kv.FindOpts{Offset: 1, Limit: 2, ReportMore: true, Sort: findSort})
+					assert.Len(t, ids, 2)
+					assert.Equal(t, kv.FindResult{Count: 2}, result)
+				})
+
+				t.Run("not reported unless asked", func(t *testing.T) {
+					ids, result := find(t, kv.FindOpts{Limit: 2, Sort: findSort})
+					assert.Len(t, ids, 2)
+					assert.Equal(t, kv.FindResult{Count: 2}, result)
+				})
+			})
+		}
+	})
+
+	t.Run("Find max bytes", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_max_bytes")
+		defer done()
+
+		ents := []kv.Entity{
+			newFooEnt(1, 9000, "a"),
+			newFooEnt(2, 9000, strings.Repeat("b", 200)),
+			newFooEnt(3, 9000, "c"),
+			newFooEnt(4, 9000, "d"),
+		}
+		seedEnts(t, kvStore, base, ents...)
+
+		sizes := make([]int, len(ents))
+		for i, ent := range ents {
+			sizes[i] = len(getEntRaw(t, kvStore, base.BktName, encodeID(t, ent.Body.(foo).ID)))
+		}
+
+		find := func(t *testing.T, opts kv.FindOpts) ([]influxdb.ID, kv.FindResult) {
+			t.Helper()
+
+			var (
+				ids    []influxdb.ID
+				result kv.FindResult
+			)
+			opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+				ids = append(ids, decodedVal.(foo).ID)
+				return nil
+			}
+			view(t, kvStore, func(tx kv.Tx) error {
+				var err error
+				result, err = base.FindWithResult(context.TODO(), tx, opts)
+				return err
+			})
+			return ids, result
+		}
+
+		t.Run("stops before the entity past the cap", func(t *testing.T) {
+			ids, result := find(t, kv.FindOpts{MaxBytes: sizes[0] + sizes[1] - 1})
+			assert.Equal(t, []influxdb.ID{1}, ids)
+			assert.Equal(t, kv.FindResult{Count: 1, HasMore: true}, result)
+		})
+
+		t.Run("entity reaching the cap is captured", func(t *testing.T) {
+			ids, result := find(t, kv.FindOpts{MaxBytes: sizes[0] + sizes[1]})
+			assert.Equal(t, []influxdb.ID{1, 2}, ids)
+			assert.Equal(t, kv.FindResult{Count: 2, HasMore: true}, result)
+		})
+
+		t.Run("first entity is captured whatever its size", func(t *testing.T) {
+			ids, result := find(t, kv.FindOpts{MaxBytes: 1, After: encodeID(t, 1)})
+			assert.Equal(t, []influxdb.ID{2}, ids)
+			assert.Equal(t, kv.FindResult{Count: 1, HasMore: true}, result)
+		})
+
+		t.Run("every entity within the cap", func(t *testing.T) {
+			ids, result := find(t, kv.FindOpts{MaxBytes: sizes[0] + sizes[1] + sizes[2] + sizes[3]})
+			assert.Equal(t, []influxdb.ID{1, 2, 3, 4}, ids)
+			assert.Equal(t, kv.FindResult{Count: 4}, result)
+		})
+
+		t.Run("limit reached before the cap", func(t *testing.T) {
+			ids, result := find(t, kv.FindOpts{MaxBytes: 1 << 20, Limit: 2, ReportMore: true})
+			assert.Equal(t, []influxdb.ID{1, 2}, ids)
+			assert.Equal(t, kv.FindResult{Count: 2, HasMore: true}, result)
+		})
+
+		t.Run("sorted by name is invalid", func(t *testing.T) {
+			base.DecodedNameFn = func(decodedVal interface{}) string {
+				return decodedVal.(foo).Name
+			}
+			defer func() { base.DecodedNameFn = nil }()
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := base.FindWithResult(context.TODO(), tx, kv.FindOpts{MaxBytes: 1, Sort: kv.SortByName})
+				assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+				return nil
+			})
+		})
+	})
+
+	t.Run("BufferedTx", func(t *testing.T) {
+		findIDs := func(t *testing.T, tx kv.Tx, base *kv.StoreBase, opts kv.FindOpts) []influxdb.ID {
+			t.Helper()
+
+			var ids []influxdb.ID
+			opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+				ids = append(ids, decodedVal.(foo).ID)
+				return nil
+			}
+			require.NoError(t, base.Find(context.TODO(), tx, opts))
+			return ids
+		}
+
+		t.Run("reads its own writes", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "buffered_tx")
+			defer done()
+
+			seedEnts(t, kvStore, base,
+				newFooEnt(2, 9000, "foo_2"),
+				newFooEnt(4, 9000, "foo_4"),
+				newFooEnt(6, 9000, "foo_6"),
+			)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				btx := kv.NewBufferedTx(tx)
+				for _, ent := range []kv.Entity{
+					newFooEnt(1, 9000, "foo_1"),
+					newFooEnt(5, 9000, "foo_5"),
+					newFooEnt(4, 9000, "foo_4_renamed"),
+				} {
+					require.NoError(t, base.Put(context.TODO(), btx, ent))
+				}
+				require.NoError(t, base.DeleteEnt(context.TODO(), btx, kv.Entity{PK: kv.EncID(6)}))
+				assert.Equal(t, 4, btx.Len())
+
+				v, err := base.FindEnt(context.TODO(), btx, kv.Entity{PK: kv.EncID(4)})
+				require.NoError(t, err)
+				assert.Equal(t, foo{ID: 4, OrgID: 9000, Name: "foo_4_renamed"}, v)
+				_, err = base.FindEnt(context.TODO(), btx, kv.Entity{PK: kv.EncID(6)})
+				isNotFoundErr(t, err)
+
+				assert.Equal(t, []influxdb.ID{1, 2, 4, 5}, findIDs(t, btx, base, kv.FindOpts{}))
+				assert.Equal(t, []influxdb.ID{5, 4, 2, 1}, findIDs(t, btx, base, kv.FindOpts{Descending: true}))
+				assert.Equal(t, []influxdb.ID{4, 5}, findIDs(t, btx, base, kv.FindOpts{After: encodeID(t, 2)}))
+
+				// nothing is written to the wrapped tx until the flush
+				_, err = base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				isNotFoundErr(t, err)
+				assert.Equal(t, []influxdb.ID{2, 4, 6}, findIDs(t, tx, base, kv.FindOpts{}))
+
+				require.NoError(t, btx.Flush())
+				assert.Equal(t, 0, btx.Len())
+				return nil
+			})
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				assert.Equal(t, []influxdb.ID{1, 2, 4, 5}, findIDs(t, tx, base, kv.FindOpts{}))
+				return nil
+			})
+		})
+
+		t.Run("forward cursor", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "buffered_tx_forward")
+			defer done()
+
+			seedEnts(t, kvStore, base, newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3"))
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				btx := kv.NewBufferedTx(tx)
+				require.NoError(t, base.Put(context.TODO(), btx, newFooEnt(1, 9000, "foo_1")))
+				require.NoError(t, base.DeleteEnt(context.TODO(), btx, kv.Entity{PK: kv.EncID(3)}))
+
+				b, err := btx.Bucket(base.BktName)
+				require.NoError(t, err)
+
+				for _, tt := range []struct {
+					opts []kv.CursorOption
+					want []influxdb.ID
+				}{
+					{want: []influxdb.ID{1, 2}},
+					{opts: []kv.CursorOption{kv.WithCursorDirection(kv.CursorDescending)}, want: []influxdb.ID{2, 1}},
+					{opts: []kv.CursorOption{kv.WithCursorLimit(1)}, want: []influxdb.ID{1}},
+				} {
+					cur, err := b.ForwardCursor(nil, tt.opts...)
+					require.NoError(t, err)
+
+					var ids []influxdb.ID
+					for k, _ := cur.Next(); k != nil; k, _ = cur.Next() {
+						var id influxdb.ID
+						require.NoError(t, id.Decode(k))
+						ids = append(ids, id)
+					}
+					assert.Equal(t, tt.want, ids)
+				}
+				return nil
+			})
+		})
+
+		t.Run("flushes in key order", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "buffered_tx_flush")
+			defer done()
+
+			seedEnts(t, kvStore, base, newFooEnt(2, 9000, "foo_2"))
+
+			err := kv.UpdateBuffered(context.Background(), kvStore, func(tx kv.Tx) error {
+				for _, id := range []influxdb.ID{5, 1, 4, 3} {
+					if err := base.Put(context.TODO(), tx, newFooEnt(id, 9000, fmt.Sprintf("foo_%d", id))); err != nil {
+						return err
+					}
+				}
+				return base.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+			})
+			require.NoError(t, err)
+
+			var flushed []string
+			update(t, kvStore, func(tx kv.Tx) error {
+				btx := kv.NewBufferedTx(&recordingTx{Tx: tx, writes: &flushed})
+				for _, id := range []influxdb.ID{9, 7, 8} {
+					require.NoError(t, base.Put(context.TODO(), btx, newFooEnt(id, 9000, fmt.Sprintf("foo_%d", id))))
+				}
+				require.NoError(t, base.DeleteEnt(context.TODO(), btx, kv.Entity{PK: kv.EncID(1)}))
+				assert.Empty(t, flushed)
+				return btx.Flush()
+			})
+			assert.Equal(t, []string{
+				"delete " + string(encodeID(t, 1)),
+				"put " + string(encodeID(t, 7)),
+				"put " + string(encodeID(t, 8)),
+				"put " + string(encodeID(t, 9)),
+			}, flushed)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				assert.Equal(t, []influxdb.ID{3, 4, 5, 7, 8, 9}, findIDs(t, tx, base, kv.FindOpts{}))
+				return nil
+			})
+		})
+	})
+
+	t.Run("FindKeys", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_keys")
+		defer done()
+
+		seedEnts(t, kvStore, base,
+			newFooEnt(3, 9000, "foo_c"),
+			newFooEnt(1, 9000, "foo_a"),
+			newFooEnt(2, 9000, "foo_b"),
+		)
+		// the values are never decoded by a keys only find
+		base.DecodeEntFn = func(key, val []byte) ([]byte, interface{}, error) {
+			return nil, nil, errors.New("decoded the value")
+		}
+
+		findKeys := func(t *testing.T, opts kv.FindOpts) [][]byte {
+			t.Helper()
+
+			var keys [][]byte
+			view(t, kvStore, func(tx kv.Tx) error {
+				var err error
+				keys, err = base.FindKeys(context.TODO(), tx, opts)
+				return err
+			})
+			return keys
+		}
+
+		t.Run("returns the keys in order", func(t *testing.T) {
+			expected := [][]byte{encodeID(t, 1), encodeID(t, 2), encodeID(t, 3)}
+			assert.Equal(t, expected, findKeys(t, kv.FindOpts{}))
+			assert.Equal(t, [][]byte{encodeID(t, 3), encodeID(t, 2)}, findKeys(t, kv.FindOpts{Descending: true, Limit: 2}))
+		})
+
+		t.Run("formats the keys", func(t *testing.T) {
+			keys := findKeys(t, kv.FindOpts{
+				Offset: 1,
+				KeyFormatFn: func(key []byte) ([]byte, error) {
+					var id influxdb.ID
+					if err := id.Decode(key); err != nil {
+						return nil, err
+					}
+					return []byte(fmt.Sprintf("id=%d", id)), nil
+				},
+			})
+			assert.Equal(t, [][]byte{[]byte("id=2"), []byte("id=3")}, keys)
+		})
+
+		t.Run("stops at the end of the prefix", func(t *testing.T) {
+			prefix := encodeID(t, 2)
+			assert.Equal(t, [][]byte{prefix}, findKeys(t, kv.FindOpts{Prefix: prefix}))
+		})
+
+		t.Run("options requiring the values are invalid", func(t *testing.T) {
+			for _, opts := range []kv.FindOpts{
+				{SummaryOnly: true},
+				{Sort: kv.SortByName},
+				{FilterDecodedFn: func(kv.Entity) bool { return true }},
+			} {
+				err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+					_, err := base.FindKeys(context.TODO(), tx, opts)
+					return err
+				})
+				assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+			}
+		})
+	})
+
+	t.Run("ScanRange", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "scan_range")
+		defer done()
+
+		for i := 1; i <= 5; i++ {
+			seedEnts(t, kvStore, base, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i)))
+		}
+
+		scan := func(t *testing.T, start, end []byte) []influxdb.ID {
+			t.Helper()
+
+			var ids []influxdb.ID
+			view(t, kvStore, func(tx kv.Tx) error {
+				return base.ScanRange(context.TODO(), tx, start, end, func(k, v []byte) error {
+					var f foo
+					if err := json.Unmarshal(v, &f); err != nil {
+						return err
+					}
+					assert.Equal(t, encodeID(t, f.ID), k)
+					ids = append(ids, f.ID)
+					return nil
+				})
+			})
+			return ids
+		}
+
+		tests := []struct {
+			name       string
+			start, end []byte
+			expected   []influxdb.ID
+		}{
+			{name: "bounded", start: encodeID(t, 2), end: encodeID(t, 4), expected: []influxdb.ID{2, 3}},
+			{name: "single element", start: encodeID(t, 3), end: encodeID(t, 4), expected: []influxdb.ID{3}},
+			{name: "empty", start: encodeID(t, 3), end: encodeID(t, 3)},
+			{name: "end before start", start: encodeID(t, 4), end: encodeID(t, 2)},
+			{name: "between keys", start: append(encodeID(t, 2), 0x00), end: encodeID(t, 3)},
+			{name: "open end", start: encodeID(t, 4), expected: []influxdb.ID{4, 5}},
+			{name: "open start", end: encodeID(t, 2), expected: []influxdb.ID{1}},
+			{name: "unbounded", expected: []influxdb.ID{1, 2, 3, 4, 5}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.expected, scan(t, tt.start, tt.end))
+			})
+		}
+
+		t.Run("between keys in memory", func(t *testing.T) {
+			base, done, kvStore := newInmemFooStoreBase(t, "scan_range")
+			defer done()
+
+			for i := 1; i <= 3; i++ {
+				seedEnts(t, kvStore, base, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i)))
+			}
+
+			var ids []influxdb.ID
+			view(t, kvStore, func(tx kv.Tx) error {
+				return base.ScanRange(context.TODO(), tx, append(encodeID(t, 1), 0x00), encodeID(t, 3), func(k, v []byte) error {
+					var f foo
+					if err := json.Unmarshal(v, &f); err != nil {
+						return err
+					}
+					ids = append(ids, f.ID)
+					return nil
+				})
+			})
+			assert.Equal(t, []influxdb.ID{2}, ids)
+		})
+
+		t.Run("error stops the scan", func(t *testing.T) {
+			stopErr := errors.New("stop")
+			var calls int
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				return base.ScanRange(context.TODO(), tx, nil, nil, func(k, v []byte) error {
+					calls++
+					return stopErr
+				})
+			})
+			assert.Equal(t, stopErr, err)
+			assert.Equal(t, 1, calls)
+		})
+	})
+
+	t.Run("Find prefix and after", func(t *testing.T) {
+		// the keys of IDs 0x10 to 0x1f
+		prefix := encodeID(t, 0x10)[:influxdb.IDLength-1]
+
+		tests := []struct {
+			name     string
+			opts     kv.FindOpts
+			expected []influxdb.ID
+		}{
+			{
+				name:     "after before the prefix",
+				opts:     kv.FindOpts{Prefix: prefix, After: encodeID(t, 0x05)},
+				expected: []influxdb.ID{0x10, 0x11, 0x12},
+			},
+			{
+				name:     "after inside the prefix",
+				opts:     kv.FindOpts{Prefix: prefix, After: encodeID(t, 0x10)},
+				expected: []influxdb.ID{0x11, 0x12},
+			},
+			{
+				name: "after the last key of the prefix",
+				opts: kv.FindOpts{Prefix: prefix, After: encodeID(t, 0x12)},
+			},
+			{
+				name: "after past the prefix",
+				opts: kv.FindOpts{Prefix: prefix, After: encodeID(t, 0x20)},
+			},
+			{
+				name:     "after with a limit",
+				opts:     kv.FindOpts{Prefix: prefix, After: encodeID(t, 0x10), Limit: 1},
+				expected: []influxdb.ID{0x11},
+			},
+			{
+				name:     "after without a prefix",
+				opts:     kv.FindOpts{After: encodeID(t, 0x12)},
+				expected: []influxdb.ID{0x20, 0x21},
+			},
+			{
+				name:     "descending after inside the prefix",
+				opts:     kv.FindOpts{Prefix: prefix, After: encodeID(t, 0x12), Descending: true},
+				expected: []influxdb.ID{0x11, 0x10},
+			},
+			{
+				name:     "descending after past the prefix",
+				opts:     kv.FindOpts{Prefix: prefix, After: encodeID(t, 0x21), Descending: true},
+				expected: []influxdb.ID{0x12, 0x11, 0x10},
+			},
+			{
+				name: "descending after before the prefix",
+				opts: kv.FindOpts{Prefix: prefix, After: encodeID(t, 0x05), Descending: true},
+			},
+			{
+				name:     "after between keys without a prefix",
+				opts:     kv.FindOpts{After: encodeID(t, 0x13)},
+				expected: []influxdb.ID{0x20, 0x21},
+			},
+			{
+				name: "after past the last key",
+				opts: kv.FindOpts{After: encodeID(t, 0x22)},
+			},
+		}
+		for name, newBase := range map[string]func(*testing.T, string) (*kv.StoreBase, func(), kv.Store){
+			"bolt":  newFooStoreBase,
+			"inmem": newInmemFooStoreBase,
+		} {
+			t.Run(name, func(t *testing.T) {
+				base, done, kvStore := newBase(t, "find_after")
+				defer done()
+
+				for _, id := range []influxdb.ID{0x10, 0x11, 0x12, 0x20, 0x21} {
+					seedEnts(t, kvStore, base, newFooEnt(id, 9000, fmt.Sprintf("foo_%x", id)))
+				}
+
+				find := func(t *testing.T, opts kv.FindOpts) []influxdb.ID {
+					t.Helper()
+
+					var ids []influxdb.ID
+					opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+						ids = append(ids, decodedVal.(foo).ID)
+						return nil
+					}
+					view(t, kvStore, func(tx kv.Tx) error {
+						return base.Find(context.TODO(), tx, opts)
+					})
+					return ids
+				}
+
+				for _, tt := range tests {
+					t.Run(tt.name, func(t *testing.T) {
+						assert.Equal(t, tt.expected, find(t, tt.opts))
+					})
+				}
+			})
+		}
+	})
+
+	t.Run("Put key validation", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "key_validate")
+		defer done()
+
+		base.KeyValidateFn = func(key []byte) error {
+			if len(key) != influxdb.IDLength {
+				return fmt.Errorf("key is %d bytes rather than %d", len(key), influxdb.IDLength)
+			}
+			return nil
+		}
+
+		short := kv.Entity{PK: kv.EncBytes([]byte("short")), Body: foo{Name: "short"}}
+		err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+			return base.Put(context.TODO(), tx, short)
+		})
+		require.Error(t, err)
+		assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		assert.Contains(t, err.Error(), "foo")
+		assert.Contains(t, err.Error(), "short")
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: short.PK})
+			isNotFoundErr(t, err)
+			return nil
+		})
+
+		ent := newFooEnt(1, 9000, "foo_1")
+		seedEnts(t, kvStore, base, ent)
+		view(t, kvStore, func(tx kv.Tx) error {
+			actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+			require.NoError(t, err)
+			assert.Equal(t, ent.Body, actual)
+			return nil
+		})
+	})
+
+	t.Run("Find summary only", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_summary")
+		defer done()
+
+		seedEnts(t, kvStore, base, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+
+		find := func(opts kv.FindOpts) ([]interface{}, error) {
+			var actual []interface{}
+			opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+				actual = append(actual, decodedVal)
+				return nil
+			}
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				return base.Find(context.TODO(), tx, opts)
+			})
+			return actual, err
+		}
+
+		t.Run("store without a summary fn", func(t *testing.T) {
+			_, err := find(kv.FindOpts{SummaryOnly: true})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+
+		base.ConvertValToSummaryFn = decFooSummaryFn
+
+		t.Run("captures summaries", func(t *testing.T) {
+			actual, err := find(kv.FindOpts{SummaryOnly: true})
+			require.NoError(t, err)
+			assert.Equal(t, []interface{}{
+				fooSummary{ID: 1, Name: "foo_1"},
+				fooSummary{ID: 2, Name: "foo_2"},
+			}, actual)
+		})
+
+		t.Run("filters summaries", func(t *testing.T) {
+			actual, err := find(kv.FindOpts{
+				SummaryOnly: true,
+				FilterEntFn: func(key []byte, decodedVal interface{}) bool {
+					return decodedVal.(fooSummary).Name == "foo_2"
+				},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []interface{}{fooSummary{ID: 2, Name: "foo_2"}}, actual)
+		})
+
+		t.Run("full bodies without the option", func(t *testing.T) {
+			actual, err := find(kv.FindOpts{Limit: 1})
+			require.NoError(t, err)
+			assert.Equal(t, []interface{}{newFooEnt(1, 9000, "foo_1").Body}, actual)
+		})
+
+		t.Run("can not filter decoded entities", func(t *testing.T) {
+			_, err := find(kv.FindOpts{
+				SummaryOnly:     true,
+				FilterDecodedFn: func(ent kv.Entity) bool { return true },
+			})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("Find with decoded filter", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_decoded_filter")
+		defer done()
+
+		// the IDs 0x10 through 0x1f share a key prefix, the odd ones belong
+		// to another org
+		var expected []interface{}
+		seedEnts(t, kvStore, base, newFooEnt(0x01, 9000, "foo_outside_prefix"))
+		for i := 0x10; i <= 0x1f; i++ {
+			orgID := influxdb.ID(9000 + i%2)
+			ent := newFooEnt(influxdb.ID(i), orgID, fmt.Sprintf("foo_%d", i))
+			seedEnts(t, kvStore, base, ent)
+			if orgID == 9000 {
+				expected = append(expected, ent.Body)
+			}
+		}
+
+		find := func(t *testing.T, opts kv.FindOpts) []interface{} {
+			t.Helper()
+
+			var actual []interface{}
+			opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+				actual = append(actual, decodedVal)
+				return nil
+			}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return base.Find(context.TODO(), tx, opts)
+			})
+			return actual
+		}
+
+		inOrg := func(ent kv.Entity) bool {
+			return ent.Body.(foo).OrgID == 9000
+		}
+		prefix := encodeID(t, 0x10)[:influxdb.IDLength-1]
+
+		t.Run("with prefix", func(t *testing.T) {
+			assert.Equal(t, expected, find(t, kv.FindOpts{Prefix: prefix, FilterDecodedFn: inOrg}))
+		})
+
+		t.Run("limit counts entities passing the filter", func(t *testing.T) {
+			assert.Equal(t, expected[:3], find(t, kv.FindOpts{Prefix: prefix, Limit: 3, FilterDecodedFn: inOrg}))
+			assert.Equal(t, expected[2:4], find(t, kv.FindOpts{Prefix: prefix, Offset: 2, Limit: 2, FilterDecodedFn: inOrg}))
+		})
+
+		t.Run("composes with the raw filter", func(t *testing.T) {
+			actual := find(t, kv.FindOpts{
+				Prefix: prefix,
+				Limit:  2,
+				FilterEntFn: func(key []byte, decodedVal interface{}) bool {
+					return decodedVal.(foo).ID >= 0x14
+				},
+				FilterDecodedFn: inOrg,
+			})
+			assert.Equal(t, expected[2:4], actual)
+		})
+	})
+
+	t.Run("FindEnts", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "find_ents")
+		defer done()
+
+		ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3")}
+		seedEnts(t, kvStore, base, ents...)
+
+		t.Run("returns entities in the order provided", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := base.FindEnts(context.TODO(), tx, []kv.Entity{
+					{PK: kv.EncID(3)}, {PK: kv.EncID(1)}, {PK: kv.EncID(3)}, {PK: kv.EncID(2)},
+				})
+				require.NoError(t, err)
+				assert.Equal(t, toIfaces(ents[2], ents[0], ents[2], ents[1]), actual)
+				return nil
+			})
+		})
+
+		t.Run("missing entity is not found", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := base.FindEnts(context.TODO(), tx, []kv.Entity{{PK: kv.EncID(1)}, {PK: kv.EncID(4)}})
+				isNotFoundErr(t, err)
+				assert.True(t, errors.Is(err, kv.ErrEntNotFound("foo", encodeID(t, 4))))
+				return nil
+			})
+		})
+
+		t.Run("allow missing leaves a nil placeholder", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := base.FindEnts(context.TODO(), tx, []kv.Entity{
+					{PK: kv.EncID(4)}, {PK: kv.EncID(2)}, {PK: kv.EncID(0xff)},
+				}, kv.FindEntsAllowMissing())
+				require.NoError(t, err)
+				assert.Equal(t, []interface{}{nil, ents[1].Body, nil}, actual)
+				return nil
+			})
+		})
+	})
+
+	t.Run("Iterator", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "iterator")
+		defer done()
+
+		ents := []kv.Entity{
+			newFooEnt(1, 9000, "foo_1"),
+			newFooEnt(2, 9000, "foo_2"),
+			newFooEnt(3, 9000, "foo_3"),
+			newFooEnt(4, 9000, "foo_4"),
+		}
+		seedEnts(t, kvStore, base, ents...)
+
+		iterate := func(t *testing.T, ctx context.Context, opts kv.FindOpts, max int) ([]interface{}, error) {
+			t.Helper()
+
+			var (
+				actual  []interface{}
+				iterErr error
+			)
+			view(t, kvStore, func(tx kv.Tx) error {
+				iter, err := base.Iterator(ctx, tx, opts)
+				if err != nil {
+					return err
+				}
+				defer iter.Close()
+
+				for iter.Next() {
+					actual = append(actual, iter.Entity().Body)
+					if len(actual) == max {
+						require.NoError(t, iter.Close())
+					}
+				}
+				iterErr = iter.Err()
+				return nil
+			})
+			return actual, iterErr
+		}
+
+		t.Run("all entities", func(t *testing.T) {
+			actual, err := iterate(t, context.TODO(), kv.FindOpts{}, -1)
+			require.NoError(t, err)
+			assert.Equal(t, toIfaces(ents...), actual)
+		})
+
+		t.Run("with options", func(t *testing.T) {
+			actual, err := iterate(t, context.TODO(), kv.FindOpts{Descending: true, Offset: 1, Limit: 2}, -1)
+			require.NoError(t, err)
+			assert.Equal(t, toIfaces(ents[2], ents[1]), actual)
+		})
+
+		t.Run("early close stops the iterator", func(t *testing.T) {
+			actual, err := iterate(t, context.TODO(), kv.FindOpts{}, 2)
+			require.NoError(t, err)
+			assert.Equal(t, toIfaces(ents[0], ents[1]), actual)
+		})
+
+		t.Run("canceled context", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			actual, err := iterate(t, ctx, kv.FindOpts{}, -1)
+			assert.Empty(t, actual)
+			assert.Equal(t, influxdb.ECanceled, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("CachedStore", func(t *testing.T) {
+		findEnt := func(t *testing.T, kvStore kv.Store, store *kv.CachedStore, ent kv.Entity) (interface{}, error) {
+			t.Helper()
+
+			var v interface{}
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				var err error
+				v, err = store.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+				return err
+			})
+			return v, err
+		}
+
+		t.Run("caches entities found in a read only tx", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "cache")
+			defer done()
+
+			store := kv.NewCachedStore(base, 10)
+			ent := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, store, ent)
+
+			v, err := findEnt(t, kvStore, store, ent)
+			require.NoError(t, err)
+			assert.Equal(t, ent.Body, v)
+			assert.Equal(t, 1, store.Len())
+
+			// writing behind the cache's back shows the entity is served from the cache
+			seedEnts(t, kvStore, base, newFooEnt(1, 9000, "foo_behind"))
+			v, err = findEnt(t, kvStore, store, ent)
+			require.NoError(t, err)
+			assert.Equal(t, ent.Body, v)
+		})
+
+		t.Run("update is not read stale", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "cache")
+			defer done()
+
+			store := kv.NewCachedStore(base, 10)
+			seedEnts(t, kvStore, store, newFooEnt(1, 9000, "foo_1"))
+			_, err := findEnt(t, kvStore, store, newFooEnt(1, 9000, "foo_1"))
+			require.NoError(t, err)
+
+			updated := newFooEnt(1, 9000, "foo_updated")
+			update(t, kvStore, func(tx kv.Tx) error {
+				if err := store.Put(context.TODO(), tx, updated); err != nil {
+					return err
+				}
+				v, err := store.FindEnt(context.TODO(), tx, kv.Entity{PK: updated.PK})
+				require.NoError(t, err)
+				assert.Equal(t, updated.Body, v)
+				return nil
+			})
+
+			v, err := findEnt(t, kvStore, store, updated)
+			require.NoError(t, err)
+			assert.Equal(t, updated.Body, v)
+		})
+
+		t.Run("delete is not read stale", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "cache")
+			defer done()
+
+			store := kv.NewCachedStore(base, 10)
+			ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2")}
+			seedEnts(t, kvStore, store, ents...)
+			for _, ent := range ents {
+				_, err := findEnt(t, kvStore, store, ent)
+				require.NoError(t, err)
+			}
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return store.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ents[0].PK})
+			})
+			_, err := findEnt(t, kvStore, store, ents[0])
+			isNotFoundErr(t, err)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return store.Delete(context.TODO(), tx, kv.DeleteOpts{
+					FilterFn: func(k []byte, v interface{}) bool { return true },
+				})
+			})
+			_, err = findEnt(t, kvStore, store, ents[1])
+			isNotFoundErr(t, err)
+		})
+
+		t.Run("rolled back write leaves the committed entity", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "cache")
+			defer done()
+
+			store := kv.NewCachedStore(base, 10)
+			ent := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, store, ent)
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				if err := store.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_uncommitted")); err != nil {
+					return err
+				}
+				return errors.New("rollback")
+			})
+			require.Error(t, err)
+
+			v, err := findEnt(t, kvStore, store, ent)
+			require.NoError(t, err)
+			assert.Equal(t, ent.Body, v)
+		})
+
+		t.Run("evicts the least recently used entity", func(t *testing.T) {
+			base, done, kvStore := newFooStoreBase(t, "cache")
+			defer done()
+
+			store := kv.NewCachedStore(base, 2)
+			ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3")}
+			seedEnts(t, kvStore, store, ents...)
+
+			for _, ent := range []kv.Entity{ents[0], ents[1], ents[0], ents[2]} {
+				_, err := findEnt(t, kvStore, store, ent)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, 2, store.Len())
+
+			// entity 2 was evicted, so a write behind the cache's back is seen for it alone
+			seedEnts(t, kvStore, base, newFooEnt(1, 9000, "foo_1b"), newFooEnt(2, 9000, "foo_2b"))
+			v, err := findEnt(t, kvStore, store, ents[0])
+			require.NoError(t, err)
+			assert.Equal(t, ents[0].Body, v)
+			v, err = findEnt(t, kvStore, store, ents[1])
+			require.NoError(t, err)
+			assert.Equal(t, newFooEnt(2, 9000, "foo_2b").Body, v)
+		})
+	})
+	t.Run("decode errors", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "decode_errors")
+		defer done()
+
+		key := encodeID(t, 1)
+		update(t, kvStore, func(tx kv.Tx) error {
+			b, err := tx.Bucket([]byte("foo_decode_errors"))
+			if err != nil {
+				return err
+			}
+			return b.Put(key, []byte("not json"))
+		})
+
+		assertDecodeErr := func(t *testing.T, err error) {
+			t.Helper()
+
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
+
+			decErr, ok := kv.AsDecodeError(err)
+			require.True(t, ok, "expected a decode error: %v", err)
+			assert.Equal(t, "foo", decErr.Resource)
+			assert.Equal(t, key, decErr.Key)
+			assert.Equal(t, len("not json"), decErr.Len)
+		}
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+			assertDecodeErr(t, err)
+
+			err = base.Find(context.TODO(), tx, kv.FindOpts{
+				CaptureFn: func(key []byte, decodedVal interface{}) error { return nil },
+			})
+			assertDecodeErr(t, err)
+			return nil
+		})
+
+		t.Run("storage errors are not decode errors", func(t *testing.T) {
+			missing := kv.NewStoreBase("foo", []byte("foo_missing"), kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := missing.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				require.Error(t, err)
+				_, ok := kv.AsDecodeError(err)
+				assert.False(t, ok)
+				return nil
+			})
+		})
+	})
+
+	t.Run("max value size", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "max_value_size")
+		defer done()
+
+		ent := newFooEnt(1, 9000, "foo_1")
+		body, err := json.Marshal(ent.Body)
+		require.NoError(t, err)
+
+		put := func(ent kv.Entity) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, ent)
+			})
+		}
+
+		t.Run("value at the limit is put", func(t *testing.T) {
+			base.WithMaxValueSize(len(body))
+			require.NoError(t, put(ent))
+		})
+
+		t.Run("value over the limit is rejected", func(t *testing.T) {
+			base.WithMaxValueSize(len(body) - 1)
+
+			err := put(newFooEnt(2, 9000, "foo_2"))
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+			assert.Equal(t, fmt.Sprintf("foo value of %d bytes exceeds the max value size of %d bytes", len(body), len(body)-1), err.Error())
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+				isNotFoundErr(t, err)
+				return nil
+			})
+		})
+
+		t.Run("dry run is rejected", func(t *testing.T) {
+			base.WithMaxValueSize(len(body) - 1)
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_2"), kv.PutDryRun())
+			})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
 		})
 
-		t.Run("new", func(t *testing.T) {
-			base, done, kvStore := newFooStoreBase(t, "put")
+		t.Run("no limit by default", func(t *testing.T) {
+			base.WithMaxValueSize(0)
+			require.NoError(t, put(newFooEnt(2, 9000, strings.Repeat("foo", 1<<16))))
+		})
+	})
+
+	t.Run("empty keys", func(t *testing.T) {
+		newNameKeyedStore := func(t *testing.T, kvStore kv.SchemaStore, bktName []byte) *kv.StoreBase {
+			t.Helper()
+
+			err := migration.CreateBuckets("add foo bucket", bktName).Up(context.Background(), kvStore)
+			require.NoError(t, err)
+			return kv.NewStoreBase("foo", bktName, kv.EncUniqKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+		}
+		unnamed := kv.Entity{UniqueKey: kv.EncString(""), Body: foo{ID: 1, OrgID: 9000}}
+
+		t.Run("are rejected", func(t *testing.T) {
+			kvStore, done, err := NewTestBoltStore(t)
+			require.NoError(t, err)
 			defer done()
 
-			expected := newFooEnt(3, 33, "name3")
-			update(t, kvStore, func(tx kv.Tx) error {
-				return base.Put(context.TODO(), tx, expected, kv.PutNew())
+			base := newNameKeyedStore(t, kvStore, []byte("foo_empty_keys"))
+
+			err = kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, unnamed)
 			})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+			assert.Contains(t, err.Error(), "foo key is empty")
+
+			_, err = base.EntKey(context.TODO(), unnamed)
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
 
-			var actual interface{}
 			view(t, kvStore, func(tx kv.Tx) error {
-				f, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: expected.PK})
-				actual = f
-				return err
+				n, err := base.Count(context.TODO(), tx, nil)
+				require.NoError(t, err)
+				assert.Zero(t, n)
+				return nil
 			})
-
-			assert.Equal(t, expected.Body, actual)
 		})
 
-		t.Run("update", func(t *testing.T) {
-			base, done, kvStore := newFooStoreBase(t, "put")
+		t.Run("are put when allowed", func(t *testing.T) {
+			// bolt does not support empty keys, unlike the in memory store
+			kvStore, done, err := NewTestInmemStore(t)
+			require.NoError(t, err)
 			defer done()
 
-			expected := testPutBase(t, kvStore, base, base.BktName)
+			base := newNameKeyedStore(t, kvStore, []byte("foo_empty_keys")).WithEmptyKeys()
 
-			updateEnt := newFooEnt(expected.ID, expected.OrgID, "new name")
 			update(t, kvStore, func(tx kv.Tx) error {
-				return base.Put(context.TODO(), tx, updateEnt, kv.PutUpdate())
+				return base.Put(context.TODO(), tx, unnamed)
 			})
-
-			var actual interface{}
 			view(t, kvStore, func(tx kv.Tx) error {
-				f, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(expected.ID)})
-				actual = f
-				return err
+				actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: kv.EncString("")})
+				require.NoError(t, err)
+				assert.Equal(t, unnamed.Body, actual)
+				return nil
 			})
-
-			expected.Name = "new name"
-			assert.Equal(t, expected, actual)
 		})
+	})
 
-		t.Run("error cases", func(t *testing.T) {
-			t.Run("new entity conflicts with existing", func(t *testing.T) {
-				base, done, kvStore := newFooStoreBase(t, "put")
-				defer done()
+	t.Run("integrity check", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "integrity")
+		defer done()
+		base.WithIntegrityCheck()
 
-				expected := testPutBase(t, kvStore, base, base.BktName)
+		bktName := []byte("foo_integrity")
+		legacy := kv.NewStoreBase("foo", bktName, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+		update(t, kvStore, func(tx kv.Tx) error {
+			if err := base.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1")); err != nil {
+				return err
+			}
+			return legacy.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_2"))
+		})
 
-				err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
-					entCopy := newFooEnt(expected.ID, expected.OrgID, expected.Name)
-					return base.Put(context.TODO(), tx, entCopy, kv.PutNew())
-				})
-				require.Error(t, err)
-				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+		t.Run("values with and without a checksum are read", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				for _, ent := range []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2")} {
+					actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+					require.NoError(t, err)
+					assert.Equal(t, ent.Body, actual)
+				}
+				return nil
 			})
 		})
 
-		t.Run("updating entity that does not exist", func(t *testing.T) {
-			base, done, kvStore := newFooStoreBase(t, "put")
-			defer done()
+		t.Run("a corrupt value is detected", func(t *testing.T) {
+			key := encodeID(t, 1)
+			update(t, kvStore, func(tx kv.Tx) error {
+				b, err := tx.Bucket(bktName)
+				if err != nil {
+					return err
+				}
+				val, err := b.Get(key)
+				if err != nil {
+					return err
+				}
+				corrupt := append([]byte(nil), val...)
+				corrupt[len(corrupt)-2] ^= 0xff
+				return b.Put(key, corrupt)
+			})
 
-			expected := testPutBase(t, kvStore, base, base.BktName)
+			assertCorruptionErr := func(t *testing.T, err error) {
+				t.Helper()
 
-			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
-				// ent by id does not exist
-				entCopy := newFooEnt(333, expected.OrgID, "name1")
-				return base.Put(context.TODO(), tx, entCopy, kv.PutUpdate())
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
+
+				corruptErr, ok := kv.AsCorruptionError(err)
+				require.True(t, ok, "expected a corruption error: %v", err)
+				assert.Equal(t, "foo", corruptErr.Resource)
+				assert.Equal(t, key, corruptErr.Key)
+				assert.NotEqual(t, corruptErr.Expected, corruptErr.Actual)
+			}
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				assertCorruptionErr(t, err)
+
+				err = base.Find(context.TODO(), tx, kv.FindOpts{
+					CaptureFn: func(key []byte, decodedVal interface{}) error { return nil },
+				})
+				assertCorruptionErr(t, err)
+				return nil
 			})
-			require.Error(t, err)
-			assert.Equal(t, influxdb.ENotFound, influxdb.ErrorCode(err))
 		})
 	})
 
-	t.Run("DeleteEnt", func(t *testing.T) {
-		base, done, kvStore := newFooStoreBase(t, "delete_ent")
+	t.Run("schema versioning", func(t *testing.T) {
+		base, done, kvStore := newFooStoreBase(t, "schema")
 		defer done()
 
-		testDeleteEntBase(t, kvStore, base)
-	})
+		// v0 named the field Title and v1 Label, before v2 settled on Name
+		var migrated []uint8
+		rename := func(from uint8, oldField, newField string) kv.SchemaMigrationFn {
+			return func(body []byte) ([]byte, error) {
+				migrated = append(migrated, from)
+				var m map[string]interface{}
+				if err := json.Unmarshal(body, &m); err != nil {
+					return nil, err
+				}
+				m[newField] = m[oldField]
+				delete(m, oldField)
+				return json.Marshal(m)
+			}
+		}
+		base.WithSchemaVersion(2).
+			WithSchemaMigration(0, rename(0, "Title", "Label")).
+			WithSchemaMigration(1, rename(1, "Label", "Name"))
 
-	t.Run("Delete", func(t *testing.T) {
-		testDeleteBase(t, func(t *testing.T, suffix string) (storeBase, func(), kv.Store) {
-			return newFooStoreBase(t, suffix)
+		putRaw := func(t *testing.T, id influxdb.ID, val []byte) {
+			t.Helper()
+			update(t, kvStore, func(tx kv.Tx) error {
+				b, err := tx.Bucket(base.BktName)
+				if err != nil {
+					return err
+				}
+				return b.Put(encodeID(t, id), val)
+			})
+		}
+		find := func(t *testing.T, id influxdb.ID) (interface{}, error) {
+			t.Helper()
+			var actual interface{}
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				var err error
+				actual, err = base.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(id)})
+				return err
+			})
+			return actual, err
+		}
+
+		t.Run("unversioned value is migrated from v0 through each version", func(t *testing.T) {
+			migrated = nil
+			putRaw(t, 1, []byte(`{"ID":"0000000000000001","OrgID":"0000000000002328","Title":"foo_1"}`))
+
+			actual, err := find(t, 1)
+			require.NoError(t, err)
+			assert.Equal(t, foo{ID: 1, OrgID: 9000, Name: "foo_1"}, actual)
+			assert.Equal(t, []uint8{0, 1}, migrated)
 		})
-	})
 
-	t.Run("FindEnt", func(t *testing.T) {
-		base, done, kvStore := newFooStoreBase(t, "find_ent")
-		defer done()
+		t.Run("v1 value is only migrated from v1", func(t *testing.T) {
+			migrated = nil
+			putRaw(t, 2, append([]byte{0x00, 's', 'v', 1}, `{"ID":"0000000000000002","OrgID":"0000000000002328","Label":"foo_2"}`...))
 
-		testFindEnt(t, kvStore, base)
-	})
+			actual, err := find(t, 2)
+			require.NoError(t, err)
+			assert.Equal(t, foo{ID: 2, OrgID: 9000, Name: "foo_2"}, actual)
+			assert.Equal(t, []uint8{1}, migrated)
+		})
 
-	t.Run("Find", func(t *testing.T) {
-		testFind(t, func(t *testing.T, suffix string) (storeBase, func(), kv.Store) {
-			return newFooStoreBase(t, suffix)
+		t.Run("value put is stored at the current version", func(t *testing.T) {
+			migrated = nil
+			ent := newFooEnt(3, 9000, "foo_3")
+			seedEnts(t, kvStore, base, ent)
+
+			raw := getEntRaw(t, kvStore, base.BktName, encodeID(t, 3))
+			assert.Equal(t, []byte{0x00, 's', 'v', 2}, raw[:4])
+
+			actual, err := find(t, 3)
+			require.NoError(t, err)
+			assert.Equal(t, ent.Body, actual)
+			assert.Empty(t, migrated)
+		})
+
+		t.Run("newer version fails to decode", func(t *testing.T) {
+			putRaw(t, 4, append([]byte{0x00, 's', 'v', 3}, `{"ID":"0000000000000004"}`...))
+
+			_, err := find(t, 4)
+			assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
 		})
 	})
 }
 
+type fakeMetrics struct {
+	calls map[string]int
+}
+
+func (m *fakeMetrics) ObservePut(resource string, dur time.Duration) { m.observe("put", resource) }
+
+func (m *fakeMetrics) ObserveFind(resource string, dur time.Duration) { m.observe("find", resource) }
+
+func (m *fakeMetrics) ObserveDelete(resource string, dur time.Duration) {
+	m.observe("delete", resource)
+}
+
+func (m *fakeMetrics) ObserveIndexLookup(resource string, dur time.Duration) {
+	m.observe("index_lookup", resource)
+}
+
+func (m *fakeMetrics) ObserveDecode(resource string, dur time.Duration) {
+	m.observe("decode", resource)
+}
+
+func (m *fakeMetrics) ObserveIndexFetch(resource string, dur time.Duration) {
+	m.observe("index_fetch", resource)
+}
+
+func (m *fakeMetrics) observe(op, resource string) {
+	if m.calls == nil {
+		m.calls = make(map[string]int)
+	}
+	m.calls[op+":"+resource]++
+}
+
 func testPutBase(t *testing.T, kvStore kv.Store, base storeBase, bktName []byte) foo {
 	t.Helper()
 
@@ -340,6 +2267,46 @@ func testFind(t *testing.T, fn func(t *testing.T, suffix string) (storeBase, fun
 			},
 			expected: toIfaces(expectedEnts[2], expectedEnts[3]),
 		},
+		{
+			name: "with descending and limit",
+			opts: kv.FindOpts{
+				Descending: true,
+				Limit:      2,
+			},
+			expected: toIfaces(expectedEnts[3], expectedEnts[2]),
+		},
+		{
+			name: "with descending and id prefix",
+			opts: kv.FindOpts{
+				Descending: true,
+				Prefix:     encodeID(t, 2000)[:influxdb.IDLength-2],
+			},
+			expected: toIfaces(expectedEnts[1]),
+		},
+		{
+			name: "with descending, id prefix, and limit",
+			opts: kv.FindOpts{
+				Descending: true,
+				Limit:      2,
+				Prefix:     encodeID(t, 1)[:influxdb.IDLength-5],
+			},
+			expected: toIfaces(expectedEnts[1], expectedEnts[0]),
+		},
+		{
+			name: "with descending and id prefix matching the last key",
+			opts: kv.FindOpts{
+				Descending: true,
+				Prefix:     encodeID(t, 4000000000),
+			},
+			expected: toIfaces(expectedEnts[3]),
+		},
+		{
+			name: "with descending and id prefix matching no keys",
+			opts: kv.FindOpts{
+				Descending: true,
+				Prefix:     []byte("zzz"),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -365,6 +2332,37 @@ func testFind(t *testing.T, fn func(t *testing.T, suffix string) (storeBase, fun
 	}
 }
 
+// recordingTx records the writes made via its buckets, in the order they are made.
+type recordingTx struct {
+	kv.Tx
+
+	writes *[]string
+}
+
+func (tx *recordingTx) Bucket(b []byte) (kv.Bucket, error) {
+	bkt, err := tx.Tx.Bucket(b)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingBucket{Bucket: bkt, writes: tx.writes}, nil
+}
+
+type recordingBucket struct {
+	kv.Bucket
+
+	writes *[]string
+}
+
+func (b *recordingBucket) Put(key, value []byte) error {
+	*b.writes = append(*b.writes, "put "+string(key))
+	return b.Bucket.Put(key, value)
+}
+
+func (b *recordingBucket) Delete(key []byte) error {
+	*b.writes = append(*b.writes, "delete "+string(key))
+	return b.Bucket.Delete(key)
+}
+
 type foo struct {
 	ID    influxdb.ID
 	OrgID influxdb.ID
@@ -379,8 +2377,8 @@ func decodeJSON(t *testing.T, b []byte, v interface{}) {
 
 type storeBase interface {
 	Delete(ctx context.Context, tx kv.Tx, opts kv.DeleteOpts) error
-	DeleteEnt(ctx context.Context, tx kv.Tx, ent kv.Entity) error
-	FindEnt(ctx context.Context, tx kv.Tx, ent kv.Entity) (interface{}, error)
+	DeleteEnt(ctx context.Context, tx kv.Tx, ent kv.Entity, opts ...kv.DeleteEntOptionFn) error
+	FindEnt(ctx context.Context, tx kv.Tx, ent kv.Entity, opts ...kv.FindEntOptionFn) (interface{}, error)
 	Find(ctx context.Context, tx kv.Tx, opts kv.FindOpts) error
 	Put(ctx context.Context, tx kv.Tx, ent kv.Entity, opts ...kv.PutOptionFn) error
 }
@@ -438,6 +2436,19 @@ func decJSONFooFn(key, val []byte) ([]byte, interface{}, error) {
 	return key, f, nil
 }
 
+type fooSummary struct {
+	ID   influxdb.ID
+	Name string
+}
+
+func decFooSummaryFn(key, val []byte) ([]byte, interface{}, error) {
+	var f fooSummary
+	if err := json.Unmarshal(val, &f); err != nil {
+		return nil, nil, err
+	}
+	return key, f, nil
+}
+
 func decFooEntFn(k []byte, v interface{}) (kv.Entity, error) {
 	f, ok := v.(foo)
 	if !ok {
@@ -483,3 +2494,246 @@ func reverseSlc(slc []interface{}) []interface{} {
 	}
 	return slc
 }
+
+func BenchmarkStoreBase_FindEnts(b *testing.B) {
+	f, err := ioutil.TempFile("", "influxdata-bolt-")
+	if err != nil {
+		b.Fatal(errors.New("unable to open temporary boltdb file"))
+	}
+	f.Close()
+
+	path := f.Name()
+	s := bolt.NewKVStore(zaptest.NewLogger(b), path, bolt.WithNoSync)
+	if err := s.Open(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		s.Close()
+		os.Remove(path)
+	}()
+
+	bktName := []byte("foo_find_ents")
+	if err := migration.CreateBuckets("add foo bucket", bktName).Up(context.Background(), s); err != nil {
+		b.Fatal(err)
+	}
+	base := kv.NewStoreBase("foo", bktName, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+
+	const n = 1000
+	ents := make([]kv.Entity, 0, n)
+	err = s.Update(context.Background(), func(tx kv.Tx) error {
+		for i := 1; i <= n; i++ {
+			ent := newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i))
+			if err := base.Put(context.Background(), tx, ent); err != nil {
+				return err
+			}
+			ents = append(ents, kv.Entity{PK: ent.PK})
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	// resolve the entities in an order unlike the bucket's
+	rand.New(rand.NewSource(1)).Shuffle(len(ents), func(i, j int) { ents[i], ents[j] = ents[j], ents[i] })
+
+	b.Run("FindEnt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			err := s.View(context.Background(), func(tx kv.Tx) error {
+				for _, ent := range ents {
+					if _, err := base.FindEnt(context.Background(), tx, ent); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("FindEnts", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			err := s.View(context.Background(), func(tx kv.Tx) error {
+				_, err := base.FindEnts(context.Background(), tx, ents)
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStoreBase_FindKeysOnly(b *testing.B) {
+	f, err := ioutil.TempFile("", "influxdata-bolt-")
+	if err != nil {
+		b.Fatal(errors.New("unable to open temporary boltdb file"))
+	}
+	f.Close()
+
+	path := f.Name()
+	s := bolt.NewKVStore(zaptest.NewLogger(b), path, bolt.WithNoSync)
+	if err := s.Open(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		s.Close()
+		os.Remove(path)
+	}()
+
+	bktName := []byte("foo_find_keys")
+	if err := migration.CreateBuckets("add foo bucket", bktName).Up(context.Background(), s); err != nil {
+		b.Fatal(err)
+	}
+	base := kv.NewStoreBase("foo", bktName, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+
+	const n = 10000
+	err = s.Update(context.Background(), func(tx kv.Tx) error {
+		for i := 1; i <= n; i++ {
+			if err := base.Put(context.Background(), tx, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var ids [][]byte
+			err := s.View(context.Background(), func(tx kv.Tx) error {
+				return base.Find(context.Background(), tx, kv.FindOpts{
+					CaptureFn: func(key []byte, decodedVal interface{}) error {
+						id, err := decodedVal.(foo).ID.Encode()
+						ids = append(ids, id)
+						return err
+					},
+				})
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(ids) != n {
+				b.Fatalf("found %d entities, expected %d", len(ids), n)
+			}
+		}
+	})
+
+	b.Run("keys only", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var ids [][]byte
+			err := s.View(context.Background(), func(tx kv.Tx) error {
+				var err error
+				ids, err = base.FindKeys(context.Background(), tx, kv.FindOpts{})
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(ids) != n {
+				b.Fatalf("found %d keys, expected %d", len(ids), n)
+			}
+		}
+	})
+}
+
+func BenchmarkStoreBase_FindSummaryOnly(b *testing.B) {
+	f, err := ioutil.TempFile("", "influxdata-bolt-")
+	if err != nil {
+		b.Fatal(errors.New("unable to open temporary boltdb file"))
+	}
+	f.Close()
+
+	path := f.Name()
+	s := bolt.NewKVStore(zaptest.NewLogger(b), path, bolt.WithNoSync)
+	if err := s.Open(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		s.Close()
+		os.Remove(path)
+	}()
+
+	bktName := []byte("foo_find_summary")
+	if err := migration.CreateBuckets("add foo bucket", bktName).Up(context.Background(), s); err != nil {
+		b.Fatal(err)
+	}
+
+	// fooWithCells embeds a large blob, as the cells of a dashboard
+	type fooCell struct {
+		ID         string
+		Query      string
+		Properties map[string]interface{}
+	}
+	type fooWithCells struct {
+		ID    influxdb.ID
+		OrgID influxdb.ID
+		Name  string
+		Cells []fooCell
+	}
+	decFn := func(key, val []byte) ([]byte, interface{}, error) {
+		var f fooWithCells
+		if err := json.Unmarshal(val, &f); err != nil {
+			return nil, nil, err
+		}
+		return key, f, nil
+	}
+	base := kv.NewStoreBase("foo", bktName, kv.EncIDKey, kv.EncBodyJSON, decFn, decFooEntFn)
+	base.ConvertValToSummaryFn = decFooSummaryFn
+
+	const n = 100
+	err = s.Update(context.Background(), func(tx kv.Tx) error {
+		for i := 1; i <= n; i++ {
+			ent := fooWithCells{ID: influxdb.ID(i), OrgID: 9000, Name: fmt.Sprintf("foo_%d", i)}
+			for j := 0; j < 50; j++ {
+				ent.Cells = append(ent.Cells, fooCell{
+					ID:    fmt.Sprintf("cell_%d", j),
+					Query: `from(bucket: "foo") |> range(start: -1h) |> filter(fn: (r) => r._measurement == "cpu")`,
+					Properties: map[string]interface{}{
+						"colors": []string{"#00C9FF", "#22ADF6", "#BE2EE4"},
+						"x":      j,
+						"y":      j * 2,
+					},
+				})
+			}
+			if err := base.Put(context.Background(), tx, kv.Entity{PK: kv.EncID(ent.ID), Body: ent}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	find := func(b *testing.B, opts kv.FindOpts) {
+		var found int
+		opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+			found++
+			return nil
+		}
+		for i := 0; i < b.N; i++ {
+			found = 0
+			err := s.View(context.Background(), func(tx kv.Tx) error {
+				return base.Find(context.Background(), tx, opts)
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if found != n {
+				b.Fatalf("found %d entities, expected %d", found, n)
+			}
+		}
+	}
+
+	b.Run("full", func(b *testing.B) {
+		find(b, kv.FindOpts{})
+	})
+
+	b.Run("summary", func(b *testing.B) {
+		find(b, kv.FindOpts{SummaryOnly: true})
+	})
+}
@@ -0,0 +1,221 @@
+package kv
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// CachedStore wraps a StoreBase with an LRU cache of the entities found via FindEnt.
+// Entities are cached by their key when found in a read only tx, and invalidated
+// once a tx that puts or deletes them is committed. Writable transactions never
+// read from, or add to, the cache, so a tx always sees its own writes. Writes must
+// be made in a tx that implements CommitHookTx.
+//
+// Cached values are shared between callers and must not be mutated. A StoreBase
+// with an ExpiryStore is not cached, as expiry is decided on every read. A read
+// only tx opened before a write is committed still sees the prior entity, and
+// a find in that tx made after the commit may cache it; long running read only
+// transactions should find entities via the StoreBase instead.
+type CachedStore struct {
+	store *StoreBase
+	size  int
+
+	mu sync.Mutex
+	// gen is bumped on every invalidation. A find only adds to the cache when no
+	// invalidation happened while it was reading from the store.
+	gen   uint64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key string
+	val interface{}
+}
+
+// NewCachedStore creates a CachedStore that holds up to size entities of the store.
+func NewCachedStore(store *StoreBase, size int) *CachedStore {
+	return &CachedStore{
+		store: store,
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Delete deletes entities by the provided options. See StoreBase.Delete.
+func (s *CachedStore) Delete(ctx context.Context, tx Tx, opts DeleteOpts) error {
+	span, ctx := s.store.startSpan(ctx)
+	defer span.Finish()
+
+	hookTx, err := s.commitHookTx(tx)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	opts.DeleteRelationFns = append(opts.DeleteRelationFns, func(k []byte, v interface{}) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err := s.store.Delete(ctx, tx, opts); err != nil {
+		return err
+	}
+
+	s.invalidateOnCommit(hookTx, keys...)
+	return nil
+}
+
+// DeleteEnt deletes an entity. See StoreBase.DeleteEnt.
+func (s *CachedStore) DeleteEnt(ctx context.Context, tx Tx, ent Entity, opts ...DeleteEntOptionFn) error {
+	span, ctx := s.store.startSpan(ctx)
+	defer span.Finish()
+
+	hookTx, err := s.commitHookTx(tx)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.store.EntKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+	if err := s.store.DeleteEnt(ctx, tx, ent, opts...); err != nil {
+		return err
+	}
+
+	s.invalidateOnCommit(hookTx, string(key))
+	return nil
+}
+
+// Find provides a mechanism for looking through the bucket via the set options.
+// Finds are not cached. See StoreBase.Find.
+func (s *CachedStore) Find(ctx context.Context, tx Tx, opts FindOpts) error {
+	return s.store.Find(ctx, tx, opts)
+}
+
+// FindEnt returns the decoded entity body via the provided entity, from the cache
+// when present. See StoreBase.FindEnt.
+func (s *CachedStore) FindEnt(ctx context.Context, tx Tx, ent Entity, opts ...FindEntOptionFn) (interface{}, error) {
+	span, ctx := s.store.startSpan(ctx)
+	defer span.Finish()
+
+	if wtx, ok := tx.(WritableTx); !ok || wtx.Writable() || s.store.ExpiryStore != nil {
+		span.SetTag("Cached", false)
+		return s.store.FindEnt(ctx, tx, ent, opts...)
+	}
+
+	key, err := s.store.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	gen := s.gen
+	if el, ok := s.items[string(key)]; ok {
+		s.ll.MoveToFront(el)
+		s.mu.Unlock()
+		span.SetTag("Cached", true)
+		return el.Value.(*cacheEntry).val, nil
+	}
+	s.mu.Unlock()
+
+	span.SetTag("Cached", false)
+	v, err := s.store.FindEnt(ctx, tx, ent, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.add(gen, string(key), v)
+	return v, nil
+}
+
+// Put will persist the entity. See StoreBase.Put.
+func (s *CachedStore) Put(ctx context.Context, tx Tx, ent Entity, opts ...PutOptionFn) error {
+	span, ctx := s.store.startSpan(ctx)
+	defer span.Finish()
+
+	hookTx, err := s.commitHookTx(tx)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.store.EntKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(ctx, tx, ent, opts...); err != nil {
+		return err
+	}
+
+	s.invalidateOnCommit(hookTx, string(key))
+	return nil
+}
+
+// Len returns the number of entities in the cache.
+func (s *CachedStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ll.Len()
+}
+
+func (s *CachedStore) commitHookTx(tx Tx) (CommitHookTx, error) {
+	hookTx, ok := tx.(CommitHookTx)
+	if !ok {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("%s cache requires a transaction that supports commit hooks", s.store.Resource),
+		}
+	}
+	return hookTx, nil
+}
+
+// invalidateOnCommit removes the keys from the cache once the tx is committed.
+// The keys are removed right away as well, so finds made while the tx is open
+// are not served entities the tx is replacing for longer than needed.
+func (s *CachedStore) invalidateOnCommit(tx CommitHookTx, keys ...string) {
+	s.invalidate(keys...)
+	tx.OnCommit(func() {
+		s.invalidate(keys...)
+	})
+}
+
+func (s *CachedStore) invalidate(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gen++
+	for _, key := range keys {
+		if el, ok := s.items[key]; ok {
+			s.ll.Remove(el)
+			delete(s.items, key)
+		}
+	}
+}
+
+func (s *CachedStore) add(gen uint64, key string, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size <= 0 || gen != s.gen {
+		return
+	}
+
+	if el, ok := s.items[key]; ok {
+		s.ll.MoveToFront(el)
+		el.Value.(*cacheEntry).val = val
+		return
+	}
+
+	s.items[key] = s.ll.PushFront(&cacheEntry{key: key, val: val})
+	if s.ll.Len() > s.size {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// CopyTransformFn returns the entity to put into the destination of a CopyStore in
+// place of the source entity.
+type CopyTransformFn func(ent Entity) (Entity, error)
+
+// CopyStore copies every entity of the src store into the dst store, streaming the
+// entities of the src entity store through the transform before putting them as
+// new entities of the dst, which creates their indexes. A nil transform copies the
+// entities as is. Soft deleted and expired entities are not copied.
+//
+// The copy fails with an EConflict error when a transformed entity collides with an
+// entity of the dst, by PK or by any of its indexes. The txs may be the same tx
+// when the stores are of the same kv.Store, as long as the stores do not share any
+// buckets; the dst tx should be rolled back when an error is returned.
+func CopyStore(ctx context.Context, srcTx Tx, src *IndexStore, dstTx Tx, dst *IndexStore, transform CopyTransformFn) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	span.SetTag("Resource", src.Resource)
+
+	now := time.Now()
+	var n int
+	err := src.EntStore.FindStream(ctx, srcTx, FindOpts{}, func(ent Entity) error {
+		pk, err := src.EntStore.EntKey(ctx, ent)
+		if err != nil {
+			return err
+		}
+		if expired, err := src.EntStore.expired(ctx, srcTx, pk, now); err != nil || expired {
+			return err
+		}
+		if deleted, err := src.deleted(ctx, srcTx, Entity{PK: EncBytes(pk)}); err != nil || deleted {
+			return err
+		}
+
+		if transform != nil {
+			if ent, err = transform(ent); err != nil {
+				return err
+			}
+		}
+		if err := dst.Put(ctx, dstTx, ent, PutNew()); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	span.SetTag("Count", n)
+	return err
+}
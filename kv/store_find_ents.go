@@ -0,0 +1,230 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// FindEntsAllowMissing returns a nil placeholder for each entity that is not found,
+// rather than failing with an ENotFound error. This only applies to FindEnts.
+func FindEntsAllowMissing() FindEntOptionFn {
+	return func(o *findEntOption) {
+		o.allowMissing = true
+	}
+}
+
+// FindEnts returns the decoded entity bodies of the provided entities, each found
+// via its PK as FindEnt would, in the order of ents. The keys are sorted so the
+// bucket is read in a single forward pass of one cursor. The first entity not
+// found fails the find with an ENotFound error, unless FindEntsAllowMissing is
+// provided.
+func (s *StoreBase) FindEnts(ctx context.Context, tx Tx, ents []Entity, opts ...FindEntOptionFn) ([]interface{}, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+	span.SetTag("Count", len(ents))
+
+	opt := newFindEntOption(opts...)
+	if opt.readOnly {
+		if err := assertReadOnly(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([][]byte, len(ents))
+	for i, ent := range ents {
+		key, err := s.EntKey(ctx, ent)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return s.findKeys(ctx, tx, keys, opt.allowMissing)
+}
+
+// findKeys returns the decoded values of the keys in the order provided, reading
+// the keys in sorted order. A nil key is left as a nil value.
+func (s *StoreBase) findKeys(ctx context.Context, tx Tx, keys [][]byte, allowMissing bool) ([]interface{}, error) {
+	if s.Metrics != nil {
+		defer func(start time.Time) { s.Metrics.ObserveFind(s.Resource, time.Since(start)) }(time.Now())
+	}
+
+	order := make([]int, 0, len(keys))
+	for i, key := range keys {
+		if key == nil {
+			continue
+		}
+		order = append(order, i)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return bytes.Compare(keys[order[i]], keys[order[j]]) < 0
+	})
+
+	cur, err := s.bucketCursor(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make([]interface{}, len(keys))
+	var k, v []byte
+	for _, i := range order {
+		key := keys[i]
+		// the keys are sorted, so the cursor only ever seeks forward, and
+		// stays put for a repeated key
+		if k == nil || bytes.Compare(k, key) < 0 {
+			k, v = cur.Seek(key)
+		}
+
+		if !bytes.Equal(k, key) {
+			if !allowMissing {
+				return nil, s.ErrEntNotFound(key)
+			}
+			continue
+		}
+
+		expired, err := s.expired(ctx, tx, key, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			if !allowMissing {
+				return nil, s.ErrEntNotFound(key)
+			}
+			continue
+		}
+
+		val, err := s.decodeEnt(ctx, key, v)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+// FindEnts returns the decoded entity bodies of the provided entities, in the order
+// of ents. Each entity is resolved by its PK, or by the index when no PK is
+// provided, in the same manner as FindEnt, after which the entity store is read
+// as StoreBase.FindEnts does.
+func (s *IndexStore) FindEnts(ctx context.Context, tx Tx, ents []Entity, opts ...FindEntOptionFn) ([]interface{}, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+	span.SetTag("Count", len(ents))
+
+	opt := newFindEntOption(opts...)
+	if opt.readOnly {
+		if err := assertReadOnly(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	pks := make([][]byte, len(ents))
+	for i, ent := range ents {
+		pk, err := s.findPK(ctx, tx, ent, opt)
+		if err != nil {
+			if !opt.allowMissing || influxdb.ErrorCode(err) != influxdb.ENotFound {
+				return nil, err
+			}
+			continue
+		}
+		pks[i] = pk
+	}
+	return s.EntStore.findKeys(ctx, tx, pks, opt.allowMissing)
+}
+
+// findPK returns the PK of the entity, resolving it via the index when the entity
+// provides no PK. An ENotFound error is returned for a soft deleted entity, unless
+// the option includes deleted entities.
+func (s *IndexStore) findPK(ctx context.Context, tx Tx, ent Entity, opt findEntOption) ([]byte, error) {
+	pk, err := s.EntStore.EntKey(ctx, ent)
+	if err != nil {
+		idx, err := s.lookupIndex(ctx, ent)
+		if err != nil {
+			return nil, err
+		}
+		indexEnt, err := s.findIndexEnt(ctx, tx, idx, ent)
+		if err != nil {
+			return nil, err
+		}
+		if pk, err = s.EntStore.EntKey(ctx, indexEnt); err != nil {
+			return nil, err
+		}
+	}
+
+	if !opt.includeDeleted {
+		if err := s.assertNotDeleted(ctx, tx, pk); err != nil {
+			return nil, err
+		}
+	}
+	return pk, nil
+}
+
+// ForEach walks the index in ascending key order, calling fn with each index key
+// and the decoded body of the entity it points to. The entities are read in
+// batches, with the PKs of each batch sorted so the entity store is read in a
+// single forward pass of one cursor, as FindEnts does. Index keys that do not
+// resolve to an entity, i.e. whose entity is missing or expired, are skipped. When
+// fn returns an error the walk is stopped and the error is returned.
+func (s *IndexStore) ForEach(ctx context.Context, tx Tx, fn func(indexKey []byte, ent interface{}) error) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.forEachIndexed(ctx, tx, func(capture FindCaptureFn) error {
+		return s.IndexStore.Find(ctx, tx, FindOpts{CaptureFn: capture})
+	}, fn)
+}
+
+// forEachIndexed calls fn with each index key captured by the scan of the index
+// store and the decoded body of the entity it points to, resolving the entities in
+// batches as ForEach describes.
+func (s *IndexStore) forEachIndexed(ctx context.Context, tx Tx, scan func(capture FindCaptureFn) error, fn func(indexKey []byte, ent interface{}) error) error {
+	var idxKeys, pks [][]byte
+	flush := func() error {
+		if len(pks) == 0 {
+			return nil
+		}
+		vals, err := s.EntStore.findKeys(ctx, tx, pks, true)
+		if err != nil {
+			return err
+		}
+		for i, v := range vals {
+			if v == nil {
+				continue
+			}
+			if err := fn(idxKeys[i], v); err != nil {
+				return err
+			}
+		}
+		idxKeys, pks = idxKeys[:0], pks[:0]
+		return nil
+	}
+
+	err := scan(func(k []byte, v interface{}) error {
+		idxEnt, err := s.IndexStore.convertValToEnt(k, v)
+		if err != nil {
+			return err
+		}
+		pk, err := s.EntStore.EntKey(ctx, idxEnt)
+		if err != nil {
+			return err
+		}
+
+		idxKeys = append(idxKeys, append([]byte(nil), k...))
+		pks = append(pks, pk)
+		if len(pks) < findIndexBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,165 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// errFindKeysDone stops a keys only scan once the keys no longer have the prefix.
+var errFindKeysDone = errors.New("find keys done")
+
+// FindKeys returns the keys of the store found via the set options, without
+// decoding the values, i.e. to build the list of IDs of the members of an org
+// rather than reading the members. The keys are formatted via the KeyFormatFn of
+// the options when set. The CaptureFn of the options is replaced, and the keys are
+// found as a KeysOnly find.
+func (s *StoreBase) FindKeys(ctx context.Context, tx Tx, opts FindOpts) ([][]byte, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	opts.KeysOnly = true
+	return collectKeys(opts.Prefix, opts, func(opts FindOpts) error {
+		return s.Find(ctx, tx, opts)
+	})
+}
+
+// FindKeys returns the primary keys of the entity store found via the set
+// options, without decoding the entities. See StoreBase.FindKeys.
+func (s *IndexStore) FindKeys(ctx context.Context, tx Tx, opts FindOpts) ([][]byte, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.EntStore.FindKeys(ctx, tx, opts)
+}
+
+// FindIndexKeys returns the keys of the index store found via the set options,
+// i.e. the org and name keys of an org name index, without decoding the index
+// values. See StoreBase.FindKeys.
+func (s *IndexStore) FindIndexKeys(ctx context.Context, tx Tx, opts FindOpts) ([][]byte, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.IndexStore.FindKeys(ctx, tx, opts)
+}
+
+// FindIndexPKs returns the primary keys the index entries found via the set
+// options point to, in index order, without reading the entities. The index
+// prefix, offset and limit are honored as they are by FindIndex, and the
+// FilterEntFn is called with each primary key and a nil decoded value.
+func (s *IndexStore) FindIndexPKs(ctx context.Context, tx Tx, opts FindOpts) ([][]byte, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	opts.KeysOnly = true
+	// the primary keys do not share the prefix of the index keys, which the
+	// index scan is bound by
+	return collectKeys(nil, opts, func(opts FindOpts) error {
+		return s.FindIndex(ctx, tx, opts)
+	})
+}
+
+// findIndexPKs captures the primary keys the index entries found point to, for a
+// KeysOnly FindIndex.
+func (s *IndexStore) findIndexPKs(ctx context.Context, tx Tx, opts FindOpts) error {
+	if err := validKeysOnly(opts); err != nil {
+		return err
+	}
+
+	var counter int
+	err := s.IndexStore.Find(ctx, tx, FindOpts{
+		Descending: opts.Descending,
+		Prefix:     opts.Prefix,
+		After:      opts.After,
+		ReadOnly:   opts.ReadOnly,
+		CaptureFn: func(k []byte, v interface{}) error {
+			if !bytes.HasPrefix(k, opts.Prefix) {
+				return errFindIndexDone
+			}
+			idxEnt, err := s.IndexStore.convertValToEnt(k, v)
+			if err != nil {
+				return err
+			}
+			pk, err := s.EntStore.EntKey(ctx, idxEnt)
+			if err != nil {
+				return err
+			}
+			if opts.FilterEntFn != nil && !opts.FilterEntFn(pk, nil) {
+				return nil
+			}
+
+			counter++
+			if counter <= opts.Offset {
+				return nil
+			}
+			if err := opts.CaptureFn(pk, nil); err != nil {
+				return err
+			}
+			if opts.Limit > 0 && counter >= opts.Limit+opts.Offset {
+				return errFindIndexDone
+			}
+			return nil
+		},
+	})
+	if err == errFindIndexDone {
+		return nil
+	}
+	return err
+}
+
+// collectKeys returns a copy of each key captured by the find, up to the first
+// key without the prefix.
+func collectKeys(prefix []byte, opts FindOpts, find func(FindOpts) error) ([][]byte, error) {
+	var keys [][]byte
+	opts.CaptureFn = func(k []byte, _ interface{}) error {
+		if !bytes.HasPrefix(k, prefix) {
+			return errFindKeysDone
+		}
+		key := append([]byte(nil), k...)
+		if opts.KeyFormatFn != nil {
+			var err error
+			if key, err = opts.KeyFormatFn(key); err != nil {
+				return err
+			}
+		}
+		keys = append(keys, key)
+		return nil
+	}
+
+	if err := find(opts); err != nil && err != errFindKeysDone {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// validKeysOnly errors for the options a KeysOnly find can not honor, all of
+// which require the decoded values.
+func validKeysOnly(opts FindOpts) error {
+	var msg string
+	switch {
+	case opts.FilterDecodedFn != nil:
+		msg = "keys only finds can not filter decoded entities"
+	case opts.SummaryOnly:
+		msg = "keys only finds can not be summary only"
+	case opts.Sort != SortByKey:
+		msg = "keys only finds can only be sorted by key"
+	default:
+		return nil
+	}
+	return &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  msg,
+	}
+}
+
+// decodeKeyOnly decodes the key alone, leaving the value undecoded.
+func decodeKeyOnly(key, _ []byte) ([]byte, interface{}, error) {
+	return key, nil, nil
+}
@@ -0,0 +1,83 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// FindEntInOrg returns the decoded entity body via the provided entity, as FindEnt
+// does, only when the entity belongs to the org, i.e. so a user of one org can not
+// read the entity of another org by its ID. An entity of another org results in
+// the same ENotFound error as a missing entity, so its existence is not leaked.
+// The org of the entity is provided by the DecodedOrgIDFn of the store.
+func (s *StoreBase) FindEntInOrg(ctx context.Context, tx Tx, orgID influxdb.ID, ent Entity, opts ...FindEntOptionFn) (interface{}, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	if err := s.validOrgScoped(); err != nil {
+		return nil, err
+	}
+
+	v, err := s.FindEnt(ctx, tx, ent, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if s.DecodedOrgIDFn(v) != orgID {
+		key, err := s.EntKey(ctx, ent)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.ErrEntNotFound(key)
+	}
+	return v, nil
+}
+
+// FindEntInOrg returns the decoded entity body via the provided entity, resolved
+// by its PK or its index as FindEnt resolves it, only when the entity belongs to
+// the org. The org of the entity is provided by the DecodedOrgIDFn of the entity
+// store. See StoreBase.FindEntInOrg.
+func (s *IndexStore) FindEntInOrg(ctx context.Context, tx Tx, orgID influxdb.ID, ent Entity, opts ...FindEntOptionFn) (interface{}, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	if err := s.EntStore.validOrgScoped(); err != nil {
+		return nil, err
+	}
+
+	v, err := s.FindEnt(ctx, tx, ent, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if s.EntStore.DecodedOrgIDFn(v) == orgID {
+		return v, nil
+	}
+
+	// the error of an entity missing from the store it was looked up in
+	if pk, err := s.EntStore.EntKey(ctx, ent); err == nil {
+		return nil, s.EntStore.ErrEntNotFound(pk)
+	}
+	idx, err := s.lookupIndex(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	idxKey, err := idx.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	return nil, idx.ErrEntNotFound(idxKey)
+}
+
+func (s *StoreBase) validOrgScoped() error {
+	if s.DecodedOrgIDFn != nil {
+		return nil
+	}
+	return &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  fmt.Sprintf("%s store is not scoped by org", s.Resource),
+	}
+}
@@ -0,0 +1,50 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// EntFinder finds the decoded body of an entity, as both StoreBase and IndexStore do.
+type EntFinder interface {
+	FindEnt(ctx context.Context, tx Tx, ent Entity, opts ...FindEntOptionFn) (interface{}, error)
+}
+
+// FindEntInto finds the entity via the finder and stores its decoded body in the
+// value dst points to, rather than leaving the caller to assert the type of the
+// body. A body that is not assignable to the type dst points to is returned as an
+// EInternal error describing both types, instead of the panic a failed assertion
+// would cause. A dst that is not a non-nil pointer is an EInvalid error.
+//
+//	var f foo
+//	if err := kv.FindEntInto(ctx, tx, store, kv.Entity{PK: kv.EncID(id)}, &f); err != nil {
+//		return err
+//	}
+func FindEntInto(ctx context.Context, tx Tx, s EntFinder, ent Entity, dst interface{}, opts ...FindEntOptionFn) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("entity must be found into a non-nil pointer; got %T", dst),
+		}
+	}
+
+	v, err := s.FindEnt(ctx, tx, ent, opts...)
+	if err != nil {
+		return err
+	}
+
+	dstVal := rv.Elem()
+	val := reflect.ValueOf(v)
+	if !val.IsValid() || !val.Type().AssignableTo(dstVal.Type()) {
+		return &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("found entity of type %T can not be stored in %s", v, dstVal.Type()),
+		}
+	}
+	dstVal.Set(val)
+	return nil
+}
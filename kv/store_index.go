@@ -5,12 +5,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/influxdata/influxdb/v2"
 	ierrors "github.com/influxdata/influxdb/v2/kit/errors"
 	"github.com/influxdata/influxdb/v2/kit/tracing"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
 )
 
+// DefaultIndexName is the name of the index provided by IndexStore.IndexStore.
+const DefaultIndexName = "default"
+
 // IndexStore provides a entity store that uses an index lookup.
 // The index store manages deleting and creating indexes for the
 // caller. The index is automatically used if the FindEnt entity
@@ -19,165 +25,1337 @@ type IndexStore struct {
 	Resource   string
 	EntStore   *StoreBase
 	IndexStore *StoreBase
+
+	// Indexes are additional unique indexes maintained alongside the IndexStore.
+	// Each index store's EncodeEntKeyFn is responsible for deriving its key from
+	// the entity. Indexes are consulted in order, after the IndexStore.
+	Indexes []NamedIndex
+
+	// VersionStore, when set, tracks a version for every entity that is bumped
+	// on each put. See NewVersionStore.
+	VersionStore *StoreBase
+
+	// AuditFn, when set, is called with every put and delete of an entity.
+	AuditFn AuditFn
+
+	// TombstoneStore, when set, allows entities to be soft deleted and restored.
+	// Soft deleted entities are not found by FindEnt or Exists, but remain visible
+	// to scans of the entity store such as Find. See NewTombstoneStore.
+	TombstoneStore *StoreBase
+
+	// EventBus, when set, is sent an event for every committed put and delete of
+	// an entity. See NewEventBus.
+	EventBus *EventBus
+
+	// RateLimiter, when set, is consulted before every put and delete of an
+	// entity, failing the write with an ETooManyRequests error when the write
+	// rate of the resource is exceeded. See NewResourceRateLimiter.
+	RateLimiter RateLimiter
+
+	// MissCache, when set, caches the index keys FindEnt does not find, so
+	// repeated finds of missing entities are not looked up again. See
+	// NewMissCache.
+	MissCache *MissCache
+
+	// Logger, when set, is logged to when a delete forced via DeleteEntForce finds
+	// an index entry of the entity already missing, and when a find repairs an
+	// index entry via FindEntRepairOnRead.
+	Logger *zap.Logger
+}
+
+// NamedIndex is a named unique index of an entity.
+type NamedIndex struct {
+	Name  string
+	Store *StoreBase
+}
+
+// indexes returns every index the store maintains, starting with the default index.
+func (s *IndexStore) indexes() []NamedIndex {
+	indexes := make([]NamedIndex, 0, len(s.Indexes)+1)
+	if s.IndexStore != nil {
+		indexes = append(indexes, NamedIndex{Name: DefaultIndexName, Store: s.IndexStore})
+	}
+	return append(indexes, s.Indexes...)
+}
+
+func (s *IndexStore) index(name string) (*StoreBase, error) {
+	for _, idx := range s.indexes() {
+		if idx.Name == name {
+			return idx.Store, nil
+		}
+	}
+	return nil, &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  fmt.Sprintf("no index named %q for %s", name, s.Resource),
+	}
+}
+
+// setSpanTags tags the span with the store's resource and the operation performed.
+func (s *IndexStore) setSpanTags(span opentracing.Span, op string) {
+	span.SetTag("Resource", s.Resource)
+	span.SetTag("Operation", op)
+}
+
+// Delete deletes entities and associated indexes.
+func (s *IndexStore) Delete(ctx context.Context, tx Tx, opts DeleteOpts) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opDelete)
+
+	_, err := s.DeleteMany(ctx, tx, opts)
+	return err
+}
+
+// DeleteMany deletes entities and associated indexes in a single scan of the
+// entity store and returns the number of entities deleted. When an error is
+// returned, entities seen before the failure may have been deleted; the tx
+// should be rolled back to leave the stores consistent.
+func (s *IndexStore) DeleteMany(ctx context.Context, tx Tx, opts DeleteOpts) (int, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opDelete)
+
+	if err := s.allowWrite(); err != nil {
+		return 0, err
+	}
+
+	opts.DeleteRelationFns = append(opts.DeleteRelationFns, s.deleteIndexedRelationFn(ctx, tx))
+	return s.EntStore.deleteMany(ctx, tx, opts)
+}
+
+// DeletePrefix deletes every entity of the entity store whose key has the prefix,
+// along with its indexes, and returns the number of entities deleted. See
+// StoreBase.DeletePrefix.
+func (s *IndexStore) DeletePrefix(ctx context.Context, tx Tx, prefix []byte) (int, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opDelete)
+
+	if err := s.allowWrite(); err != nil {
+		return 0, err
+	}
+
+	n, err := s.EntStore.deletePrefix(ctx, tx, prefix, s.deleteIndexedRelationFn(ctx, tx))
+	span.SetTag("Count", n)
+	return n, err
+}
+
+// deleteIndexedRelationFn returns the DeleteRelationsFn that deletes the relations
+// of an entity deleted from the entity store.
+func (s *IndexStore) deleteIndexedRelationFn(ctx context.Context, tx Tx) DeleteRelationsFn {
+	return func(k []byte, v interface{}) error {
+		ent, err := s.EntStore.convertValToEnt(k, v)
+		if err != nil {
+			return err
+		}
+		if err := s.deleteRelations(ctx, tx, ent); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, AuditDelete, k, v, nil)
+	}
+}
+
+// DeleteBatches deletes entities and associated indexes in batches, each in its
+// own tx opened via the store's Update. See StoreBase.DeleteBatches for the
+// guarantees it relaxes.
+func (s *IndexStore) DeleteBatches(ctx context.Context, store Store, opts DeleteOpts) (int, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opDelete)
+
+	return deleteBatches(ctx, store, opts, s.DeleteMany)
+}
+
+// DeleteEnt deletes an entity and associated index.
+func (s *IndexStore) DeleteEnt(ctx context.Context, tx Tx, ent Entity, opts ...DeleteEntOptionFn) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opDelete)
+
+	_, err := s.DeleteEntReturning(ctx, tx, ent, opts...)
+	return err
+}
+
+// DeleteEntReturning deletes an entity and associated index, returning the decoded
+// entity body that was deleted. An ENotFound error is returned when the entity does
+// not exist, or when any of its index entries are missing, unless the delete is
+// forced via DeleteEntForce. An EConflict error is returned when the entity does
+// not pass the match of DeleteEntIfMatch. A soft deleted entity can only be soft deleted once,
+// but may still be deleted for good.
+func (s *IndexStore) DeleteEntReturning(ctx context.Context, tx Tx, ent Entity, opts ...DeleteEntOptionFn) (interface{}, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opDelete)
+
+	if err := s.allowWrite(); err != nil {
+		return nil, err
+	}
+
+	opt := newDeleteEntOption(opts...)
+
+	var findOpts []FindEntOptionFn
+	if !opt.softDelete {
+		findOpts = append(findOpts, FindEntIncludeDeleted())
+	}
+	existing, err := s.FindEnt(ctx, tx, ent, findOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedEnt, err := s.EntStore.convertValToEnt(nil, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := s.EntStore.EntKey(ctx, decodedEnt)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.matchFn != nil && !opt.matchFn(decodedEnt) {
+		return nil, &influxdb.Error{
+			Code: influxdb.EConflict,
+			Msg:  fmt.Sprintf("%s entity for key %s does not match; it may have changed since", s.Resource, s.EntStore.KeyString(pk)),
+		}
+	}
+
+	if opt.softDelete {
+		if err := s.softDelete(ctx, tx, decodedEnt); err != nil {
+			return nil, err
+		}
+		if err := s.audit(ctx, tx, AuditDelete, pk, existing, nil); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	missing, err := s.missingIndexes(ctx, tx, pk, decodedEnt)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		if !opt.force {
+			return nil, &influxdb.Error{
+				Code: influxdb.ENotFound,
+				Msg:  fmt.Sprintf("%s index %s has no entry for key %s; the entity may only be deleted by force", s.Resource, missing[0], s.EntStore.KeyString(pk)),
+			}
+		}
+		if s.Logger != nil {
+			s.Logger.Info("Deleting entity with missing index entries",
+				zap.String("resource", s.Resource),
+				zap.String("key", s.EntStore.KeyString(pk)),
+				zap.Strings("indexes", missing))
+		}
+	}
+
+	if err := s.EntStore.DeleteEnt(ctx, tx, decodedEnt); err != nil {
+		return nil, err
+	}
+
+	if err := s.deleteRelations(ctx, tx, decodedEnt, missing...); err != nil {
+		return nil, err
+	}
+	if err := s.audit(ctx, tx, AuditDelete, pk, existing, nil); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// missingIndexes returns the names of the indexes whose key for the entity does not
+// resolve to the PK. The indexes of a soft deleted entity were removed when it was
+// deleted, so none are missing.
+func (s *IndexStore) missingIndexes(ctx context.Context, tx Tx, pk []byte, ent Entity) ([]string, error) {
+	deleted, err := s.deleted(ctx, tx, ent)
+	if err != nil || deleted {
+		return nil, err
+	}
+
+	var missing []string
+	for _, idx := range s.indexes() {
+		idxKey, err := idx.Store.EntKey(ctx, ent)
+		if err != nil {
+			return nil, err
+		}
+		idxPK, err := s.indexedPK(ctx, tx, idx.Store, idxKey)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(idxPK, pk) {
+			missing = append(missing, idx.Name)
+		}
+	}
+	return missing, nil
+}
+
+// deleteIndexes deletes the index entries of the entity, other than those of the
+// indexes skipped.
+func (s *IndexStore) deleteIndexes(ctx context.Context, tx Tx, ent Entity, skip ...string) error {
+	for _, idx := range s.indexes() {
+		if containsString(skip, idx.Name) {
+			continue
+		}
+		if err := idx.Store.DeleteEnt(ctx, tx, ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteRelations deletes the indexes, other than those skipped, version and
+// tombstone of the entity. The indexes of a soft deleted entity were removed when
+// it was deleted, and its unique keys may since belong to another entity, so they
+// are left as is.
+func (s *IndexStore) deleteRelations(ctx context.Context, tx Tx, ent Entity, skip ...string) error {
+	deleted, err := s.deleted(ctx, tx, ent)
+	if err != nil {
+		return err
+	}
+
+	if deleted {
+		if err := s.TombstoneStore.DeleteEnt(ctx, tx, Entity{PK: ent.PK}); err != nil {
+			return err
+		}
+	} else if err := s.deleteIndexes(ctx, tx, ent, skip...); err != nil {
+		return err
+	}
+
+	if s.VersionStore == nil {
+		return nil
+	}
+	return s.VersionStore.DeleteEnt(ctx, tx, Entity{PK: ent.PK})
+}
+
+// SweepExpired deletes up to max entities of the entity store that have expired
+// as of now, along with their indexes, and returns the number of entities deleted.
+// See StoreBase.SweepExpired.
+func (s *IndexStore) SweepExpired(ctx context.Context, tx Tx, now time.Time, max int) (int, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opDelete)
+
+	return s.EntStore.sweepExpired(ctx, tx, now, max, s.deleteIndexedRelationFn(ctx, tx))
+}
+
+// Find provides a mechanism for looking through the bucket via
+// the set options. When a prefix is provided, it will be used within
+// the entity store. If you would like to search the index store, then
+// you can by calling FindIndex.
+func (s *IndexStore) Find(ctx context.Context, tx Tx, opts FindOpts) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.EntStore.Find(ctx, tx, opts)
+}
+
+// FindWithResult finds the entities of the entity store as Find does, and returns
+// the result of the find. See StoreBase.FindWithResult.
+func (s *IndexStore) FindWithResult(ctx context.Context, tx Tx, opts FindOpts) (FindResult, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.EntStore.FindWithResult(ctx, tx, opts)
+}
+
+// FindStream calls fn with each entity found in the entity store via the set
+// options as the cursor advances.
+func (s *IndexStore) FindStream(ctx context.Context, tx Tx, opts FindOpts, fn func(Entity) error) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.EntStore.FindStream(ctx, tx, opts, fn)
+}
+
+// Count returns the number of entities in the entity store whose key matches
+// the prefix. See StoreBase.Count for how filter funcs are applied.
+func (s *IndexStore) Count(ctx context.Context, tx Tx, prefix []byte, filterFns ...FilterFn) (int, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.EntStore.Count(ctx, tx, prefix, filterFns...)
+}
+
+// CountIndex returns the number of keys in the named index that match the prefix.
+func (s *IndexStore) CountIndex(ctx context.Context, tx Tx, indexName string, prefix []byte, filterFns ...FilterFn) (int, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	idx, err := s.index(indexName)
+	if err != nil {
+		return 0, err
+	}
+	return idx.Count(ctx, tx, prefix, filterFns...)
+}
+
+// ListIndexKeys returns the keys of the index store that have the prefix, in
+// ascending order, i.e. the org and name keys of an org name index. Only the
+// keys of the index bucket are read; neither the index values nor the entities
+// are decoded.
+func (s *IndexStore) ListIndexKeys(ctx context.Context, tx Tx, prefix []byte) ([][]byte, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	var keys [][]byte
+	err := s.IndexStore.forEachKey(ctx, tx, prefix, func(k []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+	span.SetTag("Count", len(keys))
+	return keys, err
+}
+
+// ListIndexKeyStrings returns the keys of the index store that have the prefix in
+// the readable form of the index store's KeyString, i.e. org=<id>/name=<name> for
+// an org name index. See ListIndexKeys.
+func (s *IndexStore) ListIndexKeyStrings(ctx context.Context, tx Tx, prefix []byte) ([]string, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	var keys []string
+	err := s.IndexStore.forEachKey(ctx, tx, prefix, func(k []byte) error {
+		keys = append(keys, s.IndexStore.KeyString(k))
+		return nil
+	})
+	span.SetTag("Count", len(keys))
+	return keys, err
+}
+
+// FindPage returns a page of decoded values from the entity store.
+func (s *IndexStore) FindPage(ctx context.Context, tx Tx, opts FindPageOpts) (Page, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.EntStore.FindPage(ctx, tx, opts)
+}
+
+// findIndexBatchSize is the number of entities FindIndex reads from the entity
+// store at once.
+const findIndexBatchSize = 100
+
+// errFindIndexDone stops the scan of the index once the limit is reached.
+var errFindIndexDone = errors.New("find index done")
+
+// FindIndex scans the index store via the set options and resolves each index
+// entry to its entity in the entity store. The Prefix, After and Descending options
+// apply to the index keys, while the FilterEntFn, Offset, Limit and CaptureFn
+// options apply to the resolved entities, as they do for Find. Index entries
+// that do not resolve to an entity are skipped. The entity store is read in
+// batches as the index is scanned.
+//
+// Entities are captured in ascending order of their index keys, or descending
+// order for a descending find, rather than the order of their PKs as Find
+// captures them, unless another order is requested via the Sort option.
+//
+// A KeysOnly find captures the primary keys the index entries point to, with a
+// nil decoded value, without reading the entity store. See FindIndexPKs.
+func (s *IndexStore) FindIndex(ctx context.Context, tx Tx, opts FindOpts) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	if opts.KeysOnly {
+		return s.findIndexPKs(ctx, tx, opts)
+	}
+
+	if opts.Sort != SortByKey {
+		sortedOpts, captureFn, err := s.EntStore.sortedFind(opts)
+		if err != nil {
+			return err
+		}
+		if err := s.FindIndex(ctx, tx, sortedOpts); err != nil {
+			return err
+		}
+		_, err = captureFn()
+		return err
+	}
+
+	r, err := newEntResolver(ctx, tx, s.EntStore, opts)
+	if err != nil {
+		return err
+	}
+
+	err = s.IndexStore.Find(ctx, tx, FindOpts{
+		Descending: opts.Descending,
+		Prefix:     opts.Prefix,
+		After:      opts.After,
+		ReadOnly:   opts.ReadOnly,
+		CaptureFn: func(k []byte, v interface{}) error {
+			if !bytes.HasPrefix(k, opts.Prefix) {
+				// a prefixed scan of the index is done once the keys no
+				// longer have the prefix
+				if err := r.resolve(ctx, tx); err != nil {
+					return err
+				}
+				return errFindIndexDone
+			}
+			idxEnt, err := s.IndexStore.convertValToEnt(k, v)
+			if err != nil {
+				return err
+			}
+			pk, err := s.EntStore.EntKey(ctx, idxEnt)
+			if err != nil {
+				return err
+			}
+			return r.add(ctx, tx, pk)
+		},
+	})
+	return r.done(ctx, tx, err)
+}
+
+// entResolver resolves the primary keys found in an index to their entities,
+// reading the entity store in batches. The filter, offset and limit of the find
+// options are applied to the resolved entities.
+type entResolver struct {
+	store *StoreBase
+	opts  FindOpts
+
+	filterDecodedFn func([]byte, interface{}) (bool, error)
+
+	pks           [][]byte
+	seen, matched int
+}
+
+func newEntResolver(ctx context.Context, tx Tx, store *StoreBase, opts FindOpts) (*entResolver, error) {
+	if _, err := store.shardBuckets(ctx, tx); err != nil {
+		return nil, err
+	}
+	return &entResolver{
+		store: store,
+		opts:  opts,
+		pks:   make([][]byte, 0, findIndexBatchSize),
+
+		filterDecodedFn: store.filterDecodedFn(opts.FilterDecodedFn),
+	}, nil
+}
+
+// add queues the primary key to be resolved, resolving the queued keys once a
+// batch is full. An errFindIndexDone is returned once the limit is reached.
+func (r *entResolver) add(ctx context.Context, tx Tx, pk []byte) error {
+	r.pks = append(r.pks, pk)
+	if len(r.pks) < findIndexBatchSize {
+		return nil
+	}
+	return r.resolve(ctx, tx)
+}
+
+func (r *entResolver) resolve(ctx context.Context, tx Tx) error {
+	if len(r.pks) == 0 {
+		return nil
+	}
+	defer func() { r.pks = r.pks[:0] }()
+
+	values, err := r.store.getBatch(ctx, tx, r.pks)
+	if err != nil {
+		return err
+	}
+
+	for i, raw := range values {
+		if raw == nil {
+			continue
+		}
+		pk := r.pks[i]
+		expired, err := r.store.expired(ctx, tx, pk, time.Now())
+		if err != nil {
+			return err
+		}
+		if expired {
+			continue
+		}
+
+		v, err := r.store.decodeEnt(ctx, pk, raw)
+		if err != nil {
+			return err
+		}
+		if r.opts.FilterEntFn != nil && !r.opts.FilterEntFn(pk, v) {
+			continue
+		}
+		if r.filterDecodedFn != nil {
+			ok, err := r.filterDecodedFn(pk, v)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		r.seen++
+		if r.seen <= r.opts.Offset {
+			continue
+		}
+		if err := r.opts.CaptureFn(pk, v); err != nil {
+			return err
+		}
+		r.matched++
+		if r.opts.Limit > 0 && r.matched >= r.opts.Limit {
+			return errFindIndexDone
+		}
+	}
+	return nil
+}
+
+// done resolves the keys left once the scan of the index ends with err.
+func (r *entResolver) done(ctx context.Context, tx Tx, err error) error {
+	if err == nil {
+		err = r.resolve(ctx, tx)
+	}
+	if err == errFindIndexDone {
+		return nil
+	}
+	return err
+}
+
+// FindEnt returns the decoded entity body via teh provided entity.
+// An example entity should not include a Body, but rather the ID,
+// Name, or OrgID. If no ID is provided, then the algorithm assumes
+// you are looking up the entity by the first index the entity
+// provides a key for.
+func (s *IndexStore) FindEnt(ctx context.Context, tx Tx, ent Entity, opts ...FindEntOptionFn) (interface{}, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	opt := newFindEntOption(opts...)
+	if opt.readOnly {
+		if err := assertReadOnly(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if pk, err := s.EntStore.EntKey(ctx, ent); err == nil {
+		span.SetTag("IndexLookup", false)
+		v, err := s.EntStore.FindEnt(ctx, tx, ent)
+		if err != nil {
+			return nil, err
+		}
+		if !opt.includeDeleted {
+			if err := s.assertNotDeleted(ctx, tx, pk); err != nil {
+				return nil, err
+			}
+		}
+		if opt.verifyIndex {
+			if err := s.verifyEntIndexes(ctx, tx, pk, ent); err != nil {
+				return nil, err
+			}
+		}
+		return s.onMiss(ctx, tx, v, opt)
+	}
+
+	idx, err := s.lookupIndex(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("IndexLookup", true)
+	return s.findEntByIndex(ctx, tx, idx, ent, opt)
+}
+
+// findEntByIndex finds the entity via the index as FindEnt does, through the
+// MissCache when set and with the options applied.
+func (s *IndexStore) findEntByIndex(ctx context.Context, tx Tx, idx *StoreBase, ent Entity, opt findEntOption) (interface{}, error) {
+	findByIndex := s.findByIndex
+	if opt.repairOnRead {
+		findByIndex = s.findByIndexRepair
+	}
+	var (
+		v   interface{}
+		err error
+	)
+	if s.MissCache != nil {
+		v, err = s.findByIndexCached(ctx, tx, idx, ent, findByIndex)
+	} else {
+		v, err = findByIndex(ctx, tx, idx, ent)
+	}
+	if opt.allowStale && influxdb.ErrorCode(err) == influxdb.ENotFound {
+		v, err = s.findStale(ctx, tx, idx, ent, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.onMiss(ctx, tx, v, opt)
+}
+
+// onMiss populates the derived fields of the decoded entity found via the OnMissFn
+// of the options, when provided. See FindEntOnMiss.
+func (s *IndexStore) onMiss(ctx context.Context, tx Tx, v interface{}, opt findEntOption) (interface{}, error) {
+	if opt.onMissFn == nil {
+		return v, nil
+	}
+
+	ent, err := s.EntStore.convertValToEnt(nil, v)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := s.EntStore.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	return s.EntStore.onMiss(ctx, tx, pk, v, opt.onMissFn, func(ent Entity) error {
+		return s.Put(ctx, tx, ent, PutUpdate())
+	})
+}
+
+// FindEntAny returns the decoded entity body of the first of the entity's keys that
+// resolves to an entity. The PK is tried first, followed by each index in the order
+// of IndexStore.IndexStore and then Indexes, skipping the keys the entity does not
+// provide. An ENotFound error is returned only when every key provided misses, and
+// an EInvalid error when the entity provides no key at all.
+func (s *IndexStore) FindEntAny(ctx context.Context, tx Tx, ent Entity, opts ...FindEntOptionFn) (interface{}, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	opt := newFindEntOption(opts...)
+	if opt.readOnly {
+		if err := assertReadOnly(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	var notFoundErr error
+	if _, err := s.EntStore.EntKey(ctx, ent); err == nil {
+		v, err := s.FindEnt(ctx, tx, Entity{PK: ent.PK}, opts...)
+		if influxdb.ErrorCode(err) != influxdb.ENotFound {
+			return v, err
+		}
+		notFoundErr = err
+	}
+
+	for _, idx := range s.indexes() {
+		if _, err := idx.Store.EntKey(ctx, ent); err != nil {
+			continue
+		}
+		v, err := s.findEntByIndex(ctx, tx, idx.Store, ent, opt)
+		if influxdb.ErrorCode(err) != influxdb.ENotFound {
+			span.SetTag("Index", idx.Name)
+			return v, err
+		}
+		notFoundErr = err
+	}
+
+	if notFoundErr == nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  "no key was provided for " + s.Resource,
+		}
+	}
+	return nil, notFoundErr
 }
 
-// Delete deletes entities and associated indexes.
-func (s *IndexStore) Delete(ctx context.Context, tx Tx, opts DeleteOpts) error {
+// FindEntRaw returns the raw stored bytes of the entity from the entity store,
+// without decoding them. The entity is resolved by its PK, or by the index when
+// no PK is provided, in the same manner as FindEnt.
+func (s *IndexStore) FindEntRaw(ctx context.Context, tx Tx, ent Entity) ([]byte, error) {
 	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	if pk, err := s.EntStore.EntKey(ctx, ent); err == nil {
+		span.SetTag("IndexLookup", false)
+		if err := s.assertNotDeleted(ctx, tx, pk); err != nil {
+			return nil, err
+		}
+		return s.EntStore.FindEntRaw(ctx, tx, ent)
+	}
+
+	idx, err := s.lookupIndex(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("IndexLookup", true)
+
+	indexEnt, err := s.findIndexEnt(ctx, tx, idx, ent)
+	if err != nil {
+		return nil, err
+	}
+	return s.EntStore.FindEntRaw(ctx, tx, indexEnt)
+}
 
-	deleteIndexedRelationFn := func(k []byte, v interface{}) error {
-		ent, err := s.EntStore.ConvertValToEntFn(k, v)
+// verifyEntIndexes verifies every index the entity provides a key for resolves to
+// the provided PK.
+func (s *IndexStore) verifyEntIndexes(ctx context.Context, tx Tx, pk []byte, ent Entity) error {
+	for _, idx := range s.indexes() {
+		idxKey, err := idx.Store.EntKey(ctx, ent)
+		if err != nil {
+			continue
+		}
+		idxPK, err := s.indexedPK(ctx, tx, idx.Store, idxKey)
 		if err != nil {
 			return err
 		}
-		return s.IndexStore.DeleteEnt(ctx, tx, ent)
+		if !bytes.Equal(idxPK, pk) {
+			return &influxdb.Error{
+				Code: influxdb.EInternal,
+				Msg:  fmt.Sprintf("%s index %s key %s resolves to %q; expected %q", s.Resource, idx.Name, idx.Store.KeyString(idxKey), s.EntStore.KeyString(idxPK), s.EntStore.KeyString(pk)),
+			}
+		}
 	}
-	opts.DeleteRelationFns = append(opts.DeleteRelationFns, deleteIndexedRelationFn)
-	return s.EntStore.Delete(ctx, tx, opts)
+	return nil
 }
 
-// DeleteEnt deletes an entity and associated index.
-func (s *IndexStore) DeleteEnt(ctx context.Context, tx Tx, ent Entity) error {
+// FindEntByIndex returns the decoded entity body found via the named index.
+// The default index is named by DefaultIndexName.
+func (s *IndexStore) FindEntByIndex(ctx context.Context, tx Tx, indexName string, ent Entity) (interface{}, error) {
 	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
+	s.setSpanTags(span, opFind)
 
-	existing, err := s.FindEnt(ctx, tx, ent)
+	idx, err := s.index(indexName)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	span.SetTag("IndexLookup", true)
+	span.SetTag("Index", indexName)
+	return s.findByIndex(ctx, tx, idx, ent)
+}
 
-	if err := s.EntStore.DeleteEnt(ctx, tx, ent); err != nil {
-		return err
+// lookupIndex returns the first index the entity provides a key for.
+func (s *IndexStore) lookupIndex(ctx context.Context, ent Entity) (*StoreBase, error) {
+	for _, idx := range s.indexes() {
+		if _, err := idx.Store.EntKey(ctx, ent); err == nil {
+			return idx.Store, nil
+		}
 	}
+	return nil, &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  "no key was provided for " + s.Resource,
+	}
+}
+
+func (s *IndexStore) findByIndex(ctx context.Context, tx Tx, idx *StoreBase, ent Entity) (interface{}, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
 
-	decodedEnt, err := s.EntStore.ConvertValToEntFn(nil, existing)
+	indexEnt, err := s.findIndexEnt(ctx, tx, idx, ent)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return s.IndexStore.DeleteEnt(ctx, tx, decodedEnt)
+	return s.fetchIndexedEnt(ctx, tx, indexEnt)
 }
 
-// Find provides a mechanism for looking through the bucket via
-// the set options. When a prefix is provided, it will be used within
-// the entity store. If you would like to search the index store, then
-// you can by calling the index store directly.
-func (s *IndexStore) Find(ctx context.Context, tx Tx, opts FindOpts) error {
+// fetchIndexedEnt returns the decoded entity the index entity resolved by
+// findIndexEnt points to. Its span and metrics cover the fetch alone, apart from
+// the index lookup before it.
+func (s *IndexStore) fetchIndexedEnt(ctx context.Context, tx Tx, indexEnt Entity) (interface{}, error) {
 	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
+	span.SetTag("Resource", s.Resource)
+	span.SetTag("IndexPhase", "fetch")
 
-	return s.EntStore.Find(ctx, tx, opts)
+	if m, ok := s.EntStore.Metrics.(IndexFetchMetrics); ok {
+		defer func(start time.Time) { m.ObserveIndexFetch(s.Resource, time.Since(start)) }(time.Now())
+	}
+
+	return s.EntStore.FindEnt(ctx, tx, indexEnt)
 }
 
-// FindEnt returns the decoded entity body via teh provided entity.
-// An example entity should not include a Body, but rather the ID,
-// Name, or OrgID. If no ID is provided, then the algorithm assumes
-// you are looking up the entity by the index.
-func (s *IndexStore) FindEnt(ctx context.Context, tx Tx, ent Entity) (interface{}, error) {
+// findIndexEnt resolves the index entry for the provided entity. The returned
+// entity contains the PK of the entity the index points to.
+func (s *IndexStore) findIndexEnt(ctx context.Context, tx Tx, idx *StoreBase, ent Entity) (Entity, error) {
 	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
+	span.SetTag("Resource", s.Resource)
+	span.SetTag("Bucket", string(idx.BktName))
+	span.SetTag("IndexPhase", "lookup")
+
+	if m := s.EntStore.Metrics; m != nil {
+		defer func(start time.Time) { m.ObserveIndexLookup(s.Resource, time.Since(start)) }(time.Now())
+	}
 
-	_, err := s.EntStore.EntKey(ctx, ent)
+	idxEncodedID, err := idx.FindEnt(ctx, tx, ent)
 	if err != nil {
-		if _, idxErr := s.IndexStore.EntKey(ctx, ent); idxErr != nil {
-			return nil, &influxdb.Error{
-				Code: influxdb.EInvalid,
-				Msg:  "no key was provided for " + s.Resource,
+		return Entity{}, err
+	}
+
+	indexKey, err := idx.EntKey(ctx, ent)
+	if err != nil {
+		return Entity{}, err
+	}
+
+	return idx.convertValToEnt(indexKey, idxEncodedID)
+}
+
+// Exists returns whether the entity exists. The entity is resolved by its PK, or
+// by the index when no PK is provided, in the same manner as FindEnt. Unlike FindEnt,
+// the stored entity is never decoded. A missing entity is not considered an error.
+func (s *IndexStore) Exists(ctx context.Context, tx Tx, ent Entity) (bool, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	pk, err := s.EntStore.EntKey(ctx, ent)
+	if err != nil {
+		idx, err := s.lookupIndex(ctx, ent)
+		if err != nil {
+			return false, err
+		}
+
+		indexEnt, err := s.findIndexEnt(ctx, tx, idx, ent)
+		if err != nil {
+			if influxdb.ErrorCode(err) == influxdb.ENotFound {
+				return false, nil
 			}
+			return false, err
 		}
+
+		pk, err = s.EntStore.EntKey(ctx, indexEnt)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := s.EntStore.bucketGet(ctx, tx, pk); err != nil {
+		if influxdb.ErrorCode(err) == influxdb.ENotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	expired, err := s.EntStore.expired(ctx, tx, pk, time.Now())
+	if err != nil || expired {
+		return false, err
+	}
+
+	deleted, err := s.deleted(ctx, tx, Entity{PK: EncBytes(pk)})
+	if err != nil {
+		return false, err
 	}
+	return !deleted, nil
+}
+
+// assertNotDeleted returns an ENotFound error when the entity is soft deleted.
+func (s *IndexStore) assertNotDeleted(ctx context.Context, tx Tx, pk []byte) error {
+	deleted, err := s.deleted(ctx, tx, Entity{PK: EncBytes(pk)})
 	if err != nil {
-		return s.findByIndex(ctx, tx, ent)
+		return err
+	}
+	if deleted {
+		return errEntNotFound(s.Resource, s.EntStore.KeyString(pk))
 	}
-	return s.EntStore.FindEnt(ctx, tx, ent)
+	return nil
 }
 
-func (s *IndexStore) findByIndex(ctx context.Context, tx Tx, ent Entity) (interface{}, error) {
+// FindOrCreate returns the decoded entity body found via the provided entity, in
+// the same manner as FindEnt. When it is not found, the entity returned by create
+// is put as a new entity, and its decoded body is returned along with true. When
+// the new entity conflicts with an existing one on the key it is found by, the
+// existing entity is found again and returned instead; a conflict on any other
+// key is returned. The find and create share the tx, so the pair is atomic with
+// respect to other transactions.
+func (s *IndexStore) FindOrCreate(ctx context.Context, tx Tx, ent Entity, create func() (Entity, error)) (interface{}, bool, error) {
 	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	v, err := s.FindEnt(ctx, tx, ent)
+	if err == nil {
+		return v, false, nil
+	}
+	if influxdb.ErrorCode(err) != influxdb.ENotFound {
+		return nil, false, err
+	}
 
-	idxEncodedID, err := s.IndexStore.FindEnt(ctx, tx, ent)
+	newEnt, err := create()
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	if err := s.Put(ctx, tx, newEnt, PutNew()); err != nil {
+		lookupErr, lookupKeyErr := s.lookupKeyConflict(ctx, ent)
+		if lookupKeyErr != nil || !errors.Is(err, lookupErr) {
+			return nil, false, err
+		}
+		v, findErr := s.FindEnt(ctx, tx, ent)
+		if findErr != nil {
+			return nil, false, err
+		}
+		return v, false, nil
 	}
 
-	indexKey, err := s.IndexStore.EntKey(ctx, ent)
+	v, err = s.FindEnt(ctx, tx, Entity{PK: newEnt.PK})
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// lookupKeyConflict returns the conflict error of the key FindEnt finds the
+// entity by.
+func (s *IndexStore) lookupKeyConflict(ctx context.Context, ent Entity) (error, error) {
+	if pk, err := s.EntStore.EntKey(ctx, ent); err == nil {
+		return errKeyConflict(s.Resource, s.EntStore.KeyString(pk)), nil
 	}
 
-	indexEnt, err := s.IndexStore.ConvertValToEntFn(indexKey, idxEncodedID)
+	idx, err := s.lookupIndex(ctx, ent)
 	if err != nil {
 		return nil, err
 	}
-
-	return s.EntStore.FindEnt(ctx, tx, indexEnt)
+	key, err := idx.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	return errKeyConflict(s.Resource, idx.KeyString(key)), nil
 }
 
 // Put will persist the entity into both the entity store and the index store.
 func (s *IndexStore) Put(ctx context.Context, tx Tx, ent Entity, opts ...PutOptionFn) error {
 	span, ctx := tracing.StartSpanFromContext(ctx)
 	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	if err := s.allowWrite(); err != nil {
+		return err
+	}
+
+	opt, err := newPutOption(opts...)
+	if err != nil {
+		return err
+	}
+
+	// validating an update removes the existing index entries, so the match and
+	// version are checked before it
+	validOpt := opt
+	validOpt.dryRun = true
+	if err := s.EntStore.putMatch(ctx, tx, ent, opt); err != nil {
+		return err
+	}
+	if err := s.putVersion(ctx, tx, ent, validOpt); err != nil {
+		return err
+	}
+	if err := s.putValidate(ctx, tx, ent, opt); err != nil {
+		return err
+	}
+
+	if err := s.putVersion(ctx, tx, ent, opt); err != nil {
+		return err
+	}
+	if opt.dryRun {
+		return nil
+	}
+
+	return s.put(ctx, tx, ent, opt.skipIndex)
+}
+
+// PutMany will persist the entities into both the entity store and the index store.
+// All entities are validated before anything is written. Entities within the batch
+// that share a primary key or unique key are rejected before the stores are touched.
+// The first failure is returned and identifies the offending entity by its position
+// in ents. A failed validation leaves the stores untouched, while writes made prior
+// to any later error are only undone by rolling back the tx, which is the default
+// behavior of a kv.Store Update that returns an error.
+func (s *IndexStore) PutMany(ctx context.Context, tx Tx, ents []Entity, opts ...PutOptionFn) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	if err := s.allowWrite(); err != nil {
+		return err
+	}
+
+	opt, err := newPutOption(opts...)
+	if err != nil {
+		return err
+	}
+
+	if err := s.validBatch(ctx, ents); err != nil {
+		return err
+	}
 
-	var opt putOption
-	for _, o := range opts {
-		if err := o(&opt); err != nil {
+	// validating an update removes the existing index entries, so that is left
+	// to the writes once every entity is known to be valid
+	validOpt := opt
+	validOpt.dryRun = true
+	for i, ent := range ents {
+		err := s.EntStore.putMatch(ctx, tx, ent, validOpt)
+		if err == nil {
+			err = s.putValidate(ctx, tx, ent, validOpt)
+		}
+		if err == nil {
+			err = s.putVersion(ctx, tx, ent, validOpt)
+		}
+		if err != nil {
 			return &influxdb.Error{
-				Code: influxdb.EConflict,
+				Code: influxdb.ErrorCode(err),
+				Msg:  fmt.Sprintf("%s entity at index %d failed validation", s.Resource, i),
 				Err:  err,
 			}
 		}
 	}
 
-	if err := s.putValidate(ctx, tx, ent, opt); err != nil {
+	for _, ent := range ents {
+		if err := s.putVersion(ctx, tx, ent, opt); err != nil {
+			return err
+		}
+		if opt.dryRun {
+			continue
+		}
+		if err := s.putValidate(ctx, tx, ent, opt); err != nil {
+			return err
+		}
+		if err := s.put(ctx, tx, ent, opt.skipIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename changes the unique keys of the entity identified by pk to those of the
+// renamed entity, whose body holds the new index field values and replaces the
+// stored body. The new keys are checked before the old index entries are removed,
+// so a rename fails with an EConflict when a key is taken by another entity, and
+// with an ENotFound when there is no entity for pk, leaving the stores untouched
+// in either case. See PutReplaceIndex.
+func (s *IndexStore) Rename(ctx context.Context, tx Tx, pk EncodeFn, renamed Entity) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	if renamed.Body == nil {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("renaming a %s entity requires its renamed body", s.Resource),
+		}
+	}
+	renamed.PK = pk
+
+	key, err := s.EntStore.EntKey(ctx, renamed)
+	if err != nil {
+		return err
+	}
+	if err := s.assertNotDeleted(ctx, tx, key); err != nil {
+		return err
+	}
+	return s.Put(ctx, tx, renamed, PutReplaceIndex(), PutUpdate())
+}
+
+// put writes the entity to the entity store and, unless skipIndex is set, its
+// index entries to the indexes.
+func (s *IndexStore) put(ctx context.Context, tx Tx, ent Entity, skipIndex bool) error {
+	var (
+		pk     []byte
+		before interface{}
+	)
+	if s.auditing() {
+		var err error
+		if pk, err = s.EntStore.EntKey(ctx, ent); err != nil {
+			return err
+		}
+		if before, err = s.storedVal(ctx, tx, pk); err != nil {
+			return err
+		}
+	}
+
+	if !skipIndex {
+		for _, idx := range s.indexes() {
+			if err := s.putIndex(ctx, tx, idx.Store, ent); err != nil {
+				return err
+			}
+		}
+	} else {
+		// the entity may be put back behind index entries left in place, which
+		// were missed while it was gone
+		for _, idx := range s.indexes() {
+			s.invalidateMiss(ctx, tx, idx.Store, ent)
+		}
+	}
+
+	if s.TombstoneStore != nil {
+		// putting a soft deleted entity brings it back
+		if err := s.TombstoneStore.DeleteEnt(ctx, tx, Entity{PK: ent.PK}); err != nil {
+			return err
+		}
+	}
+
+	if err := s.EntStore.Put(ctx, tx, ent); err != nil {
 		return err
 	}
 
-	if err := s.IndexStore.Put(ctx, tx, ent); err != nil {
+	if !s.auditing() {
+		return nil
+	}
+	after, err := s.storedVal(ctx, tx, pk)
+	if err != nil {
 		return err
 	}
+	op := AuditUpdate
+	if before == nil {
+		op = AuditCreate
+	}
+	return s.audit(ctx, tx, op, pk, before, after)
+}
+
+// validBatch verifies no two entities within the batch share a primary key or
+// unique key. This does not touch the underlying buckets.
+func (s *IndexStore) validBatch(ctx context.Context, ents []Entity) error {
+	stores := []*StoreBase{s.EntStore}
+	for _, idx := range s.indexes() {
+		stores = append(stores, idx.Store)
+	}
 
-	return s.EntStore.Put(ctx, tx, ent)
+	for _, store := range stores {
+		seen := make(map[string]int, len(ents))
+		for i, ent := range ents {
+			key, err := store.EntKey(ctx, ent)
+			if err != nil {
+				return err
+			}
+			if j, ok := seen[string(key)]; ok {
+				return &influxdb.Error{
+					Code: influxdb.EConflict,
+					Msg:  fmt.Sprintf("%s entity at index %d conflicts with entity at index %d for key %s", s.Resource, i, j, store.KeyString(key)),
+					Err:  errKeyInUse,
+				}
+			}
+			seen[string(key)] = i
+		}
+	}
+	return nil
 }
 
 func (s *IndexStore) putValidate(ctx context.Context, tx Tx, ent Entity, opt putOption) error {
+	// an entity the entity store rejects is rejected before any index entry is
+	// written for it, and by a dry run
+	if _, _, err := s.EntStore.encodePut(ctx, ent); err != nil {
+		return err
+	}
+	if opt.skipIndex && (opt.isNew || opt.isUpdate || opt.isUpsert || opt.replaceIndex) {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s puts skipping the index can not be validated against it", s.Resource),
+		}
+	}
+	if opt.replaceIndex && !opt.isNew {
+		return s.validReplaceIndex(ctx, tx, ent, opt.isUpdate, opt.dryRun)
+	}
 	if opt.isNew {
 		return s.validNew(ctx, tx, ent)
 	}
 	if opt.isUpdate {
-		return s.validUpdate(ctx, tx, ent)
+		return s.validUpdate(ctx, tx, ent, opt.dryRun)
+	}
+	if opt.isUpsert {
+		return s.validUpsert(ctx, tx, ent, opt.dryRun)
 	}
 	return nil
 }
 
+// validReplaceIndex verifies none of the entity's index keys belong to another
+// entity, and only then removes the index entries of the existing entity, unless
+// this is a dry run.
+func (s *IndexStore) validReplaceIndex(ctx context.Context, tx Tx, ent Entity, mustExist, dryRun bool) error {
+	pk, err := s.EntStore.EntKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+
+	existingVal, err := s.EntStore.FindEnt(ctx, tx, Entity{PK: ent.PK})
+	if err != nil && (mustExist || influxdb.ErrorCode(err) != influxdb.ENotFound) {
+		return err
+	}
+	exists := err == nil
+
+	for _, idx := range s.indexes() {
+		idxKey, err := idx.Store.EntKey(ctx, ent)
+		if err != nil {
+			return err
+		}
+		idxPK, err := s.indexedPK(ctx, tx, idx.Store, idxKey)
+		if err != nil {
+			return err
+		}
+		if idxPK != nil && !bytes.Equal(idxPK, pk) {
+			return &influxdb.Error{
+				Code: influxdb.EConflict,
+				Msg:  fmt.Sprintf("%s entity %q cannot take %s key %s; it belongs to entity %q", s.Resource, s.EntStore.KeyString(pk), idx.Name, idx.Store.KeyString(idxKey), s.EntStore.KeyString(idxPK)),
+				Err:  errKeyInUse,
+			}
+		}
+	}
+
+	if !exists || dryRun {
+		return nil
+	}
+
+	// the unique keys of a soft deleted entity may belong to another entity by
+	// now, and its index entries were removed when it was deleted
+	deleted, err := s.deleted(ctx, tx, ent)
+	if err != nil || deleted {
+		return err
+	}
+
+	existingEnt, err := s.EntStore.convertValToEnt(pk, existingVal)
+	if err != nil {
+		return err
+	}
+	return s.deleteIndexes(ctx, tx, existingEnt)
+}
+
+func (s *IndexStore) validUpsert(ctx context.Context, tx Tx, ent Entity, dryRun bool) error {
+	_, err := s.EntStore.FindEnt(ctx, tx, Entity{PK: ent.PK})
+	if err == nil {
+		return s.validUpdate(ctx, tx, ent, dryRun)
+	}
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return s.validNew(ctx, tx, ent)
+	}
+	return err
+}
+
 func (s *IndexStore) validNew(ctx context.Context, tx Tx, ent Entity) error {
-	_, err := s.IndexStore.FindEnt(ctx, tx, ent)
-	if err == nil || influxdb.ErrorCode(err) != influxdb.ENotFound {
-		key, _ := s.IndexStore.EntKey(ctx, ent)
-		return &influxdb.Error{
-			Code: influxdb.EConflict,
-			Msg:  fmt.Sprintf("%s is not unique for key %s", s.Resource, string(key)),
-			Err:  err,
+	for _, idx := range s.indexes() {
+		_, err := idx.Store.FindEnt(ctx, tx, ent)
+		if err == nil || influxdb.ErrorCode(err) != influxdb.ENotFound {
+			key, _ := idx.Store.EntKey(ctx, ent)
+			conflictErr := errKeyConflict(s.Resource, idx.Store.KeyString(key))
+			conflictErr.Err = keyInUse(err)
+			return conflictErr
 		}
 	}
 
-	_, err = s.EntStore.FindEnt(ctx, tx, ent)
+	_, err := s.EntStore.FindEnt(ctx, tx, ent)
 	if err == nil || influxdb.ErrorCode(err) != influxdb.ENotFound {
-		return &influxdb.Error{Code: influxdb.EConflict, Err: err}
+		key, _ := s.EntStore.EntKey(ctx, ent)
+		conflictErr := errKeyConflict(s.Resource, s.EntStore.KeyString(key))
+		conflictErr.Err = keyInUse(err)
+		return conflictErr
 	}
 	return nil
 }
 
-func (s *IndexStore) validUpdate(ctx context.Context, tx Tx, ent Entity) (e error) {
+func (s *IndexStore) validUpdate(ctx context.Context, tx Tx, ent Entity, dryRun bool) (e error) {
 	// first check to make sure the existing entity exists in the ent store
 	existingVal, err := s.EntStore.FindEnt(ctx, tx, Entity{PK: ent.PK})
 	if err != nil {
 		return err
 	}
+	// the unique keys of a soft deleted entity may belong to another entity by now,
+	// so it must be restored before it can be updated
+	pk, err := s.EntStore.EntKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+	if err := s.assertNotDeleted(ctx, tx, pk); err != nil {
+		return err
+	}
 
 	defer func() {
-		if e != nil {
+		if e != nil || dryRun {
 			return
 		}
 		// we need to cleanup the unique key entry when this is deemed
@@ -187,15 +1365,24 @@ func (s *IndexStore) validUpdate(ctx context.Context, tx Tx, ent Entity) (e erro
 			e = ierrors.Wrap(err, "failed to encode PK")
 			return
 		}
-		existingEnt, err := s.EntStore.ConvertValToEntFn(pk, existingVal)
+		existingEnt, err := s.EntStore.convertValToEnt(pk, existingVal)
 		if err != nil {
 			e = ierrors.Wrap(err, "failed to convert value")
 			return
 		}
-		e = s.IndexStore.DeleteEnt(ctx, tx, existingEnt)
+		e = s.deleteIndexes(ctx, tx, existingEnt)
 	}()
 
-	idxVal, err := s.IndexStore.FindEnt(ctx, tx, ent)
+	for _, idx := range s.indexes() {
+		if err := s.validUpdateIndex(ctx, tx, idx.Store, ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *IndexStore) validUpdateIndex(ctx context.Context, tx Tx, idx *StoreBase, ent Entity) error {
+	idxVal, err := idx.FindEnt(ctx, tx, ent)
 	if err != nil {
 		if influxdb.ErrorCode(err) == influxdb.ENotFound {
 			return nil
@@ -203,12 +1390,12 @@ func (s *IndexStore) validUpdate(ctx context.Context, tx Tx, ent Entity) (e erro
 		return err
 	}
 
-	idxKey, err := s.IndexStore.EntKey(ctx, ent)
+	idxKey, err := idx.EntKey(ctx, ent)
 	if err != nil {
 		return err
 	}
 
-	indexEnt, err := s.IndexStore.ConvertValToEntFn(idxKey, idxVal)
+	indexEnt, err := idx.convertValToEnt(idxKey, idxVal)
 	if err != nil {
 		return err
 	}
@@ -216,16 +1403,14 @@ func (s *IndexStore) validUpdate(ctx context.Context, tx Tx, ent Entity) (e erro
 	if err := sameKeys(ent.PK, indexEnt.PK); err != nil {
 		if _, err := s.EntStore.FindEnt(ctx, tx, ent); influxdb.ErrorCode(err) == influxdb.ENotFound {
 			key, _ := ent.PK()
-			return &influxdb.Error{
-				Code: influxdb.ENotFound,
-				Msg:  fmt.Sprintf("%s does not exist for key %s", s.Resource, string(key)),
-				Err:  err,
-			}
+			notFoundErr := errEntNotFound(s.Resource, s.EntStore.KeyString(key))
+			notFoundErr.Err = err
+			return notFoundErr
 		}
-		key, _ := indexEnt.UniqueKey()
 		return &influxdb.Error{
 			Code: influxdb.EConflict,
-			Msg:  fmt.Sprintf("%s entity update conflicts with an existing entity for key %s", s.Resource, string(key)),
+			Msg:  fmt.Sprintf("%s entity update conflicts with an existing entity for key %s", s.Resource, idx.KeyString(idxKey)),
+			Err:  errKeyInUse,
 		}
 	}
 
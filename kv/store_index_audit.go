@@ -0,0 +1,73 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// AuditOp is the kind of mutation reported to an AuditFn.
+type AuditOp string
+
+const (
+	// AuditCreate reports the put of an entity that did not exist.
+	AuditCreate AuditOp = "create"
+	// AuditUpdate reports the put of an existing entity.
+	AuditUpdate AuditOp = "update"
+	// AuditDelete reports the delete of an entity, including soft deletes.
+	AuditDelete AuditOp = "delete"
+	// AuditRestore reports the restore of a soft deleted entity.
+	AuditRestore AuditOp = "restore"
+)
+
+// AuditEvent describes a mutation of an entity. Before is the decoded entity
+// prior to the mutation and is nil for a create. After is the decoded entity
+// following the mutation and is nil for a delete.
+type AuditEvent struct {
+	Op       AuditOp
+	Resource string
+	Key      []byte
+	Before   interface{}
+	After    interface{}
+}
+
+// AuditFn is called with every mutation of an IndexStore's entities. It is called
+// within the tx of the mutation, so records written by it are committed, or rolled
+// back, along with the mutation. An error returned by it fails the mutation.
+type AuditFn func(ctx context.Context, tx Tx, event AuditEvent) error
+
+// audit reports the mutation to the AuditFn, and to the EventBus once the tx is
+// committed.
+func (s *IndexStore) audit(ctx context.Context, tx Tx, op AuditOp, key []byte, before, after interface{}) error {
+	if s.AuditFn != nil {
+		err := s.AuditFn(ctx, tx, AuditEvent{
+			Op:       op,
+			Resource: s.Resource,
+			Key:      append([]byte(nil), key...),
+			Before:   before,
+			After:    after,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.publishOnCommit(tx, op, key)
+}
+
+// auditing reports whether mutations are reported to an AuditFn or EventBus.
+func (s *IndexStore) auditing() bool {
+	return s.AuditFn != nil || s.EventBus != nil
+}
+
+// storedVal returns the decoded entity stored at the key, or nil when there is
+// none. Unlike FindEnt, expired and soft deleted entities are returned.
+func (s *IndexStore) storedVal(ctx context.Context, tx Tx, key []byte) (interface{}, error) {
+	body, err := s.EntStore.bucketGet(ctx, tx, key)
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.EntStore.decodeEnt(ctx, key, body)
+}
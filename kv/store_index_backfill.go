@@ -0,0 +1,88 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// BackfillIndex writes an index entry to the index store for every entity within
+// the entity store. The index entity for each entity is provided by deriveIndexEnt
+// and must provide both the index key and the PK of the entity it points to.
+//
+// Index entries that already point to their entity are left as is, which makes it
+// safe to run again after a partial backfill. When two entities derive the same
+// index key an EConflict error is returned, as the data violates the uniqueness
+// of the index.
+//
+// The index store is written to directly, so the MissCache of an IndexStore over
+// it is left as is; backfill an IndexStore via its BackfillIndex method instead.
+func BackfillIndex(ctx context.Context, tx Tx, entStore, indexStore *StoreBase, deriveIndexEnt func(Entity) Entity) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	return entStore.Find(ctx, tx, FindOpts{
+		CaptureFn: func(k []byte, v interface{}) error {
+			ent, err := entStore.convertValToEnt(k, v)
+			if err != nil {
+				return err
+			}
+			return backfillIndexEnt(ctx, tx, entStore, indexStore, deriveIndexEnt(ent))
+		},
+	})
+}
+
+// BackfillIndex writes an entry to the default index for every entity of the store,
+// as the BackfillIndex func does, and invalidates every miss of the MissCache once
+// the tx is committed, as the backfill may create any index key.
+func (s *IndexStore) BackfillIndex(ctx context.Context, tx Tx, deriveIndexEnt func(Entity) Entity) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	if err := BackfillIndex(ctx, tx, s.EntStore, s.IndexStore, deriveIndexEnt); err != nil {
+		return err
+	}
+	if s.MissCache != nil {
+		s.MissCache.invalidateOnCommit(tx, nil, nil)
+	}
+	return nil
+}
+
+func backfillIndexEnt(ctx context.Context, tx Tx, entStore, indexStore *StoreBase, idxEnt Entity) error {
+	idxKey, err := indexStore.EntKey(ctx, idxEnt)
+	if err != nil {
+		return err
+	}
+	pk, _, err := indexStore.EncodeEntBodyFn(idxEnt)
+	if err != nil {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("failed to encode %s index entry for key %s", indexStore.Resource, indexStore.KeyString(idxKey)),
+			Err:  err,
+		}
+	}
+
+	existing, err := indexStore.bucketGet(ctx, tx, idxKey)
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return indexStore.Put(ctx, tx, idxEnt)
+	}
+	if err != nil {
+		return err
+	}
+	existing, err = indexStore.storedBody(idxKey, existing)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(existing, pk) {
+		return &influxdb.Error{
+			Code: influxdb.EConflict,
+			Msg:  fmt.Sprintf("%s index key %s is shared by entities %q and %q", indexStore.Resource, indexStore.KeyString(idxKey), entStore.KeyString(existing), entStore.KeyString(pk)),
+		}
+	}
+	return nil
+}
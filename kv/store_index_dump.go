@@ -0,0 +1,128 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// DumpFormat is the format IndexStore.Dump writes the records of its buckets in.
+type DumpFormat int
+
+const (
+	// DumpText writes a tab separated line per record: the bucket, the readable
+	// key, the hex encoded key and value and the decoded value as JSON.
+	DumpText DumpFormat = iota
+	// DumpJSON writes a JSON object per line, one per record, i.e. for piping into jq.
+	DumpJSON
+)
+
+// DumpRecord is a single record of a dump, as written in the DumpJSON format.
+type DumpRecord struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	KeyHex   string `json:"keyHex"`
+	ValueHex string `json:"valueHex"`
+	// Decoded is the value decoded by the bucket's store, which is omitted when
+	// the value fails to decode, in which case DecodeErr holds the error.
+	Decoded   interface{} `json:"decoded,omitempty"`
+	DecodeErr string      `json:"decodeErr,omitempty"`
+}
+
+// Dump writes every record of the entity bucket, followed by the records of each
+// index bucket, to the writer in the format provided. The records are written as
+// the buckets are scanned rather than buffered, and a value that fails to decode
+// is written with its decode error rather than failing the dump, so a dump can be
+// taken of corrupt buckets.
+func (s *IndexStore) Dump(ctx context.Context, tx Tx, w io.Writer, format DumpFormat) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	if format != DumpText && format != DumpJSON {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("unknown dump format %d", format),
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	stores := []*StoreBase{s.EntStore}
+	for _, idx := range s.indexes() {
+		stores = append(stores, idx.Store)
+	}
+	for _, store := range stores {
+		if err := store.dump(ctx, tx, bw, format); err != nil {
+			return err
+		}
+	}
+	return s.EntStore.dumpWriteErr(bw.Flush())
+}
+
+func (s *StoreBase) dump(ctx context.Context, tx Tx, w *bufio.Writer, format DumpFormat) error {
+	cur, err := s.bucketCursor(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	var seen int
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		seen++
+		if err := checkScanCtx(ctx, seen); err != nil {
+			return err
+		}
+
+		record := DumpRecord{
+			Bucket:   string(s.BktName),
+			Key:      s.KeyString(k),
+			KeyHex:   hex.EncodeToString(k),
+			ValueHex: hex.EncodeToString(v),
+		}
+		if _, decoded, err := s.decodeVal(k, v); err != nil {
+			record.DecodeErr = err.Error()
+		} else {
+			record.Decoded = decoded
+		}
+
+		if format == DumpJSON {
+			err = enc.Encode(record)
+		} else {
+			err = writeDumpText(w, record)
+		}
+		if err != nil {
+			return s.dumpWriteErr(err)
+		}
+	}
+	return nil
+}
+
+func writeDumpText(w io.Writer, record DumpRecord) error {
+	decoded := "error: " + record.DecodeErr
+	if record.DecodeErr == "" {
+		b, err := json.Marshal(record.Decoded)
+		if err != nil {
+			return err
+		}
+		decoded = string(b)
+	}
+	_, err := fmt.Fprintf(w, "%s\t%s\t0x%s\t0x%s\t%s\n", record.Bucket, record.Key, record.KeyHex, record.ValueHex, decoded)
+	return err
+}
+
+func (s *StoreBase) dumpWriteErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  fmt.Sprintf("failed to write %s dump", s.Resource),
+		Err:  err,
+	}
+}
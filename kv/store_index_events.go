@@ -0,0 +1,160 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// EntEvent describes a committed mutation of an entity, as delivered to the
+// subscribers of an EventBus.
+type EntEvent struct {
+	Op       AuditOp
+	Resource string
+	Key      []byte
+}
+
+// EventPolicy decides what happens to an event when a subscriber's buffer is full.
+type EventPolicy int
+
+const (
+	// EventDrop drops the event for the subscriber, which is counted by the
+	// subscription's Dropped.
+	EventDrop EventPolicy = iota
+	// EventBlock waits for the subscriber to receive the event. The wait happens
+	// after the tx is committed, but holds up the caller of the tx's Update until
+	// every subscriber has room for its events.
+	EventBlock
+)
+
+// EventBus delivers the committed mutations of the IndexStores it is set on to its
+// subscribers. Events are sent once the tx of the mutation is committed, so a
+// subscriber never observes a mutation that is rolled back. Mutations must be made
+// in a tx that implements CommitHookTx.
+type EventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewEventBus creates an EventBus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the events of an EventBus on C until it is closed.
+type Subscription struct {
+	// C receives the events of the bus. It is closed once the subscription is.
+	C <-chan EntEvent
+
+	bus     *EventBus
+	ch      chan EntEvent
+	policy  EventPolicy
+	dropped uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Subscribe registers a subscription that buffers up to bufSize events, handling
+// events beyond that as the policy decides.
+func (b *EventBus) Subscribe(bufSize int, policy EventPolicy) *Subscription {
+	ch := make(chan EntEvent, bufSize)
+	sub := &Subscription{
+		C:      ch,
+		bus:    b,
+		ch:     ch,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Dropped returns the number of events dropped for the subscriber as its buffer
+// was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close removes the subscription from its bus and closes C. A delivery blocked on
+// the subscription is abandoned.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		// unblock deliveries before waiting on them to release the bus
+		close(s.done)
+
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.ch)
+	})
+}
+
+func (b *EventBus) publish(event EntEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		sub.deliver(event)
+	}
+}
+
+func (s *Subscription) deliver(event EntEvent) {
+	if s.policy == EventBlock {
+		select {
+		case s.ch <- event:
+		case <-s.done:
+		}
+		return
+	}
+
+	select {
+	case s.ch <- event:
+	case <-s.done:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Subscribe registers a subscription to the committed mutations of the store's
+// entities. See EventBus.Subscribe.
+func (s *IndexStore) Subscribe(bufSize int, policy EventPolicy) (*Subscription, error) {
+	if s.EventBus == nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("%s store has no event bus to subscribe to", s.Resource),
+		}
+	}
+	return s.EventBus.Subscribe(bufSize, policy), nil
+}
+
+// publishOnCommit sends the event to the subscribers of the store's bus once the tx
+// is committed.
+func (s *IndexStore) publishOnCommit(tx Tx, op AuditOp, key []byte) error {
+	if s.EventBus == nil {
+		return nil
+	}
+
+	hookTx, ok := tx.(CommitHookTx)
+	if !ok {
+		return &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("%s events require a transaction that supports commit hooks", s.Resource),
+		}
+	}
+
+	event := EntEvent{
+		Op:       op,
+		Resource: s.Resource,
+		Key:      append([]byte(nil), key...),
+	}
+	hookTx.OnCommit(func() {
+		s.EventBus.publish(event)
+	})
+	return nil
+}
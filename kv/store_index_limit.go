@@ -0,0 +1,63 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/influxdata/influxdb/v2"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter decides whether a write of an IndexStore's resource may proceed. It
+// is consulted once at the start of every put and delete of the store, including
+// batched calls such as PutMany and DeleteMany.
+type RateLimiter interface {
+	// AllowWrite reports whether a write of the resource may proceed now.
+	AllowWrite(resource string) bool
+}
+
+// ResourceRateLimiter is a RateLimiter that keeps a token bucket per resource, so
+// a flood of writes to one resource does not throttle the writes of another.
+type ResourceRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewResourceRateLimiter creates a ResourceRateLimiter that allows every resource
+// up to limit writes per second, with bursts of up to burst writes.
+func NewResourceRateLimiter(limit rate.Limit, burst int) *ResourceRateLimiter {
+	return &ResourceRateLimiter{
+		limit:    limit,
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// AllowWrite takes a token from the resource's bucket, reporting whether there was
+// one to take.
+func (l *ResourceRateLimiter) AllowWrite(resource string) bool {
+	l.mu.Lock()
+	limiter, ok := l.limiters[resource]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[resource] = limiter
+	}
+	l.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// allowWrite returns an ETooManyRequests error when the store's RateLimiter rejects
+// a write of its resource. A nil RateLimiter allows every write.
+func (s *IndexStore) allowWrite() error {
+	if s.RateLimiter == nil || s.RateLimiter.AllowWrite(s.Resource) {
+		return nil
+	}
+	return &influxdb.Error{
+		Code: influxdb.ETooManyRequests,
+		Msg:  fmt.Sprintf("%s write rate exceeded", s.Resource),
+	}
+}
@@ -0,0 +1,175 @@
+package kv
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/v2"
+)
+
+// MissCache is a bounded cache of the index keys FindEnt did not find, so repeated
+// finds of entities that do not exist, i.e. the lookups of unknown tokens by a
+// misconfigured client, are not found via the index every time. A miss is cached
+// for the TTL, and invalidated as soon as an index entry is put for the key, and
+// again once the tx putting it is committed when the tx implements CommitHookTx,
+// so a miss never masks a create. Misses are only cached by read only txs, as a
+// write tx may miss an entity it deleted itself and then be rolled back. As with
+// a CachedStore, a read only tx opened before the create is committed may cache
+// the miss again, for up to the TTL.
+type MissCache struct {
+	size int
+	ttl  time.Duration
+
+	mu sync.Mutex
+	// gen is bumped on every invalidation. A find only adds its miss when no
+	// invalidation happened while it was reading from the store.
+	gen   uint64
+	ll    *list.List
+	items map[missKey]*list.Element
+}
+
+type missKey struct {
+	bucket string
+	key    string
+}
+
+type missEntry struct {
+	key     missKey
+	expires time.Time
+}
+
+// NewMissCache creates a MissCache that holds up to size misses, each for the ttl.
+func NewMissCache(size int, ttl time.Duration) *MissCache {
+	return &MissCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[missKey]*list.Element),
+	}
+}
+
+// Len returns the number of misses in the cache, including expired misses not yet
+// evicted.
+func (c *MissCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// has reports whether the key of the index is a cached miss, and the generation of
+// the cache to add the miss with when it is not.
+func (c *MissCache) has(idx *StoreBase, key []byte) (bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mk := missKey{bucket: string(idx.BktName), key: string(key)}
+	el, ok := c.items[mk]
+	if !ok {
+		return false, c.gen
+	}
+	if time.Now().After(el.Value.(*missEntry).expires) {
+		c.ll.Remove(el)
+		delete(c.items, mk)
+		return false, c.gen
+	}
+	c.ll.MoveToFront(el)
+	return true, c.gen
+}
+
+func (c *MissCache) add(gen uint64, idx *StoreBase, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 || gen != c.gen {
+		return
+	}
+
+	mk := missKey{bucket: string(idx.BktName), key: string(key)}
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[mk]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*missEntry).expires = expires
+		return
+	}
+
+	c.items[mk] = c.ll.PushFront(&missEntry{key: mk, expires: expires})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*missEntry).key)
+	}
+}
+
+// invalidate removes the key of the index from the cache. A nil idx removes every
+// miss.
+func (c *MissCache) invalidate(idx *StoreBase, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if idx == nil {
+		c.ll.Init()
+		c.items = make(map[missKey]*list.Element)
+		return
+	}
+
+	mk := missKey{bucket: string(idx.BktName), key: string(key)}
+	if el, ok := c.items[mk]; ok {
+		c.ll.Remove(el)
+		delete(c.items, mk)
+	}
+}
+
+// invalidateOnCommit invalidates the key right away, and again once the tx is
+// committed when it supports commit hooks.
+func (c *MissCache) invalidateOnCommit(tx Tx, idx *StoreBase, key []byte) {
+	c.invalidate(idx, key)
+	if hookTx, ok := tx.(CommitHookTx); ok {
+		hookTx.OnCommit(func() {
+			c.invalidate(idx, key)
+		})
+	}
+}
+
+// findByIndexCached finds the entity via the index, serving the miss from the
+// MissCache when the index key is a cached miss, and caching the miss otherwise
+// when the tx is read only.
+func (s *IndexStore) findByIndexCached(ctx context.Context, tx Tx, idx *StoreBase, ent Entity, findByIndex func(context.Context, Tx, *StoreBase, Entity) (interface{}, error)) (interface{}, error) {
+	idxKey, err := idx.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, gen := s.MissCache.has(idx, idxKey)
+	if cached {
+		return nil, idx.ErrEntNotFound(idxKey)
+	}
+
+	v, err := findByIndex(ctx, tx, idx, ent)
+	if wtx, ok := tx.(WritableTx); ok && !wtx.Writable() && influxdb.ErrorCode(err) == influxdb.ENotFound {
+		s.MissCache.add(gen, idx, idxKey)
+	}
+	return v, err
+}
+
+// putIndex puts the index entry of the entity, invalidating the cached miss of its
+// index key.
+func (s *IndexStore) putIndex(ctx context.Context, tx Tx, idx *StoreBase, ent Entity) error {
+	s.invalidateMiss(ctx, tx, idx, ent)
+	return idx.Put(ctx, tx, ent)
+}
+
+// invalidateMiss invalidates the cached miss of the entity's key for the index,
+// when the store has a MissCache. An entity without a key for the index has no
+// miss to invalidate.
+func (s *IndexStore) invalidateMiss(ctx context.Context, tx Tx, idx *StoreBase, ent Entity) {
+	if s.MissCache == nil {
+		return
+	}
+	if idxKey, err := idx.EntKey(ctx, ent); err == nil {
+		s.MissCache.invalidateOnCommit(tx, idx, idxKey)
+	}
+}
@@ -0,0 +1,44 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// MoveEnt moves the entity from the src store to the dst store within the tx, i.e.
+// when a resource is reclassified as another type of resource. The entity is found
+// in src, passed to the transform, when provided, and put into dst as a new
+// entity, which derives its index entries from the dst indexes. The entity and its
+// index entries are deleted from src. The put into dst is validated before
+// anything is written, so an EConflict error is returned when the primary key or
+// an index key of the entity is already taken in dst, leaving both stores as they
+// were. An error returned by a later step leaves the tx partially written, which
+// is undone by rolling it back, the default behavior of a kv.Store Update that
+// returns an error.
+func MoveEnt(ctx context.Context, tx Tx, src, dst *IndexStore, ent Entity, transform func(Entity) Entity) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	span.SetTag("Resource", src.Resource)
+	span.SetTag("Destination", dst.Resource)
+
+	existing, err := src.FindEnt(ctx, tx, ent)
+	if err != nil {
+		return err
+	}
+	moved, err := src.EntStore.convertValToEnt(nil, existing)
+	if err != nil {
+		return err
+	}
+	if transform != nil {
+		moved = transform(moved)
+	}
+
+	if err := dst.Put(ctx, tx, moved, PutNew(), PutDryRun()); err != nil {
+		return err
+	}
+	if err := src.DeleteEnt(ctx, tx, ent); err != nil {
+		return err
+	}
+	return dst.Put(ctx, tx, moved, PutNew())
+}
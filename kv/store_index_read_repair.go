@@ -0,0 +1,63 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/influxdata/influxdb/v2"
+	"go.uber.org/zap"
+)
+
+// FindEntRepairOnRead deletes an index entry found to point to an entity that no
+// longer exists in the entity store, and returns an ENotFound error for the index
+// key, rather than the PK the index entry pointed to. The dangling entry is only
+// deleted when the find is performed in a writable transaction, i.e. one opened
+// via Update; a find in any other transaction leaves it in place. This only
+// applies to an IndexStore, and to finds via the index.
+func FindEntRepairOnRead() FindEntOptionFn {
+	return func(o *findEntOption) {
+		o.repairOnRead = true
+	}
+}
+
+// findByIndexRepair finds the entity via the index as findByIndex does, repairing
+// a dangling index entry as FindEntRepairOnRead describes.
+func (s *IndexStore) findByIndexRepair(ctx context.Context, tx Tx, idx *StoreBase, ent Entity) (interface{}, error) {
+	indexEnt, err := s.findIndexEnt(ctx, tx, idx, ent)
+	if err != nil {
+		return nil, err
+	}
+
+	v, fetchErr := s.fetchIndexedEnt(ctx, tx, indexEnt)
+	if influxdb.ErrorCode(fetchErr) != influxdb.ENotFound {
+		return v, fetchErr
+	}
+
+	// an expired entity is not found either, but its index entry is removed
+	// along with the entity once it is swept
+	pk, err := s.EntStore.EntKey(ctx, indexEnt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.EntStore.bucketGet(ctx, tx, pk); err == nil {
+		return nil, fetchErr
+	} else if influxdb.ErrorCode(err) != influxdb.ENotFound {
+		return nil, err
+	}
+
+	idxKey, err := idx.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	if wtx, ok := tx.(WritableTx); ok && wtx.Writable() {
+		if err := idx.bucketDelete(ctx, tx, idxKey); err != nil {
+			return nil, err
+		}
+		if s.Logger != nil {
+			s.Logger.Info("Deleted index entry of missing entity",
+				zap.String("resource", s.Resource),
+				zap.String("index", idx.KeyString(idxKey)),
+				zap.String("key", s.EntStore.KeyString(pk)))
+		}
+	}
+	return nil, errEntNotFound(s.Resource, idx.KeyString(idxKey))
+}
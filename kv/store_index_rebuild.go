@@ -0,0 +1,232 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// IndexCollision indicates an entity that could not be indexed by a rebuild, as
+// its index key points to a different entity.
+const IndexCollision InconsistencyKind = "index collision"
+
+// RebuildOpts configures RebuildIndexIncremental.
+type RebuildOpts struct {
+	// BatchSize is the number of entities indexed within each tx. It must be
+	// greater than 0.
+	BatchSize int
+
+	// CheckpointBucket, when set, is the bucket the key of the last entity indexed
+	// is recorded in as each batch is committed, keyed by the bucket name of the
+	// entity store. A rebuild resumes after the recorded key, and removes it once
+	// every entity has been indexed. Without a checkpoint, an interrupted rebuild
+	// starts over.
+	CheckpointBucket []byte
+}
+
+// RebuildIndexIncremental writes the index entries of every entity of the store
+// that is not soft deleted, for every index of the store. Unlike Repair, the
+// entity store is scanned in key ordered batches, each indexed and committed in
+// its own tx opened via the kv store's Update, so the rebuild of a large bucket
+// does not hold a single long running tx.
+//
+// An entity whose index key points to a different entity is not indexed and is
+// returned as an IndexCollision once the rebuild is done, rather than aborting
+// it. Collisions found before an interrupted rebuild are not reported by the
+// resumed rebuild; Verify reports the entities as unindexed. Orphaned index keys
+// are left as is; see Repair.
+func RebuildIndexIncremental(ctx context.Context, kvStore Store, s *IndexStore, opts RebuildOpts) ([]IndexInconsistency, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	if opts.BatchSize <= 0 {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("batch size must be greater than 0; got %d", opts.BatchSize),
+		}
+	}
+
+	var after []byte
+	if len(opts.CheckpointBucket) > 0 {
+		err := kvStore.View(ctx, func(tx Tx) error {
+			var err error
+			after, err = s.rebuildCheckpoint(tx, opts.CheckpointBucket)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var collisions []IndexInconsistency
+	for {
+		if err := ctx.Err(); err != nil {
+			return collisions, &influxdb.Error{
+				Code: influxdb.ECanceled,
+				Msg:  "rebuild canceled",
+				Err:  err,
+			}
+		}
+
+		var (
+			last  []byte
+			n     int
+			found []IndexInconsistency
+		)
+		err := kvStore.Update(ctx, func(tx Tx) error {
+			found = nil
+			var err error
+			last, n, err = s.rebuildBatch(ctx, tx, after, opts.BatchSize, func(inc IndexInconsistency) {
+				found = append(found, inc)
+			})
+			if err != nil || len(opts.CheckpointBucket) == 0 {
+				return err
+			}
+			if n < opts.BatchSize {
+				return s.putRebuildCheckpoint(tx, opts.CheckpointBucket, nil)
+			}
+			return s.putRebuildCheckpoint(tx, opts.CheckpointBucket, last)
+		})
+		if err != nil {
+			return collisions, err
+		}
+		collisions = append(collisions, found...)
+
+		if n < opts.BatchSize {
+			return collisions, nil
+		}
+		after = last
+	}
+}
+
+// rebuildBatch indexes up to batchSize entities with a key after the after key,
+// returning the key of the last entity seen and the number of entities seen.
+func (s *IndexStore) rebuildBatch(ctx context.Context, tx Tx, after []byte, batchSize int, collisionFn func(IndexInconsistency)) ([]byte, int, error) {
+	cur, err := s.EntStore.bucketCursor(ctx, tx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	k, v := cur.First()
+	if after != nil {
+		k, v = cur.Seek(after)
+		if bytes.Equal(k, after) {
+			k, v = cur.Next()
+		}
+	}
+
+	var (
+		last []byte
+		n    int
+	)
+	for ; k != nil && n < batchSize; k, v = cur.Next() {
+		n++
+		last = append([]byte(nil), k...)
+		if err := s.rebuildEnt(ctx, tx, last, v, collisionFn); err != nil {
+			return nil, 0, err
+		}
+	}
+	return last, n, nil
+}
+
+func (s *IndexStore) rebuildEnt(ctx context.Context, tx Tx, pk, raw []byte, collisionFn func(IndexInconsistency)) error {
+	deleted, err := s.deleted(ctx, tx, Entity{PK: EncBytes(pk)})
+	if err != nil || deleted {
+		return err
+	}
+
+	decoded, err := s.EntStore.decodeEnt(ctx, pk, raw)
+	if err != nil {
+		return err
+	}
+	ent, err := s.EntStore.convertValToEnt(pk, decoded)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range s.indexes() {
+		idxKey, err := idx.Store.EntKey(ctx, ent)
+		if err != nil {
+			return err
+		}
+		idxPK, err := s.indexedPK(ctx, tx, idx.Store, idxKey)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case idxPK == nil:
+			if err := s.putIndex(ctx, tx, idx.Store, ent); err != nil {
+				return err
+			}
+		case !bytes.Equal(idxPK, pk):
+			collisionFn(IndexInconsistency{
+				Kind:  IndexCollision,
+				Index: idx.Name,
+				Key:   pk,
+			})
+		}
+	}
+	return nil
+}
+
+// rebuildCheckpoint returns the key of the last entity indexed by an interrupted
+// rebuild, or nil when there is none.
+func (s *IndexStore) rebuildCheckpoint(tx Tx, bktName []byte) ([]byte, error) {
+	b, err := s.checkpointBucket(tx, bktName)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := b.Get(s.EntStore.BktName)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("failed to read %s rebuild checkpoint", s.Resource),
+			Err:  err,
+		}
+	}
+	return append([]byte(nil), after...), nil
+}
+
+// putRebuildCheckpoint records the key of the last entity indexed, removing the
+// checkpoint when the key is nil.
+func (s *IndexStore) putRebuildCheckpoint(tx Tx, bktName, last []byte) error {
+	b, err := s.checkpointBucket(tx, bktName)
+	if err != nil {
+		return err
+	}
+
+	if last == nil {
+		err = b.Delete(s.EntStore.BktName)
+	} else {
+		err = b.Put(s.EntStore.BktName, last)
+	}
+	if err != nil {
+		return &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("failed to write %s rebuild checkpoint", s.Resource),
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+func (s *IndexStore) checkpointBucket(tx Tx, bktName []byte) (Bucket, error) {
+	b, err := tx.Bucket(bktName)
+	if err != nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("unexpected error retrieving bucket %q; Err %v", string(bktName), err),
+			Err:  err,
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,261 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+const snapshotVersion = 1
+
+// snapshotHeader is the first line of a snapshot, describing the buckets the
+// records that follow belong to.
+type snapshotHeader struct {
+	Version  int      `json:"version"`
+	Resource string   `json:"resource"`
+	Indexes  []string `json:"indexes"`
+	// Stores names the stores kept alongside the entities, such as the
+	// TombstoneStore, whose buckets follow the index buckets.
+	Stores []string `json:"stores,omitempty"`
+}
+
+// snapshotRecord is a line of a snapshot following its header. Bucket is 0 for
+// the entity bucket, the position of the index in the header's indexes plus one
+// for an index bucket, and the position of the store in the header's stores
+// following the indexes otherwise. The last line of a snapshot has End set along with the number
+// of records written, so a truncated snapshot is not mistaken for a complete one.
+type snapshotRecord struct {
+	Bucket  int    `json:"b"`
+	Key     []byte `json:"k,omitempty"`
+	Value   []byte `json:"v,omitempty"`
+	End     bool   `json:"end,omitempty"`
+	Records int    `json:"records,omitempty"`
+}
+
+// Snapshot writes every record of the entity bucket, followed by the records of
+// each index bucket and of the TombstoneStore, VersionStore and the ExpiryStore
+// of the entity store when set, to the writer as a stream RestoreSnapshot can
+// load, so soft deleted entities, versions and expiries survive a round trip. The
+// records are read in the tx provided, so the snapshot is consistent as of the
+// tx when taken in a View. The values are written as stored, so a snapshot of a
+// store with the integrity check enabled keeps its checksums.
+func (s *IndexStore) Snapshot(ctx context.Context, tx Tx, w io.Writer) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	header := snapshotHeader{Version: snapshotVersion, Resource: s.Resource}
+	for _, idx := range s.indexes() {
+		header.Indexes = append(header.Indexes, idx.Name)
+	}
+	for _, store := range s.snapshotAuxStores() {
+		header.Stores = append(header.Stores, store.Name)
+	}
+	if err := enc.Encode(header); err != nil {
+		return s.snapshotWriteErr(err)
+	}
+
+	var records int
+	for i, store := range s.snapshotStores() {
+		cur, err := store.bucketCursor(ctx, tx)
+		if err != nil {
+			return err
+		}
+		for k, v := cur.First(); k != nil; k, v = cur.Next() {
+			records++
+			if err := checkScanCtx(ctx, records); err != nil {
+				return err
+			}
+			if err := enc.Encode(snapshotRecord{Bucket: i, Key: k, Value: v}); err != nil {
+				return s.snapshotWriteErr(err)
+			}
+		}
+	}
+
+	if err := enc.Encode(snapshotRecord{End: true, Records: records}); err != nil {
+		return s.snapshotWriteErr(err)
+	}
+	return s.snapshotWriteErr(bw.Flush())
+}
+
+// RestoreSnapshot loads a stream written by Snapshot into the buckets of the stores
+// it was taken of, which must be empty. Each value is decoded by the store of its bucket
+// as it is read, and once loaded the buckets are verified as Verify does; a value
+// that does not decode, a truncated stream or an index that disagrees with the
+// entities fails the restore with an EInvalid error. The records are written to
+// the tx as they are read, so the tx must be rolled back when the restore fails.
+// It is named apart from Restore, which restores soft deleted entities.
+func (s *IndexStore) RestoreSnapshot(ctx context.Context, tx Tx, r io.Reader) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	if err := s.allowWrite(); err != nil {
+		return err
+	}
+
+	stores := s.snapshotStores()
+	for _, store := range stores {
+		if err := assertBucketEmpty(ctx, tx, store); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return s.snapshotInvalidErr("failed to read header", err)
+	}
+	if err := s.validSnapshotHeader(header); err != nil {
+		return err
+	}
+
+	var records int
+	for {
+		var record snapshotRecord
+		if err := dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return s.snapshotInvalidErr(fmt.Sprintf("failed to read record %d", records+1), err)
+		}
+		if record.End {
+			if record.Records != records {
+				return s.snapshotInvalidErr(fmt.Sprintf("snapshot ends after %d records, expected %d", records, record.Records), nil)
+			}
+			break
+		}
+
+		records++
+		if err := checkScanCtx(ctx, records); err != nil {
+			return err
+		}
+		if record.Bucket < 0 || record.Bucket >= len(stores) {
+			return s.snapshotInvalidErr(fmt.Sprintf("record %d is for unknown bucket %d", records, record.Bucket), nil)
+		}
+
+		store := stores[record.Bucket]
+		if _, _, err := store.decodeVal(record.Key, record.Value); err != nil {
+			return s.snapshotInvalidErr(fmt.Sprintf("record %d of bucket %q does not decode", records, string(store.BktName)), err)
+		}
+		if err := store.bucketPut(ctx, tx, record.Key, record.Value); err != nil {
+			return err
+		}
+	}
+	if s.MissCache != nil {
+		s.MissCache.invalidateOnCommit(tx, nil, nil)
+	}
+
+	inconsistencies, err := s.Verify(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if len(inconsistencies) > 0 {
+		inc := inconsistencies[0]
+		return s.snapshotInvalidErr(fmt.Sprintf("%d inconsistencies between its entities and indexes; first is %s %q for index %q", len(inconsistencies), inc.Kind, string(inc.Key), inc.Index), nil)
+	}
+	return nil
+}
+
+// snapshotStores returns the entity store followed by the store of each index
+// and the stores kept alongside the entities, in the order their records are
+// written to a snapshot.
+func (s *IndexStore) snapshotStores() []*StoreBase {
+	stores := []*StoreBase{s.EntStore}
+	for _, idx := range s.indexes() {
+		stores = append(stores, idx.Store)
+	}
+	for _, store := range s.snapshotAuxStores() {
+		stores = append(stores, store.Store)
+	}
+	return stores
+}
+
+// snapshotAuxStores returns the stores set alongside the entities whose records are
+// written to a snapshot after those of the indexes.
+func (s *IndexStore) snapshotAuxStores() []NamedIndex {
+	var stores []NamedIndex
+	if s.TombstoneStore != nil {
+		stores = append(stores, NamedIndex{Name: "tombstone", Store: s.TombstoneStore})
+	}
+	if s.VersionStore != nil {
+		stores = append(stores, NamedIndex{Name: "version", Store: s.VersionStore})
+	}
+	if s.EntStore.ExpiryStore != nil {
+		stores = append(stores, NamedIndex{Name: "expiry", Store: s.EntStore.ExpiryStore})
+	}
+	return stores
+}
+
+func (s *IndexStore) validSnapshotHeader(header snapshotHeader) error {
+	if header.Version != snapshotVersion {
+		return s.snapshotInvalidErr(fmt.Sprintf("unsupported snapshot version %d", header.Version), nil)
+	}
+	if header.Resource != s.Resource {
+		return s.snapshotInvalidErr(fmt.Sprintf("snapshot is of resource %q", header.Resource), nil)
+	}
+
+	indexes := s.indexes()
+	if len(header.Indexes) != len(indexes) {
+		return s.snapshotInvalidErr(fmt.Sprintf("snapshot has %d indexes, expected %d", len(header.Indexes), len(indexes)), nil)
+	}
+	for i, idx := range indexes {
+		if header.Indexes[i] != idx.Name {
+			return s.snapshotInvalidErr(fmt.Sprintf("snapshot has index %q where %q is expected", header.Indexes[i], idx.Name), nil)
+		}
+	}
+
+	stores := s.snapshotAuxStores()
+	if len(header.Stores) != len(stores) {
+		return s.snapshotInvalidErr(fmt.Sprintf("snapshot has %d stores, expected %d", len(header.Stores), len(stores)), nil)
+	}
+	for i, store := range stores {
+		if header.Stores[i] != store.Name {
+			return s.snapshotInvalidErr(fmt.Sprintf("snapshot has store %q where %q is expected", header.Stores[i], store.Name), nil)
+		}
+	}
+	return nil
+}
+
+// assertBucketEmpty returns an EConflict error when the bucket of the store has
+// any records.
+func assertBucketEmpty(ctx context.Context, tx Tx, store *StoreBase) error {
+	cur, err := store.bucketCursor(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if k, _ := cur.First(); k != nil {
+		return &influxdb.Error{
+			Code: influxdb.EConflict,
+			Msg:  fmt.Sprintf("snapshot can only be restored into empty buckets; bucket %q is not empty", string(store.BktName)),
+		}
+	}
+	return nil
+}
+
+func (s *IndexStore) snapshotInvalidErr(msg string, err error) error {
+	return &influxdb.Error{
+		Code: influxdb.EInvalid,
+		Msg:  fmt.Sprintf("invalid %s snapshot: %s", s.Resource, msg),
+		Err:  err,
+	}
+}
+
+func (s *IndexStore) snapshotWriteErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &influxdb.Error{
+		Code: influxdb.EInternal,
+		Msg:  fmt.Sprintf("failed to write %s snapshot", s.Resource),
+		Err:  err,
+	}
+}
@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// errStaleFound stops the scan of the entity store once the entity is found.
+var errStaleFound = errors.New("stale entity found")
+
+// FindEntAllowStale falls back to a scan of the entity store when the entity is
+// not found via its index, returning the first entity whose index key matches
+// the key of the entity provided. This keeps finds by index available while the
+// index is being repaired, i.e. via RebuildIndex, at the cost of reading and
+// decoding every entity of the store on a miss. The scan is O(n) in the number of
+// entities, so it should only be enabled for the duration of a repair. This only
+// applies to an IndexStore, and has no effect on a find by PK.
+func FindEntAllowStale() FindEntOptionFn {
+	return func(o *findEntOption) {
+		o.allowStale = true
+	}
+}
+
+// findStale scans the entity store for the entity whose key for the index matches
+// the key of the entity provided. Soft deleted and expired entities are skipped,
+// as they are by a find via the index. The notFoundErr is returned when no
+// entity matches.
+func (s *IndexStore) findStale(ctx context.Context, tx Tx, idx *StoreBase, ent Entity, notFoundErr error) (interface{}, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	span.SetTag("Resource", s.Resource)
+
+	idxKey, err := idx.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+
+	var found interface{}
+	err = s.EntStore.Find(ctx, tx, FindOpts{
+		CaptureFn: func(k []byte, v interface{}) error {
+			candidate, err := s.EntStore.convertValToEnt(k, v)
+			if err != nil {
+				return err
+			}
+			key, err := idx.EntKey(ctx, candidate)
+			if err != nil || !bytes.Equal(key, idxKey) {
+				// entities without a key for the index are not indexed by it
+				return nil
+			}
+
+			if err := s.assertNotDeleted(ctx, tx, k); influxdb.ErrorCode(err) == influxdb.ENotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			v, err = s.EntStore.FindEnt(ctx, tx, Entity{PK: EncBytes(k)})
+			if influxdb.ErrorCode(err) == influxdb.ENotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			found = v
+			return errStaleFound
+		},
+	})
+	if err != nil && err != errStaleFound {
+		return nil, err
+	}
+	if found == nil {
+		return nil, notFoundErr
+	}
+	span.SetTag("StaleHit", true)
+	return found, nil
+}
@@ -0,0 +1,126 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// StoreStats describes the size of an IndexStore's buckets.
+type StoreStats struct {
+	Resource string `json:"resource"`
+	// Entities is the number of entities in the entity store, including soft
+	// deleted entities.
+	Entities int `json:"entities"`
+	// Deleted is the number of soft deleted entities.
+	Deleted int `json:"deleted"`
+	// Bytes approximates the bytes of the entity store and its indexes.
+	Bytes   int          `json:"bytes"`
+	Indexes []IndexStats `json:"indexes"`
+	// CountMismatch is set when an index does not hold one entry for every entity
+	// that is not soft deleted, which signals the index is inconsistent. See Verify
+	// to find the inconsistent entries.
+	CountMismatch bool `json:"countMismatch"`
+}
+
+// IndexStats describes the size of an index.
+type IndexStats struct {
+	Name    string `json:"name"`
+	Entries int    `json:"entries"`
+	Bytes   int    `json:"bytes"`
+}
+
+// Stats returns the number of keys in the store's bucket and its approximate size,
+// summed over the shards of a sharded store. The stats of a StatsBucket are
+// returned as is, otherwise the keys are scanned.
+func (s *StoreBase) Stats(ctx context.Context, tx Tx) (BucketStats, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	bkts, err := s.shardBuckets(ctx, tx)
+	if err != nil {
+		return BucketStats{}, err
+	}
+
+	var stats BucketStats
+	for _, b := range bkts {
+		bs, err := bucketStats(ctx, b)
+		if err != nil {
+			return BucketStats{}, err
+		}
+		stats.KeyN += bs.KeyN
+		stats.Bytes += bs.Bytes
+	}
+	return stats, nil
+}
+
+func bucketStats(ctx context.Context, b Bucket) (BucketStats, error) {
+	if sb, ok := b.(StatsBucket); ok {
+		return sb.Stats(), nil
+	}
+
+	cur, err := b.Cursor()
+	if err != nil {
+		return BucketStats{}, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  "failed to retrieve cursor",
+			Err:  err,
+		}
+	}
+
+	var stats BucketStats
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		stats.KeyN++
+		stats.Bytes += len(k) + len(v)
+		if err := checkScanCtx(ctx, stats.KeyN); err != nil {
+			return BucketStats{}, err
+		}
+	}
+	return stats, nil
+}
+
+// Stats returns the number of entities and index entries of the store, along with
+// their approximate size. The counts are compared as a cheap consistency check of
+// the indexes, which is reported via the CountMismatch of the stats.
+func (s *IndexStore) Stats(ctx context.Context, tx Tx) (StoreStats, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	entStats, err := s.EntStore.Stats(ctx, tx)
+	if err != nil {
+		return StoreStats{}, err
+	}
+	stats := StoreStats{
+		Resource: s.Resource,
+		Entities: entStats.KeyN,
+		Bytes:    entStats.Bytes,
+	}
+
+	if s.TombstoneStore != nil {
+		deletedStats, err := s.TombstoneStore.Stats(ctx, tx)
+		if err != nil {
+			return StoreStats{}, err
+		}
+		stats.Deleted = deletedStats.KeyN
+	}
+
+	for _, idx := range s.indexes() {
+		idxStats, err := idx.Store.Stats(ctx, tx)
+		if err != nil {
+			return StoreStats{}, err
+		}
+		stats.Indexes = append(stats.Indexes, IndexStats{
+			Name:    idx.Name,
+			Entries: idxStats.KeyN,
+			Bytes:   idxStats.Bytes,
+		})
+		stats.Bytes += idxStats.Bytes
+		if idxStats.KeyN != stats.Entities-stats.Deleted {
+			stats.CountMismatch = true
+		}
+	}
+	return stats, nil
+}
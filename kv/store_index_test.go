@@ -1,25 +1,63 @@
 package kv_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/prom/promtest"
 	"github.com/influxdata/influxdb/v2/kv"
 	"github.com/influxdata/influxdb/v2/kv/migration"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/time/rate"
 )
 
+// countingUpdateStore counts the updates run against the underlying store, failing
+// the update at failAt when set.
+type countingUpdateStore struct {
+	kv.Store
+
+	failAt  int
+	updates int
+}
+
+func (s *countingUpdateStore) Update(ctx context.Context, fn func(kv.Tx) error) error {
+	s.updates++
+	if s.updates == s.failAt {
+		return s.Store.Update(ctx, func(tx kv.Tx) error {
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return errors.New("interrupted")
+		})
+	}
+	return s.Store.Update(ctx, fn)
+}
+
 func TestIndexStore(t *testing.T) {
 	newStoreBase := func(resource string, bktName []byte, encKeyFn, encBodyFn kv.EncodeEntFn, decFn kv.DecodeBucketValFn, decToEntFn kv.ConvertValToEntFn) *kv.StoreBase {
 		return kv.NewStoreBase(resource, bktName, encKeyFn, encBodyFn, decFn, decToEntFn)
 	}
 
-	newFooIndexStore := func(t *testing.T, bktSuffix string) (*kv.IndexStore, func(), kv.Store) {
+	newFooIndexStoreOn := func(t *testing.T, newKVStore func(*testing.T) (kv.SchemaStore, func(), error), bktSuffix string) (*kv.IndexStore, func(), kv.Store) {
 		t.Helper()
 
-		kvStoreStore, done, err := NewTestBoltStore(t)
+		kvStoreStore, done, err := newKVStore(t)
 		require.NoError(t, err)
 
 		const resource = "foo"
@@ -41,6 +79,18 @@ func TestIndexStore(t *testing.T) {
 		return indexStore, done, kvStoreStore
 	}
 
+	newFooIndexStore := func(t *testing.T, bktSuffix string) (*kv.IndexStore, func(), kv.Store) {
+		t.Helper()
+		return newFooIndexStoreOn(t, NewTestBoltStore, bktSuffix)
+	}
+
+	// newInmemFooIndexStore is newFooIndexStore over the in memory store, whose
+	// cursors are not bolt cursors.
+	newInmemFooIndexStore := func(t *testing.T, bktSuffix string) (*kv.IndexStore, func(), kv.Store) {
+		t.Helper()
+		return newFooIndexStoreOn(t, NewTestInmemStore, bktSuffix)
+	}
+
 	t.Run("Put", func(t *testing.T) {
 		t.Run("basic", func(t *testing.T) {
 			indexStore, done, kvStore := newFooIndexStore(t, "put")
@@ -141,6 +191,8 @@ func TestIndexStore(t *testing.T) {
 				})
 				require.Error(t, err)
 				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+				assert.True(t, kv.IsErrKeyConflict(err))
+				assert.False(t, kv.IsErrEntNotFound(err))
 			})
 
 			t.Run("updating entity that does not exist", func(t *testing.T) {
@@ -161,6 +213,7 @@ func TestIndexStore(t *testing.T) {
 				})
 				require.Error(t, err)
 				assert.Equal(t, influxdb.ENotFound, influxdb.ErrorCode(err), "got: "+err.Error())
+				assert.True(t, kv.IsErrEntNotFound(err))
 			})
 
 			t.Run("updating entity that does collides with an existing entity", func(t *testing.T) {
@@ -182,120 +235,4282 @@ func TestIndexStore(t *testing.T) {
 				require.Error(t, err)
 				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
 				assert.Contains(t, err.Error(), "update conflicts")
+				assert.True(t, kv.IsErrKeyConflict(err))
+			})
+
+			t.Run("finding entity that does not exist", func(t *testing.T) {
+				indexStore, done, kvStore := newFooIndexStore(t, "put")
+				defer done()
+
+				err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+					_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(333)})
+					return err
+				})
+				require.Error(t, err)
+				assert.Equal(t, influxdb.ENotFound, influxdb.ErrorCode(err))
+				assert.True(t, kv.IsErrEntNotFound(err))
+				assert.True(t, errors.Is(err, kv.ErrEntNotFound("foo", encodeID(t, 333))))
+				assert.False(t, errors.Is(err, kv.ErrEntNotFound("foo", encodeID(t, 334))))
+			})
+
+			t.Run("new entity conflicts on an org name index", func(t *testing.T) {
+				indexStore, done, kvStore := newFooIndexStore(t, "put")
+				defer done()
+
+				seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+
+				ent := newFooEnt(2, 9000, "foo_1")
+				err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+					return indexStore.Put(context.TODO(), tx, ent, kv.PutNew())
+				})
+				require.Error(t, err)
+
+				idxKey, keyErr := indexStore.IndexStore.EntKey(context.TODO(), ent)
+				require.NoError(t, keyErr)
+				assert.True(t, errors.Is(err, indexStore.IndexStore.ErrKeyConflict(idxKey)))
+
+				otherKey, keyErr := indexStore.IndexStore.EntKey(context.TODO(), newFooEnt(2, 9000, "foo_2"))
+				require.NoError(t, keyErr)
+				assert.False(t, errors.Is(err, indexStore.IndexStore.ErrKeyConflict(otherKey)))
 			})
 		})
 	})
 
-	t.Run("DeleteEnt", func(t *testing.T) {
-		indexStore, done, kvStore := newFooIndexStore(t, "delete_ent")
+	t.Run("readable keys in validation errors", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "key_string")
 		defer done()
 
-		expected := testDeleteEntBase(t, kvStore, indexStore)
+		seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
 
-		err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
-			_, err := indexStore.IndexStore.FindEnt(context.TODO(), tx, kv.Entity{
-				UniqueKey: expected.UniqueKey,
+		t.Run("index conflict on create", func(t *testing.T) {
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(3, 9000, "foo_1"), kv.PutNew())
 			})
-			return err
+			require.Error(t, err)
+			assert.True(t, kv.IsErrKeyConflict(err))
+			assert.Equal(t, "foo is not unique for key org=0000000000002328/name=foo_1", influxdb.ErrorMessage(err))
+		})
+
+		t.Run("index conflict on update", func(t *testing.T) {
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_2"), kv.PutUpdate())
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+			assert.Contains(t, influxdb.ErrorMessage(err), "org=0000000000002328/name=foo_2")
+		})
+
+		t.Run("binary keys are hex dumped", func(t *testing.T) {
+			assert.Equal(t, "0x00ff01", indexStore.EntStore.KeyString([]byte{0x00, 0xff, 0x01}))
+			assert.Equal(t, "0000000000000001", indexStore.EntStore.KeyString(encodeID(t, 1)))
+		})
+
+		t.Run("custom key string", func(t *testing.T) {
+			indexStore.EntStore.KeyStringFn = func(key []byte) string { return "id=" + string(key) }
+			defer func() { indexStore.EntStore.KeyStringFn = nil }()
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_new"), kv.PutNew())
+			})
+			require.Error(t, err)
+			assert.Equal(t, "foo is not unique for key id=0000000000000002", influxdb.ErrorMessage(err))
 		})
-		isNotFoundErr(t, err)
 	})
 
-	t.Run("Delete", func(t *testing.T) {
-		fn := func(t *testing.T, suffix string) (storeBase, func(), kv.Store) {
-			return newFooIndexStore(t, suffix)
+	t.Run("Put upsert", func(t *testing.T) {
+		findByName := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore, orgID influxdb.ID, name string) (interface{}, error) {
+			t.Helper()
+
+			var actual interface{}
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				f, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{
+					UniqueKey: kv.Encode(kv.EncID(orgID), kv.EncString(name)),
+				})
+				actual = f
+				return err
+			})
+			return actual, err
 		}
 
-		testDeleteBase(t, fn, func(t *testing.T, kvStore kv.Store, base storeBase, foosLeft []foo) {
-			var expectedIndexIDs []interface{}
-			for _, ent := range foosLeft {
-				expectedIndexIDs = append(expectedIndexIDs, ent.ID)
-			}
+		t.Run("creates missing entity", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_upsert")
+			defer done()
 
-			indexStore, ok := base.(*kv.IndexStore)
-			require.True(t, ok)
+			expected := newFooEnt(1, 9000, "foo_1")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, expected, kv.PutUpsert())
+			})
 
-			// next to verify they are not within the index store
-			var actualIDs []interface{}
-			view(t, kvStore, func(tx kv.Tx) error {
-				return indexStore.IndexStore.Find(context.TODO(), tx, kv.FindOpts{
-					CaptureFn: func(key []byte, decodedVal interface{}) error {
-						actualIDs = append(actualIDs, decodedVal)
-						return nil
-					},
-				})
+			actual, err := findByName(t, kvStore, indexStore, 9000, "foo_1")
+			require.NoError(t, err)
+			assert.Equal(t, expected.Body, actual)
+		})
+
+		t.Run("updates existing entity and rewrites the index", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_upsert")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+
+			expected := newFooEnt(1, 9000, "foo_renamed")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, expected, kv.PutUpsert())
 			})
 
-			assert.Equal(t, expectedIndexIDs, actualIDs)
+			actual, err := findByName(t, kvStore, indexStore, 9000, "foo_renamed")
+			require.NoError(t, err)
+			assert.Equal(t, expected.Body, actual)
+
+			_, err = findByName(t, kvStore, indexStore, 9000, "foo_1")
+			isNotFoundErr(t, err)
+		})
+
+		t.Run("rename colliding with a different entity conflicts", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_upsert")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_2"), kv.PutUpsert())
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+
+			actual, err := findByName(t, kvStore, indexStore, 9000, "foo_1")
+			require.NoError(t, err)
+			assert.Equal(t, newFooEnt(1, 9000, "foo_1").Body, actual)
+		})
+
+		t.Run("new entity colliding with an existing unique key conflicts", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_upsert")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_1"), kv.PutUpsert())
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
 		})
 	})
 
-	t.Run("FindEnt", func(t *testing.T) {
-		t.Run("by ID", func(t *testing.T) {
-			base, done, kvStoreStore := newFooIndexStore(t, "find_ent")
+	t.Run("Put replace index", func(t *testing.T) {
+		t.Run("rename frees the old key", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_replace_index")
 			defer done()
-			testFindEnt(t, kvStoreStore, base)
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+
+			expected := newFooEnt(1, 9000, "foo_renamed")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, expected, kv.PutReplaceIndex())
+			})
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: expected.UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, actual)
+
+				_, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(1, 9000, "foo_1").UniqueKey})
+				isNotFoundErr(t, err)
+				return nil
+			})
 		})
 
-		t.Run("find by name", func(t *testing.T) {
-			base, done, kvStore := newFooIndexStore(t, "find_ent")
+		t.Run("rename to an existing key leaves the stores untouched", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_replace_index")
 			defer done()
 
-			expected := newFooEnt(1, 9000, "foo_1")
-			seedEnts(t, kvStore, base, expected)
+			entA, entB := newFooEnt(1, 9000, "foo_a"), newFooEnt(2, 9000, "foo_b")
+			seedEnts(t, kvStore, indexStore, entA, entB)
+
+			// the assertions share the tx of the failed put, so nothing is
+			// undone by a rollback
+			update(t, kvStore, func(tx kv.Tx) error {
+				err := indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_b"), kv.PutReplaceIndex())
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+
+				pkB, _ := entB.PK()
+				newKey, _ := entB.UniqueKey()
+				assert.Contains(t, influxdb.ErrorMessage(err), string(pkB))
+				assert.Contains(t, influxdb.ErrorMessage(err), indexStore.IndexStore.KeyString(newKey))
+
+				for _, ent := range []kv.Entity{entA, entB} {
+					actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+					require.NoError(t, err)
+					assert.Equal(t, ent.Body, actual)
+				}
+				return nil
+			})
+		})
+
+		t.Run("with update requires an existing entity", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_replace_index")
+			defer done()
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1"), kv.PutReplaceIndex(), kv.PutUpdate())
+			})
+			isNotFoundErr(t, err)
+		})
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		t.Run("moves the entity to the new key", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "rename")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+
+			renamed := newFooEnt(1, 9000, "foo_renamed")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Rename(context.TODO(), tx, kv.EncID(1), kv.Entity{UniqueKey: renamed.UniqueKey, Body: renamed.Body})
+			})
 
-			var actual interface{}
 			view(t, kvStore, func(tx kv.Tx) error {
-				f, err := base.FindEnt(context.TODO(), tx, kv.Entity{
-					UniqueKey: expected.UniqueKey,
-				})
-				actual = f
-				return err
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: renamed.UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, renamed.Body, actual)
+
+				actual, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				require.NoError(t, err)
+				assert.Equal(t, renamed.Body, actual)
+
+				_, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(1, 9000, "foo_1").UniqueKey})
+				isNotFoundErr(t, err)
+				return nil
 			})
+		})
 
-			assert.Equal(t, expected.Body, actual)
+		t.Run("taken key is a conflict", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "rename")
+			defer done()
+
+			entA, entB := newFooEnt(1, 9000, "foo_a"), newFooEnt(2, 9000, "foo_b")
+			seedEnts(t, kvStore, indexStore, entA, entB)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				taken := newFooEnt(1, 9000, "foo_b")
+				err := indexStore.Rename(context.TODO(), tx, kv.EncID(1), kv.Entity{UniqueKey: taken.UniqueKey, Body: taken.Body})
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+
+				for _, ent := range []kv.Entity{entA, entB} {
+					actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+					require.NoError(t, err)
+					assert.Equal(t, ent.Body, actual)
+				}
+				return nil
+			})
+		})
+
+		t.Run("missing entity is not found", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "rename")
+			defer done()
+
+			renamed := newFooEnt(1, 9000, "foo_renamed")
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Rename(context.TODO(), tx, kv.EncID(1), kv.Entity{UniqueKey: renamed.UniqueKey, Body: renamed.Body})
+			})
+			isNotFoundErr(t, err)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: renamed.UniqueKey})
+				isNotFoundErr(t, err)
+				return nil
+			})
 		})
 	})
 
-	t.Run("Find", func(t *testing.T) {
-		t.Run("base", func(t *testing.T) {
-			fn := func(t *testing.T, suffix string) (storeBase, func(), kv.Store) {
-				return newFooIndexStore(t, suffix)
-			}
+	t.Run("Put dry run", func(t *testing.T) {
+		bucketKeys := func(t *testing.T, kvStore kv.Store, bktName []byte) [][]byte {
+			t.Helper()
 
-			testFind(t, fn)
+			var keys [][]byte
+			view(t, kvStore, func(tx kv.Tx) error {
+				b, err := tx.Bucket(bktName)
+				if err != nil {
+					return err
+				}
+				cur, err := b.ForwardCursor(nil)
+				if err != nil {
+					return err
+				}
+				defer cur.Close()
+				for k, _ := cur.Next(); k != nil; k, _ = cur.Next() {
+					keys = append(keys, append([]byte(nil), k...))
+				}
+				return cur.Err()
+			})
+			return keys
+		}
+
+		t.Run("new entity is not written", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_dry_run")
+			defer done()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1"), kv.PutNew(), kv.PutDryRun())
+			})
+
+			assert.Empty(t, bucketKeys(t, kvStore, indexStore.EntStore.BktName))
+			assert.Empty(t, bucketKeys(t, kvStore, indexStore.IndexStore.BktName))
 		})
 
-		t.Run("with entity filter", func(t *testing.T) {
-			base, done, kvStore := newFooIndexStore(t, "find_index_search")
+		t.Run("returns the same conflict as a put", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_dry_run")
 			defer done()
 
-			expectedEnts := []kv.Entity{
-				newFooEnt(1, 9000, "foo_0"),
-				newFooEnt(2, 9001, "foo_1"),
-				newFooEnt(3, 9003, "foo_2"),
-			}
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
 
-			seedEnts(t, kvStore, base, expectedEnts...)
+			dup := newFooEnt(2, 9000, "foo_1")
+			dryRunErr := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, dup, kv.PutNew(), kv.PutDryRun())
+			})
+			putErr := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, dup, kv.PutNew())
+			})
+			require.Error(t, dryRunErr)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(dryRunErr))
+			assert.Equal(t, putErr, dryRunErr)
+		})
 
-			var actuals []interface{}
+		t.Run("returns the same max value size error as a put", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_dry_run")
+			defer done()
+			indexStore.EntStore.WithMaxValueSize(5)
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			dryRunErr := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, ent, kv.PutNew(), kv.PutDryRun())
+			})
+			putErr := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, ent, kv.PutNew())
+			})
+			require.Error(t, dryRunErr)
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(dryRunErr))
+			assert.Equal(t, putErr, dryRunErr)
+			assert.Empty(t, bucketKeys(t, kvStore, indexStore.IndexStore.BktName))
+		})
+
+		t.Run("update leaves the existing index entries", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_dry_run")
+			defer done()
+
+			existing := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, existing)
+			entKeys := bucketKeys(t, kvStore, indexStore.EntStore.BktName)
+			idxKeys := bucketKeys(t, kvStore, indexStore.IndexStore.BktName)
+
+			for _, opt := range []kv.PutOptionFn{kv.PutUpdate(), kv.PutUpsert(), kv.PutReplaceIndex()} {
+				update(t, kvStore, func(tx kv.Tx) error {
+					return indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_renamed"), opt, kv.PutDryRun())
+				})
+			}
+
+			assert.Equal(t, entKeys, bucketKeys(t, kvStore, indexStore.EntStore.BktName))
+			assert.Equal(t, idxKeys, bucketKeys(t, kvStore, indexStore.IndexStore.BktName))
 			view(t, kvStore, func(tx kv.Tx) error {
-				return base.Find(context.TODO(), tx, kv.FindOpts{
-					FilterEntFn: func(key []byte, decodedVal interface{}) bool {
-						return decodedVal.(foo).ID < 3
-					},
-					CaptureFn: func(key []byte, decodedVal interface{}) error {
-						actuals = append(actuals, decodedVal)
-						return nil
-					},
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: existing.UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, existing.Body, actual)
+				return nil
+			})
+		})
+	})
+
+	t.Run("FindOrCreate", func(t *testing.T) {
+		findOrCreate := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity, create func(tx kv.Tx) (kv.Entity, error)) (interface{}, bool) {
+			t.Helper()
+
+			var (
+				v       interface{}
+				created bool
+			)
+			update(t, kvStore, func(tx kv.Tx) error {
+				var err error
+				v, created, err = indexStore.FindOrCreate(context.TODO(), tx, ent, func() (kv.Entity, error) {
+					return create(tx)
 				})
+				return err
 			})
+			return v, created
+		}
 
-			expected := []interface{}{
-				expectedEnts[0].Body,
-				expectedEnts[1].Body,
-			}
-			assert.Equal(t, expected, actuals)
+		byName := kv.Entity{UniqueKey: newFooEnt(0, 9000, "foo_1").UniqueKey}
+
+		t.Run("creates a missing entity", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "find_or_create")
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			v, created := findOrCreate(t, kvStore, indexStore, byName, func(kv.Tx) (kv.Entity, error) {
+				return expected, nil
+			})
+			assert.True(t, created)
+			assert.Equal(t, expected.Body, v)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				v, err := indexStore.FindEnt(context.TODO(), tx, byName)
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, v)
+				return nil
+			})
+		})
+
+		t.Run("finds an existing entity", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "find_or_create")
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, expected)
+
+			v, created := findOrCreate(t, kvStore, indexStore, byName, func(kv.Tx) (kv.Entity, error) {
+				t.Fatal("create should not be called")
+				return kv.Entity{}, nil
+			})
+			assert.False(t, created)
+			assert.Equal(t, expected.Body, v)
 		})
+
+		t.Run("returns the entity that won a conflicting create", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "find_or_create")
+			defer done()
+
+			winner := newFooEnt(1, 9000, "foo_1")
+			v, created := findOrCreate(t, kvStore, indexStore, byName, func(tx kv.Tx) (kv.Entity, error) {
+				// another create of the same name lands between the find and the put
+				if err := indexStore.Put(context.TODO(), tx, winner, kv.PutNew()); err != nil {
+					return kv.Entity{}, err
+				}
+				return newFooEnt(2, 9000, "foo_1"), nil
+			})
+			assert.False(t, created)
+			assert.Equal(t, winner.Body, v)
+		})
+
+		t.Run("create error is returned", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "find_or_create")
+			defer done()
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, _, err := indexStore.FindOrCreate(context.TODO(), tx, byName, func() (kv.Entity, error) {
+					return kv.Entity{}, errors.New("create failed")
+				})
+				return err
+			})
+			require.EqualError(t, err, "create failed")
+		})
+	})
+
+	t.Run("case insensitive index", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "case_insensitive")
+		defer done()
+
+		indexStore.IndexStore = kv.NewOrgNameKeyStore("foo", indexStore.IndexStore.BktName, true)
+		indexStore.IndexStore.KeyNormalizeFn = bytes.ToLower
+
+		expected := newFooEnt(1, 9000, "MyBucket")
+		update(t, kvStore, func(tx kv.Tx) error {
+			return indexStore.Put(context.TODO(), tx, expected, kv.PutNew())
+		})
+
+		err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+			return indexStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "mybucket"), kv.PutNew())
+		})
+		require.Error(t, err)
+		assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+
+		var actual interface{}
+		view(t, kvStore, func(tx kv.Tx) error {
+			f, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{
+				UniqueKey: kv.Encode(kv.EncID(9000), kv.EncString("MYBUCKET")),
+			})
+			actual = f
+			return err
+		})
+		assert.Equal(t, expected.Body, actual)
+	})
+
+	t.Run("PutMany", func(t *testing.T) {
+		t.Run("new entities", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_many")
+			defer done()
+
+			expected := []kv.Entity{
+				newFooEnt(1, 9000, "foo_0"),
+				newFooEnt(2, 9000, "foo_1"),
+				newFooEnt(3, 9003, "foo_2"),
+			}
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.PutMany(context.TODO(), tx, expected, kv.PutNew())
+			})
+
+			var actuals []interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				for _, ent := range expected {
+					f, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+					if err != nil {
+						return err
+					}
+					actuals = append(actuals, f)
+				}
+				return nil
+			})
+			assert.Equal(t, toIfaces(expected...), actuals)
+		})
+
+		t.Run("error cases", func(t *testing.T) {
+			t.Run("entities within batch share a unique key", func(t *testing.T) {
+				indexStore, done, kvStore := newFooIndexStore(t, "put_many")
+				defer done()
+
+				err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+					return indexStore.PutMany(context.TODO(), tx, []kv.Entity{
+						newFooEnt(1, 9000, "foo_0"),
+						newFooEnt(2, 9000, "foo_1"),
+						newFooEnt(3, 9000, "foo_0"),
+					}, kv.PutNew())
+				})
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+				assert.Contains(t, err.Error(), "index 2 conflicts with entity at index 0")
+
+				err = kvStore.View(context.TODO(), func(tx kv.Tx) error {
+					_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+					return err
+				})
+				isNotFoundErr(t, err)
+			})
+
+			t.Run("entity conflicts with existing", func(t *testing.T) {
+				indexStore, done, kvStore := newFooIndexStore(t, "put_many")
+				defer done()
+
+				expected := testPutBase(t, kvStore, indexStore, indexStore.EntStore.BktName)
+
+				err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+					return indexStore.PutMany(context.TODO(), tx, []kv.Entity{
+						newFooEnt(2, expected.OrgID, "foo_2"),
+						newFooEnt(3, expected.OrgID, expected.Name),
+					}, kv.PutNew())
+				})
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+				assert.Contains(t, err.Error(), "index 1 failed validation")
+
+				err = kvStore.View(context.TODO(), func(tx kv.Tx) error {
+					_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+					return err
+				})
+				isNotFoundErr(t, err)
+			})
+
+			t.Run("failed batch leaves the indexes of a tx in use", func(t *testing.T) {
+				indexStore, done, kvStore := newFooIndexStore(t, "put_many")
+				defer done()
+
+				existing := newFooEnt(1, 9000, "foo_0")
+				seedEnts(t, kvStore, indexStore, existing)
+
+				update(t, kvStore, func(tx kv.Tx) error {
+					err := indexStore.PutMany(context.TODO(), tx, []kv.Entity{
+						newFooEnt(1, 9000, "foo_1"),
+						newFooEnt(2, 9000, "foo_2"),
+					}, kv.PutUpdate())
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), "index 1 failed validation")
+
+					// the tx is kept and committed after the failed batch
+					_, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: existing.UniqueKey})
+					return err
+				})
+
+				var actual interface{}
+				view(t, kvStore, func(tx kv.Tx) error {
+					f, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: existing.UniqueKey})
+					actual = f
+					return err
+				})
+				assert.Equal(t, existing.Body, actual)
+			})
+		})
+	})
+
+	t.Run("DeleteEnt", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "delete_ent")
+		defer done()
+
+		expected := testDeleteEntBase(t, kvStore, indexStore)
+
+		err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+			_, err := indexStore.IndexStore.FindEnt(context.TODO(), tx, kv.Entity{
+				UniqueKey: expected.UniqueKey,
+			})
+			return err
+		})
+		isNotFoundErr(t, err)
+	})
+
+	t.Run("DeleteEntReturning", func(t *testing.T) {
+		t.Run("returns the deleted entity", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_ent_returning")
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, expected)
+
+			var actual interface{}
+			update(t, kvStore, func(tx kv.Tx) error {
+				deleted, err := indexStore.DeleteEntReturning(context.TODO(), tx, kv.Entity{UniqueKey: expected.UniqueKey})
+				actual = deleted
+				return err
+			})
+			assert.Equal(t, expected.Body, actual)
+
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: expected.PK})
+				return err
+			})
+			isNotFoundErr(t, err)
+		})
+
+		t.Run("missing entity returns not found", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_ent_returning")
+			defer done()
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, err := indexStore.DeleteEntReturning(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				return err
+			})
+			isNotFoundErr(t, err)
+		})
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		fn := func(t *testing.T, suffix string) (storeBase, func(), kv.Store) {
+			return newFooIndexStore(t, suffix)
+		}
+
+		testDeleteBase(t, fn, func(t *testing.T, kvStore kv.Store, base storeBase, foosLeft []foo) {
+			var expectedIndexIDs []interface{}
+			for _, ent := range foosLeft {
+				expectedIndexIDs = append(expectedIndexIDs, ent.ID)
+			}
+
+			indexStore, ok := base.(*kv.IndexStore)
+			require.True(t, ok)
+
+			// next to verify they are not within the index store
+			var actualIDs []interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.IndexStore.Find(context.TODO(), tx, kv.FindOpts{
+					CaptureFn: func(key []byte, decodedVal interface{}) error {
+						actualIDs = append(actualIDs, decodedVal)
+						return nil
+					},
+				})
+			})
+
+			assert.Equal(t, expectedIndexIDs, actualIDs)
+		})
+	})
+
+	t.Run("DeleteMany", func(t *testing.T) {
+		t.Run("deletes matching entities and their indexes", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_many")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore,
+				newFooEnt(1, 9000, "foo_0"),
+				newFooEnt(2, 9000, "foo_1"),
+				newFooEnt(3, 9003, "foo_2"),
+			)
+
+			var n int
+			update(t, kvStore, func(tx kv.Tx) error {
+				deleted, err := indexStore.DeleteMany(context.TODO(), tx, kv.DeleteOpts{
+					FilterFn: func(k []byte, v interface{}) bool {
+						return v.(foo).OrgID == 9000
+					},
+				})
+				n = deleted
+				return err
+			})
+			assert.Equal(t, 2, n)
+
+			var actualIDs []interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.IndexStore.Find(context.TODO(), tx, kv.FindOpts{
+					CaptureFn: func(key []byte, decodedVal interface{}) error {
+						actualIDs = append(actualIDs, decodedVal)
+						return nil
+					},
+				})
+			})
+			assert.Equal(t, []interface{}{influxdb.ID(3)}, actualIDs)
+		})
+
+		t.Run("failing relation aborts the delete", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_many")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_0"), newFooEnt(2, 9000, "foo_1"))
+
+			relationErr := errors.New("relation failed")
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, err := indexStore.DeleteMany(context.TODO(), tx, kv.DeleteOpts{
+					DeleteRelationFns: []kv.DeleteRelationsFn{func(key []byte, decodedVal interface{}) error {
+						if decodedVal.(foo).ID == 2 {
+							return relationErr
+						}
+						return nil
+					}},
+					FilterFn: func(k []byte, v interface{}) bool { return true },
+				})
+				return err
+			})
+			assert.Equal(t, relationErr, err)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				n, err := indexStore.Count(context.TODO(), tx, nil)
+				assert.Equal(t, 2, n)
+				return err
+			})
+		})
+	})
+
+	t.Run("DeleteBatches", func(t *testing.T) {
+		t.Run("interrupted delete keeps committed batches and resumes", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_batches")
+			defer done()
+
+			for i := 1; i <= 10; i++ {
+				seedEnts(t, kvStore, indexStore, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i)))
+			}
+			seedEnts(t, kvStore, indexStore, newFooEnt(11, 9001, "foo_11"))
+
+			filterFn := func(k []byte, v interface{}) bool {
+				return v.(foo).OrgID == 9000
+			}
+
+			relationErr := errors.New("relation failed")
+			n, err := indexStore.DeleteBatches(context.TODO(), kvStore, kv.DeleteOpts{
+				BatchSize: 3,
+				FilterFn:  filterFn,
+				DeleteRelationFns: []kv.DeleteRelationsFn{func(key []byte, decodedVal interface{}) error {
+					if decodedVal.(foo).ID == 5 {
+						return relationErr
+					}
+					return nil
+				}},
+			})
+			assert.Equal(t, relationErr, err)
+			assert.Equal(t, 3, n)
+
+			count := func() int {
+				var n int
+				view(t, kvStore, func(tx kv.Tx) error {
+					var err error
+					n, err = indexStore.Count(context.TODO(), tx, nil)
+					return err
+				})
+				return n
+			}
+			assert.Equal(t, 8, count())
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				inconsistencies, err := indexStore.Verify(context.TODO(), tx)
+				assert.Empty(t, inconsistencies)
+				return err
+			})
+
+			n, err = indexStore.DeleteBatches(context.TODO(), kvStore, kv.DeleteOpts{
+				BatchSize: 3,
+				FilterFn:  filterFn,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, 7, n)
+			assert.Equal(t, 1, count())
+		})
+
+		t.Run("canceled context stops between batches", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_batches")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			n, err := indexStore.DeleteBatches(ctx, kvStore, kv.DeleteOpts{
+				BatchSize: 1,
+				FilterFn:  func(k []byte, v interface{}) bool { return true },
+				DeleteRelationFns: []kv.DeleteRelationsFn{func(key []byte, decodedVal interface{}) error {
+					cancel()
+					return nil
+				}},
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.ECanceled, influxdb.ErrorCode(err))
+			assert.Equal(t, 1, n)
+		})
+
+		t.Run("requires a batch size", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_batches")
+			defer done()
+
+			_, err := indexStore.DeleteBatches(context.TODO(), kvStore, kv.DeleteOpts{
+				FilterFn: func(k []byte, v interface{}) bool { return true },
+			})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("FindEnt", func(t *testing.T) {
+		t.Run("by ID", func(t *testing.T) {
+			base, done, kvStoreStore := newFooIndexStore(t, "find_ent")
+			defer done()
+			testFindEnt(t, kvStoreStore, base)
+		})
+
+		t.Run("find by name", func(t *testing.T) {
+			base, done, kvStore := newFooIndexStore(t, "find_ent")
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, base, expected)
+
+			var actual interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				f, err := base.FindEnt(context.TODO(), tx, kv.Entity{
+					UniqueKey: expected.UniqueKey,
+				})
+				actual = f
+				return err
+			})
+
+			assert.Equal(t, expected.Body, actual)
+		})
+	})
+
+	t.Run("FindEnt verify index", func(t *testing.T) {
+		t.Run("agreeing index", func(t *testing.T) {
+			base, done, kvStore := newFooIndexStore(t, "find_ent_verify")
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, base, expected)
+
+			var actual interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				f, err := base.FindEnt(context.TODO(), tx, expected, kv.FindEntVerifyIndex())
+				actual = f
+				return err
+			})
+			assert.Equal(t, expected.Body, actual)
+		})
+
+		t.Run("index resolving to another entity", func(t *testing.T) {
+			base, done, kvStore := newFooIndexStore(t, "find_ent_verify")
+			defer done()
+
+			seedEnts(t, kvStore, base, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+
+			// point the index of foo_1 at foo_2
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.IndexStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_1"))
+			})
+
+			stale := newFooEnt(1, 9000, "foo_1")
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := base.FindEnt(context.TODO(), tx, stale)
+				require.NoError(t, err)
+
+				_, err = base.FindEnt(context.TODO(), tx, stale, kv.FindEntVerifyIndex())
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
+				return nil
+			})
+		})
+
+		t.Run("missing index", func(t *testing.T) {
+			base, done, kvStore := newFooIndexStore(t, "find_ent_verify")
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.EntStore.Put(context.TODO(), tx, expected)
+			})
+
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				_, err := base.FindEnt(context.TODO(), tx, expected, kv.FindEntVerifyIndex())
+				return err
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("FindEntRaw", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "find_ent_raw")
+		defer done()
+
+		ent := newFooEnt(1, 9000, "foo_1")
+		seedEnts(t, kvStore, indexStore, ent)
+
+		expected := getEntRaw(t, kvStore, indexStore.EntStore.BktName, encodeID(t, 1))
+
+		findRaw := func(ent kv.Entity) ([]byte, error) {
+			var raw []byte
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				var err error
+				raw, err = indexStore.FindEntRaw(context.TODO(), tx, ent)
+				return err
+			})
+			return raw, err
+		}
+
+		t.Run("by PK", func(t *testing.T) {
+			raw, err := findRaw(kv.Entity{PK: ent.PK})
+			require.NoError(t, err)
+			assert.Equal(t, expected, raw)
+		})
+
+		t.Run("by index", func(t *testing.T) {
+			raw, err := findRaw(kv.Entity{UniqueKey: ent.UniqueKey})
+			require.NoError(t, err)
+			assert.Equal(t, expected, raw)
+		})
+
+		t.Run("not found matches FindEnt", func(t *testing.T) {
+			for _, missing := range []kv.Entity{
+				{PK: kv.EncID(2)},
+				{UniqueKey: kv.Encode(kv.EncID(9000), kv.EncString("missing"))},
+			} {
+				_, rawErr := findRaw(missing)
+				require.Error(t, rawErr)
+
+				err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+					_, err := indexStore.FindEnt(context.TODO(), tx, missing)
+					return err
+				})
+				assert.Equal(t, err, rawErr)
+			}
+		})
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		base, done, kvStore := newFooIndexStore(t, "exists")
+		defer done()
+
+		expected := newFooEnt(1, 9000, "foo_1")
+		seedEnts(t, kvStore, base, expected)
+
+		tests := []struct {
+			name     string
+			ent      kv.Entity
+			expected bool
+		}{
+			{
+				name:     "by ID",
+				ent:      kv.Entity{PK: expected.PK},
+				expected: true,
+			},
+			{
+				name:     "by name",
+				ent:      kv.Entity{UniqueKey: expected.UniqueKey},
+				expected: true,
+			},
+			{
+				name: "missing ID",
+				ent:  kv.Entity{PK: kv.EncID(2)},
+			},
+			{
+				name: "missing name",
+				ent:  kv.Entity{UniqueKey: kv.Encode(kv.EncID(9000), kv.EncString("foo_2"))},
+			},
+		}
+
+		for _, tt := range tests {
+			fn := func(t *testing.T) {
+				var actual bool
+				view(t, kvStore, func(tx kv.Tx) error {
+					exists, err := base.Exists(context.TODO(), tx, tt.ent)
+					actual = exists
+					return err
+				})
+				assert.Equal(t, tt.expected, actual)
+			}
+			t.Run(tt.name, fn)
+		}
+
+		t.Run("no key provided", func(t *testing.T) {
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				_, err := base.Exists(context.TODO(), tx, kv.Entity{})
+				return err
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("multiple indexes", func(t *testing.T) {
+		newMultiIndexStore := func(t *testing.T) (*kv.IndexStore, func(), kv.Store) {
+			t.Helper()
+
+			indexStore, done, kvStore := newFooIndexStore(t, "multi_index")
+
+			nameBucketName := []byte("foo_name_idx")
+			err := migration.CreateBuckets("add foo name index bucket", nameBucketName).Up(context.Background(), kvStore.(kv.SchemaStore))
+			require.NoError(t, err)
+
+			encNameKey := func(ent kv.Entity) ([]byte, string, error) {
+				f, ok := ent.Body.(foo)
+				if !ok || f.Name == "" {
+					return nil, "Name", errors.New("no name provided")
+				}
+				return []byte(f.Name), "Name", nil
+			}
+			decNameIdxToEnt := func(k []byte, v interface{}) (kv.Entity, error) {
+				id, ok := v.(influxdb.ID)
+				if err := kv.IsErrUnexpectedDecodeVal(ok); err != nil {
+					return kv.Entity{}, err
+				}
+				return kv.Entity{PK: kv.EncID(id)}, nil
+			}
+
+			indexStore.Indexes = []kv.NamedIndex{{
+				Name:  "name",
+				Store: newStoreBase("foo", nameBucketName, encNameKey, kv.EncIDKey, kv.DecIndexID, decNameIdxToEnt),
+			}}
+			return indexStore, done, kvStore
+		}
+
+		t.Run("find by each index", func(t *testing.T) {
+			base, done, kvStore := newMultiIndexStore(t)
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, expected, kv.PutNew())
+			})
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := base.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: expected.UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, actual)
+
+				actual, err = base.FindEnt(context.TODO(), tx, kv.Entity{Body: foo{Name: "foo_1"}})
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, actual)
+
+				actual, err = base.FindEntByIndex(context.TODO(), tx, "name", kv.Entity{Body: foo{Name: "foo_1"}})
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, actual)
+
+				actual, err = base.FindEntByIndex(context.TODO(), tx, kv.DefaultIndexName, kv.Entity{UniqueKey: expected.UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, actual)
+
+				_, err = base.FindEntByIndex(context.TODO(), tx, "missing", kv.Entity{UniqueKey: expected.UniqueKey})
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+				return nil
+			})
+		})
+
+		t.Run("FindOrCreate conflict on another index is returned", func(t *testing.T) {
+			base, done, kvStore := newMultiIndexStore(t)
+			defer done()
+
+			existing := newFooEnt(1, 9000, "foo_1")
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, _, err := base.FindOrCreate(context.TODO(), tx, kv.Entity{UniqueKey: existing.UniqueKey}, func() (kv.Entity, error) {
+					// the entity looked up is created before the put, and the
+					// entity created takes its name in another org
+					if err := base.Put(context.TODO(), tx, existing, kv.PutNew()); err != nil {
+						return kv.Entity{}, err
+					}
+					return newFooEnt(2, 9001, "foo_1"), nil
+				})
+				return err
+			})
+			require.Error(t, err)
+			assert.True(t, kv.IsErrKeyConflict(err))
+			assert.Equal(t, "foo is not unique for key foo_1", influxdb.ErrorMessage(err))
+		})
+
+		t.Run("FindEntAny", func(t *testing.T) {
+			base, done, kvStore := newMultiIndexStore(t)
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, base, expected, newFooEnt(2, 9000, "foo_2"))
+
+			findAny := func(ent kv.Entity) (interface{}, error) {
+				var actual interface{}
+				err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+					var err error
+					actual, err = base.FindEntAny(context.TODO(), tx, ent)
+					return err
+				})
+				return actual, err
+			}
+
+			t.Run("hits the PK first", func(t *testing.T) {
+				actual, err := findAny(kv.Entity{PK: kv.EncID(1), Body: foo{Name: "foo_2"}})
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, actual)
+			})
+
+			t.Run("first index misses but the second hits", func(t *testing.T) {
+				actual, err := findAny(kv.Entity{
+					UniqueKey: kv.Encode(kv.EncID(9001), kv.EncString("foo_1")),
+					Body:      foo{Name: "foo_1"},
+				})
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, actual)
+			})
+
+			t.Run("PK misses but an index hits", func(t *testing.T) {
+				actual, err := findAny(kv.Entity{PK: kv.EncID(99), UniqueKey: expected.UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, expected.Body, actual)
+			})
+
+			t.Run("every key misses", func(t *testing.T) {
+				_, err := findAny(kv.Entity{
+					PK:        kv.EncID(99),
+					UniqueKey: kv.Encode(kv.EncID(9001), kv.EncString("foo_1")),
+					Body:      foo{Name: "foo_99"},
+				})
+				isNotFoundErr(t, err)
+			})
+
+			t.Run("no key provided", func(t *testing.T) {
+				_, err := findAny(kv.Entity{})
+				assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+			})
+		})
+
+		t.Run("FindEntAny with a MissCache", func(t *testing.T) {
+			base, done, kvStore := newMultiIndexStore(t)
+			defer done()
+			base.MissCache = kv.NewMissCache(10, time.Minute)
+
+			ent := newFooEnt(3, 9000, "foo_3")
+			findAny := func() (interface{}, error) {
+				var actual interface{}
+				err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+					var err error
+					actual, err = base.FindEntAny(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey, Body: ent.Body})
+					return err
+				})
+				return actual, err
+			}
+
+			_, err := findAny()
+			isNotFoundErr(t, err)
+			// a miss of each index is cached
+			assert.Equal(t, 2, base.MissCache.Len())
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, ent, kv.PutNew())
+			})
+			assert.Equal(t, 0, base.MissCache.Len())
+
+			actual, err := findAny()
+			require.NoError(t, err)
+			assert.Equal(t, ent.Body, actual)
+		})
+
+		t.Run("new entity conflicts on secondary index", func(t *testing.T) {
+			base, done, kvStore := newMultiIndexStore(t)
+			defer done()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1"), kv.PutNew())
+			})
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, newFooEnt(2, 9001, "foo_1"), kv.PutNew())
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+		})
+
+		t.Run("update and delete maintain every index", func(t *testing.T) {
+			base, done, kvStore := newMultiIndexStore(t)
+			defer done()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1"), kv.PutNew())
+			})
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_2"), kv.PutUpdate())
+			})
+
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				_, err := base.FindEntByIndex(context.TODO(), tx, "name", kv.Entity{Body: foo{Name: "foo_1"}})
+				return err
+			})
+			isNotFoundErr(t, err)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return base.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+			})
+
+			err = kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				_, err := base.FindEntByIndex(context.TODO(), tx, "name", kv.Entity{Body: foo{Name: "foo_2"}})
+				return err
+			})
+			isNotFoundErr(t, err)
+		})
+	})
+
+	t.Run("Count", func(t *testing.T) {
+		base, done, kvStore := newFooIndexStore(t, "count")
+		defer done()
+
+		seedEnts(t, kvStore, base,
+			newFooEnt(1, 9000, "foo_0"),
+			newFooEnt(2, 9000, "foo_1"),
+			newFooEnt(3, 9003, "foo_2"),
+		)
+
+		orgPrefix, err := kv.EncID(9000)()
+		require.NoError(t, err)
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			n, err := base.Count(context.TODO(), tx, nil)
+			require.NoError(t, err)
+			assert.Equal(t, 3, n)
+
+			n, err = base.CountIndex(context.TODO(), tx, kv.DefaultIndexName, orgPrefix)
+			require.NoError(t, err)
+			assert.Equal(t, 2, n)
+
+			_, err = base.CountIndex(context.TODO(), tx, "missing", nil)
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+			return nil
+		})
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		newVersionedStoreOn := func(t *testing.T, newIndexStore func(*testing.T, string) (*kv.IndexStore, func(), kv.Store)) (*kv.IndexStore, func(), kv.Store) {
+			t.Helper()
+
+			indexStore, done, kvStore := newIndexStore(t, "versions")
+
+			versionBucket := []byte("foo_version")
+			err := migration.CreateBuckets("add foo version bucket", versionBucket).Up(context.Background(), kvStore.(kv.SchemaStore))
+			require.NoError(t, err)
+			indexStore.VersionStore = kv.NewVersionStore("foo", versionBucket)
+
+			return indexStore, done, kvStore
+		}
+
+		newVersionedStore := func(t *testing.T) (*kv.IndexStore, func(), kv.Store) {
+			t.Helper()
+			return newVersionedStoreOn(t, newFooIndexStore)
+		}
+
+		version := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity) int {
+			t.Helper()
+
+			var v int
+			view(t, kvStore, func(tx kv.Tx) error {
+				var err error
+				v, err = indexStore.Version(context.TODO(), tx, ent)
+				return err
+			})
+			return v
+		}
+
+		put := func(kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity, opts ...kv.PutOptionFn) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, ent, opts...)
+			})
+		}
+
+		t.Run("bumped on every put", func(t *testing.T) {
+			indexStore, done, kvStore := newVersionedStore(t)
+			defer done()
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			assert.Equal(t, 0, version(t, kvStore, indexStore, ent))
+
+			require.NoError(t, put(kvStore, indexStore, ent, kv.PutNew(), kv.PutExpectedVersion(0)))
+			assert.Equal(t, 1, version(t, kvStore, indexStore, ent))
+
+			require.NoError(t, put(kvStore, indexStore, newFooEnt(1, 9000, "foo_2"), kv.PutUpdate(), kv.PutExpectedVersion(1)))
+			assert.Equal(t, 2, version(t, kvStore, indexStore, ent))
+
+			require.NoError(t, put(kvStore, indexStore, newFooEnt(1, 9000, "foo_3"), kv.PutUpdate()))
+			assert.Equal(t, 3, version(t, kvStore, indexStore, ent))
+		})
+
+		t.Run("stale version is rejected", func(t *testing.T) {
+			indexStore, done, kvStore := newVersionedStore(t)
+			defer done()
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			require.NoError(t, put(kvStore, indexStore, ent, kv.PutNew()))
+			require.NoError(t, put(kvStore, indexStore, ent, kv.PutUpdate()))
+
+			err := put(kvStore, indexStore, newFooEnt(1, 9000, "foo_2"), kv.PutUpdate(), kv.PutExpectedVersion(1))
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+			assert.False(t, kv.IsErrKeyConflict(err))
+			assert.Equal(t, 2, version(t, kvStore, indexStore, ent))
+
+			var actual interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				var err error
+				actual, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+				return err
+			})
+			assert.Equal(t, ent.Body, actual)
+		})
+
+		t.Run("stale version leaves the index entries", func(t *testing.T) {
+			for name, newIndexStore := range map[string]func(*testing.T, string) (*kv.IndexStore, func(), kv.Store){
+				"bolt":  newFooIndexStore,
+				"inmem": newInmemFooIndexStore,
+			} {
+				t.Run(name, func(t *testing.T) {
+					indexStore, done, kvStore := newVersionedStoreOn(t, newIndexStore)
+					defer done()
+
+					ent := newFooEnt(1, 9000, "foo_1")
+					require.NoError(t, put(kvStore, indexStore, ent, kv.PutNew()))
+
+					update(t, kvStore, func(tx kv.Tx) error {
+						err := indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_2"), kv.PutUpdate(), kv.PutExpectedVersion(7))
+						require.Error(t, err)
+						assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+						// the conflict is handled and the tx is committed
+						return nil
+					})
+
+					var actual interface{}
+					view(t, kvStore, func(tx kv.Tx) error {
+						var err error
+						actual, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+						return err
+					})
+					assert.Equal(t, ent.Body, actual)
+				})
+			}
+		})
+
+		t.Run("concurrent updates of the same version", func(t *testing.T) {
+			indexStore, done, kvStore := newVersionedStore(t)
+			defer done()
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			require.NoError(t, put(kvStore, indexStore, ent, kv.PutNew()))
+
+			const updaters = 5
+			var (
+				wg      sync.WaitGroup
+				read    sync.WaitGroup
+				errs    = make(chan error, updaters)
+				success int
+			)
+			read.Add(updaters)
+			for i := 0; i < updaters; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					var v int
+					err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+						var err error
+						v, err = indexStore.Version(context.TODO(), tx, ent)
+						return err
+					})
+					read.Done()
+					if err != nil {
+						errs <- err
+						return
+					}
+					// every updater has read the version before any of them writes
+					read.Wait()
+
+					name := fmt.Sprintf("foo_updated_%d", i)
+					errs <- put(kvStore, indexStore, newFooEnt(1, 9000, name), kv.PutUpdate(), kv.PutExpectedVersion(v))
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if err == nil {
+					success++
+					continue
+				}
+				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+			}
+			assert.Equal(t, 1, success)
+			assert.Equal(t, 2, version(t, kvStore, indexStore, ent))
+		})
+
+		t.Run("deleted with the entity", func(t *testing.T) {
+			indexStore, done, kvStore := newVersionedStore(t)
+			defer done()
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			require.NoError(t, put(kvStore, indexStore, ent, kv.PutNew()))
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+			})
+			assert.Equal(t, 0, version(t, kvStore, indexStore, ent))
+		})
+
+		t.Run("expected version requires a version store", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "versions")
+			defer done()
+
+			err := put(kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), kv.PutExpectedVersion(0))
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("put if match", func(t *testing.T) {
+		nameFn := func(ent kv.Entity) []byte {
+			return []byte(ent.Body.(foo).Name)
+		}
+
+		put := func(kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity, opts ...kv.PutOptionFn) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, ent, opts...)
+			})
+		}
+
+		findName := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore) string {
+			t.Helper()
+
+			var name string
+			view(t, kvStore, func(tx kv.Tx) error {
+				v, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				require.NoError(t, err)
+				name = v.(foo).Name
+				return nil
+			})
+			return name
+		}
+
+		t.Run("match is updated", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_if_match")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "pending"))
+
+			err := put(kvStore, indexStore, newFooEnt(1, 9000, "running"), kv.PutUpdate(), kv.PutIfMatch(nameFn, []byte("pending")))
+			require.NoError(t, err)
+			assert.Equal(t, "running", findName(t, kvStore, indexStore))
+		})
+
+		t.Run("mismatch is rejected", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_if_mismatch")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "running"))
+
+			err := put(kvStore, indexStore, newFooEnt(1, 9000, "done"), kv.PutUpdate(), kv.PutIfMatch(nameFn, []byte("pending")))
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+			assert.False(t, kv.IsErrKeyConflict(err))
+			assert.Equal(t, "running", findName(t, kvStore, indexStore))
+		})
+
+		t.Run("mismatch leaves the index entries", func(t *testing.T) {
+			for name, newIndexStore := range map[string]func(*testing.T, string) (*kv.IndexStore, func(), kv.Store){
+				"bolt":  newFooIndexStore,
+				"inmem": newInmemFooIndexStore,
+			} {
+				t.Run(name, func(t *testing.T) {
+					indexStore, done, kvStore := newIndexStore(t, "put_if_mismatch")
+					defer done()
+
+					existing := newFooEnt(1, 9000, "running")
+					seedEnts(t, kvStore, indexStore, existing)
+
+					err := put(kvStore, indexStore, newFooEnt(1, 9000, "done"), kv.PutUpdate(), kv.PutIfMatch(nameFn, []byte("pending")))
+					assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+
+					var actual interface{}
+					view(t, kvStore, func(tx kv.Tx) error {
+						var err error
+						actual, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: existing.UniqueKey})
+						return err
+					})
+					assert.Equal(t, existing.Body, actual)
+				})
+			}
+		})
+
+		t.Run("missing entity is not found", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "put_if_match_missing")
+			defer done()
+
+			err := put(kvStore, indexStore, newFooEnt(1, 9000, "running"), kv.PutIfMatch(nameFn, []byte("pending")))
+			isNotFoundErr(t, err)
+		})
+	})
+
+	t.Run("delete if match", func(t *testing.T) {
+		pending := func(existing kv.Entity) bool {
+			return existing.Body.(foo).Name == "pending"
+		}
+
+		deleteEnt := func(kvStore kv.Store, indexStore *kv.IndexStore, opts ...kv.DeleteEntOptionFn) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)}, opts...)
+			})
+		}
+
+		t.Run("match is deleted with its index", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_if_match")
+			defer done()
+
+			ent := newFooEnt(1, 9000, "pending")
+			seedEnts(t, kvStore, indexStore, ent)
+
+			require.NoError(t, deleteEnt(kvStore, indexStore, kv.DeleteEntIfMatch(pending)))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+				isNotFoundErr(t, err)
+				_, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+				isNotFoundErr(t, err)
+				return nil
+			})
+		})
+
+		t.Run("mismatch is rejected", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "delete_if_mismatch")
+			defer done()
+
+			ent := newFooEnt(1, 9000, "running")
+			seedEnts(t, kvStore, indexStore, ent)
+
+			err := deleteEnt(kvStore, indexStore, kv.DeleteEntIfMatch(pending))
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, ent.Body, actual)
+				return nil
+			})
+		})
+	})
+
+	t.Run("force delete", func(t *testing.T) {
+		newUnindexedStore := func(t *testing.T) (*kv.IndexStore, func(), kv.Store) {
+			t.Helper()
+
+			indexStore, done, kvStore := newFooIndexStore(t, "force_delete")
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "shared"))
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				// entities whose index entries are missing, one of which has its
+				// unique key taken by another entity
+				if err := indexStore.EntStore.Put(context.TODO(), tx, newFooEnt(3, 9000, "unindexed")); err != nil {
+					return err
+				}
+				return indexStore.EntStore.Put(context.TODO(), tx, newFooEnt(4, 9000, "shared"))
+			})
+			return indexStore, done, kvStore
+		}
+
+		deleteEnt := func(kvStore kv.Store, indexStore *kv.IndexStore, id influxdb.ID, opts ...kv.DeleteEntOptionFn) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(id)}, opts...)
+			})
+		}
+
+		t.Run("strict delete of an unindexed entity is not found", func(t *testing.T) {
+			indexStore, done, kvStore := newUnindexedStore(t)
+			defer done()
+
+			isNotFoundErr(t, deleteEnt(kvStore, indexStore, 3))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.EntStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(3)})
+				require.NoError(t, err)
+				return nil
+			})
+		})
+
+		t.Run("forced delete removes the unindexed entity", func(t *testing.T) {
+			indexStore, done, kvStore := newUnindexedStore(t)
+			defer done()
+
+			core, logs := observer.New(zap.InfoLevel)
+			indexStore.Logger = zap.New(core)
+
+			require.NoError(t, deleteEnt(kvStore, indexStore, 3, kv.DeleteEntForce()))
+			require.NoError(t, deleteEnt(kvStore, indexStore, 4, kv.DeleteEntForce()))
+			assert.Equal(t, 2, logs.FilterMessage("Deleting entity with missing index entries").Len())
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				for _, id := range []influxdb.ID{3, 4} {
+					_, err := indexStore.EntStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(id)})
+					isNotFoundErr(t, err)
+				}
+
+				// the index entry of the entity sharing the unique key is left as is
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(0, 9000, "shared").UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, newFooEnt(2, 9000, "shared").Body, actual)
+				return nil
+			})
+		})
+
+		t.Run("forced delete of an indexed entity removes its index", func(t *testing.T) {
+			indexStore, done, kvStore := newUnindexedStore(t)
+			defer done()
+
+			require.NoError(t, deleteEnt(kvStore, indexStore, 1, kv.DeleteEntForce()))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(0, 9000, "foo_1").UniqueKey})
+				isNotFoundErr(t, err)
+				return nil
+			})
+		})
+	})
+
+	t.Run("soft delete", func(t *testing.T) {
+		newSoftDeleteStore := func(t *testing.T) (*kv.IndexStore, func(), kv.Store) {
+			t.Helper()
+
+			indexStore, done, kvStore := newFooIndexStore(t, "soft_delete")
+
+			tombstoneBucket := []byte("foo_tombstone")
+			err := migration.CreateBuckets("add foo tombstone bucket", tombstoneBucket).Up(context.Background(), kvStore.(kv.SchemaStore))
+			require.NoError(t, err)
+			indexStore.TombstoneStore = kv.NewTombstoneStore("foo", tombstoneBucket)
+
+			return indexStore, done, kvStore
+		}
+
+		softDelete := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity) {
+			t.Helper()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK}, kv.DeleteEntSoft())
+			})
+		}
+
+		findEnt := func(kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity, opts ...kv.FindEntOptionFn) (interface{}, error) {
+			var v interface{}
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				var err error
+				v, err = indexStore.FindEnt(context.TODO(), tx, ent, opts...)
+				return err
+			})
+			return v, err
+		}
+
+		restore := func(kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Restore(context.TODO(), tx, kv.Entity{PK: ent.PK})
+			})
+		}
+
+		t.Run("hides the entity and frees its name", func(t *testing.T) {
+			indexStore, done, kvStore := newSoftDeleteStore(t)
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, expected)
+			softDelete(t, kvStore, indexStore, expected)
+
+			_, err := findEnt(kvStore, indexStore, kv.Entity{PK: expected.PK})
+			isNotFoundErr(t, err)
+			_, err = findEnt(kvStore, indexStore, kv.Entity{UniqueKey: expected.UniqueKey})
+			isNotFoundErr(t, err)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				exists, err := indexStore.Exists(context.TODO(), tx, kv.Entity{PK: expected.PK})
+				require.NoError(t, err)
+				assert.False(t, exists)
+				return nil
+			})
+
+			v, err := findEnt(kvStore, indexStore, kv.Entity{PK: expected.PK}, kv.FindEntIncludeDeleted())
+			require.NoError(t, err)
+			assert.Equal(t, expected.Body, v)
+
+			err = kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: expected.PK}, kv.DeleteEntSoft())
+			})
+			isNotFoundErr(t, err)
+		})
+
+		t.Run("restore recreates the index", func(t *testing.T) {
+			indexStore, done, kvStore := newSoftDeleteStore(t)
+			defer done()
+
+			expected := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, expected)
+			softDelete(t, kvStore, indexStore, expected)
+
+			require.NoError(t, restore(kvStore, indexStore, expected))
+
+			v, err := findEnt(kvStore, indexStore, kv.Entity{UniqueKey: expected.UniqueKey})
+			require.NoError(t, err)
+			assert.Equal(t, expected.Body, v)
+
+			err = restore(kvStore, indexStore, expected)
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+
+		t.Run("name reused after soft delete", func(t *testing.T) {
+			indexStore, done, kvStore := newSoftDeleteStore(t)
+			defer done()
+
+			deleted := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, deleted)
+			softDelete(t, kvStore, indexStore, deleted)
+
+			reused := newFooEnt(2, 9000, "foo_1")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, reused, kv.PutNew())
+			})
+
+			err := restore(kvStore, indexStore, deleted)
+			require.Error(t, err)
+			assert.True(t, kv.IsErrKeyConflict(err))
+
+			err = kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_2"), kv.PutUpdate())
+			})
+			isNotFoundErr(t, err)
+
+			// deleting the soft deleted entity for good must leave the
+			// index of the entity that reused its name alone
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: deleted.PK})
+			})
+
+			_, err = findEnt(kvStore, indexStore, kv.Entity{PK: deleted.PK}, kv.FindEntIncludeDeleted())
+			isNotFoundErr(t, err)
+
+			v, err := findEnt(kvStore, indexStore, kv.Entity{UniqueKey: reused.UniqueKey})
+			require.NoError(t, err)
+			assert.Equal(t, reused.Body, v)
+		})
+
+		t.Run("requires a tombstone store", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "soft_delete")
+			defer done()
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, ent)
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK}, kv.DeleteEntSoft())
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("SweepExpired deletes the indexes of expired entities", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "sweep")
+		defer done()
+
+		expiryBucket := []byte("foo_expires_at")
+		err := migration.CreateBuckets("add foo expiry bucket", expiryBucket).Up(context.Background(), kvStore.(kv.SchemaStore))
+		require.NoError(t, err)
+		indexStore.EntStore.ExpiryStore = kv.NewExpiryStore("foo", expiryBucket)
+
+		now := time.Now()
+		expired := newFooEnt(1, 9000, "foo_1")
+		expired.ExpiresAt = now.Add(-time.Minute)
+		seedEnts(t, kvStore, indexStore, expired)
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: expired.UniqueKey})
+			isNotFoundErr(t, err)
+
+			exists, err := indexStore.Exists(context.TODO(), tx, kv.Entity{PK: expired.PK})
+			require.NoError(t, err)
+			assert.False(t, exists)
+			return nil
+		})
+
+		update(t, kvStore, func(tx kv.Tx) error {
+			n, err := indexStore.SweepExpired(context.TODO(), tx, now, 10)
+			assert.Equal(t, 1, n)
+			return err
+		})
+
+		// with the index removed, the name is free to be used again
+		update(t, kvStore, func(tx kv.Tx) error {
+			return indexStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_1"), kv.PutNew())
+		})
+	})
+
+	t.Run("AuditFn", func(t *testing.T) {
+		newAuditedStore := func(t *testing.T) (*kv.IndexStore, func(), kv.Store, *[]kv.AuditEvent) {
+			t.Helper()
+
+			indexStore, done, kvStore := newFooIndexStore(t, "audit")
+
+			auditBucket := []byte("foo_audit")
+			err := migration.CreateBuckets("add foo audit bucket", auditBucket).Up(context.Background(), kvStore.(kv.SchemaStore))
+			require.NoError(t, err)
+
+			var events []kv.AuditEvent
+			indexStore.AuditFn = func(ctx context.Context, tx kv.Tx, event kv.AuditEvent) error {
+				b, err := tx.Bucket(auditBucket)
+				if err != nil {
+					return err
+				}
+				if err := b.Put([]byte(fmt.Sprintf("%03d", len(events))), []byte(event.Op)); err != nil {
+					return err
+				}
+				events = append(events, event)
+				return nil
+			}
+			return indexStore, done, kvStore, &events
+		}
+
+		t.Run("captures before and after values", func(t *testing.T) {
+			indexStore, done, kvStore, events := newAuditedStore(t)
+			defer done()
+
+			created := newFooEnt(1, 9000, "foo_1")
+			updated := newFooEnt(1, 9000, "foo_updated")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, created, kv.PutNew())
+			})
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, updated, kv.PutUpdate())
+			})
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: created.PK})
+			})
+
+			key := encodeID(t, 1)
+			assert.Equal(t, []kv.AuditEvent{
+				{Op: kv.AuditCreate, Resource: "foo", Key: key, After: created.Body},
+				{Op: kv.AuditUpdate, Resource: "foo", Key: key, Before: created.Body, After: updated.Body},
+				{Op: kv.AuditDelete, Resource: "foo", Key: key, Before: updated.Body},
+			}, *events)
+			assert.Equal(t, []byte("delete"), getEntRaw(t, kvStore, []byte("foo_audit"), []byte("002")))
+		})
+
+		t.Run("delete many captures each entity", func(t *testing.T) {
+			indexStore, done, kvStore, events := newAuditedStore(t)
+			defer done()
+
+			ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2")}
+			seedEnts(t, kvStore, indexStore, ents...)
+			*events = nil
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Delete(context.TODO(), tx, kv.DeleteOpts{
+					FilterFn: func(k []byte, v interface{}) bool { return true },
+				})
+			})
+
+			require.Len(t, *events, 2)
+			for i, event := range *events {
+				assert.Equal(t, kv.AuditDelete, event.Op)
+				assert.Equal(t, ents[i].Body, event.Before)
+				assert.Nil(t, event.After)
+			}
+		})
+
+		t.Run("soft delete and restore", func(t *testing.T) {
+			indexStore, done, kvStore, events := newAuditedStore(t)
+			defer done()
+
+			tombstoneBucket := []byte("foo_tombstone")
+			require.NoError(t, migration.CreateBuckets("add foo tombstone bucket", tombstoneBucket).Up(context.Background(), kvStore.(kv.SchemaStore)))
+			indexStore.TombstoneStore = kv.NewTombstoneStore("foo", tombstoneBucket)
+			indexStore.EventBus = kv.NewEventBus()
+			sub, err := indexStore.Subscribe(10, kv.EventDrop)
+			require.NoError(t, err)
+			defer sub.Close()
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, ent)
+			*events = nil
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK}, kv.DeleteEntSoft())
+			})
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Restore(context.TODO(), tx, kv.Entity{PK: ent.PK})
+			})
+
+			key := encodeID(t, 1)
+			assert.Equal(t, []kv.AuditEvent{
+				{Op: kv.AuditDelete, Resource: "foo", Key: key, Before: ent.Body},
+				{Op: kv.AuditRestore, Resource: "foo", Key: key, After: ent.Body},
+			}, *events)
+
+			var ops []kv.AuditOp
+			for len(sub.C) > 0 {
+				ops = append(ops, (<-sub.C).Op)
+			}
+			assert.Equal(t, []kv.AuditOp{kv.AuditCreate, kv.AuditDelete, kv.AuditRestore}, ops)
+		})
+
+		t.Run("failing audit fails the mutation", func(t *testing.T) {
+			indexStore, done, kvStore, _ := newAuditedStore(t)
+			defer done()
+
+			indexStore.AuditFn = func(ctx context.Context, tx kv.Tx, event kv.AuditEvent) error {
+				return errors.New("audit failed")
+			}
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, ent, kv.PutNew())
+			})
+			require.Error(t, err)
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+				isNotFoundErr(t, err)
+				return nil
+			})
+		})
+	})
+
+	t.Run("EventBus", func(t *testing.T) {
+		newSubscribedStore := func(t *testing.T, bufSize int, policy kv.EventPolicy) (*kv.IndexStore, func(), kv.Store, *kv.Subscription) {
+			t.Helper()
+
+			indexStore, done, kvStore := newFooIndexStore(t, "events")
+			indexStore.EventBus = kv.NewEventBus()
+
+			sub, err := indexStore.Subscribe(bufSize, policy)
+			require.NoError(t, err)
+			return indexStore, func() { sub.Close(); done() }, kvStore, sub
+		}
+
+		received := func(sub *kv.Subscription) []kv.EntEvent {
+			var events []kv.EntEvent
+			for {
+				select {
+				case event := <-sub.C:
+					events = append(events, event)
+				default:
+					return events
+				}
+			}
+		}
+
+		t.Run("committed mutations emit once", func(t *testing.T) {
+			indexStore, done, kvStore, sub := newSubscribedStore(t, 10, kv.EventDrop)
+			defer done()
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, ent, kv.PutNew())
+			})
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_updated"), kv.PutUpdate())
+			})
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+			})
+
+			key := encodeID(t, 1)
+			assert.Equal(t, []kv.EntEvent{
+				{Op: kv.AuditCreate, Resource: "foo", Key: key},
+				{Op: kv.AuditUpdate, Resource: "foo", Key: key},
+				{Op: kv.AuditDelete, Resource: "foo", Key: key},
+			}, received(sub))
+		})
+
+		t.Run("rolled back mutations emit nothing", func(t *testing.T) {
+			indexStore, done, kvStore, sub := newSubscribedStore(t, 10, kv.EventDrop)
+			defer done()
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				if err := indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1"), kv.PutNew()); err != nil {
+					return err
+				}
+				return errors.New("rollback")
+			})
+			require.Error(t, err)
+
+			assert.Empty(t, received(sub))
+		})
+
+		t.Run("full buffer drops events", func(t *testing.T) {
+			indexStore, done, kvStore, sub := newSubscribedStore(t, 1, kv.EventDrop)
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3"))
+
+			require.Len(t, received(sub), 1)
+			assert.Equal(t, uint64(2), sub.Dropped())
+		})
+
+		t.Run("blocking subscriber receives every event", func(t *testing.T) {
+			indexStore, done, kvStore, sub := newSubscribedStore(t, 0, kv.EventBlock)
+			defer done()
+
+			got := make(chan []kv.EntEvent)
+			go func() {
+				var events []kv.EntEvent
+				for i := 0; i < 3; i++ {
+					events = append(events, <-sub.C)
+				}
+				got <- events
+			}()
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3"))
+
+			events := <-got
+			require.Len(t, events, 3)
+			for i, event := range events {
+				assert.Equal(t, encodeID(t, influxdb.ID(i+1)), event.Key)
+			}
+		})
+
+		t.Run("closed subscription receives nothing", func(t *testing.T) {
+			indexStore, done, kvStore, sub := newSubscribedStore(t, 10, kv.EventBlock)
+			defer done()
+
+			sub.Close()
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+
+			_, ok := <-sub.C
+			assert.False(t, ok)
+		})
+
+		t.Run("store without a bus can not be subscribed to", func(t *testing.T) {
+			indexStore, done, _ := newFooIndexStore(t, "events")
+			defer done()
+
+			_, err := indexStore.Subscribe(10, kv.EventDrop)
+			assert.Equal(t, influxdb.EInternal, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("RateLimiter", func(t *testing.T) {
+		putFoo := func(kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, ent)
+			})
+		}
+
+		t.Run("trips and recovers", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "rate_limit")
+			defer done()
+
+			const every = 200 * time.Millisecond
+			indexStore.RateLimiter = kv.NewResourceRateLimiter(rate.Every(every), 2)
+
+			require.NoError(t, putFoo(kvStore, indexStore, newFooEnt(1, 9000, "foo_1")))
+			require.NoError(t, putFoo(kvStore, indexStore, newFooEnt(2, 9000, "foo_2")))
+
+			err := putFoo(kvStore, indexStore, newFooEnt(3, 9000, "foo_3"))
+			assert.Equal(t, influxdb.ETooManyRequests, influxdb.ErrorCode(err))
+			err = kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+			})
+			assert.Equal(t, influxdb.ETooManyRequests, influxdb.ErrorCode(err))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(3)})
+				isNotFoundErr(t, err)
+				return nil
+			})
+
+			time.Sleep(every + every/2)
+			require.NoError(t, putFoo(kvStore, indexStore, newFooEnt(3, 9000, "foo_3")))
+		})
+
+		t.Run("limits each resource on its own", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "rate_limit")
+			defer done()
+
+			limiter := kv.NewResourceRateLimiter(rate.Every(time.Hour), 1)
+			indexStore.RateLimiter = limiter
+			require.NoError(t, putFoo(kvStore, indexStore, newFooEnt(1, 9000, "foo_1")))
+			err := putFoo(kvStore, indexStore, newFooEnt(2, 9000, "foo_2"))
+			assert.Equal(t, influxdb.ETooManyRequests, influxdb.ErrorCode(err))
+
+			assert.True(t, limiter.AllowWrite("bar"))
+		})
+
+		t.Run("nil limiter allows every write", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "rate_limit")
+			defer done()
+
+			for i := 1; i <= 10; i++ {
+				require.NoError(t, putFoo(kvStore, indexStore, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i))))
+			}
+		})
+	})
+
+	t.Run("RebuildIndexIncremental", func(t *testing.T) {
+		newRebuildStore := func(t *testing.T) (*kv.IndexStore, func(), kv.Store, []kv.Entity) {
+			t.Helper()
+
+			indexStore, done, kvStore := newFooIndexStore(t, "rebuild")
+			err := migration.CreateBuckets("add rebuild checkpoint bucket", []byte("foo_rebuild_checkpoints")).Up(context.Background(), kvStore.(kv.SchemaStore))
+			require.NoError(t, err)
+
+			var ents []kv.Entity
+			for i := 1; i <= 5; i++ {
+				ents = append(ents, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i)))
+			}
+			seedEnts(t, kvStore, indexStore, ents...)
+
+			// drop the index so there is something to rebuild
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.IndexStore.Delete(context.TODO(), tx, kv.DeleteOpts{
+					FilterFn: func(k []byte, v interface{}) bool { return true },
+				})
+			})
+			return indexStore, done, kvStore, ents
+		}
+
+		opts := kv.RebuildOpts{BatchSize: 2, CheckpointBucket: []byte("foo_rebuild_checkpoints")}
+
+		indexed := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity) bool {
+			t.Helper()
+
+			var found bool
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+				found = err == nil
+				return nil
+			})
+			return found
+		}
+
+		t.Run("indexes every entity in batches", func(t *testing.T) {
+			indexStore, done, kvStore, ents := newRebuildStore(t)
+			defer done()
+
+			store := &countingUpdateStore{Store: kvStore}
+			collisions, err := kv.RebuildIndexIncremental(context.TODO(), store, indexStore, opts)
+			require.NoError(t, err)
+			assert.Empty(t, collisions)
+			assert.Equal(t, 3, store.updates)
+
+			for _, ent := range ents {
+				assert.True(t, indexed(t, kvStore, indexStore, ent))
+			}
+			view(t, kvStore, func(tx kv.Tx) error {
+				inconsistencies, err := indexStore.Verify(context.TODO(), tx)
+				require.NoError(t, err)
+				assert.Empty(t, inconsistencies)
+				return nil
+			})
+		})
+
+		t.Run("resumes after an interruption", func(t *testing.T) {
+			indexStore, done, kvStore, ents := newRebuildStore(t)
+			defer done()
+
+			store := &countingUpdateStore{Store: kvStore, failAt: 2}
+			_, err := kv.RebuildIndexIncremental(context.TODO(), store, indexStore, opts)
+			require.Error(t, err)
+
+			// the first batch was committed, the second rolled back
+			assert.True(t, indexed(t, kvStore, indexStore, ents[1]))
+			assert.False(t, indexed(t, kvStore, indexStore, ents[2]))
+
+			// the resumed rebuild starts after the first batch; dropping an index
+			// entry of it shows the batch is not indexed again
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.IndexStore.DeleteEnt(context.TODO(), tx, kv.Entity{UniqueKey: ents[0].UniqueKey})
+			})
+
+			store = &countingUpdateStore{Store: kvStore}
+			collisions, err := kv.RebuildIndexIncremental(context.TODO(), store, indexStore, opts)
+			require.NoError(t, err)
+			assert.Empty(t, collisions)
+			assert.Equal(t, 2, store.updates)
+
+			assert.False(t, indexed(t, kvStore, indexStore, ents[0]))
+			for _, ent := range ents[1:] {
+				assert.True(t, indexed(t, kvStore, indexStore, ent))
+			}
+
+			// the checkpoint is removed once done, so the next rebuild starts over
+			_, err = kv.RebuildIndexIncremental(context.TODO(), kvStore, indexStore, opts)
+			require.NoError(t, err)
+			assert.True(t, indexed(t, kvStore, indexStore, ents[0]))
+		})
+
+		t.Run("collisions are reported at the end", func(t *testing.T) {
+			indexStore, done, kvStore, ents := newRebuildStore(t)
+			defer done()
+
+			// point the index key of foo_2 at another entity
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.IndexStore.Put(context.TODO(), tx, kv.Entity{PK: kv.EncID(9), UniqueKey: ents[1].UniqueKey})
+			})
+
+			collisions, err := kv.RebuildIndexIncremental(context.TODO(), kvStore, indexStore, opts)
+			require.NoError(t, err)
+			assert.Equal(t, []kv.IndexInconsistency{
+				{Kind: kv.IndexCollision, Index: kv.DefaultIndexName, Key: encodeID(t, 2)},
+			}, collisions)
+
+			for _, ent := range append([]kv.Entity{ents[0]}, ents[2:]...) {
+				assert.True(t, indexed(t, kvStore, indexStore, ent))
+			}
+		})
+
+		t.Run("requires a batch size", func(t *testing.T) {
+			indexStore, done, kvStore, _ := newRebuildStore(t)
+			defer done()
+
+			_, err := kv.RebuildIndexIncremental(context.TODO(), kvStore, indexStore, kv.RebuildOpts{})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("BackfillIndex", func(t *testing.T) {
+		deriveIndexEnt := func(ent kv.Entity) kv.Entity {
+			return kv.Entity{PK: ent.PK, UniqueKey: ent.UniqueKey}
+		}
+
+		backfill := func(kvStore kv.Store, indexStore *kv.IndexStore) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return kv.BackfillIndex(context.TODO(), tx, indexStore.EntStore, indexStore.IndexStore, deriveIndexEnt)
+			})
+		}
+
+		t.Run("indexes every entity and is safe to re-run", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "backfill")
+			defer done()
+
+			ents := []kv.Entity{
+				newFooEnt(1, 9000, "foo_1"),
+				newFooEnt(2, 9000, "foo_2"),
+				newFooEnt(3, 9001, "foo_1"),
+			}
+			// a partial backfill, only the first entity is indexed
+			seedEnts(t, kvStore, indexStore, ents[0])
+			update(t, kvStore, func(tx kv.Tx) error {
+				for _, ent := range ents[1:] {
+					if err := indexStore.EntStore.Put(context.TODO(), tx, ent); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+			require.NoError(t, backfill(kvStore, indexStore))
+			require.NoError(t, backfill(kvStore, indexStore))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				for _, ent := range ents {
+					actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+					require.NoError(t, err)
+					assert.Equal(t, ent.Body, actual)
+				}
+				inconsistencies, err := indexStore.Verify(context.TODO(), tx)
+				require.NoError(t, err)
+				assert.Empty(t, inconsistencies)
+				return nil
+			})
+		})
+
+		t.Run("re-run on a versioned index store", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "backfill_versioned")
+			defer done()
+			indexStore.IndexStore.WithSchemaVersion(1)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				for _, ent := range []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2")} {
+					if err := indexStore.EntStore.Put(context.TODO(), tx, ent); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+			require.NoError(t, backfill(kvStore, indexStore))
+			require.NoError(t, backfill(kvStore, indexStore))
+		})
+
+		t.Run("fails when entities share an index key", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "backfill")
+			defer done()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				for _, ent := range []kv.Entity{
+					newFooEnt(1, 9000, "foo_1"),
+					newFooEnt(2, 9000, "foo_1"),
+				} {
+					if err := indexStore.EntStore.Put(context.TODO(), tx, ent); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+			err := backfill(kvStore, indexStore)
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+		})
+
+		t.Run("follows puts skipping the index", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "backfill_skip_index")
+			defer done()
+
+			ents := []kv.Entity{
+				newFooEnt(1, 9000, "foo_1"),
+				newFooEnt(2, 9000, "foo_2"),
+				newFooEnt(3, 9001, "foo_1"),
+			}
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.PutMany(context.TODO(), tx, ents, kv.PutSkipIndex())
+			})
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				n, err := indexStore.IndexStore.Count(context.TODO(), tx, nil)
+				require.NoError(t, err)
+				assert.Zero(t, n)
+				return nil
+			})
+
+			require.NoError(t, backfill(kvStore, indexStore))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				for _, ent := range ents {
+					actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+					require.NoError(t, err)
+					assert.Equal(t, ent.Body, actual)
+				}
+				inconsistencies, err := indexStore.Verify(context.TODO(), tx)
+				require.NoError(t, err)
+				assert.Empty(t, inconsistencies)
+				return nil
+			})
+		})
+
+		t.Run("skipping the index can not be combined with validation", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "backfill_skip_index")
+			defer done()
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1"), kv.PutSkipIndex(), kv.PutNew())
+			})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("Verify and Repair", func(t *testing.T) {
+		newInconsistentStore := func(t *testing.T) (*kv.IndexStore, func(), kv.Store) {
+			t.Helper()
+
+			indexStore, done, kvStore := newFooIndexStore(t, "verify")
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				// index key for an entity that does not exist
+				if err := indexStore.IndexStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "orphan")); err != nil {
+					return err
+				}
+				// entity that does not have an index entry
+				return indexStore.EntStore.Put(context.TODO(), tx, newFooEnt(3, 9000, "unindexed"))
+			})
+			return indexStore, done, kvStore
+		}
+
+		verify := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore) []kv.IndexInconsistency {
+			t.Helper()
+
+			var inconsistencies []kv.IndexInconsistency
+			view(t, kvStore, func(tx kv.Tx) error {
+				found, err := indexStore.Verify(context.TODO(), tx)
+				inconsistencies = found
+				return err
+			})
+			return inconsistencies
+		}
+
+		orphanKey, err := kv.Encode(kv.EncID(9000), kv.EncString("orphan"))()
+		require.NoError(t, err)
+
+		expectedOrphan := kv.IndexInconsistency{
+			Kind:  kv.OrphanedIndex,
+			Index: kv.DefaultIndexName,
+			Key:   orphanKey,
+		}
+		expectedUnindexed := kv.IndexInconsistency{
+			Kind:  kv.UnindexedEntity,
+			Index: kv.DefaultIndexName,
+			Key:   encodeID(t, 3),
+		}
+
+		t.Run("verify reports orphans and unindexed entities", func(t *testing.T) {
+			indexStore, done, kvStore := newInconsistentStore(t)
+			defer done()
+
+			actual := verify(t, kvStore, indexStore)
+			assert.ElementsMatch(t, []kv.IndexInconsistency{expectedOrphan, expectedUnindexed}, actual)
+		})
+
+		t.Run("consistent store reports nothing", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "verify")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+
+			assert.Empty(t, verify(t, kvStore, indexStore))
+		})
+
+		t.Run("repair deletes orphans", func(t *testing.T) {
+			indexStore, done, kvStore := newInconsistentStore(t)
+			defer done()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				repaired, err := indexStore.Repair(context.TODO(), tx, kv.RepairDeleteOrphans)
+				assert.Equal(t, []kv.IndexInconsistency{expectedOrphan}, repaired)
+				return err
+			})
+
+			assert.Equal(t, []kv.IndexInconsistency{expectedUnindexed}, verify(t, kvStore, indexStore))
+		})
+
+		t.Run("repair rebuilds the index", func(t *testing.T) {
+			indexStore, done, kvStore := newInconsistentStore(t)
+			defer done()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				repaired, err := indexStore.Repair(context.TODO(), tx, kv.RepairRebuildIndex)
+				assert.Equal(t, []kv.IndexInconsistency{expectedOrphan, expectedUnindexed}, repaired)
+				return err
+			})
+
+			assert.Empty(t, verify(t, kvStore, indexStore))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{
+					UniqueKey: kv.Encode(kv.EncID(9000), kv.EncString("unindexed")),
+				})
+				require.NoError(t, err)
+				assert.Equal(t, newFooEnt(3, 9000, "unindexed").Body, actual)
+				return nil
+			})
+		})
+
+		t.Run("repair fails when entities share an index key", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "verify")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.EntStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_1"))
+			})
+
+			assert.Equal(t, []kv.IndexInconsistency{{
+				Kind:  kv.UnindexedEntity,
+				Index: kv.DefaultIndexName,
+				Key:   encodeID(t, 2),
+			}}, verify(t, kvStore, indexStore))
+
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, err := indexStore.Repair(context.TODO(), tx, kv.RepairRebuildIndex)
+				return err
+			})
+			require.Error(t, err)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+		})
+
+		t.Run("soft deleted entities are not unindexed", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "verify")
+			defer done()
+
+			tombstoneBucket := []byte("foo_tombstone")
+			require.NoError(t, migration.CreateBuckets("add foo tombstone bucket", tombstoneBucket).Up(context.Background(), kvStore.(kv.SchemaStore)))
+			indexStore.TombstoneStore = kv.NewTombstoneStore("foo", tombstoneBucket)
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)}, kv.DeleteEntSoft())
+			})
+			// the name of the soft deleted entity is reused
+			seedEnts(t, kvStore, indexStore, newFooEnt(3, 9000, "foo_2"))
+
+			assert.Empty(t, verify(t, kvStore, indexStore))
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				repaired, err := indexStore.Repair(context.TODO(), tx, kv.RepairRebuildIndex)
+				assert.Empty(t, repaired)
+				return err
+			})
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+				isNotFoundErr(t, err)
+
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{
+					UniqueKey: kv.Encode(kv.EncID(9000), kv.EncString("foo_2")),
+				})
+				require.NoError(t, err)
+				assert.Equal(t, newFooEnt(3, 9000, "foo_2").Body, actual)
+				return nil
+			})
+		})
+	})
+
+	t.Run("IndexVerifier", func(t *testing.T) {
+		newInconsistentStore := func(t *testing.T) (*kv.IndexStore, func(), kv.Store) {
+			t.Helper()
+
+			indexStore, done, kvStore := newFooIndexStore(t, "verifier")
+
+			// spans more than a single batch of the verifier
+			var ents []kv.Entity
+			for i := 1; i <= 150; i++ {
+				ents = append(ents, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%03d", i)))
+			}
+			seedEnts(t, kvStore, indexStore, ents...)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				if err := indexStore.IndexStore.Put(context.TODO(), tx, newFooEnt(900, 9000, "orphan")); err != nil {
+					return err
+				}
+				return indexStore.EntStore.Put(context.TODO(), tx, newFooEnt(901, 9000, "unindexed"))
+			})
+			return indexStore, done, kvStore
+		}
+
+		counter := func(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+			t.Helper()
+
+			m := promtest.FindMetric(promtest.MustGather(t, reg), name, labels)
+			if m == nil {
+				return 0
+			}
+			return m.GetCounter().GetValue()
+		}
+
+		indexLabels := map[string]string{"resource": "foo", "index": kv.DefaultIndexName}
+
+		t.Run("counts injected inconsistencies", func(t *testing.T) {
+			indexStore, done, kvStore := newInconsistentStore(t)
+			defer done()
+
+			verifier := kv.NewIndexVerifier(kvStore, time.Hour, 1, indexStore)
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(verifier.PrometheusCollectors()...)
+
+			require.NoError(t, verifier.VerifyOnce(context.Background()))
+			assert.Equal(t, float64(1), counter(t, reg, "kv_index_verifier_orphaned_index_keys_total", indexLabels))
+			assert.Equal(t, float64(1), counter(t, reg, "kv_index_verifier_unindexed_entities_total", indexLabels))
+			assert.Equal(t, float64(302), counter(t, reg, "kv_index_verifier_sampled_total", map[string]string{"resource": "foo"}))
+		})
+
+		t.Run("samples a fraction of the records", func(t *testing.T) {
+			indexStore, done, kvStore := newInconsistentStore(t)
+			defer done()
+
+			verifier := kv.NewIndexVerifier(kvStore, time.Hour, 0.5, indexStore)
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(verifier.PrometheusCollectors()...)
+
+			require.NoError(t, verifier.VerifyOnce(context.Background()))
+			sampled := counter(t, reg, "kv_index_verifier_sampled_total", map[string]string{"resource": "foo"})
+			assert.True(t, sampled > 0 && sampled < 302, "sampled %v of 302 records", sampled)
+		})
+
+		t.Run("runs on its interval until stopped", func(t *testing.T) {
+			indexStore, done, kvStore := newInconsistentStore(t)
+			defer done()
+
+			verifier := kv.NewIndexVerifier(kvStore, time.Millisecond, 1, indexStore)
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(verifier.PrometheusCollectors()...)
+
+			verifier.Start(context.Background())
+			require.Eventually(t, func() bool {
+				return counter(t, reg, "kv_index_verifier_orphaned_index_keys_total", indexLabels) >= 2
+			}, 5*time.Second, time.Millisecond)
+			verifier.Stop()
+
+			stopped := counter(t, reg, "kv_index_verifier_orphaned_index_keys_total", indexLabels)
+			time.Sleep(10 * time.Millisecond)
+			assert.Equal(t, stopped, counter(t, reg, "kv_index_verifier_orphaned_index_keys_total", indexLabels))
+		})
+
+		t.Run("stops once the context is canceled", func(t *testing.T) {
+			indexStore, done, kvStore := newInconsistentStore(t)
+			defer done()
+
+			verifier := kv.NewIndexVerifier(kvStore, time.Millisecond, 1, indexStore)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := verifier.VerifyOnce(ctx)
+			assert.Equal(t, influxdb.ECanceled, influxdb.ErrorCode(err))
+
+			verifier.Start(ctx)
+			verifier.Stop()
+		})
+	})
+
+	t.Run("Find", func(t *testing.T) {
+		t.Run("base", func(t *testing.T) {
+			fn := func(t *testing.T, suffix string) (storeBase, func(), kv.Store) {
+				return newFooIndexStore(t, suffix)
+			}
+
+			testFind(t, fn)
+		})
+
+		t.Run("with entity filter", func(t *testing.T) {
+			base, done, kvStore := newFooIndexStore(t, "find_index_search")
+			defer done()
+
+			expectedEnts := []kv.Entity{
+				newFooEnt(1, 9000, "foo_0"),
+				newFooEnt(2, 9001, "foo_1"),
+				newFooEnt(3, 9003, "foo_2"),
+			}
+
+			seedEnts(t, kvStore, base, expectedEnts...)
+
+			var actuals []interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return base.Find(context.TODO(), tx, kv.FindOpts{
+					FilterEntFn: func(key []byte, decodedVal interface{}) bool {
+						return decodedVal.(foo).ID < 3
+					},
+					CaptureFn: func(key []byte, decodedVal interface{}) error {
+						actuals = append(actuals, decodedVal)
+						return nil
+					},
+				})
+			})
+
+			expected := []interface{}{
+				expectedEnts[0].Body,
+				expectedEnts[1].Body,
+			}
+			assert.Equal(t, expected, actuals)
+		})
+	})
+
+	t.Run("FindIndex", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "find_index")
+		defer done()
+
+		var expected []interface{}
+		for i := 1; i <= 150; i++ {
+			ent := newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("bucket_%03d", i))
+			expected = append(expected, ent.Body)
+			seedEnts(t, kvStore, indexStore, ent)
+		}
+		seedEnts(t, kvStore, indexStore, newFooEnt(500, 9000, "other"), newFooEnt(501, 9001, "bucket_001"))
+
+		prefix, err := kv.Encode(kv.EncID(9000), kv.EncString("bucket_"))()
+		require.NoError(t, err)
+
+		findIndex := func(t *testing.T, opts kv.FindOpts) []interface{} {
+			t.Helper()
+
+			var actual []interface{}
+			opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+				actual = append(actual, decodedVal)
+				return nil
+			}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.FindIndex(context.TODO(), tx, opts)
+			})
+			return actual
+		}
+
+		t.Run("by name prefix", func(t *testing.T) {
+			assert.Equal(t, expected, findIndex(t, kv.FindOpts{Prefix: prefix}))
+		})
+
+		t.Run("descending", func(t *testing.T) {
+			assert.Equal(t, reverseSlc(append([]interface{}(nil), expected...)), findIndex(t, kv.FindOpts{Prefix: prefix, Descending: true}))
+		})
+
+		t.Run("with offset and limit", func(t *testing.T) {
+			assert.Equal(t, expected[99:104], findIndex(t, kv.FindOpts{Prefix: prefix, Offset: 99, Limit: 5}))
+		})
+
+		t.Run("with entity filter", func(t *testing.T) {
+			actual := findIndex(t, kv.FindOpts{
+				Prefix: prefix,
+				Limit:  2,
+				FilterEntFn: func(key []byte, decodedVal interface{}) bool {
+					return decodedVal.(foo).ID%50 == 0
+				},
+			})
+			assert.Equal(t, []interface{}{expected[49], expected[99]}, actual)
+		})
+
+		t.Run("with decoded filter", func(t *testing.T) {
+			actual := findIndex(t, kv.FindOpts{
+				Prefix: prefix,
+				Limit:  2,
+				FilterDecodedFn: func(ent kv.Entity) bool {
+					return ent.Body.(foo).ID%50 == 0
+				},
+			})
+			assert.Equal(t, []interface{}{expected[49], expected[99]}, actual)
+		})
+
+		t.Run("skips orphaned index entries", func(t *testing.T) {
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.EntStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+			})
+			assert.Equal(t, expected[1:], findIndex(t, kv.FindOpts{Prefix: prefix}))
+		})
+	})
+
+	t.Run("DeletePrefix", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "delete_prefix")
+		defer done()
+
+		// the IDs 0x10 through 0x1f share a key prefix
+		var removed []kv.Entity
+		for i := 0x10; i <= 0x1f; i++ {
+			removed = append(removed, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%d", i)))
+		}
+		kept := []kv.Entity{newFooEnt(0x0f, 9000, "foo_kept_1"), newFooEnt(0x20, 9000, "foo_kept_2")}
+		seedEnts(t, kvStore, indexStore, append(removed, kept...)...)
+
+		prefix := encodeID(t, 0x10)[:influxdb.IDLength-1]
+
+		var n int
+		update(t, kvStore, func(tx kv.Tx) error {
+			var err error
+			n, err = indexStore.DeletePrefix(context.TODO(), tx, prefix)
+			return err
+		})
+		assert.Equal(t, len(removed), n)
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			for _, ent := range removed {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+				isNotFoundErr(t, err)
+				_, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+				isNotFoundErr(t, err)
+			}
+			for _, ent := range kept {
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, ent.Body, actual)
+			}
+
+			inconsistencies, err := indexStore.Verify(context.TODO(), tx)
+			require.NoError(t, err)
+			assert.Empty(t, inconsistencies)
+
+			n, err := indexStore.CountIndex(context.TODO(), tx, kv.DefaultIndexName, nil)
+			require.NoError(t, err)
+			assert.Equal(t, len(kept), n)
+			return nil
+		})
+
+		t.Run("requires a prefix", func(t *testing.T) {
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				_, err := indexStore.DeletePrefix(context.TODO(), tx, nil)
+				return err
+			})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+	})
+
+	t.Run("CopyStore", func(t *testing.T) {
+		newCopyStores := func(t *testing.T) (*kv.IndexStore, *kv.IndexStore, func(), kv.Store) {
+			t.Helper()
+
+			src, done, kvStore := newFooIndexStore(t, "copy_src")
+
+			entBkt, idxBkt := []byte("foo_ent_copy_dst"), []byte("foo_idx+copy_dst")
+			err := migration.CreateBuckets("add foo dst buckets", entBkt, idxBkt).Up(context.Background(), kvStore.(kv.SchemaStore))
+			require.NoError(t, err)
+
+			dst := &kv.IndexStore{
+				Resource:   "foo",
+				EntStore:   kv.NewStoreBase("foo", entBkt, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn),
+				IndexStore: kv.NewOrgNameKeyStore("foo", idxBkt, false),
+			}
+			return src, dst, done, kvStore
+		}
+
+		copyStore := func(kvStore kv.Store, src, dst *kv.IndexStore, transform kv.CopyTransformFn) error {
+			return kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				return kv.CopyStore(context.TODO(), tx, src, tx, dst, transform)
+			})
+		}
+
+		verifyIndex := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore) {
+			t.Helper()
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				inconsistencies, err := indexStore.Verify(context.TODO(), tx)
+				require.NoError(t, err)
+				assert.Empty(t, inconsistencies)
+				return nil
+			})
+		}
+
+		t.Run("copies entities as is", func(t *testing.T) {
+			src, dst, done, kvStore := newCopyStores(t)
+			defer done()
+
+			ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2")}
+			seedEnts(t, kvStore, src, ents...)
+
+			require.NoError(t, copyStore(kvStore, src, dst, nil))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				for _, ent := range ents {
+					actual, err := dst.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+					require.NoError(t, err)
+					assert.Equal(t, ent.Body, actual)
+				}
+				return nil
+			})
+			verifyIndex(t, kvStore, dst)
+		})
+
+		t.Run("copies entities through the transform", func(t *testing.T) {
+			src, dst, done, kvStore := newCopyStores(t)
+			defer done()
+
+			seedEnts(t, kvStore, src, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+
+			rewriteOrgID := func(ent kv.Entity) (kv.Entity, error) {
+				f := ent.Body.(foo)
+				return newFooEnt(f.ID+10, 9001, f.Name), nil
+			}
+			require.NoError(t, copyStore(kvStore, src, dst, rewriteOrgID))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := dst.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: kv.Encode(kv.EncID(9001), kv.EncString("foo_2"))})
+				require.NoError(t, err)
+				assert.Equal(t, newFooEnt(12, 9001, "foo_2").Body, actual)
+
+				_, err = dst.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: kv.Encode(kv.EncID(9000), kv.EncString("foo_2"))})
+				isNotFoundErr(t, err)
+				return nil
+			})
+			verifyIndex(t, kvStore, dst)
+		})
+
+		t.Run("collision in the destination fails the copy", func(t *testing.T) {
+			src, dst, done, kvStore := newCopyStores(t)
+			defer done()
+
+			seedEnts(t, kvStore, src, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+			seedEnts(t, kvStore, dst, newFooEnt(3, 9000, "foo_2"))
+
+			err := copyStore(kvStore, src, dst, nil)
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := dst.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				isNotFoundErr(t, err)
+				return nil
+			})
+		})
+
+		t.Run("transform error fails the copy", func(t *testing.T) {
+			src, dst, done, kvStore := newCopyStores(t)
+			defer done()
+
+			seedEnts(t, kvStore, src, newFooEnt(1, 9000, "foo_1"))
+
+			err := copyStore(kvStore, src, dst, func(ent kv.Entity) (kv.Entity, error) {
+				return kv.Entity{}, errors.New("transform failed")
+			})
+			assert.EqualError(t, err, "transform failed")
+		})
+	})
+
+	t.Run("Find and FindIndex order", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "order")
+		defer done()
+		indexStore.EntStore.DecodedNameFn = func(decodedVal interface{}) string {
+			return decodedVal.(foo).Name
+		}
+
+		// the index keys sort by org, then by name
+		seedEnts(t, kvStore, indexStore,
+			newFooEnt(3, 9000, "foo_a"),
+			newFooEnt(1, 9001, "foo_b"),
+			newFooEnt(2, 9000, "foo_c"),
+		)
+
+		find := func(t *testing.T, findFn func(context.Context, kv.Tx, kv.FindOpts) error, opts kv.FindOpts) []influxdb.ID {
+			t.Helper()
+
+			var ids []influxdb.ID
+			opts.CaptureFn = func(key []byte, decodedVal interface{}) error {
+				ids = append(ids, decodedVal.(foo).ID)
+				return nil
+			}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return findFn(context.TODO(), tx, opts)
+			})
+			return ids
+		}
+
+		t.Run("Find is in key order", func(t *testing.T) {
+			assert.Equal(t, []influxdb.ID{1, 2, 3}, find(t, indexStore.Find, kv.FindOpts{}))
+		})
+
+		t.Run("FindIndex is in index key order", func(t *testing.T) {
+			assert.Equal(t, []influxdb.ID{3, 2, 1}, find(t, indexStore.FindIndex, kv.FindOpts{}))
+			assert.Equal(t, []influxdb.ID{1, 2, 3}, find(t, indexStore.FindIndex, kv.FindOpts{Descending: true}))
+		})
+
+		t.Run("both sort by name", func(t *testing.T) {
+			opts := kv.FindOpts{Sort: kv.SortByName}
+			assert.Equal(t, []influxdb.ID{3, 1, 2}, find(t, indexStore.Find, opts))
+			assert.Equal(t, []influxdb.ID{3, 1, 2}, find(t, indexStore.FindIndex, opts))
+
+			opts.Limit = 2
+			assert.Equal(t, []influxdb.ID{3, 1}, find(t, indexStore.FindIndex, opts))
+		})
+	})
+
+	t.Run("ListIndexKeys", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "list_keys")
+		defer done()
+
+		ents := []kv.Entity{
+			newFooEnt(1, 9000, "foo_b"),
+			newFooEnt(2, 9000, "foo_a"),
+			newFooEnt(3, 9001, "foo_c"),
+		}
+		seedEnts(t, kvStore, indexStore, ents...)
+
+		indexKey := func(ent kv.Entity) []byte {
+			key, err := ent.UniqueKey()
+			require.NoError(t, err)
+			return key
+		}
+
+		t.Run("every key", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				keys, err := indexStore.ListIndexKeys(context.TODO(), tx, nil)
+				require.NoError(t, err)
+				assert.Equal(t, [][]byte{indexKey(ents[1]), indexKey(ents[0]), indexKey(ents[2])}, keys)
+				return nil
+			})
+		})
+
+		t.Run("keys with the prefix", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				keys, err := indexStore.ListIndexKeys(context.TODO(), tx, encodeID(t, 9000))
+				require.NoError(t, err)
+				assert.Equal(t, [][]byte{indexKey(ents[1]), indexKey(ents[0])}, keys)
+
+				keys, err = indexStore.ListIndexKeys(context.TODO(), tx, encodeID(t, 9002))
+				require.NoError(t, err)
+				assert.Empty(t, keys)
+				return nil
+			})
+		})
+
+		t.Run("readable keys", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				keys, err := indexStore.ListIndexKeyStrings(context.TODO(), tx, encodeID(t, 9001))
+				require.NoError(t, err)
+				assert.Equal(t, []string{fmt.Sprintf("org=%s/name=foo_c", influxdb.ID(9001))}, keys)
+				return nil
+			})
+		})
+	})
+
+	t.Run("Dump", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "dump")
+		defer done()
+
+		ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9001, "foo_3")}
+		seedEnts(t, kvStore, indexStore, ents...)
+
+		dump := func(t *testing.T, format kv.DumpFormat) string {
+			t.Helper()
+
+			var buf bytes.Buffer
+			view(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Dump(context.TODO(), tx, &buf, format)
+			})
+			return buf.String()
+		}
+
+		t.Run("json", func(t *testing.T) {
+			dec := json.NewDecoder(strings.NewReader(dump(t, kv.DumpJSON)))
+
+			var records []kv.DumpRecord
+			for dec.More() {
+				var record kv.DumpRecord
+				require.NoError(t, dec.Decode(&record))
+				records = append(records, record)
+			}
+			require.Len(t, records, 2*len(ents))
+
+			for i, ent := range ents {
+				entRecord, idxRecord := records[i], records[len(ents)+i]
+
+				assert.Equal(t, "foo_ent_dump", entRecord.Bucket)
+				assert.Equal(t, hex.EncodeToString(encodeID(t, ent.Body.(foo).ID)), entRecord.KeyHex)
+				assert.Equal(t, ent.Body.(foo).Name, entRecord.Decoded.(map[string]interface{})["Name"])
+				assert.Empty(t, entRecord.DecodeErr)
+
+				f := ent.Body.(foo)
+				assert.Equal(t, "foo_idx+dump", idxRecord.Bucket)
+				assert.Equal(t, fmt.Sprintf("org=%s/name=%s", f.OrgID, f.Name), idxRecord.Key)
+				assert.Equal(t, f.ID.String(), idxRecord.Decoded)
+			}
+		})
+
+		t.Run("text", func(t *testing.T) {
+			lines := strings.Split(strings.TrimSuffix(dump(t, kv.DumpText), "\n"), "\n")
+			require.Len(t, lines, 2*len(ents))
+			for i, ent := range ents {
+				assert.Contains(t, lines[i], `"Name":"`+ent.Body.(foo).Name+`"`)
+			}
+		})
+
+		t.Run("undecodable values are dumped with their error", func(t *testing.T) {
+			update(t, kvStore, func(tx kv.Tx) error {
+				b, err := tx.Bucket([]byte("foo_ent_dump"))
+				if err != nil {
+					return err
+				}
+				return b.Put(encodeID(t, 4), []byte("{not json"))
+			})
+
+			records := strings.Split(strings.TrimSuffix(dump(t, kv.DumpJSON), "\n"), "\n")
+			var record kv.DumpRecord
+			require.NoError(t, json.Unmarshal([]byte(records[len(ents)]), &record))
+			assert.Equal(t, hex.EncodeToString([]byte("{not json")), record.ValueHex)
+			assert.Nil(t, record.Decoded)
+			assert.NotEmpty(t, record.DecodeErr)
+		})
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		srcStore, done, srcKV := newFooIndexStore(t, "snap_src")
+		defer done()
+
+		ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9001, "foo_3")}
+		seedEnts(t, srcKV, srcStore, ents...)
+
+		var snapshot bytes.Buffer
+		view(t, srcKV, func(tx kv.Tx) error {
+			return srcStore.Snapshot(context.TODO(), tx, &snapshot)
+		})
+
+		restore := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore, stream string) error {
+			t.Helper()
+
+			return kvStore.Update(context.Background(), func(tx kv.Tx) error {
+				return indexStore.RestoreSnapshot(context.TODO(), tx, strings.NewReader(stream))
+			})
+		}
+
+		t.Run("round trips into empty buckets", func(t *testing.T) {
+			dstStore, done, dstKV := newFooIndexStore(t, "snap_dst")
+			defer done()
+
+			require.NoError(t, restore(t, dstKV, dstStore, snapshot.String()))
+
+			view(t, dstKV, func(tx kv.Tx) error {
+				for _, ent := range ents {
+					actual, err := dstStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+					require.NoError(t, err)
+					assert.Equal(t, ent.Body, actual)
+				}
+
+				inconsistencies, err := dstStore.Verify(context.TODO(), tx)
+				require.NoError(t, err)
+				assert.Empty(t, inconsistencies)
+				return nil
+			})
+		})
+
+		t.Run("non empty buckets are rejected", func(t *testing.T) {
+			err := restore(t, srcKV, srcStore, snapshot.String())
+			assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+		})
+
+		t.Run("round trips soft deleted entities and versions", func(t *testing.T) {
+			newTrackedStore := func(t *testing.T, suffix string) (*kv.IndexStore, func(), kv.Store) {
+				t.Helper()
+
+				indexStore, done, kvStore := newFooIndexStore(t, suffix)
+				tombstoneBucket, versionBucket := []byte("foo_tombstone_"+suffix), []byte("foo_version_"+suffix)
+				require.NoError(t, migration.CreateBuckets("add foo tracking buckets", tombstoneBucket, versionBucket).Up(context.Background(), kvStore.(kv.SchemaStore)))
+				indexStore.TombstoneStore = kv.NewTombstoneStore("foo", tombstoneBucket)
+				indexStore.VersionStore = kv.NewVersionStore("foo", versionBucket)
+				return indexStore, done, kvStore
+			}
+
+			trackedSrc, done, trackedKV := newTrackedStore(t, "snap_tracked_src")
+			defer done()
+
+			seedEnts(t, trackedKV, trackedSrc, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+			seedEnts(t, trackedKV, trackedSrc, newFooEnt(1, 9000, "foo_1"))
+			update(t, trackedKV, func(tx kv.Tx) error {
+				return trackedSrc.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)}, kv.DeleteEntSoft())
+			})
+
+			var tracked bytes.Buffer
+			view(t, trackedKV, func(tx kv.Tx) error {
+				return trackedSrc.Snapshot(context.TODO(), tx, &tracked)
+			})
+
+			t.Run("store without the tracking stores is rejected", func(t *testing.T) {
+				dstStore, done, dstKV := newFooIndexStore(t, "snap_untracked_dst")
+				defer done()
+
+				err := restore(t, dstKV, dstStore, tracked.String())
+				assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+			})
+
+			dstStore, done, dstKV := newTrackedStore(t, "snap_tracked_dst")
+			defer done()
+
+			require.NoError(t, restore(t, dstKV, dstStore, tracked.String()))
+
+			view(t, dstKV, func(tx kv.Tx) error {
+				_, err := dstStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+				isNotFoundErr(t, err)
+
+				version, err := dstStore.Version(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				require.NoError(t, err)
+				assert.Equal(t, 2, version)
+				return nil
+			})
+
+			update(t, dstKV, func(tx kv.Tx) error {
+				return dstStore.Restore(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+			})
+			view(t, dstKV, func(tx kv.Tx) error {
+				actual, err := dstStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(2, 9000, "foo_2").UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, newFooEnt(2, 9000, "foo_2").Body, actual)
+				return nil
+			})
+		})
+
+		t.Run("truncated snapshot aborts the restore", func(t *testing.T) {
+			dstStore, done, dstKV := newFooIndexStore(t, "snap_trunc")
+			defer done()
+
+			lines := strings.SplitAfter(snapshot.String(), "\n")
+			err := restore(t, dstKV, dstStore, strings.Join(lines[:3], ""))
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+
+			view(t, dstKV, func(tx kv.Tx) error {
+				n, err := dstStore.Count(context.TODO(), tx, nil)
+				require.NoError(t, err)
+				assert.Zero(t, n)
+				return nil
+			})
+		})
+
+		t.Run("index mismatch aborts the restore", func(t *testing.T) {
+			dstStore, done, dstKV := newFooIndexStore(t, "snap_mismatch")
+			defer done()
+
+			// drop the index record of the last entity, keeping the count of
+			// the snapshot's last line in step
+			lines := strings.SplitAfter(strings.TrimSuffix(snapshot.String(), "\n"), "\n")
+			tampered := append([]string(nil), lines[:len(lines)-2]...)
+			tampered = append(tampered, fmt.Sprintf(`{"b":0,"end":true,"records":%d}`+"\n", 2*len(ents)-1))
+
+			err := restore(t, dstKV, dstStore, strings.Join(tampered, ""))
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+			assert.Contains(t, err.Error(), string(kv.UnindexedEntity))
+
+			view(t, dstKV, func(tx kv.Tx) error {
+				n, err := dstStore.Count(context.TODO(), tx, nil)
+				require.NoError(t, err)
+				assert.Zero(t, n)
+				return nil
+			})
+		})
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		stats := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore) kv.StoreStats {
+			t.Helper()
+
+			var stats kv.StoreStats
+			view(t, kvStore, func(tx kv.Tx) error {
+				var err error
+				stats, err = indexStore.Stats(context.TODO(), tx)
+				return err
+			})
+			return stats
+		}
+
+		t.Run("counts entities and index entries", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "stats")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3"))
+
+			actual := stats(t, kvStore, indexStore)
+			assert.Equal(t, "foo", actual.Resource)
+			assert.Equal(t, 3, actual.Entities)
+			require.Len(t, actual.Indexes, 1)
+			assert.Equal(t, kv.DefaultIndexName, actual.Indexes[0].Name)
+			assert.Equal(t, 3, actual.Indexes[0].Entries)
+			assert.Greater(t, actual.Bytes, 0)
+			assert.False(t, actual.CountMismatch)
+		})
+
+		t.Run("orphaned index entry trips the mismatch", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "stats")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.EntStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+			})
+
+			actual := stats(t, kvStore, indexStore)
+			assert.Equal(t, 1, actual.Entities)
+			assert.Equal(t, 2, actual.Indexes[0].Entries)
+			assert.True(t, actual.CountMismatch)
+		})
+
+		t.Run("stores without bucket stats are scanned", func(t *testing.T) {
+			kvStore, done, err := NewTestInmemStore(t)
+			require.NoError(t, err)
+			defer done()
+
+			entBkt, idxBkt := []byte("foo_ent_stats_inmem"), []byte("foo_idx_stats_inmem")
+			require.NoError(t, migration.CreateBuckets("add foo buckets", entBkt, idxBkt).Up(context.Background(), kvStore.(kv.SchemaStore)))
+
+			indexStore := &kv.IndexStore{
+				Resource:   "foo",
+				EntStore:   kv.NewStoreBase("foo", entBkt, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn),
+				IndexStore: kv.NewOrgNameKeyStore("foo", idxBkt, false),
+			}
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+
+			actual := stats(t, kvStore, indexStore)
+			assert.Equal(t, 2, actual.Entities)
+			assert.Equal(t, 2, actual.Indexes[0].Entries)
+			assert.Greater(t, actual.Bytes, 0)
+			assert.False(t, actual.CountMismatch)
+		})
+	})
+
+	t.Run("FindEnts", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "find_ents")
+		defer done()
+
+		ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3")}
+		seedEnts(t, kvStore, indexStore, ents...)
+
+		byName := func(name string) kv.Entity {
+			return kv.Entity{UniqueKey: newFooEnt(0, 9000, name).UniqueKey}
+		}
+
+		t.Run("resolves entities by PK and index", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := indexStore.FindEnts(context.TODO(), tx, []kv.Entity{
+					byName("foo_3"), {PK: kv.EncID(1)}, byName("foo_2"),
+				})
+				require.NoError(t, err)
+				assert.Equal(t, toIfaces(ents[2], ents[0], ents[1]), actual)
+				return nil
+			})
+		})
+
+		t.Run("missing index key is not found", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnts(context.TODO(), tx, []kv.Entity{byName("foo_1"), byName("missing")})
+				isNotFoundErr(t, err)
+
+				actual, err := indexStore.FindEnts(context.TODO(), tx, []kv.Entity{byName("missing"), byName("foo_1")}, kv.FindEntsAllowMissing())
+				require.NoError(t, err)
+				assert.Equal(t, []interface{}{nil, ents[0].Body}, actual)
+				return nil
+			})
+		})
+	})
+
+	t.Run("ForEach", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "for_each")
+		defer done()
+
+		// spans more than a single batch, with the names ordered unlike the IDs
+		var ents []kv.Entity
+		for i := 1; i <= 250; i++ {
+			ents = append(ents, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%03d", 251-i)))
+		}
+		seedEnts(t, kvStore, indexStore, ents...)
+
+		update(t, kvStore, func(tx kv.Tx) error {
+			// index key for an entity that does not exist
+			return indexStore.IndexStore.Put(context.TODO(), tx, newFooEnt(900, 9000, "orphan"))
+		})
+
+		t.Run("visits every index and entity pair once in index order", func(t *testing.T) {
+			visited := make(map[string]int)
+			var lastKey []byte
+			view(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.ForEach(context.TODO(), tx, func(indexKey []byte, ent interface{}) error {
+					assert.True(t, bytes.Compare(lastKey, indexKey) < 0, "index keys out of order")
+					lastKey = indexKey
+
+					f := ent.(foo)
+					expectedKey, err := kv.Encode(kv.EncID(f.OrgID), kv.EncString(f.Name))()
+					require.NoError(t, err)
+					assert.Equal(t, expectedKey, indexKey)
+					visited[f.Name]++
+					return nil
+				})
+			})
+
+			require.Len(t, visited, len(ents))
+			for _, ent := range ents {
+				assert.Equal(t, 1, visited[ent.Body.(foo).Name])
+			}
+		})
+
+		t.Run("error stops the walk", func(t *testing.T) {
+			stopErr := errors.New("stop")
+			var calls int
+			err := kvStore.View(context.TODO(), func(tx kv.Tx) error {
+				return indexStore.ForEach(context.TODO(), tx, func(indexKey []byte, ent interface{}) error {
+					calls++
+					if calls == 3 {
+						return stopErr
+					}
+					return nil
+				})
+			})
+			assert.Equal(t, stopErr, err)
+			assert.Equal(t, 3, calls)
+		})
+	})
+
+	t.Run("FindIndexRange", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "index_range")
+		defer done()
+
+		ents := []kv.Entity{
+			newFooEnt(5, 9000, "foo_a"),
+			newFooEnt(4, 9000, "foo_b"),
+			newFooEnt(3, 9000, "foo_c"),
+			newFooEnt(2, 9000, "foo_d"),
+			newFooEnt(1, 9001, "foo_a"),
+		}
+		seedEnts(t, kvStore, indexStore, ents...)
+
+		nameKey := func(orgID influxdb.ID, name string) []byte {
+			key, err := kv.Encode(kv.EncID(orgID), kv.EncString(name))()
+			require.NoError(t, err)
+			return key
+		}
+		findRange := func(start, end []byte) []interface{} {
+			var actual []interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.FindIndexRange(context.TODO(), tx, start, end, func(indexKey []byte, ent interface{}) error {
+					actual = append(actual, ent)
+					return nil
+				})
+			})
+			return actual
+		}
+
+		assert.Equal(t, toIfaces(ents[1:3]...), findRange(nameKey(9000, "foo_b"), nameKey(9000, "foo_d")))
+		assert.Equal(t, toIfaces(ents[3:]...), findRange(nameKey(9000, "foo_d"), nil))
+		assert.Empty(t, findRange(nameKey(9000, "foo_e"), nameKey(9001, "")))
+
+		t.Run("start between keys in memory", func(t *testing.T) {
+			indexStore, done, kvStore := newInmemFooIndexStore(t, "index_range")
+			defer done()
+
+			seedEnts(t, kvStore, indexStore, ents...)
+
+			var actual []interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.FindIndexRange(context.TODO(), tx, nameKey(9000, "foo_b0"), nameKey(9001, ""), func(indexKey []byte, ent interface{}) error {
+					actual = append(actual, ent)
+					return nil
+				})
+			})
+			assert.Equal(t, toIfaces(ents[2:4]...), actual)
+		})
+	})
+
+	t.Run("Iterator", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "iterator")
+		defer done()
+
+		ents := []kv.Entity{
+			newFooEnt(3, 9000, "bucket_a"),
+			newFooEnt(1, 9000, "bucket_b"),
+			newFooEnt(2, 9000, "bucket_c"),
+			newFooEnt(4, 9000, "other"),
+		}
+		seedEnts(t, kvStore, indexStore, ents...)
+
+		prefix, err := kv.Encode(kv.EncID(9000), kv.EncString("bucket_"))()
+		require.NoError(t, err)
+
+		t.Run("resolves index entries in index order", func(t *testing.T) {
+			var actual []interface{}
+			view(t, kvStore, func(tx kv.Tx) error {
+				iter, err := indexStore.Iterator(context.TODO(), tx, kv.FindOpts{Prefix: prefix})
+				if err != nil {
+					return err
+				}
+				defer iter.Close()
+
+				for iter.Next() {
+					actual = append(actual, iter.Entity().Body)
+				}
+				return iter.Err()
+			})
+			assert.Equal(t, toIfaces(ents[:3]...), actual)
+		})
+
+		t.Run("pages after an index key", func(t *testing.T) {
+			var (
+				actual []interface{}
+				after  []byte
+			)
+			for page := 0; page < 4; page++ {
+				var n int
+				view(t, kvStore, func(tx kv.Tx) error {
+					iter, err := indexStore.Iterator(context.TODO(), tx, kv.FindOpts{Prefix: prefix, After: after, Limit: 2})
+					if err != nil {
+						return err
+					}
+					defer iter.Close()
+
+					for iter.Next() {
+						n++
+						f := iter.Entity().Body.(foo)
+						actual = append(actual, f)
+						after, err = kv.Encode(kv.EncID(f.OrgID), kv.EncString(f.Name))()
+						require.NoError(t, err)
+					}
+					return iter.Err()
+				})
+				if n == 0 {
+					break
+				}
+			}
+			assert.Equal(t, toIfaces(ents[:3]...), actual)
+		})
+
+		t.Run("after an index key that is not stored", func(t *testing.T) {
+			after, err := kv.Encode(kv.EncID(9000), kv.EncString("bucket_a0"))()
+			require.NoError(t, err)
+
+			for name, newStore := range map[string]func(*testing.T, string) (*kv.IndexStore, func(), kv.Store){
+				"bolt":  newFooIndexStore,
+				"inmem": newInmemFooIndexStore,
+			} {
+				t.Run(name, func(t *testing.T) {
+					indexStore, done, kvStore := newStore(t, "iterator_after")
+					defer done()
+
+					seedEnts(t, kvStore, indexStore, ents...)
+
+					var actual []interface{}
+					view(t, kvStore, func(tx kv.Tx) error {
+						iter, err := indexStore.Iterator(context.TODO(), tx, kv.FindOpts{Prefix: prefix, After: after})
+						if err != nil {
+							return err
+						}
+						defer iter.Close()
+
+						for iter.Next() {
+							actual = append(actual, iter.Entity().Body)
+						}
+						return iter.Err()
+					})
+					assert.Equal(t, toIfaces(ents[1:3]...), actual)
+				})
+			}
+		})
+
+		t.Run("early close stops the iterator", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				iter, err := indexStore.Iterator(context.TODO(), tx, kv.FindOpts{Prefix: prefix})
+				if err != nil {
+					return err
+				}
+
+				require.True(t, iter.Next())
+				assert.Equal(t, ents[0].Body, iter.Entity().Body)
+				require.NoError(t, iter.Close())
+
+				assert.False(t, iter.Next())
+				assert.NoError(t, iter.Err())
+				return iter.Close()
+			})
+		})
+	})
+
+	t.Run("span tags", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "span_tags")
+		defer done()
+
+		ent := newFooEnt(1, 9000, "secret_name")
+		seedEnts(t, kvStore, indexStore, ent)
+
+		tracer := mocktracer.New()
+		defer opentracing.SetGlobalTracer(opentracing.GlobalTracer())
+		opentracing.SetGlobalTracer(tracer)
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+			require.NoError(t, err)
+
+			return indexStore.Find(context.TODO(), tx, kv.FindOpts{
+				CaptureFn: func(key []byte, decodedVal interface{}) error { return nil },
+			})
+		})
+
+		var indexLookups, counts, keyHashes int
+		phases := make(map[interface{}]int)
+		for _, span := range tracer.FinishedSpans() {
+			tags := span.Tags()
+			if phase, ok := tags["IndexPhase"]; ok {
+				assert.Equal(t, "foo", tags["Resource"], span.OperationName)
+				phases[phase]++
+			}
+			if _, ok := tags["Operation"]; ok {
+				assert.Equal(t, "foo", tags["Resource"], span.OperationName)
+				assert.Equal(t, "find", tags["Operation"], span.OperationName)
+			}
+			if tags["IndexLookup"] == true {
+				indexLookups++
+			}
+			if n, ok := tags["Count"]; ok {
+				assert.Equal(t, 1, n)
+				counts++
+			}
+			if _, ok := tags["KeyHash"]; ok {
+				keyHashes++
+			}
+			for _, v := range tags {
+				if str, ok := v.(string); ok {
+					assert.NotContains(t, str, "secret_name")
+				}
+			}
+		}
+		assert.Equal(t, 1, indexLookups)
+		assert.Equal(t, 1, counts)
+		assert.NotZero(t, keyHashes)
+		assert.Equal(t, map[interface{}]int{"lookup": 1, "fetch": 1}, phases)
+	})
+
+	t.Run("Metrics records index lookups", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "metrics")
+		defer done()
+
+		ent := newFooEnt(1, 9000, "foo_1")
+		seedEnts(t, kvStore, indexStore, ent)
+
+		metrics := &fakeMetrics{}
+		indexStore.EntStore.Metrics = metrics
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+			return err
+		})
+
+		assert.Equal(t, 1, metrics.calls["index_lookup:foo"])
+		assert.Equal(t, 1, metrics.calls["index_fetch:foo"])
+		assert.Equal(t, 1, metrics.calls["find:foo"])
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+			return err
+		})
+		assert.Equal(t, 1, metrics.calls["index_fetch:foo"], "finds by PK do not fetch via the index")
+	})
+
+	t.Run("FindEnt repair on read", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "repair_on_read")
+		defer done()
+
+		ent := newFooEnt(1, 9000, "foo_1")
+		seedEnts(t, kvStore, indexStore, ent)
+
+		// the entity is removed without its index entry
+		update(t, kvStore, func(tx kv.Tx) error {
+			return indexStore.EntStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+		})
+
+		indexed := func() bool {
+			var found bool
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.IndexStore.FindEnt(context.TODO(), tx, ent)
+				found = err == nil
+				return nil
+			})
+			return found
+		}
+		byName := kv.Entity{UniqueKey: ent.UniqueKey}
+
+		t.Run("read only find leaves the index entry", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, byName, kv.FindEntRepairOnRead())
+				isNotFoundErr(t, err)
+				assert.Contains(t, err.Error(), "foo_1")
+				return nil
+			})
+			assert.True(t, indexed())
+		})
+
+		t.Run("writable find deletes the dangling index entry", func(t *testing.T) {
+			logCore, logs := observer.New(zap.InfoLevel)
+			indexStore.Logger = zap.New(logCore)
+			defer func() { indexStore.Logger = nil }()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, byName, kv.FindEntRepairOnRead())
+				isNotFoundErr(t, err)
+				return nil
+			})
+			assert.False(t, indexed())
+			assert.Equal(t, 1, logs.FilterMessage("Deleted index entry of missing entity").Len())
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				inconsistencies, err := indexStore.Verify(context.TODO(), tx)
+				assert.Empty(t, inconsistencies)
+				return err
+			})
+		})
+	})
+
+	t.Run("Put key validation", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "key_validate")
+		defer done()
+
+		indexStore.EntStore.KeyValidateFn = func(key []byte) error {
+			if len(key) != influxdb.IDLength {
+				return errors.New("not an ID")
+			}
+			return nil
+		}
+
+		short := kv.Entity{
+			PK:        kv.EncBytes([]byte("short")),
+			UniqueKey: kv.Encode(kv.EncID(9000), kv.EncString("short")),
+			Body:      foo{OrgID: 9000, Name: "short"},
+		}
+		for _, opts := range [][]kv.PutOptionFn{nil, {kv.PutDryRun()}} {
+			update(t, kvStore, func(tx kv.Tx) error {
+				// the tx is committed, so nothing may be written before the put fails
+				err := indexStore.Put(context.TODO(), tx, short, opts...)
+				assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+				return nil
+			})
+		}
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: short.UniqueKey})
+			isNotFoundErr(t, err)
+			return nil
+		})
+	})
+
+	t.Run("ValidateBatch", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "validate_batch")
+		defer done()
+
+		seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"), newFooEnt(6, 9000, "foo_6"))
+
+		ents := []kv.Entity{
+			newFooEnt(2, 9000, "foo_2"),
+			// duplicate of the name of the entity before it in the batch
+			newFooEnt(3, 9000, "foo_2"),
+			// conflicts with the stored entity
+			newFooEnt(4, 9000, "foo_1"),
+			// the stored entity itself is no conflict
+			newFooEnt(6, 9000, "foo_6"),
+			// missing its primary key
+			{UniqueKey: kv.Encode(kv.EncID(9000), kv.EncString("foo_5")), Body: foo{OrgID: 9000, Name: "foo_5"}},
+		}
+
+		var errs []kv.BatchError
+		view(t, kvStore, func(tx kv.Tx) error {
+			errs = kv.ValidateBatch(context.TODO(), tx, indexStore, ents)
+			return nil
+		})
+
+		require.Len(t, errs, 3)
+		assert.Equal(t, 1, errs[0].Index)
+		assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(errs[0].Err))
+		assert.Contains(t, errs[0].Err.Error(), "index 0")
+
+		assert.Equal(t, 2, errs[1].Index)
+		assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(errs[1].Err))
+		assert.Contains(t, errs[1].Err.Error(), "belongs to entity")
+
+		assert.Equal(t, 4, errs[2].Index)
+		assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(errs[2].Err))
+
+		view(t, kvStore, func(tx kv.Tx) error {
+			_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+			isNotFoundErr(t, err)
+			return nil
+		})
+	})
+
+	t.Run("MoveEnt", func(t *testing.T) {
+		src, done, kvStore := newFooIndexStore(t, "move_src")
+		defer done()
+
+		dstBucketName, dstIndexBucketName := []byte("foo_ent_move_dst"), []byte("foo_idx+move_dst")
+		require.NoError(t, migration.CreateBuckets("add dst buckets", dstBucketName, dstIndexBucketName).Up(context.Background(), kvStore.(kv.SchemaStore)))
+		dst := &kv.IndexStore{
+			Resource:   "bar",
+			EntStore:   newStoreBase("bar", dstBucketName, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn),
+			IndexStore: kv.NewOrgNameKeyStore("bar", dstIndexBucketName, false),
+		}
+
+		seedEnts(t, kvStore, src,
+			newFooEnt(1, 9000, "foo_1"),
+			newFooEnt(2, 9000, "foo_2"),
+			newFooEnt(3, 9000, "foo_3"),
+		)
+		seedEnts(t, kvStore, dst,
+			newFooEnt(4, 9000, "foo_2"),
+			newFooEnt(3, 9000, "bar_3"),
+		)
+
+		rename := func(ent kv.Entity) kv.Entity {
+			f := ent.Body.(foo)
+			return newFooEnt(f.ID, f.OrgID, strings.Replace(f.Name, "foo", "bar", 1))
+		}
+
+		t.Run("moves the entity and its index", func(t *testing.T) {
+			update(t, kvStore, func(tx kv.Tx) error {
+				return kv.MoveEnt(context.TODO(), tx, src, dst, kv.Entity{PK: kv.EncID(1)}, rename)
+			})
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := src.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(1)})
+				isNotFoundErr(t, err)
+				_, err = src.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(1, 9000, "foo_1").UniqueKey})
+				isNotFoundErr(t, err)
+
+				v, err := dst.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(1, 9000, "bar_1").UniqueKey})
+				require.NoError(t, err)
+				assert.Equal(t, foo{ID: 1, OrgID: 9000, Name: "bar_1"}, v)
+				return nil
+			})
+		})
+
+		t.Run("destination conflicts roll back", func(t *testing.T) {
+			for _, id := range []influxdb.ID{2, 3} {
+				err := kvStore.Update(context.Background(), func(tx kv.Tx) error {
+					// entity 2 keeps its name, taken by another entity in dst, and
+					// entity 3 has its PK taken in dst
+					return kv.MoveEnt(context.TODO(), tx, src, dst, kv.Entity{PK: kv.EncID(id)}, nil)
+				})
+				require.Error(t, err)
+				assert.Equal(t, influxdb.EConflict, influxdb.ErrorCode(err))
+			}
+
+			view(t, kvStore, func(tx kv.Tx) error {
+				for _, f := range []foo{{ID: 2, OrgID: 9000, Name: "foo_2"}, {ID: 3, OrgID: 9000, Name: "foo_3"}} {
+					v, err := src.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(f.ID, f.OrgID, f.Name).UniqueKey})
+					require.NoError(t, err)
+					assert.Equal(t, f, v)
+				}
+
+				_, err := dst.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(2)})
+				isNotFoundErr(t, err)
+				v, err := dst.FindEnt(context.TODO(), tx, kv.Entity{PK: kv.EncID(3)})
+				require.NoError(t, err)
+				assert.Equal(t, foo{ID: 3, OrgID: 9000, Name: "bar_3"}, v)
+				return nil
+			})
+		})
+	})
+
+	t.Run("MissCache", func(t *testing.T) {
+		findByName := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore, name string) (interface{}, error) {
+			t.Helper()
+
+			var (
+				v   interface{}
+				err error
+			)
+			view(t, kvStore, func(tx kv.Tx) error {
+				v, err = indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: newFooEnt(0, 9000, name).UniqueKey})
+				return nil
+			})
+			return v, err
+		}
+
+		// putUncached writes the entity and its index entry around the IndexStore,
+		// leaving its cached miss in place
+		putUncached := func(t *testing.T, kvStore kv.Store, indexStore *kv.IndexStore, ent kv.Entity) {
+			t.Helper()
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				if err := indexStore.IndexStore.Put(context.TODO(), tx, ent); err != nil {
+					return err
+				}
+				return indexStore.EntStore.Put(context.TODO(), tx, ent)
+			})
+		}
+
+		t.Run("miss is cached until the entity is created", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "miss_cache")
+			defer done()
+			indexStore.MissCache = kv.NewMissCache(10, time.Minute)
+
+			_, err := findByName(t, kvStore, indexStore, "foo_1")
+			isNotFoundErr(t, err)
+			assert.Equal(t, 1, indexStore.MissCache.Len())
+
+			// the cached miss reads the same as the miss that was cached
+			_, cachedErr := findByName(t, kvStore, indexStore, "foo_1")
+			assert.Equal(t, err.Error(), cachedErr.Error())
+
+			putUncached(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+			_, err = findByName(t, kvStore, indexStore, "foo_1")
+			isNotFoundErr(t, err)
+
+			seedEnts(t, kvStore, indexStore, newFooEnt(1, 9000, "foo_1"))
+			assert.Equal(t, 0, indexStore.MissCache.Len())
+
+			v, err := findByName(t, kvStore, indexStore, "foo_1")
+			require.NoError(t, err)
+			assert.Equal(t, foo{ID: 1, OrgID: 9000, Name: "foo_1"}, v)
+		})
+
+		t.Run("create invalidates the miss", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "miss_cache_create")
+			defer done()
+			indexStore.MissCache = kv.NewMissCache(10, time.Minute)
+
+			_, err := findByName(t, kvStore, indexStore, "foo_2")
+			isNotFoundErr(t, err)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_2"), kv.PutNew())
+			})
+
+			v, err := findByName(t, kvStore, indexStore, "foo_2")
+			require.NoError(t, err)
+			assert.Equal(t, foo{ID: 2, OrgID: 9000, Name: "foo_2"}, v)
+		})
+
+		t.Run("backfill and repair invalidate the misses", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "miss_cache_backfill")
+			defer done()
+			indexStore.MissCache = kv.NewMissCache(10, time.Minute)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.EntStore.Put(context.TODO(), tx, newFooEnt(1, 9000, "foo_1"))
+			})
+			_, err := findByName(t, kvStore, indexStore, "foo_1")
+			isNotFoundErr(t, err)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.BackfillIndex(context.TODO(), tx, func(ent kv.Entity) kv.Entity {
+					return kv.Entity{PK: ent.PK, UniqueKey: ent.UniqueKey}
+				})
+			})
+			_, err = findByName(t, kvStore, indexStore, "foo_1")
+			require.NoError(t, err)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				return indexStore.EntStore.Put(context.TODO(), tx, newFooEnt(2, 9000, "foo_2"))
+			})
+			_, err = findByName(t, kvStore, indexStore, "foo_2")
+			isNotFoundErr(t, err)
+
+			update(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.Repair(context.TODO(), tx, kv.RepairRebuildIndex)
+				return err
+			})
+			_, err = findByName(t, kvStore, indexStore, "foo_2")
+			require.NoError(t, err)
+			assert.Equal(t, 0, indexStore.MissCache.Len())
+		})
+
+		t.Run("miss in a rolled back write is not cached", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "miss_cache_rollback")
+			defer done()
+			indexStore.MissCache = kv.NewMissCache(10, time.Minute)
+
+			ent := newFooEnt(1, 9000, "foo_1")
+			seedEnts(t, kvStore, indexStore, ent)
+
+			rollback := errors.New("rollback")
+			err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+				if err := indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK}); err != nil {
+					return err
+				}
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+				isNotFoundErr(t, err)
+				return rollback
+			})
+			require.Equal(t, rollback, err)
+			assert.Equal(t, 0, indexStore.MissCache.Len())
+
+			v, err := findByName(t, kvStore, indexStore, "foo_1")
+			require.NoError(t, err)
+			assert.Equal(t, ent.Body, v)
+		})
+
+		t.Run("miss expires after the ttl", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "miss_cache_ttl")
+			defer done()
+			indexStore.MissCache = kv.NewMissCache(10, time.Millisecond)
+
+			_, err := findByName(t, kvStore, indexStore, "foo_3")
+			isNotFoundErr(t, err)
+
+			putUncached(t, kvStore, indexStore, newFooEnt(3, 9000, "foo_3"))
+			time.Sleep(5 * time.Millisecond)
+
+			_, err = findByName(t, kvStore, indexStore, "foo_3")
+			require.NoError(t, err)
+		})
+
+		t.Run("bounded by size", func(t *testing.T) {
+			indexStore, done, kvStore := newFooIndexStore(t, "miss_cache_size")
+			defer done()
+			indexStore.MissCache = kv.NewMissCache(2, time.Minute)
+
+			for _, name := range []string{"foo_a", "foo_b", "foo_c"} {
+				_, err := findByName(t, kvStore, indexStore, name)
+				isNotFoundErr(t, err)
+			}
+			assert.Equal(t, 2, indexStore.MissCache.Len())
+		})
+	})
+
+	t.Run("FindEntInOrg", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "find_in_org")
+		defer done()
+
+		seedEnts(t, kvStore, indexStore,
+			newFooEnt(1, 9000, "foo_1"),
+			newFooEnt(2, 9001, "foo_2"),
+		)
+
+		findInOrg := func(orgID influxdb.ID, ent kv.Entity) (interface{}, error) {
+			var (
+				v   interface{}
+				err error
+			)
+			view(t, kvStore, func(tx kv.Tx) error {
+				v, err = indexStore.FindEntInOrg(context.TODO(), tx, orgID, ent)
+				return nil
+			})
+			return v, err
+		}
+
+		t.Run("store not scoped by org", func(t *testing.T) {
+			_, err := findInOrg(9000, kv.Entity{PK: kv.EncID(1)})
+			assert.Equal(t, influxdb.EInvalid, influxdb.ErrorCode(err))
+		})
+
+		indexStore.EntStore.DecodedOrgIDFn = func(decodedVal interface{}) influxdb.ID {
+			return decodedVal.(foo).OrgID
+		}
+
+		t.Run("matching org", func(t *testing.T) {
+			v, err := findInOrg(9000, kv.Entity{PK: kv.EncID(1)})
+			require.NoError(t, err)
+			assert.Equal(t, foo{ID: 1, OrgID: 9000, Name: "foo_1"}, v)
+
+			v, err = findInOrg(9001, kv.Entity{UniqueKey: newFooEnt(0, 9001, "foo_2").UniqueKey})
+			require.NoError(t, err)
+			assert.Equal(t, foo{ID: 2, OrgID: 9001, Name: "foo_2"}, v)
+		})
+
+		t.Run("mismatching org is not found", func(t *testing.T) {
+			_, err := findInOrg(9000, kv.Entity{PK: kv.EncID(2)})
+			isNotFoundErr(t, err)
+
+			// indistinguishable from an entity that does not exist
+			_, missingErr := findInOrg(9000, kv.Entity{PK: kv.EncID(3)})
+			isNotFoundErr(t, missingErr)
+			assert.Equal(t, strings.Replace(missingErr.Error(), string(encodeID(t, 3)), string(encodeID(t, 2)), 1), err.Error())
+		})
+	})
+
+	t.Run("FindEnt allow stale", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "allow_stale")
+		defer done()
+
+		ents := []kv.Entity{
+			newFooEnt(1, 9000, "foo_1"),
+			newFooEnt(2, 9000, "foo_2"),
+			newFooEnt(3, 9000, "foo_3"),
+		}
+		seedEnts(t, kvStore, indexStore, ents...)
+
+		// the index entry is removed as a repair of the index would
+		update(t, kvStore, func(tx kv.Tx) error {
+			return indexStore.IndexStore.DeleteEnt(context.TODO(), tx, ents[1])
+		})
+
+		t.Run("index miss is not found by default", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ents[1].UniqueKey})
+				isNotFoundErr(t, err)
+				return nil
+			})
+		})
+
+		t.Run("index miss falls back to a scan", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ents[1].UniqueKey}, kv.FindEntAllowStale())
+				require.NoError(t, err)
+				assert.Equal(t, ents[1].Body, actual)
+				return nil
+			})
+		})
+
+		t.Run("entity missing from the store is still not found", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				missing := newFooEnt(4, 9000, "foo_4")
+				_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: missing.UniqueKey}, kv.FindEntAllowStale())
+				isNotFoundErr(t, err)
+				return nil
+			})
+		})
+	})
+
+	t.Run("FindKeys", func(t *testing.T) {
+		indexStore, done, kvStore := newFooIndexStore(t, "find_keys")
+		defer done()
+
+		ents := []kv.Entity{
+			newFooEnt(3, 9000, "bucket_a"),
+			newFooEnt(1, 9000, "bucket_b"),
+			newFooEnt(2, 9000, "bucket_c"),
+			newFooEnt(4, 9000, "other"),
+		}
+		seedEnts(t, kvStore, indexStore, ents...)
+
+		// the entities are never read when listing keys
+		indexStore.EntStore.DecodeEntFn = func(key, val []byte) ([]byte, interface{}, error) {
+			return nil, nil, errors.New("decoded the entity")
+		}
+
+		prefix, err := kv.Encode(kv.EncID(9000), kv.EncString("bucket_"))()
+		require.NoError(t, err)
+
+		indexKeys := func(ents ...kv.Entity) [][]byte {
+			var keys [][]byte
+			for _, ent := range ents {
+				key, err := indexStore.IndexStore.EntKey(context.TODO(), ent)
+				require.NoError(t, err)
+				keys = append(keys, key)
+			}
+			return keys
+		}
+
+		t.Run("entity bucket returns the primary keys", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				keys, err := indexStore.FindKeys(context.TODO(), tx, kv.FindOpts{})
+				assert.Equal(t, [][]byte{encodeID(t, 1), encodeID(t, 2), encodeID(t, 3), encodeID(t, 4)}, keys)
+				return err
+			})
+		})
+
+		t.Run("index bucket returns the index keys", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				keys, err := indexStore.FindIndexKeys(context.TODO(), tx, kv.FindOpts{Prefix: prefix})
+				assert.Equal(t, indexKeys(ents[:3]...), keys)
+				return err
+			})
+		})
+
+		t.Run("index bucket resolves the primary keys in index order", func(t *testing.T) {
+			view(t, kvStore, func(tx kv.Tx) error {
+				keys, err := indexStore.FindIndexPKs(context.TODO(), tx, kv.FindOpts{Prefix: prefix})
+				require.NoError(t, err)
+				assert.Equal(t, [][]byte{encodeID(t, 3), encodeID(t, 1), encodeID(t, 2)}, keys)
+
+				keys, err = indexStore.FindIndexPKs(context.TODO(), tx, kv.FindOpts{Prefix: prefix, Descending: true, Offset: 1, Limit: 1})
+				require.NoError(t, err)
+				assert.Equal(t, [][]byte{encodeID(t, 1)}, keys)
+				return nil
+			})
+		})
+	})
+
+	t.Run("sharded index", func(t *testing.T) {
+		for _, tt := range []struct {
+			store      string
+			newKVStore func(*testing.T) (kv.SchemaStore, func(), error)
+			shards     int
+		}{
+			{store: "bolt", newKVStore: NewTestBoltStore, shards: 1},
+			{store: "bolt", newKVStore: NewTestBoltStore, shards: 3},
+			{store: "bolt", newKVStore: NewTestBoltStore, shards: 8},
+			{store: "inmem", newKVStore: NewTestInmemStore, shards: 3},
+		} {
+			shards, newKVStore := tt.shards, tt.newKVStore
+			t.Run(fmt.Sprintf("%s %d shards", tt.store, shards), func(t *testing.T) {
+				kvStore, done, err := newKVStore(t)
+				require.NoError(t, err)
+				defer done()
+
+				suffix := fmt.Sprintf("sharded_%d", shards)
+				indexStore := &kv.IndexStore{
+					Resource:   "foo",
+					EntStore:   newStoreBase("foo", []byte("foo_ent_"+suffix), kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn),
+					IndexStore: kv.NewOrgNameKeyStore("foo", []byte("foo_idx+"+suffix), false).WithShards(shards),
+				}
+				shardNames := indexStore.IndexStore.ShardBucketNames()
+				require.NoError(t, migration.CreateBuckets("add foo buckets", indexStore.EntStore.BktName, shardNames...).Up(context.Background(), kvStore.(kv.SchemaStore)))
+
+				if shards < 2 {
+					assert.Equal(t, [][]byte{indexStore.IndexStore.BktName}, indexStore.IndexStore.ShardBucketNames())
+				} else {
+					assert.Len(t, indexStore.IndexStore.ShardBucketNames(), shards)
+				}
+
+				var ents []kv.Entity
+				for i := 1; i <= 40; i++ {
+					ents = append(ents, newFooEnt(influxdb.ID(i), 9000, fmt.Sprintf("foo_%02d", 41-i)))
+				}
+				seedEnts(t, kvStore, indexStore, ents...)
+
+				indexKey := func(ent kv.Entity) []byte {
+					key, err := indexStore.IndexStore.EntKey(context.TODO(), ent)
+					require.NoError(t, err)
+					return key
+				}
+				shardsWithKey := func(tx kv.Tx, key []byte) [][]byte {
+					var found [][]byte
+					for _, name := range indexStore.IndexStore.ShardBucketNames() {
+						b, err := tx.Bucket(name)
+						require.NoError(t, err)
+						if _, err := b.Get(key); err == nil {
+							found = append(found, name)
+						}
+					}
+					return found
+				}
+
+				t.Run("each index key is stored in its shard alone", func(t *testing.T) {
+					used := make(map[string]bool)
+					view(t, kvStore, func(tx kv.Tx) error {
+						for _, ent := range ents {
+							key := indexKey(ent)
+							shard := indexStore.IndexStore.ShardBucket(key)
+							assert.Equal(t, [][]byte{shard}, shardsWithKey(tx, key))
+							used[string(shard)] = true
+						}
+						return nil
+					})
+					if shards > 1 {
+						assert.Greater(t, len(used), 1, "keys should spread across shards")
+					}
+				})
+
+				t.Run("finds by index key", func(t *testing.T) {
+					view(t, kvStore, func(tx kv.Tx) error {
+						for _, ent := range ents {
+							actual, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+							require.NoError(t, err)
+							assert.Equal(t, ent.Body, actual)
+						}
+						return nil
+					})
+				})
+
+				t.Run("scans index keys in order", func(t *testing.T) {
+					for _, descending := range []bool{false, true} {
+						var names []string
+						view(t, kvStore, func(tx kv.Tx) error {
+							return indexStore.FindIndex(context.TODO(), tx, kv.FindOpts{
+								Descending: descending,
+								CaptureFn: func(key []byte, decodedVal interface{}) error {
+									names = append(names, decodedVal.(foo).Name)
+									return nil
+								},
+							})
+						})
+
+						require.Len(t, names, len(ents))
+						if descending {
+							assert.True(t, sort.SliceIsSorted(names, func(i, j int) bool { return names[i] > names[j] }), "descending: %v", names)
+						} else {
+							assert.True(t, sort.StringsAreSorted(names), "ascending: %v", names)
+						}
+					}
+				})
+
+				t.Run("scans a prefix in reverse", func(t *testing.T) {
+					// the reverse scan of a prefix seeks past its end and turns
+					// back, changing the direction of every shard's cursor
+					prefix, err := kv.Encode(kv.EncID(9000), kv.EncString("foo_1"))()
+					require.NoError(t, err)
+
+					var names []string
+					view(t, kvStore, func(tx kv.Tx) error {
+						return indexStore.FindIndex(context.TODO(), tx, kv.FindOpts{
+							Prefix:     prefix,
+							Descending: true,
+							CaptureFn: func(key []byte, decodedVal interface{}) error {
+								names = append(names, decodedVal.(foo).Name)
+								return nil
+							},
+						})
+					})
+
+					var expected []string
+					for i := 19; i >= 10; i-- {
+						expected = append(expected, fmt.Sprintf("foo_%02d", i))
+					}
+					assert.Equal(t, expected, names)
+				})
+
+				t.Run("deletes from the key's shard", func(t *testing.T) {
+					ent := ents[7]
+					update(t, kvStore, func(tx kv.Tx) error {
+						return indexStore.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ent.PK})
+					})
+
+					view(t, kvStore, func(tx kv.Tx) error {
+						assert.Empty(t, shardsWithKey(tx, indexKey(ent)))
+
+						_, err := indexStore.FindEnt(context.TODO(), tx, kv.Entity{UniqueKey: ent.UniqueKey})
+						isNotFoundErr(t, err)
+
+						inconsistencies, err := indexStore.Verify(context.TODO(), tx)
+						assert.Empty(t, inconsistencies)
+						return err
+					})
+				})
+			})
+		}
 	})
 }
@@ -0,0 +1,117 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// NewTombstoneStore creates a store for the tombstones of an IndexStore's soft
+// deleted entities. The store's bucket is keyed by the entity's PK and holds the
+// time the entity was deleted.
+func NewTombstoneStore(resource string, bktName []byte) *StoreBase {
+	return newTimeStore(resource, bktName, "deletedAt")
+}
+
+// Restore restores a soft deleted entity identified by its PK. The entity's
+// indexes are recreated, failing with an EConflict when an index key has since
+// been taken by another entity. The restore is reported to the AuditFn and
+// EventBus as an AuditRestore. See DeleteEntSoft.
+func (s *IndexStore) Restore(ctx context.Context, tx Tx, ent Entity) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	if err := s.allowWrite(); err != nil {
+		return err
+	}
+
+	if s.TombstoneStore == nil {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s entities are not soft deleted", s.Resource),
+		}
+	}
+
+	v, err := s.EntStore.FindEnt(ctx, tx, Entity{PK: ent.PK})
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.deleted(ctx, tx, ent)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		pk, _ := ent.PK()
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s entity for key %s is not deleted", s.Resource, s.EntStore.KeyString(pk)),
+		}
+	}
+
+	pk, err := s.EntStore.EntKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+	existing, err := s.EntStore.convertValToEnt(pk, v)
+	if err != nil {
+		return err
+	}
+
+	indexes := s.indexes()
+	for _, idx := range indexes {
+		_, err := idx.Store.FindEnt(ctx, tx, existing)
+		if err == nil || influxdb.ErrorCode(err) != influxdb.ENotFound {
+			key, _ := idx.Store.EntKey(ctx, existing)
+			conflictErr := errKeyConflict(s.Resource, idx.Store.KeyString(key))
+			conflictErr.Err = keyInUse(err)
+			return conflictErr
+		}
+	}
+
+	for _, idx := range indexes {
+		if err := s.putIndex(ctx, tx, idx.Store, existing); err != nil {
+			return err
+		}
+	}
+	if err := s.TombstoneStore.DeleteEnt(ctx, tx, Entity{PK: ent.PK}); err != nil {
+		return err
+	}
+	return s.audit(ctx, tx, AuditRestore, pk, nil, v)
+}
+
+// softDelete removes the indexes of the entity and records its tombstone,
+// leaving the entity in the entity store.
+func (s *IndexStore) softDelete(ctx context.Context, tx Tx, ent Entity) error {
+	if s.TombstoneStore == nil {
+		return &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s entities are not soft deleted", s.Resource),
+		}
+	}
+
+	if err := s.deleteIndexes(ctx, tx, ent); err != nil {
+		return err
+	}
+	return s.TombstoneStore.Put(ctx, tx, Entity{PK: ent.PK, Body: time.Now().UTC()})
+}
+
+// deleted returns whether the entity identified by its PK is soft deleted.
+func (s *IndexStore) deleted(ctx context.Context, tx Tx, ent Entity) (bool, error) {
+	if s.TombstoneStore == nil {
+		return false, nil
+	}
+
+	_, err := s.TombstoneStore.FindEnt(ctx, tx, Entity{PK: ent.PK})
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
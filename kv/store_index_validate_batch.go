@@ -0,0 +1,98 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// BatchError is a problem with a single entity of a batch found by ValidateBatch.
+type BatchError struct {
+	// Index is the position of the entity within the batch.
+	Index int
+	// Err is an EInvalid error for an entity whose keys can not be encoded, or an
+	// EConflict error for a key the entity shares with another entity.
+	Err error
+}
+
+// Error returns the position of the entity along with its error.
+func (e BatchError) Error() string {
+	return fmt.Sprintf("entity at index %d: %v", e.Index, e.Err)
+}
+
+// ValidateBatch returns every problem with the batch of entities that would stop
+// it from being put via PutMany, rather than the first, i.e. so an import can
+// report each bad row at once. An entity is reported when its keys can not be
+// encoded, when it shares its primary key or an index key with an entity before
+// it in the batch, and when an index key of the entity belongs to a different
+// stored entity. The errors are ordered by the position of their entity, and an
+// entity may be reported once per key. Nothing is written to the stores.
+func ValidateBatch(ctx context.Context, tx Tx, s *IndexStore, ents []Entity) []BatchError {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opPut)
+
+	indexes := s.indexes()
+	seenPKs := make(map[string]int, len(ents))
+	seenIdxKeys := make([]map[string]int, len(indexes))
+	for i := range seenIdxKeys {
+		seenIdxKeys[i] = make(map[string]int, len(ents))
+	}
+
+	var errs []BatchError
+	report := func(i int, err error) {
+		errs = append(errs, BatchError{Index: i, Err: err})
+	}
+
+	for i, ent := range ents {
+		pk, err := s.EntStore.EntKey(ctx, ent)
+		if err != nil {
+			report(i, err)
+			continue
+		}
+		if j, ok := seenPKs[string(pk)]; ok {
+			report(i, s.batchConflictErr(i, j, s.EntStore.KeyString(pk)))
+		} else {
+			seenPKs[string(pk)] = i
+		}
+
+		for n, idx := range indexes {
+			idxKey, err := idx.Store.EntKey(ctx, ent)
+			if err != nil {
+				report(i, err)
+				continue
+			}
+			if j, ok := seenIdxKeys[n][string(idxKey)]; ok {
+				report(i, s.batchConflictErr(i, j, idx.Store.KeyString(idxKey)))
+				continue
+			}
+			seenIdxKeys[n][string(idxKey)] = i
+
+			idxPK, err := s.indexedPK(ctx, tx, idx.Store, idxKey)
+			if err != nil {
+				report(i, err)
+				continue
+			}
+			if idxPK != nil && !bytes.Equal(idxPK, pk) {
+				report(i, &influxdb.Error{
+					Code: influxdb.EConflict,
+					Msg:  fmt.Sprintf("%s entity at index %d cannot take %s key %s; it belongs to entity %q", s.Resource, i, idx.Name, idx.Store.KeyString(idxKey), s.EntStore.KeyString(idxPK)),
+					Err:  errKeyInUse,
+				})
+			}
+		}
+	}
+	span.SetTag("Errors", len(errs))
+	return errs
+}
+
+func (s *IndexStore) batchConflictErr(i, j int, key string) error {
+	return &influxdb.Error{
+		Code: influxdb.EConflict,
+		Msg:  fmt.Sprintf("%s entity at index %d conflicts with entity at index %d for key %s", s.Resource, i, j, key),
+		Err:  errKeyInUse,
+	}
+}
@@ -0,0 +1,311 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// verifierBatchSize is the number of records scanned by the verifier within a
+// single read tx.
+const verifierBatchSize = 100
+
+// IndexVerifier periodically samples the entities and index entries of its index
+// stores, counting the inconsistencies it finds in its metrics. Unlike Verify,
+// the buckets are scanned in batches of short read txs, so a pass never holds a
+// tx open for the length of a bucket. A sampled record is only checked against
+// the records it relates to within the tx it was read in. See NewIndexVerifier.
+type IndexVerifier struct {
+	store          Store
+	interval       time.Duration
+	sampleFraction float64
+	indexStores    []*IndexStore
+
+	log *zap.Logger
+
+	// passMu serializes the passes, which share the rand source.
+	passMu sync.Mutex
+	rand   *rand.Rand
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	sampled   *prometheus.CounterVec
+	orphaned  *prometheus.CounterVec
+	unindexed *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+}
+
+// NewIndexVerifier returns a verifier that samples the fraction provided of the
+// records of each index store, once every interval after it is started. A sample
+// fraction of 1 or more checks every record.
+func NewIndexVerifier(store Store, interval time.Duration, sampleFraction float64, indexStores ...*IndexStore) *IndexVerifier {
+	const (
+		namespace = "kv"
+		subsystem = "index_verifier"
+	)
+
+	return &IndexVerifier{
+		store:          store,
+		interval:       interval,
+		sampleFraction: sampleFraction,
+		indexStores:    indexStores,
+		log:            zap.NewNop(),
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		sampled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "sampled_total",
+			Help:      "Total number of entities and index entries checked.",
+		}, []string{"resource"}),
+		orphaned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "orphaned_index_keys_total",
+			Help:      "Total number of sampled index keys that do not resolve to an entity whose index key they are.",
+		}, []string{"resource", "index"}),
+		unindexed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "unindexed_entities_total",
+			Help:      "Total number of sampled entities without an index entry pointing to them.",
+		}, []string{"resource", "index"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Total number of passes that failed before sampling every bucket.",
+		}, []string{"resource"}),
+	}
+}
+
+// WithLogger sets the logger the failed passes are logged to.
+func (v *IndexVerifier) WithLogger(log *zap.Logger) *IndexVerifier {
+	v.log = log.With(zap.String("service", "index_verifier"))
+	return v
+}
+
+// PrometheusCollectors satisfies the prom.PrometheusCollector interface.
+func (v *IndexVerifier) PrometheusCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		v.sampled,
+		v.orphaned,
+		v.unindexed,
+		v.errors,
+	}
+}
+
+// Start runs a pass of the verifier once every interval, until the verifier is
+// stopped or the context is canceled. Starting a started verifier is a no-op.
+func (v *IndexVerifier) Start(ctx context.Context) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.cancel != nil {
+		return
+	}
+	ctx, v.cancel = context.WithCancel(ctx)
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+		v.run(ctx)
+	}()
+}
+
+// Stop stops the verifier, waiting for a pass in progress to be abandoned.
+func (v *IndexVerifier) Stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.cancel == nil {
+		return
+	}
+	v.cancel()
+	v.wg.Wait()
+	v.cancel = nil
+}
+
+func (v *IndexVerifier) run(ctx context.Context) {
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := v.VerifyOnce(ctx); err != nil && ctx.Err() == nil {
+				v.log.Info("Failed to verify indexes", zap.Error(err))
+			}
+		}
+	}
+}
+
+// VerifyOnce runs a single pass of the verifier over every index store, adding
+// the inconsistencies found to the metrics. A store that fails its pass does not
+// stop the others from being sampled; the first error is returned.
+func (v *IndexVerifier) VerifyOnce(ctx context.Context) error {
+	v.passMu.Lock()
+	defer v.passMu.Unlock()
+
+	var firstErr error
+	for _, s := range v.indexStores {
+		if err := v.verifyStore(ctx, s); err != nil {
+			v.errors.WithLabelValues(s.Resource).Inc()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (v *IndexVerifier) verifyStore(ctx context.Context, s *IndexStore) error {
+	indexes := s.indexes()
+
+	err := v.scan(ctx, s.EntStore, func(tx Tx, pk, val []byte) error {
+		_, decoded, err := s.EntStore.decodeVal(pk, val)
+		if err != nil {
+			return err
+		}
+		ent, err := s.EntStore.convertValToEnt(pk, decoded)
+		if err != nil {
+			return err
+		}
+
+		for _, idx := range indexes {
+			idxKey, err := idx.Store.EntKey(ctx, ent)
+			if err != nil {
+				return err
+			}
+			idxPK, err := s.indexedPK(ctx, tx, idx.Store, idxKey)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(idxPK, pk) {
+				v.unindexed.WithLabelValues(s.Resource, idx.Name).Inc()
+			}
+		}
+		v.sampled.WithLabelValues(s.Resource).Inc()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		idx := idx
+		err := v.scan(ctx, idx.Store, func(tx Tx, idxKey, val []byte) error {
+			orphaned, err := v.orphanedIndex(ctx, tx, s, idx.Store, idxKey, val)
+			if err != nil {
+				return err
+			}
+			if orphaned {
+				v.orphaned.WithLabelValues(s.Resource, idx.Name).Inc()
+			}
+			v.sampled.WithLabelValues(s.Resource).Inc()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orphanedIndex reports whether the index entry does not resolve to an entity
+// whose index key it is.
+func (v *IndexVerifier) orphanedIndex(ctx context.Context, tx Tx, s *IndexStore, idx *StoreBase, idxKey, val []byte) (bool, error) {
+	_, decoded, err := idx.decodeVal(idxKey, val)
+	if err != nil {
+		return false, err
+	}
+	idxEnt, err := idx.convertValToEnt(idxKey, decoded)
+	if err != nil {
+		return false, err
+	}
+	pk, err := s.EntStore.EntKey(ctx, idxEnt)
+	if err != nil {
+		return false, err
+	}
+
+	raw, err := s.EntStore.bucketGet(ctx, tx, pk)
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	found, err := s.EntStore.decodeEnt(ctx, pk, raw)
+	if err != nil {
+		return false, err
+	}
+	ent, err := s.EntStore.convertValToEnt(pk, found)
+	if err != nil {
+		return false, err
+	}
+	expected, err := idx.EntKey(ctx, ent)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(expected, idxKey), nil
+}
+
+// scan calls fn with the sampled records of the store's bucket, reading the bucket
+// in batches of verifierBatchSize records, each within its own read tx.
+func (v *IndexVerifier) scan(ctx context.Context, store *StoreBase, fn func(tx Tx, k, val []byte) error) error {
+	var after []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return &influxdb.Error{
+				Code: influxdb.ECanceled,
+				Msg:  "index verification canceled",
+				Err:  err,
+			}
+		}
+
+		var n int
+		err := v.store.View(ctx, func(tx Tx) error {
+			cur, err := store.bucketCursor(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			k, val := cur.First()
+			if after != nil {
+				k, val = cur.Seek(after)
+				if bytes.Equal(k, after) {
+					k, val = cur.Next()
+				}
+			}
+			for ; k != nil && n < verifierBatchSize; k, val = cur.Next() {
+				n++
+				after = append(after[:0:0], k...)
+				if !v.sample() {
+					continue
+				}
+				if err := fn(tx, k, val); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if n < verifierBatchSize {
+			return nil
+		}
+	}
+}
+
+func (v *IndexVerifier) sample() bool {
+	return v.sampleFraction >= 1 || v.rand.Float64() < v.sampleFraction
+}
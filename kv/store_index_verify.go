@@ -0,0 +1,237 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+const (
+	// OrphanedIndex indicates an index key that does not resolve to an entity
+	// whose index key it is.
+	OrphanedIndex InconsistencyKind = "orphaned index"
+	// UnindexedEntity indicates an entity that has no index entry pointing to it.
+	UnindexedEntity InconsistencyKind = "unindexed entity"
+)
+
+// InconsistencyKind describes the way in which the entity store and an index disagree.
+type InconsistencyKind string
+
+// IndexInconsistency describes a single disagreement between the entity store and
+// one of its indexes. For an OrphanedIndex the Key is the index key, for an
+// UnindexedEntity the Key is the entity's primary key.
+type IndexInconsistency struct {
+	Kind  InconsistencyKind
+	Index string
+	Key   []byte
+}
+
+// RepairMode determines how Repair resolves inconsistencies.
+type RepairMode int
+
+const (
+	// RepairRebuildIndex deletes orphaned index keys and writes missing index
+	// entries for unindexed entities. The entity store is the source of truth.
+	RepairRebuildIndex RepairMode = iota
+	// RepairDeleteOrphans only deletes orphaned index keys.
+	RepairDeleteOrphans
+)
+
+// Verify walks the entity store and every index and reports orphaned index keys
+// and unindexed entities. Soft deleted entities are not expected to be indexed,
+// so an index key pointing to one is reported as orphaned.
+func (s *IndexStore) Verify(ctx context.Context, tx Tx) ([]IndexInconsistency, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	var inconsistencies []IndexInconsistency
+	for _, idx := range s.indexes() {
+		found, err := s.verifyIndex(ctx, tx, idx)
+		if err != nil {
+			return nil, err
+		}
+		inconsistencies = append(inconsistencies, found...)
+	}
+	return inconsistencies, nil
+}
+
+func (s *IndexStore) verifyIndex(ctx context.Context, tx Tx, idx NamedIndex) ([]IndexInconsistency, error) {
+	var inconsistencies []IndexInconsistency
+
+	// expected holds every index key derived from the entity store paired
+	// with the primary key of the entity it was derived from.
+	expected := make(map[string]bool)
+	err := s.EntStore.Find(ctx, tx, FindOpts{
+		CaptureFn: func(k []byte, v interface{}) error {
+			ent, err := s.EntStore.convertValToEnt(k, v)
+			if err != nil {
+				return err
+			}
+			pk := append([]byte(nil), k...)
+			// soft deleted entities are left out of the indexes on purpose
+			deleted, err := s.deleted(ctx, tx, Entity{PK: EncBytes(pk)})
+			if err != nil {
+				return err
+			}
+			if deleted {
+				return nil
+			}
+
+			idxKey, err := idx.Store.EntKey(ctx, ent)
+			if err != nil {
+				return err
+			}
+			expected[string(IndexKeyParts(idxKey, pk))] = true
+
+			idxPK, err := s.indexedPK(ctx, tx, idx.Store, idxKey)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(idxPK, pk) {
+				inconsistencies = append(inconsistencies, IndexInconsistency{
+					Kind:  UnindexedEntity,
+					Index: idx.Name,
+					Key:   pk,
+				})
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = idx.Store.Find(ctx, tx, FindOpts{
+		CaptureFn: func(k []byte, v interface{}) error {
+			idxEnt, err := idx.Store.convertValToEnt(k, v)
+			if err != nil {
+				return err
+			}
+			pk, err := s.EntStore.EntKey(ctx, idxEnt)
+			if err != nil {
+				return err
+			}
+			if !expected[string(IndexKeyParts(k, pk))] {
+				inconsistencies = append(inconsistencies, IndexInconsistency{
+					Kind:  OrphanedIndex,
+					Index: idx.Name,
+					Key:   append([]byte(nil), k...),
+				})
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return inconsistencies, nil
+}
+
+// indexedPK returns the primary key the index key points to, or nil when
+// the index key does not exist.
+func (s *IndexStore) indexedPK(ctx context.Context, tx Tx, idx *StoreBase, idxKey []byte) ([]byte, error) {
+	raw, err := idx.bucketGet(ctx, tx, idxKey)
+	if err != nil {
+		if influxdb.ErrorCode(err) == influxdb.ENotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	_, v, err := idx.decodeVal(idxKey, raw)
+	if err != nil {
+		return nil, err
+	}
+	idxEnt, err := idx.convertValToEnt(idxKey, v)
+	if err != nil {
+		return nil, err
+	}
+	return s.EntStore.EntKey(ctx, idxEnt)
+}
+
+// Repair resolves the inconsistencies reported by Verify according to the mode
+// provided and returns the inconsistencies that were repaired. Repairing an
+// unindexed entity whose index key belongs to a different entity fails with
+// an EConflict, as the data violates the uniqueness of the index.
+func (s *IndexStore) Repair(ctx context.Context, tx Tx, mode RepairMode) ([]IndexInconsistency, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	inconsistencies, err := s.Verify(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var repaired []IndexInconsistency
+	for _, inc := range inconsistencies {
+		if inc.Kind != OrphanedIndex {
+			continue
+		}
+		idx, err := s.index(inc.Index)
+		if err != nil {
+			return nil, err
+		}
+		if err := idx.bucketDelete(ctx, tx, inc.Key); err != nil {
+			return nil, err
+		}
+		repaired = append(repaired, inc)
+	}
+
+	if mode != RepairDeleteOrphans {
+		for _, inc := range inconsistencies {
+			if inc.Kind != UnindexedEntity {
+				continue
+			}
+			if err := s.reindexEnt(ctx, tx, inc); err != nil {
+				return nil, err
+			}
+			repaired = append(repaired, inc)
+		}
+	}
+
+	if len(repaired) > 0 && s.MissCache != nil {
+		// the index keys are written to the index buckets directly
+		s.MissCache.invalidateOnCommit(tx, nil, nil)
+	}
+	return repaired, nil
+}
+
+func (s *IndexStore) reindexEnt(ctx context.Context, tx Tx, inc IndexInconsistency) error {
+	idx, err := s.index(inc.Index)
+	if err != nil {
+		return err
+	}
+
+	body, err := s.EntStore.bucketGet(ctx, tx, inc.Key)
+	if err != nil {
+		return err
+	}
+	v, err := s.EntStore.decodeEnt(ctx, inc.Key, body)
+	if err != nil {
+		return err
+	}
+	ent, err := s.EntStore.convertValToEnt(inc.Key, v)
+	if err != nil {
+		return err
+	}
+
+	idxKey, err := idx.EntKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+	idxPK, err := s.indexedPK(ctx, tx, idx, idxKey)
+	if err != nil {
+		return err
+	}
+	if idxPK != nil {
+		return &influxdb.Error{
+			Code: influxdb.EConflict,
+			Msg:  fmt.Sprintf("%s entity %q cannot be indexed; index %s key %s belongs to entity %q", s.Resource, s.EntStore.KeyString(inc.Key), inc.Index, idx.KeyString(idxKey), s.EntStore.KeyString(idxPK)),
+		}
+	}
+	return s.putIndex(ctx, tx, idx, ent)
+}
@@ -0,0 +1,108 @@
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// NewVersionStore creates a store for the versions of an IndexStore's entities.
+// The store's bucket is keyed by the entity's PK and holds the entity's version
+// as a big endian encoded uint64. Entities missing from the bucket are at version 0,
+// so a version store can be added to an existing IndexStore without a migration of
+// the entity store.
+func NewVersionStore(resource string, bktName []byte) *StoreBase {
+	var encVersionFn EncodeEntFn = func(ent Entity) ([]byte, string, error) {
+		v, ok := ent.Body.(uint64)
+		if err := IsErrUnexpectedDecodeVal(ok); err != nil {
+			return nil, "version", err
+		}
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		return b, "version", nil
+	}
+
+	var decVersionFn DecodeBucketValFn = func(key, val []byte) ([]byte, interface{}, error) {
+		if len(val) != 8 {
+			return nil, nil, fmt.Errorf("invalid version length %d", len(val))
+		}
+		return key, binary.BigEndian.Uint64(val), nil
+	}
+
+	var decValToEntFn ConvertValToEntFn = func(k []byte, v interface{}) (Entity, error) {
+		version, ok := v.(uint64)
+		if err := IsErrUnexpectedDecodeVal(ok); err != nil {
+			return Entity{}, err
+		}
+		return Entity{PK: EncBytes(k), Body: version}, nil
+	}
+
+	return NewStoreBase(resource, bktName, EncIDKey, encVersionFn, decVersionFn, decValToEntFn)
+}
+
+// Version returns the stored version of the entity identified by its PK. An entity
+// that has never been put is at version 0.
+func (s *IndexStore) Version(ctx context.Context, tx Tx, ent Entity) (int, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	if s.VersionStore == nil {
+		return 0, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("%s entities are not versioned", s.Resource),
+		}
+	}
+	return s.version(ctx, tx, ent)
+}
+
+func (s *IndexStore) version(ctx context.Context, tx Tx, ent Entity) (int, error) {
+	v, err := s.VersionStore.FindEnt(ctx, tx, Entity{PK: ent.PK})
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version, ok := v.(uint64)
+	if err := IsErrUnexpectedDecodeVal(ok); err != nil {
+		return 0, err
+	}
+	return int(version), nil
+}
+
+// putVersion verifies the entity is at the expected version, when one is provided,
+// and bumps the entity's version unless this is a dry run.
+func (s *IndexStore) putVersion(ctx context.Context, tx Tx, ent Entity, opt putOption) error {
+	if s.VersionStore == nil {
+		if opt.expectVersion {
+			return &influxdb.Error{
+				Code: influxdb.EInvalid,
+				Msg:  fmt.Sprintf("%s entities are not versioned", s.Resource),
+			}
+		}
+		return nil
+	}
+
+	version, err := s.version(ctx, tx, ent)
+	if err != nil {
+		return err
+	}
+
+	if opt.expectVersion && version != opt.expectedVersion {
+		pk, _ := ent.PK()
+		return &influxdb.Error{
+			Code: influxdb.EConflict,
+			Msg:  fmt.Sprintf("%s entity for key %s is at version %d; expected version %d", s.Resource, s.EntStore.KeyString(pk), version, opt.expectedVersion),
+		}
+	}
+	if opt.dryRun {
+		return nil
+	}
+
+	return s.VersionStore.Put(ctx, tx, Entity{PK: ent.PK, Body: uint64(version + 1)})
+}
@@ -0,0 +1,254 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// EntIterator pulls the entities of a find one at a time, leaving their filtering,
+// paging or joining with other stores to the caller. It is only valid for the life
+// of the tx it was created in.
+//
+//	iter, err := store.Iterator(ctx, tx, opts)
+//	if err != nil {
+//		return err
+//	}
+//	defer iter.Close()
+//	for iter.Next() {
+//		ent := iter.Entity()
+//	}
+//	return iter.Err()
+type EntIterator interface {
+	// Next advances the iterator to the next entity, returning false once the
+	// entities are exhausted, an error occurred or the iterator is closed.
+	Next() bool
+	// Entity returns the entity the iterator is at.
+	Entity() Entity
+	// Err returns the error that stopped the iterator, if any.
+	Err() error
+	// Close stops the iterator and releases its cursor. It is safe to call more
+	// than once, and is called once the entities are exhausted.
+	Close() error
+}
+
+// Iterator returns an EntIterator over the entities found via the set options. The
+// options apply as they do for Find, with the CaptureFn ignored. The context is
+// checked for cancellation on every call to Next.
+func (s *StoreBase) Iterator(ctx context.Context, tx Tx, opts FindOpts) (EntIterator, error) {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	iter, closeFn, err := s.iterator(ctx, tx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entIterator{
+		ctx: ctx,
+		nextFn: func(ctx context.Context) (Entity, bool, error) {
+			k, v, err := iter.Next(ctx)
+			if err != nil || k == nil {
+				return Entity{}, false, err
+			}
+			ent, err := s.convertValToEnt(k, v)
+			return ent, err == nil, err
+		},
+		closeFn: closeFn,
+	}, nil
+}
+
+// iterator returns an iterator over the bucket via the set options, along with
+// the func that closes its cursor.
+func (s *StoreBase) iterator(ctx context.Context, tx Tx, opts FindOpts) (*iterator, func() error, error) {
+	if opts.ReadOnly {
+		if err := assertReadOnly(tx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cur, err := s.bucketCursor(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iter := &iterator{
+		cursor:          cur,
+		descending:      opts.Descending,
+		limit:           opts.Limit,
+		offset:          opts.Offset,
+		prefix:          opts.Prefix,
+		after:           opts.After,
+		decodeFn:        s.decodeVal,
+		filterFn:        opts.FilterEntFn,
+		filterDecodedFn: s.filterDecodedFn(opts.FilterDecodedFn),
+	}
+	closeFn := func() error {
+		if c, ok := cur.(io.Closer); ok {
+			return c.Close()
+		}
+		return nil
+	}
+	return iter, closeFn, nil
+}
+
+// Iterator returns an EntIterator that scans the index store via the set options,
+// resolving each index entry to its entity as the iterator advances. The options
+// apply as they do for FindIndex; index entries that do not resolve to an entity
+// are skipped.
+func (s *IndexStore) Iterator(ctx context.Context, tx Tx, opts FindOpts) (EntIterator, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	idxIter, closeFn, err := s.IndexStore.iterator(ctx, tx, FindOpts{
+		Descending: opts.Descending,
+		Prefix:     opts.Prefix,
+		After:      opts.After,
+		ReadOnly:   opts.ReadOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var seen, matched int
+	return &entIterator{
+		ctx: ctx,
+		nextFn: func(ctx context.Context) (Entity, bool, error) {
+			if opts.Limit > 0 && matched >= opts.Limit {
+				return Entity{}, false, nil
+			}
+
+			for {
+				k, v, err := idxIter.Next(ctx)
+				if err != nil || k == nil {
+					return Entity{}, false, err
+				}
+				if !bytes.HasPrefix(k, opts.Prefix) {
+					// a prefixed scan of the index is done once the keys no
+					// longer have the prefix
+					return Entity{}, false, nil
+				}
+
+				idxEnt, err := s.IndexStore.convertValToEnt(k, v)
+				if err != nil {
+					return Entity{}, false, err
+				}
+				ent, ok, err := s.resolveIndexEnt(ctx, tx, idxEnt, opts)
+				if err != nil {
+					return Entity{}, false, err
+				}
+				if !ok {
+					continue
+				}
+
+				seen++
+				if seen <= opts.Offset {
+					continue
+				}
+				matched++
+				return ent, true, nil
+			}
+		},
+		closeFn: closeFn,
+	}, nil
+}
+
+// resolveIndexEnt returns the entity the index entity points to. False is returned
+// when the entity is missing, expired or does not pass the filters of the options.
+func (s *IndexStore) resolveIndexEnt(ctx context.Context, tx Tx, idxEnt Entity, opts FindOpts) (Entity, bool, error) {
+	pk, err := s.EntStore.EntKey(ctx, idxEnt)
+	if err != nil {
+		return Entity{}, false, err
+	}
+
+	raw, err := s.EntStore.bucketGet(ctx, tx, pk)
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return Entity{}, false, nil
+	}
+	if err != nil {
+		return Entity{}, false, err
+	}
+	expired, err := s.EntStore.expired(ctx, tx, pk, time.Now())
+	if err != nil || expired {
+		return Entity{}, false, err
+	}
+
+	v, err := s.EntStore.decodeEnt(ctx, pk, raw)
+	if err != nil {
+		return Entity{}, false, err
+	}
+	if opts.FilterEntFn != nil && !opts.FilterEntFn(pk, v) {
+		return Entity{}, false, nil
+	}
+
+	ent, err := s.EntStore.convertValToEnt(pk, v)
+	if err != nil {
+		return Entity{}, false, err
+	}
+	if opts.FilterDecodedFn != nil && !opts.FilterDecodedFn(ent) {
+		return Entity{}, false, nil
+	}
+	return ent, true, nil
+}
+
+// entIterator implements EntIterator by pulling entities from nextFn until it
+// returns false.
+type entIterator struct {
+	ctx     context.Context
+	nextFn  func(ctx context.Context) (Entity, bool, error)
+	closeFn func() error
+
+	ent    Entity
+	err    error
+	closed bool
+}
+
+func (i *entIterator) Next() bool {
+	if i.closed {
+		return false
+	}
+
+	if err := i.ctx.Err(); err != nil {
+		i.err = &influxdb.Error{
+			Code: influxdb.ECanceled,
+			Msg:  "scan canceled",
+			Err:  err,
+		}
+		i.Close()
+		return false
+	}
+
+	ent, ok, err := i.nextFn(i.ctx)
+	if err != nil {
+		i.err = err
+	}
+	if !ok {
+		i.Close()
+		return false
+	}
+	i.ent = ent
+	return true
+}
+
+func (i *entIterator) Entity() Entity {
+	return i.ent
+}
+
+func (i *entIterator) Err() error {
+	return i.err
+}
+
+func (i *entIterator) Close() error {
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+	i.ent = Entity{}
+	return i.closeFn()
+}
@@ -0,0 +1,249 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// MultiIndexStore provides an entity store with a non unique index, where many
+// entities may share an index key. I.e. indexing dashboards by their owner. The
+// index bucket holds an entry for each entity, keyed by the index key and the
+// entity's PK as encoded by IndexKeyParts, so the entries of an index key are
+// found with a prefix scan and each entity only ever touches its own entry.
+type MultiIndexStore struct {
+	Resource string
+	EntStore *StoreBase
+
+	// IndexBktName is the bucket holding the index entries.
+	IndexBktName []byte
+	// EncodeIndexKeyFn derives the index key of an entity.
+	EncodeIndexKeyFn EncodeEntFn
+}
+
+// NewMultiIndexStore creates a new MultiIndexStore.
+func NewMultiIndexStore(resource string, entStore *StoreBase, indexBktName []byte, encIndexKeyFn EncodeEntFn) *MultiIndexStore {
+	return &MultiIndexStore{
+		Resource:         resource,
+		EntStore:         entStore,
+		IndexBktName:     indexBktName,
+		EncodeIndexKeyFn: encIndexKeyFn,
+	}
+}
+
+// Add adds the entity to the set of entities of its index key.
+func (s *MultiIndexStore) Add(ctx context.Context, tx Tx, ent Entity) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	key, pk, err := s.indexEntryKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+
+	b, err := s.indexBucket(tx)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(key, pk); err != nil {
+		return &influxdb.Error{
+			Code: influxdb.EInternal,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// Remove removes the entity from the set of entities of its index key, leaving
+// the other entities sharing the index key as is.
+func (s *MultiIndexStore) Remove(ctx context.Context, tx Tx, ent Entity) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	key, _, err := s.indexEntryKey(ctx, ent)
+	if err != nil {
+		return err
+	}
+
+	b, err := s.indexBucket(tx)
+	if err != nil {
+		return err
+	}
+	if err := b.Delete(key); err != nil && !IsNotFound(err) {
+		return &influxdb.Error{
+			Code: influxdb.EInternal,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+// Put will persist the entity into the entity store and add it to the index. When
+// the index key of an existing entity changes, it is removed from its prior set.
+func (s *MultiIndexStore) Put(ctx context.Context, tx Tx, ent Entity, opts ...PutOptionFn) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	existing, err := s.existingEnt(ctx, tx, ent)
+	if err != nil {
+		return err
+	}
+
+	if err := s.EntStore.Put(ctx, tx, ent, opts...); err != nil {
+		return err
+	}
+
+	if existing != nil {
+		if err := s.Remove(ctx, tx, *existing); err != nil {
+			return err
+		}
+	}
+	return s.Add(ctx, tx, ent)
+}
+
+// DeleteEnt deletes the entity from the entity store and removes it from the index.
+func (s *MultiIndexStore) DeleteEnt(ctx context.Context, tx Tx, ent Entity) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	existing, err := s.existingEnt(ctx, tx, ent)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		pk, _ := s.EntStore.EntKey(ctx, ent)
+		return errEntNotFound(s.Resource, s.EntStore.KeyString(pk))
+	}
+
+	if err := s.Remove(ctx, tx, *existing); err != nil {
+		return err
+	}
+	return s.EntStore.DeleteEnt(ctx, tx, ent)
+}
+
+// FindEnt returns the decoded entity body identified by its PK.
+func (s *MultiIndexStore) FindEnt(ctx context.Context, tx Tx, ent Entity, opts ...FindEntOptionFn) (interface{}, error) {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	return s.EntStore.FindEnt(ctx, tx, ent, opts...)
+}
+
+// FindByIndex calls the CaptureFn of the options with every entity sharing the
+// index key of the provided entity. The FilterEntFn, Offset and Limit options
+// apply to the entities found, while the Prefix option is ignored.
+func (s *MultiIndexStore) FindByIndex(ctx context.Context, tx Tx, ent Entity, opts FindOpts) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+
+	if opts.ReadOnly {
+		if err := assertReadOnly(tx); err != nil {
+			return err
+		}
+	}
+
+	idxKey, err := s.indexKey(ent)
+	if err != nil {
+		return err
+	}
+	prefix := IndexKeyParts(idxKey)
+
+	b, err := s.indexBucket(tx)
+	if err != nil {
+		return err
+	}
+	cur, err := b.Cursor()
+	if err != nil {
+		return &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  "failed to retrieve cursor",
+			Err:  err,
+		}
+	}
+
+	r, err := newEntResolver(ctx, tx, s.EntStore, opts)
+	if err != nil {
+		return err
+	}
+
+	for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+		if err := r.add(ctx, tx, append([]byte(nil), v...)); err != nil {
+			return r.done(ctx, tx, err)
+		}
+	}
+	return r.done(ctx, tx, nil)
+}
+
+// existingEnt returns the stored entity with the PK of the entity provided, or nil
+// when there is none.
+func (s *MultiIndexStore) existingEnt(ctx context.Context, tx Tx, ent Entity) (*Entity, error) {
+	pk, err := s.EntStore.EntKey(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := s.EntStore.bucketGet(ctx, tx, pk)
+	if influxdb.ErrorCode(err) == influxdb.ENotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := s.EntStore.decodeEnt(ctx, pk, body)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.EntStore.convertValToEnt(pk, v)
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// indexEntryKey returns the key of the entity's index entry and the entity's PK.
+func (s *MultiIndexStore) indexEntryKey(ctx context.Context, ent Entity) ([]byte, []byte, error) {
+	idxKey, err := s.indexKey(ent)
+	if err != nil {
+		return nil, nil, err
+	}
+	pk, err := s.EntStore.EntKey(ctx, ent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return IndexKeyParts(idxKey, pk), pk, nil
+}
+
+func (s *MultiIndexStore) indexKey(ent Entity) ([]byte, error) {
+	if s.EncodeIndexKeyFn == nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("no key was provided for %s", s.Resource),
+		}
+	}
+
+	key, field, err := s.EncodeIndexKeyFn(ent)
+	if err != nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInvalid,
+			Msg:  fmt.Sprintf("provided %s %s is an invalid format", s.Resource, field),
+			Err:  err,
+		}
+	}
+	return key, nil
+}
+
+func (s *MultiIndexStore) indexBucket(tx Tx) (Bucket, error) {
+	b, err := tx.Bucket(s.IndexBktName)
+	if err != nil {
+		return nil, &influxdb.Error{
+			Code: influxdb.EInternal,
+			Msg:  fmt.Sprintf("unexpected error retrieving bucket %q; Err %v", string(s.IndexBktName), err),
+			Err:  err,
+		}
+	}
+	return b, nil
+}
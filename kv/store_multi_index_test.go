@@ -0,0 +1,131 @@
+package kv_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/influxdata/influxdb/v2"
+	"github.com/influxdata/influxdb/v2/kv"
+	"github.com/influxdata/influxdb/v2/kv/migration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiIndexStore(t *testing.T) {
+	newFooMultiIndexStore := func(t *testing.T) (*kv.MultiIndexStore, func(), kv.Store) {
+		t.Helper()
+
+		kvStore, done, err := NewTestBoltStore(t)
+		require.NoError(t, err)
+
+		entBucket, indexBucket := []byte("foo_multi_ent"), []byte("foo_multi_idx")
+		err = migration.CreateBuckets("add foo buckets", entBucket, indexBucket).Up(context.Background(), kvStore)
+		require.NoError(t, err)
+
+		// foos are indexed by their org, which many foos share
+		var encOrgIDKeyFn kv.EncodeEntFn = func(ent kv.Entity) ([]byte, string, error) {
+			f, ok := ent.Body.(foo)
+			if !ok {
+				return nil, "OrgID", errors.New("no foo provided")
+			}
+			key, err := f.OrgID.Encode()
+			return key, "OrgID", err
+		}
+
+		entStore := kv.NewStoreBase("foo", entBucket, kv.EncIDKey, kv.EncBodyJSON, decJSONFooFn, decFooEntFn)
+		return kv.NewMultiIndexStore("foo", entStore, indexBucket, encOrgIDKeyFn), done, kvStore
+	}
+
+	findByOrg := func(t *testing.T, kvStore kv.Store, store *kv.MultiIndexStore, orgID influxdb.ID) []interface{} {
+		t.Helper()
+
+		var actual []interface{}
+		view(t, kvStore, func(tx kv.Tx) error {
+			return store.FindByIndex(context.TODO(), tx, kv.Entity{Body: foo{OrgID: orgID}}, kv.FindOpts{
+				CaptureFn: func(key []byte, decodedVal interface{}) error {
+					actual = append(actual, decodedVal)
+					return nil
+				},
+			})
+		})
+		return actual
+	}
+
+	t.Run("finds every entity sharing an index key", func(t *testing.T) {
+		store, done, kvStore := newFooMultiIndexStore(t)
+		defer done()
+
+		ents := []kv.Entity{
+			newFooEnt(1, 9000, "foo_1"),
+			newFooEnt(2, 9000, "foo_2"),
+			newFooEnt(3, 9001, "foo_3"),
+			newFooEnt(4, 9000, "foo_4"),
+		}
+		seedMultiIndexEnts(t, kvStore, store, ents...)
+
+		assert.Equal(t, toIfaces(ents[0], ents[1], ents[3]), findByOrg(t, kvStore, store, 9000))
+		assert.Equal(t, toIfaces(ents[2]), findByOrg(t, kvStore, store, 9001))
+		assert.Empty(t, findByOrg(t, kvStore, store, 9002))
+	})
+
+	t.Run("delete removes only the entity's own entry", func(t *testing.T) {
+		store, done, kvStore := newFooMultiIndexStore(t)
+		defer done()
+
+		ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3")}
+		seedMultiIndexEnts(t, kvStore, store, ents...)
+
+		update(t, kvStore, func(tx kv.Tx) error {
+			return store.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ents[1].PK})
+		})
+		assert.Equal(t, toIfaces(ents[0], ents[2]), findByOrg(t, kvStore, store, 9000))
+
+		err := kvStore.Update(context.TODO(), func(tx kv.Tx) error {
+			return store.DeleteEnt(context.TODO(), tx, kv.Entity{PK: ents[1].PK})
+		})
+		isNotFoundErr(t, err)
+	})
+
+	t.Run("changing the index key moves the entity between sets", func(t *testing.T) {
+		store, done, kvStore := newFooMultiIndexStore(t)
+		defer done()
+
+		seedMultiIndexEnts(t, kvStore, store, newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"))
+
+		moved := newFooEnt(1, 9001, "foo_1")
+		seedMultiIndexEnts(t, kvStore, store, moved)
+
+		assert.Equal(t, toIfaces(newFooEnt(2, 9000, "foo_2")), findByOrg(t, kvStore, store, 9000))
+		assert.Equal(t, toIfaces(moved), findByOrg(t, kvStore, store, 9001))
+	})
+
+	t.Run("with limit", func(t *testing.T) {
+		store, done, kvStore := newFooMultiIndexStore(t)
+		defer done()
+
+		ents := []kv.Entity{newFooEnt(1, 9000, "foo_1"), newFooEnt(2, 9000, "foo_2"), newFooEnt(3, 9000, "foo_3")}
+		seedMultiIndexEnts(t, kvStore, store, ents...)
+
+		var actual []interface{}
+		view(t, kvStore, func(tx kv.Tx) error {
+			return store.FindByIndex(context.TODO(), tx, kv.Entity{Body: foo{OrgID: 9000}}, kv.FindOpts{
+				Offset: 1,
+				Limit:  1,
+				CaptureFn: func(key []byte, decodedVal interface{}) error {
+					actual = append(actual, decodedVal)
+					return nil
+				},
+			})
+		})
+		assert.Equal(t, toIfaces(ents[1]), actual)
+	})
+}
+
+func seedMultiIndexEnts(t *testing.T, kvStore kv.Store, store *kv.MultiIndexStore, ents ...kv.Entity) {
+	t.Helper()
+
+	for _, ent := range ents {
+		update(t, kvStore, func(tx kv.Tx) error { return store.Put(context.TODO(), tx, ent) })
+	}
+}
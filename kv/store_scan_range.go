@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/influxdata/influxdb/v2/kit/tracing"
+)
+
+// ScanRange calls fn with each key of the store from start, inclusive, up to end,
+// exclusive, in ascending order, i.e. for the keys of a time bucketed resource
+// between two times. A nil start scans from the first key, and a nil end scans to
+// the last key. A range whose end is not after its start is empty. The value fn
+// is called with is the stored body as the DecodeEntFn is given it, with its
+// checksum verified and its schema upgraded. The key and value are only valid
+// for the duration of the call. When fn returns an error the scan is stopped and
+// the error is returned.
+func (s *StoreBase) ScanRange(ctx context.Context, tx Tx, start, end []byte, fn func(k, v []byte) error) error {
+	span, ctx := s.startSpan(ctx)
+	defer span.Finish()
+	span.SetTag("Operation", opFind)
+
+	return s.scanRange(ctx, tx, start, end, func(k, v []byte) error {
+		body, err := s.storedBody(k, v)
+		if err != nil {
+			return err
+		}
+		return fn(k, body)
+	})
+}
+
+// scanRange calls fn with each raw key and value of the range described by
+// ScanRange.
+func (s *StoreBase) scanRange(ctx context.Context, tx Tx, start, end []byte, fn func(k, v []byte) error) error {
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+
+	cur, err := s.bucketCursor(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	k, v := seekFrom(cur, nil, start)
+	for seen := 1; k != nil; seen++ {
+		if end != nil && bytes.Compare(k, end) >= 0 {
+			return nil
+		}
+		if err := checkScanCtx(ctx, seen); err != nil {
+			return err
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+		k, v = cur.Next()
+	}
+	return nil
+}
+
+// FindIndexRange calls fn with each key of the index store from start, inclusive,
+// up to end, exclusive, and the decoded body of the entity it points to, in
+// ascending order of the index keys, i.e. for the entities of an org whose names
+// fall between two names. The bounds are those of ScanRange, and the entities
+// are resolved as ForEach resolves them.
+func (s *IndexStore) FindIndexRange(ctx context.Context, tx Tx, start, end []byte, fn func(indexKey []byte, ent interface{}) error) error {
+	span, ctx := tracing.StartSpanFromContext(ctx)
+	defer span.Finish()
+	s.setSpanTags(span, opFind)
+
+	return s.forEachIndexed(ctx, tx, func(capture FindCaptureFn) error {
+		return s.IndexStore.scanRange(ctx, tx, start, end, func(k, raw []byte) error {
+			_, v, err := s.IndexStore.decodeVal(k, raw)
+			if err != nil {
+				return err
+			}
+			return capture(k, v)
+		})
+	}, fn)
+}
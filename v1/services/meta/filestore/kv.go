@@ -64,6 +64,10 @@ func (t *Tx) WithContext(ctx context.Context) {
 	t.ctx = ctx
 }
 
+func (t *Tx) Writable() bool {
+	return t.writable
+}
+
 // region: kv.Bucket implementation
 
 func (s *KVStore) checkKey(key []byte) bool {